@@ -0,0 +1,163 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"rtdocs/middleware"
+	"rtdocs/model/web"
+	"rtdocs/realtime"
+	"rtdocs/service"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	kickReasonDisabled = "your account has been disabled"
+	kickReasonDeleted  = "this document has been deleted"
+	kickReasonAdmin    = "disconnected by an administrator"
+)
+
+type AdminController interface {
+	SearchUsers(w http.ResponseWriter, r *http.Request)
+	DisableUser(w http.ResponseWriter, r *http.Request)
+	EnableUser(w http.ResponseWriter, r *http.Request)
+	ForcePasswordReset(w http.ResponseWriter, r *http.Request)
+	TransferDocuments(w http.ResponseWriter, r *http.Request)
+	GetDocument(w http.ResponseWriter, r *http.Request)
+	DeleteDocument(w http.ResponseWriter, r *http.Request)
+	KickClients(w http.ResponseWriter, r *http.Request)
+}
+
+type adminController struct {
+	adminService service.AdminService
+	hub          *realtime.Hub
+}
+
+func NewAdminController(adminService service.AdminService, hub *realtime.Hub) AdminController {
+	return &adminController{adminService: adminService, hub: hub}
+}
+
+// SearchUsers lists users whose username contains ?q=, a page at a time with ?limit= and ?offset=
+func (c *adminController) SearchUsers(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	query := r.URL.Query()
+	limit, _ := strconv.Atoi(query.Get("limit"))
+	offset, _ := strconv.Atoi(query.Get("offset"))
+
+	page, err := c.adminService.SearchUsers(ctx, query.Get("q"), limit, offset)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(page)
+}
+
+// DisableUser disables an account and disconnects all of its editing sessions
+func (c *adminController) DisableUser(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	userID := mux.Vars(r)["id"]
+	if err := c.adminService.DisableUser(ctx, middleware.GetUserID(ctx), userID); err != nil {
+		adminError(w, err)
+		return
+	}
+	c.hub.KickUser(userID, kickReasonDisabled)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (c *adminController) EnableUser(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	if err := c.adminService.EnableUser(ctx, mux.Vars(r)["id"]); err != nil {
+		adminError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ForcePasswordReset logs a user out and makes them reset their password before logging in again
+func (c *adminController) ForcePasswordReset(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	if err := c.adminService.ForcePasswordReset(ctx, middleware.GetUserID(ctx), mux.Vars(r)["id"]); err != nil {
+		adminError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// TransferDocuments gives every document owned by the user to another user
+func (c *adminController) TransferDocuments(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	var req web.TransferDocuments
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	transferred, err := c.adminService.TransferDocuments(ctx, mux.Vars(r)["id"], req.ToUserID)
+	if err != nil {
+		adminError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(web.TransferResult{Transferred: transferred})
+}
+
+// GetDocument returns any document, whoever it belongs to
+func (c *adminController) GetDocument(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	document, err := c.adminService.GetDocument(ctx, mux.Vars(r)["id"])
+	if err != nil {
+		adminError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(document)
+}
+
+// DeleteDocument deletes any document and disconnects everyone editing it
+func (c *adminController) DeleteDocument(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	documentID := mux.Vars(r)["id"]
+	if err := c.adminService.DeleteDocument(ctx, documentID); err != nil {
+		adminError(w, err)
+		return
+	}
+	c.hub.Kick(documentID, kickReasonDeleted)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// KickClients disconnects every WebSocket client from a document
+func (c *adminController) KickClients(w http.ResponseWriter, r *http.Request) {
+	kicked := c.hub.Kick(mux.Vars(r)["id"], kickReasonAdmin)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(web.KickResult{Kicked: kicked})
+}
+
+// adminError writes the status matching an error from the admin service
+func adminError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, service.ErrUserNotFound), errors.Is(err, service.ErrDocumentNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, service.ErrSelfModeration):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,163 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"rtdocs/model/domain"
+	"rtdocs/model/web"
+	"rtdocs/realtime"
+	"rtdocs/service"
+	"testing"
+)
+
+// moderation fails every call with err, or else succeeds
+type moderation struct {
+	service.AdminService
+	err    error
+	search []interface{}
+}
+
+func (m *moderation) SearchUsers(ctx context.Context, search string, limit, offset int) (*web.UserPage, error) {
+	m.search = []interface{}{search, limit, offset}
+	if m.err != nil {
+		return nil, m.err
+	}
+	return &web.UserPage{Users: []*domain.User{{ID: "alice"}}, Total: 1, Limit: limit, Offset: offset}, nil
+}
+
+func (m *moderation) DisableUser(ctx context.Context, adminID, userID string) error {
+	return m.err
+}
+
+func (m *moderation) EnableUser(ctx context.Context, userID string) error {
+	return m.err
+}
+
+func (m *moderation) ForcePasswordReset(ctx context.Context, adminID, userID string) error {
+	return m.err
+}
+
+func (m *moderation) TransferDocuments(ctx context.Context, fromUserID, toUserID string) (int64, error) {
+	return 2, m.err
+}
+
+func (m *moderation) GetDocument(ctx context.Context, documentID string) (*domain.Document, error) {
+	if m.err != nil {
+		return nil, m.err
+	}
+	return &domain.Document{ID: documentID}, nil
+}
+
+func (m *moderation) DeleteDocument(ctx context.Context, documentID string) error {
+	return m.err
+}
+
+// kicked reports whether the hub has disconnected client
+func kicked(client *realtime.Client) bool {
+	return !client.Send("ping")
+}
+
+func TestAdminStatus(t *testing.T) {
+	type handler func(c AdminController) http.HandlerFunc
+	disable := func(c AdminController) http.HandlerFunc { return c.DisableUser }
+	reset := func(c AdminController) http.HandlerFunc { return c.ForcePasswordReset }
+	transfer := func(c AdminController) http.HandlerFunc { return c.TransferDocuments }
+	get := func(c AdminController) http.HandlerFunc { return c.GetDocument }
+	remove := func(c AdminController) http.HandlerFunc { return c.DeleteDocument }
+
+	tests := []struct {
+		name    string
+		handler handler
+		body    string
+		err     error
+		want    int
+	}{
+		{"disable", disable, "", nil, http.StatusNoContent},
+		{"disable self", disable, "", service.ErrSelfModeration, http.StatusBadRequest},
+		{"disable unknown", disable, "", service.ErrUserNotFound, http.StatusNotFound},
+		{"disable failing", disable, "", errors.New("connection refused"), http.StatusInternalServerError},
+		{"reset", reset, "", nil, http.StatusNoContent},
+		{"reset self", reset, "", service.ErrSelfModeration, http.StatusBadRequest},
+		{"transfer", transfer, `{"to_user_id":"bob"}`, nil, http.StatusOK},
+		{"transfer malformed", transfer, `{`, nil, http.StatusBadRequest},
+		{"transfer to unknown", transfer, `{"to_user_id":"nobody"}`, service.ErrUserNotFound, http.StatusNotFound},
+		{"get document", get, "", nil, http.StatusOK},
+		{"get unknown document", get, "", service.ErrDocumentNotFound, http.StatusNotFound},
+		{"get document failing", get, "", errors.New("connection refused"), http.StatusInternalServerError},
+		{"delete unknown document", remove, "", service.ErrDocumentNotFound, http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewAdminController(&moderation{err: tt.err}, realtime.NewHub())
+
+			w := serve(tt.handler(c), "POST", "/api/admin/x", tt.body, map[string]string{"id": "target"}, "admin")
+			if w.Code != tt.want {
+				t.Fatalf("status = %d %s, want %d", w.Code, w.Body, tt.want)
+			}
+		})
+	}
+}
+
+func TestSearchUsersQuery(t *testing.T) {
+	admin := &moderation{}
+	c := NewAdminController(admin, realtime.NewHub())
+
+	w := serve(c.SearchUsers, "GET", "/api/admin/users?q=ali&limit=10&offset=20", "", nil, "admin")
+	if w.Code != http.StatusOK {
+		t.Fatalf("SearchUsers = %d %s", w.Code, w.Body)
+	}
+	var page web.UserPage
+	if err := json.NewDecoder(w.Body).Decode(&page); err != nil || page.Total != 1 || len(page.Users) != 1 {
+		t.Fatalf("page = %+v, %v", page, err)
+	}
+	if admin.search[0] != "ali" || admin.search[1] != 10 || admin.search[2] != 20 {
+		t.Fatalf("searched %v, want ali, 10, 20", admin.search)
+	}
+
+	// Unparseable paging falls back to the service defaults
+	serve(c.SearchUsers, "GET", "/api/admin/users?limit=all&offset=-", "", nil, "admin")
+	if admin.search[1] != 0 || admin.search[2] != 0 {
+		t.Fatalf("searched %v, want no limit or offset", admin.search)
+	}
+}
+
+func TestAdminKicksClients(t *testing.T) {
+	hub := realtime.NewHub()
+	bobHere := realtime.NewClient(nil, "doc", "bob", "bob")
+	bobThere := realtime.NewClient(nil, "other", "bob", "bob")
+	alice := realtime.NewClient(nil, "doc", "alice", "alice")
+	carol := realtime.NewClient(nil, "other", "carol", "carol")
+	for _, client := range []*realtime.Client{bobHere, bobThere, alice, carol} {
+		hub.Join(client)
+	}
+
+	c := NewAdminController(&moderation{}, hub)
+	w := serve(c.KickClients, "POST", "/api/admin/documents/doc/kick", "", map[string]string{"id": "doc"}, "admin")
+	var result web.KickResult
+	if err := json.NewDecoder(w.Body).Decode(&result); err != nil {
+		t.Fatalf("decoding kick result: %v", err)
+	}
+	if result.Kicked != 2 || !kicked(alice) || !kicked(bobHere) || kicked(bobThere) || kicked(carol) {
+		t.Fatalf("kicked %d; want alice and bob from doc only", result.Kicked)
+	}
+
+	// A refused request disconnects no one
+	refused := NewAdminController(&moderation{err: service.ErrSelfModeration}, hub)
+	serve(refused.DisableUser, "POST", "/api/admin/users/bob/disable", "", map[string]string{"id": "bob"}, "admin")
+	if kicked(bobThere) {
+		t.Fatal("a refused disable kicked the user")
+	}
+
+	serve(c.DisableUser, "POST", "/api/admin/users/bob/disable", "", map[string]string{"id": "bob"}, "admin")
+	if !kicked(bobThere) || kicked(carol) {
+		t.Fatal("disabling bob did not kick exactly bob's connections")
+	}
+
+	serve(c.DeleteDocument, "DELETE", "/api/admin/documents/other", "", map[string]string{"id": "other"}, "admin")
+	if !kicked(carol) {
+		t.Fatal("deleting a document did not kick its editors")
+	}
+}
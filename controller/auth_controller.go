@@ -2,21 +2,36 @@ package controller
 
 import (
 	"encoding/json"
+	"errors"
+	"log"
+	"math"
 	"net/http"
-	"rtdocs/model/domain"
+	"rtdocs/middleware"
 	"rtdocs/model/web"
 	"rtdocs/service"
 	"rtdocs/utils"
-	"time"
+	"strconv"
 
-	"github.com/golang-jwt/jwt/v5"
+	"github.com/gorilla/mux"
 )
 
 type AuthController interface {
 	Register(w http.ResponseWriter, r *http.Request)
 	Login(w http.ResponseWriter, r *http.Request)
+	LoginMFA(w http.ResponseWriter, r *http.Request)
+	OIDCLogin(w http.ResponseWriter, r *http.Request)
+	OIDCCallback(w http.ResponseWriter, r *http.Request)
 	Logout(w http.ResponseWriter, r *http.Request)
+	LogoutAll(w http.ResponseWriter, r *http.Request)
+	GetSessions(w http.ResponseWriter, r *http.Request)
+	RevokeSession(w http.ResponseWriter, r *http.Request)
+	Refresh(w http.ResponseWriter, r *http.Request)
 	Guest(w http.ResponseWriter, r *http.Request)
+	JWKS(w http.ResponseWriter, r *http.Request)
+	ForgotPassword(w http.ResponseWriter, r *http.Request)
+	ResetPassword(w http.ResponseWriter, r *http.Request)
+	VerifyEmail(w http.ResponseWriter, r *http.Request)
+	ResendVerification(w http.ResponseWriter, r *http.Request)
 }
 
 type authController struct {
@@ -31,19 +46,27 @@ func NewAuthController(authService service.AuthService) AuthController {
 func (c *authController) Register(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 
-	var user domain.User
-	if err := json.NewDecoder(r.Body).Decode(&user); err != nil {
+	var req *web.RegisterRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-
-	req := &web.RegisterRequest{
-		Username: user.Username,
-		Password: user.Password,
-	}
+	req.Client = clientOf(r)
 
 	createdUser, err := c.authService.Register(ctx, req)
 	if err != nil {
+		if errors.Is(err, service.ErrInvalidGuestToken) {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		if errors.Is(err, service.ErrInvalidEmail) || errors.Is(err, service.ErrWeakPassword) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if errors.Is(err, service.ErrEmailTaken) || errors.Is(err, service.ErrUsernameTaken) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
@@ -61,10 +84,109 @@ func (c *authController) Login(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	req.Client = clientOf(r)
 
 	loginResponse, err := c.authService.Login(ctx, req)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		loginError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(loginResponse)
+}
+
+// LoginMFA finishes a login with a second factor
+func (c *authController) LoginMFA(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	var req web.MFALoginRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	req.Client = clientOf(r)
+
+	loginResponse, err := c.authService.LoginMFA(ctx, &req)
+	if err != nil {
+		loginError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(loginResponse)
+}
+
+// oidcStateCookie holds the state of the sign-in a browser started, so that
+// only that browser can finish it
+const oidcStateCookie = "rtdocs_oidc_state"
+
+// OIDCLogin sends the browser to the identity provider to sign in
+func (c *authController) OIDCLogin(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	authURL, state, err := c.authService.StartOIDCLogin(ctx)
+	if err != nil {
+		if errors.Is(err, service.ErrOIDCDisabled) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    state,
+		Path:     "/api/auth/oidc",
+		MaxAge:   int(service.OIDCLoginDuration.Seconds()),
+		Secure:   r.TLS != nil,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// OIDCCallback finishes a single sign-on. The identity provider redirects to
+// the app, which posts the code and state it was given here along with the
+// state cookie set when the sign-in started.
+func (c *authController) OIDCCallback(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	var req web.OIDCCallbackRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	req.Client = clientOf(r)
+	if cookie, err := r.Cookie(oidcStateCookie); err == nil {
+		req.BrowserState = cookie.Value
+	}
+
+	// The sign-in is over either way
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Path:     "/api/auth/oidc",
+		MaxAge:   -1,
+		Secure:   r.TLS != nil,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	loginResponse, err := c.authService.OIDCCallback(ctx, &req)
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrOIDCDisabled):
+			http.Error(w, err.Error(), http.StatusNotFound)
+		case errors.Is(err, service.ErrInvalidOIDCState):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		case errors.Is(err, service.ErrOIDCLoginFailed), errors.Is(err, service.ErrInvalidGuestToken):
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+		case errors.Is(err, service.ErrAccountDisabled):
+			http.Error(w, err.Error(), http.StatusForbidden)
+		default:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
 		return
 	}
 
@@ -83,6 +205,10 @@ func (c *authController) Logout(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if err := c.authService.Logout(ctx, req.AccessToken); err != nil {
+		if errors.Is(err, service.ErrInvalidAccessToken) {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
@@ -90,35 +216,210 @@ func (c *authController) Logout(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 }
 
-func (c *authController) Guest(w http.ResponseWriter, r *http.Request) {
-	var userRequest web.RegisterRequest
-	var guestTokenSecret = utils.GetEnv("GUEST_TOKEN_SECRET")
+// LogoutAll revokes every session of the current user, logging them out on all devices
+func (c *authController) LogoutAll(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	if err := c.authService.LogoutAll(ctx, middleware.GetUserID(ctx)); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// GetSessions lists the current user's active sessions
+func (c *authController) GetSessions(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
 
-	userRequest.Username = "guest"
-	userRequest.Password = "guest"
-	guest, err := c.authService.GuestRegister(r.Context(), &userRequest)
+	sessions, err := c.authService.GetSessions(ctx, middleware.GetUserID(ctx))
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	guestClaims := jwt.MapClaims{
-		"user_id":  guest.UserID,
-		"username": userRequest.Username,
-		"role":     "guest",
-		"exp":      time.Now().Add(24 * time.Hour).Unix(),
+	currentID := middleware.GetSessionID(ctx)
+	response := make([]web.SessionResponse, 0, len(sessions))
+	for _, session := range sessions {
+		response = append(response, web.SessionResponse{Session: session, Current: session.ID == currentID})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// RevokeSession logs one of the current user's sessions out
+func (c *authController) RevokeSession(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	sessionID := mux.Vars(r)["id"]
+	if sessionID == "" {
+		http.Error(w, "Session ID is required", http.StatusBadRequest)
+		return
+	}
+
+	if err := c.authService.RevokeSession(ctx, middleware.GetUserID(ctx), sessionID); err != nil {
+		if errors.Is(err, service.ErrSessionNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
 
-	guestToken := jwt.NewWithClaims(jwt.SigningMethodHS256, guestClaims)
-	tokenString, err := guestToken.SignedString([]byte(guestTokenSecret))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Refresh rotates a refresh token and returns a new token pair
+func (c *authController) Refresh(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	var req web.RefreshRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	loginResponse, err := c.authService.Refresh(ctx, req.RefreshToken)
+	if err != nil {
+		if errors.Is(err, service.ErrInvalidRefreshToken) || errors.Is(err, service.ErrRefreshTokenReused) {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(loginResponse)
+}
+
+// Guest creates a guest account and returns a token for it
+func (c *authController) Guest(w http.ResponseWriter, r *http.Request) {
+	token, err := c.authService.IssueGuest(r.Context())
 	if err != nil {
-		http.Error(w, "Failed to generate guest token", http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
 	// Send the token back to the client
-	w.Header().Set("Authorization", "Bearer "+tokenString)
+	w.Header().Set("Authorization", "Bearer "+token)
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
-	w.Write([]byte(`{"token": "` + tokenString + `"}`))
+	json.NewEncoder(w).Encode(map[string]string{"token": token})
+}
+
+// JWKS publishes the public signing keys as a JSON Web Key Set
+func (c *authController) JWKS(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	json.NewEncoder(w).Encode(c.authService.JWKS())
+}
+
+// ForgotPassword emails a password reset link. It always answers 202 so it
+// does not reveal whether an account exists.
+func (c *authController) ForgotPassword(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	var req web.ForgotPasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := c.authService.ForgotPassword(ctx, req.Email); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// ResetPassword sets a new password using the token from a reset email
+func (c *authController) ResetPassword(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	var req web.ResetPasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := c.authService.ResetPassword(ctx, req.Token, req.Password); err != nil {
+		if errors.Is(err, service.ErrInvalidResetToken) || errors.Is(err, service.ErrWeakPassword) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// VerifyEmail confirms a user's email address with the token from their verification email
+func (c *authController) VerifyEmail(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	var req web.VerifyEmailRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := c.authService.VerifyEmail(ctx, req.Token); err != nil {
+		if errors.Is(err, service.ErrInvalidVerifyToken) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ResendVerification emails the current user a new verification link
+func (c *authController) ResendVerification(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	if err := c.authService.ResendVerification(ctx, middleware.GetUserID(ctx)); err != nil {
+		if errors.Is(err, service.ErrInvalidEmail) || errors.Is(err, service.ErrAlreadyVerified) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// loginError writes the status matching an error from a login. Unexpected
+// errors are logged and hidden, since their details could tell an attacker
+// more than whether the login worked.
+func loginError(w http.ResponseWriter, err error) {
+	var throttled *service.ThrottledError
+	switch {
+	case errors.As(err, &throttled):
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(throttled.RetryAfter.Seconds()))))
+		http.Error(w, err.Error(), http.StatusTooManyRequests)
+	case errors.Is(err, service.ErrMissingCredentials):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, service.ErrInvalidCredentials), errors.Is(err, service.ErrInvalidGuestToken),
+		errors.Is(err, service.ErrInvalidMFAToken), errors.Is(err, service.ErrInvalidMFACode), errors.Is(err, service.ErrMFANotEnrolled):
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+	case errors.Is(err, service.ErrAccountDisabled), errors.Is(err, service.ErrPasswordResetRequired):
+		http.Error(w, err.Error(), http.StatusForbidden)
+	default:
+		log.Printf("Login failed: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+func clientOf(r *http.Request) web.Client {
+	return web.Client{
+		IPAddress: utils.ClientIP(r),
+		UserAgent: r.UserAgent(),
+	}
 }
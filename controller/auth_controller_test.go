@@ -0,0 +1,140 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"rtdocs/model/domain"
+	"rtdocs/service"
+	"rtdocs/utils"
+	"strings"
+	"testing"
+)
+
+// userSessions serves each user's sessions from memory
+type userSessions struct {
+	service.AuthService
+	sessions  []*domain.Session
+	err       error
+	loggedOut []string
+}
+
+func (s *userSessions) GetSessions(ctx context.Context, userID string) ([]*domain.Session, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	var sessions []*domain.Session
+	for _, session := range s.sessions {
+		if session.UserID == userID {
+			sessions = append(sessions, session)
+		}
+	}
+	return sessions, nil
+}
+
+func (s *userSessions) RevokeSession(ctx context.Context, userID, sessionID string) error {
+	if s.err != nil {
+		return s.err
+	}
+	for _, session := range s.sessions {
+		if session.ID == sessionID && session.UserID == userID {
+			return nil
+		}
+	}
+	return service.ErrSessionNotFound
+}
+
+func (s *userSessions) LogoutAll(ctx context.Context, userID string) error {
+	if s.err != nil {
+		return s.err
+	}
+	s.loggedOut = append(s.loggedOut, userID)
+	return nil
+}
+
+func newUserSessions() *userSessions {
+	return &userSessions{sessions: []*domain.Session{
+		{ID: "phone", UserID: "alice", Token: "phone-refresh-token", Device: "Phone"},
+		{ID: "laptop", UserID: "alice", Token: "laptop-refresh-token", Device: "Laptop"},
+		{ID: "desktop", UserID: "bob", Token: "desktop-refresh-token", Device: "Desktop"},
+	}}
+}
+
+func TestGetSessions(t *testing.T) {
+	c := NewAuthController(newUserSessions())
+	alice := &utils.Principal{UserID: "alice", Username: "alice", SessionID: "laptop"}
+
+	w := serveAs(c.GetSessions, "GET", "/auth/sessions", "", nil, alice)
+	if w.Code != http.StatusOK {
+		t.Fatalf("GetSessions = %d %s", w.Code, w.Body)
+	}
+	if strings.Contains(w.Body.String(), "refresh-token") {
+		t.Fatalf("GetSessions leaked a refresh token: %s", w.Body)
+	}
+
+	var sessions []struct {
+		ID      string `json:"id"`
+		Current bool   `json:"current"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&sessions); err != nil {
+		t.Fatalf("decoding sessions: %v", err)
+	}
+	current := make(map[string]bool)
+	for _, session := range sessions {
+		current[session.ID] = session.Current
+	}
+	if len(current) != 2 || current["phone"] || !current["laptop"] {
+		t.Fatalf("GetSessions = %+v, want phone and the current laptop", sessions)
+	}
+
+	failing := NewAuthController(&userSessions{err: errors.New("connection refused")})
+	if w := serveAs(failing.GetSessions, "GET", "/auth/sessions", "", nil, alice); w.Code != http.StatusInternalServerError {
+		t.Fatalf("GetSessions failing = %d, want 500", w.Code)
+	}
+}
+
+func TestRevokeSession(t *testing.T) {
+	tests := []struct {
+		name    string
+		session string
+		err     error
+		want    int
+	}{
+		{"own", "phone", nil, http.StatusNoContent},
+		{"another user's", "desktop", nil, http.StatusNotFound},
+		{"unknown", "tablet", nil, http.StatusNotFound},
+		{"missing", "", nil, http.StatusBadRequest},
+		{"failing", "phone", errors.New("connection refused"), http.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sessions := newUserSessions()
+			sessions.err = tt.err
+			c := NewAuthController(sessions)
+
+			w := serve(c.RevokeSession, "DELETE", "/auth/sessions/"+tt.session, "", map[string]string{"id": tt.session}, "alice")
+			if w.Code != tt.want {
+				t.Fatalf("RevokeSession = %d %s, want %d", w.Code, w.Body, tt.want)
+			}
+		})
+	}
+}
+
+func TestLogoutAll(t *testing.T) {
+	sessions := newUserSessions()
+	c := NewAuthController(sessions)
+
+	if w := serve(c.LogoutAll, "POST", "/auth/logout/all", "", nil, "alice"); w.Code != http.StatusOK {
+		t.Fatalf("LogoutAll = %d %s", w.Code, w.Body)
+	}
+	if len(sessions.loggedOut) != 1 || sessions.loggedOut[0] != "alice" {
+		t.Fatalf("logged out %v, want alice", sessions.loggedOut)
+	}
+
+	failing := NewAuthController(&userSessions{err: errors.New("connection refused")})
+	if w := serve(failing.LogoutAll, "POST", "/auth/logout/all", "", nil, "alice"); w.Code != http.StatusInternalServerError {
+		t.Fatalf("LogoutAll failing = %d, want 500", w.Code)
+	}
+}
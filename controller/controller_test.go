@@ -0,0 +1,77 @@
+package controller
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"rtdocs/middleware"
+	"rtdocs/realtime"
+	"rtdocs/service"
+	"rtdocs/utils"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/mux"
+)
+
+// serve calls handler as userID with the route variables vars and returns the response
+func serve(handler http.HandlerFunc, method, target, body string, vars map[string]string, userID string) *httptest.ResponseRecorder {
+	var principal *utils.Principal
+	if userID != "" {
+		principal = &utils.Principal{UserID: userID, Username: userID}
+	}
+	return serveAs(handler, method, target, body, vars, principal)
+}
+
+// serveAs calls handler as principal, or anonymously if it is nil
+func serveAs(handler http.HandlerFunc, method, target, body string, vars map[string]string, principal *utils.Principal) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	r = mux.SetURLVars(r, vars)
+	if principal != nil {
+		r = r.WithContext(middleware.WithPrincipal(r.Context(), principal))
+	}
+
+	w := httptest.NewRecorder()
+	handler(w, r)
+	return w
+}
+
+// roles authorizes users by the role they have on every document
+type roles struct {
+	service.AuthorizationService
+	mu sync.Mutex
+	of map[string]string
+}
+
+func (a *roles) Authorize(ctx context.Context, documentID, userID, required string) (string, error) {
+	a.mu.Lock()
+	role := a.of[userID]
+	a.mu.Unlock()
+
+	if !service.HasRole(role, required) {
+		return role, service.ErrForbidden
+	}
+	return role, nil
+}
+
+func (a *roles) set(userID, role string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.of[userID] = role
+}
+
+type nopSaver struct {
+	mu      sync.Mutex
+	flushed []string
+}
+
+func (s *nopSaver) Save(documentID, authorID string, state realtime.State, updates []realtime.Update) {
+}
+
+func (s *nopSaver) Flush(documentID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.flushed = append(s.flushed, documentID)
+}
+
+func (s *nopSaver) Close(documentID string) {}
@@ -4,9 +4,12 @@ import (
 	"encoding/json"
 	"log"
 	"net/http"
+	"rtdocs/middleware"
 	"rtdocs/model/domain"
 	"rtdocs/model/web"
+	"rtdocs/realtime"
 	"rtdocs/service"
+	"time"
 
 	"github.com/gorilla/mux"
 )
@@ -16,14 +19,19 @@ type DocumentController interface {
 	GetAllDocuments(w http.ResponseWriter, r *http.Request)
 	CreateDocument(w http.ResponseWriter, r *http.Request)
 	UpdateDocument(w http.ResponseWriter, r *http.Request)
+	ShareDocument(w http.ResponseWriter, r *http.Request)
 }
 
 type documentController struct {
-	docService service.DocumentService
+	docService      service.DocumentService
+	revisionService service.RevisionService
+	authzService    service.AuthorizationService
+	saver           service.DocumentSaver
+	hub             *realtime.Hub
 }
 
-func NewDocumentController(docService service.DocumentService) DocumentController {
-	return &documentController{docService: docService}
+func NewDocumentController(docService service.DocumentService, revisionService service.RevisionService, authzService service.AuthorizationService, saver service.DocumentSaver, hub *realtime.Hub) DocumentController {
+	return &documentController{docService: docService, revisionService: revisionService, authzService: authzService, saver: saver, hub: hub}
 }
 
 // GetDocument retrieves a document by its ID
@@ -37,6 +45,12 @@ func (c *documentController) GetDocument(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	role, err := c.authzService.Authorize(ctx, id, middleware.GetUserID(ctx), domain.RoleViewer)
+	if err != nil {
+		authorizationError(w, err)
+		return
+	}
+
 	document, err := c.docService.GetDocument(ctx, id)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -45,6 +59,7 @@ func (c *documentController) GetDocument(w http.ResponseWriter, r *http.Request)
 
 	response := map[string]interface{}{
 		"document": document,
+		"role":     role,
 		"ws_url":   "/ws/" + id, // Include the WebSocket URL in the response
 	}
 
@@ -52,11 +67,11 @@ func (c *documentController) GetDocument(w http.ResponseWriter, r *http.Request)
 	json.NewEncoder(w).Encode(response)
 }
 
-// GetAllDocuments retrieves all documents
+// GetAllDocuments retrieves all documents the user can access
 func (c *documentController) GetAllDocuments(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 
-	documents, err := c.docService.GetAllDocuments(ctx)
+	documents, err := c.docService.GetDocumentsForUser(ctx, middleware.GetUserID(ctx))
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -75,10 +90,13 @@ func (c *documentController) CreateDocument(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
+	// Documents are always owned by the user who creates them
+	request.OwnerID = middleware.GetUserID(ctx)
+
 	createdDoc, err := c.docService.CreateDocument(ctx, request)
 	log.Println("Created document:", createdDoc)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		authorizationError(w, err)
 		return
 	}
 
@@ -87,23 +105,97 @@ func (c *documentController) CreateDocument(w http.ResponseWriter, r *http.Reque
 	json.NewEncoder(w).Encode(createdDoc)
 }
 
-// UpdateDocument updates the content of a document
+// UpdateDocument updates the title and content of a document; sharing is changed through ShareDocument
 func (c *documentController) UpdateDocument(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 
-	var document domain.Document
-	if err := json.NewDecoder(r.Body).Decode(&document); err != nil {
+	var request domain.Document
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
 		http.Error(w, "Invalid document data", http.StatusBadRequest)
 		return
 	}
 
-	updatedDoc, err := c.docService.UpdateDocument(ctx, &document)
+	userID := middleware.GetUserID(ctx)
+	if _, err := c.authzService.Authorize(ctx, request.ID, userID, domain.RoleEditor); err != nil {
+		authorizationError(w, err)
+		return
+	}
+
+	// While the document is open the room holds the latest content, so the
+	// save goes through it as an edit rather than writing past the editors
+	save := func(state realtime.State, applied []realtime.Update) {
+		c.saver.Save(request.ID, userID, state, applied)
+	}
+	open, err := c.hub.Replace(request.ID, request.Title, request.Content, nil, save)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if open {
+		c.saver.Flush(request.ID)
+		updatedDoc, err := c.docService.GetDocument(ctx, request.ID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusCreated)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(updatedDoc)
+		return
+	}
+
+	document, err := c.docService.GetDocument(ctx, request.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	document.Title = request.Title
+	document.Content = request.Content
+	document.UpdatedAt = time.Now()
+
+	updatedDoc, err := c.docService.UpdateDocument(ctx, document)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
+	if _, err := c.revisionService.Snapshot(ctx, updatedDoc, userID); err != nil {
+		log.Printf("Failed to snapshot revision: %v", err)
+	}
+
 	w.WriteHeader(http.StatusCreated)
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(updatedDoc)
 }
+
+// ShareDocument changes whether a document is public and whether the public can edit it
+func (c *documentController) ShareDocument(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	id := mux.Vars(r)["id"]
+	if id == "" {
+		http.Error(w, "Document ID is required", http.StatusBadRequest)
+		return
+	}
+
+	var request web.ShareDocument
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid share document request", http.StatusBadRequest)
+		return
+	}
+
+	if _, err := c.authzService.Authorize(ctx, id, middleware.GetUserID(ctx), domain.RoleOwner); err != nil {
+		authorizationError(w, err)
+		return
+	}
+
+	sharedDoc, err := c.docService.ShareDocument(ctx, id, middleware.GetUserID(ctx), &request)
+	if err != nil {
+		authorizationError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(sharedDoc)
+}
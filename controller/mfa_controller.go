@@ -0,0 +1,111 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"rtdocs/middleware"
+	"rtdocs/model/web"
+	"rtdocs/service"
+)
+
+type MFAController interface {
+	Enroll(w http.ResponseWriter, r *http.Request)
+	Confirm(w http.ResponseWriter, r *http.Request)
+	Disable(w http.ResponseWriter, r *http.Request)
+	RegenerateRecoveryCodes(w http.ResponseWriter, r *http.Request)
+}
+
+type mfaController struct {
+	mfaService service.MFAService
+}
+
+func NewMFAController(mfaService service.MFAService) MFAController {
+	return &mfaController{mfaService: mfaService}
+}
+
+// Enroll starts setting up an authenticator app and returns the secret to add to it
+func (c *mfaController) Enroll(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	enrollment, err := c.mfaService.Enroll(ctx, middleware.GetUserID(ctx))
+	if err != nil {
+		mfaError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(enrollment)
+}
+
+// Confirm turns two-factor authentication on with a first code from the app
+func (c *mfaController) Confirm(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	var req web.MFACodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	codes, err := c.mfaService.Confirm(ctx, middleware.GetUserID(ctx), req.Code)
+	if err != nil {
+		mfaError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(codes)
+}
+
+// Disable turns two-factor authentication off
+func (c *mfaController) Disable(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	var req web.MFACodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := c.mfaService.Disable(ctx, middleware.GetUserID(ctx), req.Code); err != nil {
+		mfaError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RegenerateRecoveryCodes replaces the current user's recovery codes
+func (c *mfaController) RegenerateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	var req web.MFACodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	codes, err := c.mfaService.RegenerateRecoveryCodes(ctx, middleware.GetUserID(ctx), req.Code)
+	if err != nil {
+		mfaError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(codes)
+}
+
+// mfaError writes the status matching an error from the MFA service
+func mfaError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, service.ErrMFAUnavailable):
+		http.Error(w, err.Error(), http.StatusForbidden)
+	case errors.Is(err, service.ErrMFAAlreadyEnabled):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, service.ErrMFANotEnrolled), errors.Is(err, service.ErrInvalidMFACode):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
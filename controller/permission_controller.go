@@ -0,0 +1,108 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"rtdocs/middleware"
+	"rtdocs/model/web"
+	"rtdocs/service"
+
+	"github.com/gorilla/mux"
+)
+
+type PermissionController interface {
+	GetPermissions(w http.ResponseWriter, r *http.Request)
+	GrantPermission(w http.ResponseWriter, r *http.Request)
+	RevokePermission(w http.ResponseWriter, r *http.Request)
+}
+
+type permissionController struct {
+	authzService service.AuthorizationService
+}
+
+func NewPermissionController(authzService service.AuthorizationService) PermissionController {
+	return &permissionController{authzService: authzService}
+}
+
+// GetPermissions lists the users who have been granted a role on a document
+func (c *permissionController) GetPermissions(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	documentID := mux.Vars(r)["id"]
+	if documentID == "" {
+		http.Error(w, "Document ID is required", http.StatusBadRequest)
+		return
+	}
+
+	permissions, err := c.authzService.GetPermissions(ctx, documentID, middleware.GetUserID(ctx))
+	if err != nil {
+		authorizationError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(permissions)
+}
+
+// GrantPermission gives a user a role on a document or changes the role they have
+func (c *permissionController) GrantPermission(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	vars := mux.Vars(r)
+	documentID, userID := vars["id"], vars["userId"]
+	if documentID == "" || userID == "" {
+		http.Error(w, "Document ID and user ID are required", http.StatusBadRequest)
+		return
+	}
+
+	var request web.GrantPermission
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid grant permission request", http.StatusBadRequest)
+		return
+	}
+
+	permission, err := c.authzService.GrantPermission(ctx, documentID, middleware.GetUserID(ctx), userID, request.Role)
+	if err != nil {
+		authorizationError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(permission)
+}
+
+// RevokePermission removes a user's role on a document
+func (c *permissionController) RevokePermission(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	vars := mux.Vars(r)
+	documentID, userID := vars["id"], vars["userId"]
+	if documentID == "" || userID == "" {
+		http.Error(w, "Document ID and user ID are required", http.StatusBadRequest)
+		return
+	}
+
+	if err := c.authzService.RevokePermission(ctx, documentID, middleware.GetUserID(ctx), userID); err != nil {
+		authorizationError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// authorizationError writes the status matching an error from the authorization service
+func authorizationError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, service.ErrDocumentNotFound):
+		http.Error(w, "Document not found", http.StatusNotFound)
+	case errors.Is(err, service.ErrForbidden):
+		http.Error(w, "Forbidden", http.StatusForbidden)
+	case errors.Is(err, service.ErrEmailNotVerified):
+		http.Error(w, err.Error(), http.StatusForbidden)
+	case errors.Is(err, service.ErrInvalidRole), errors.Is(err, service.ErrOwnerRole):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
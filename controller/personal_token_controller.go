@@ -0,0 +1,81 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"rtdocs/middleware"
+	"rtdocs/model/web"
+	"rtdocs/service"
+
+	"github.com/gorilla/mux"
+)
+
+type PersonalTokenController interface {
+	CreateToken(w http.ResponseWriter, r *http.Request)
+	GetTokens(w http.ResponseWriter, r *http.Request)
+	RevokeToken(w http.ResponseWriter, r *http.Request)
+}
+
+type personalTokenController struct {
+	tokenService service.PersonalTokenService
+}
+
+func NewPersonalTokenController(tokenService service.PersonalTokenService) PersonalTokenController {
+	return &personalTokenController{tokenService: tokenService}
+}
+
+// CreateToken issues a personal access token for the current user
+func (c *personalTokenController) CreateToken(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	var req web.CreatePersonalToken
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid create token request", http.StatusBadRequest)
+		return
+	}
+
+	created, err := c.tokenService.CreateToken(ctx, middleware.GetUserID(ctx), &req)
+	if err != nil {
+		if errors.Is(err, service.ErrPersonalTokenGuest) {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(created)
+}
+
+// GetTokens lists the current user's personal access tokens
+func (c *personalTokenController) GetTokens(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	tokens, err := c.tokenService.GetTokens(ctx, middleware.GetUserID(ctx))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tokens)
+}
+
+// RevokeToken revokes one of the current user's personal access tokens
+func (c *personalTokenController) RevokeToken(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	if err := c.tokenService.RevokeToken(ctx, middleware.GetUserID(ctx), mux.Vars(r)["id"]); err != nil {
+		if errors.Is(err, service.ErrPersonalTokenNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
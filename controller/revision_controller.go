@@ -0,0 +1,170 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"rtdocs/middleware"
+	"rtdocs/model/domain"
+	"rtdocs/realtime"
+	"rtdocs/service"
+
+	"github.com/gorilla/mux"
+)
+
+type RevisionController interface {
+	GetRevisions(w http.ResponseWriter, r *http.Request)
+	GetRevision(w http.ResponseWriter, r *http.Request)
+	RestoreRevision(w http.ResponseWriter, r *http.Request)
+	GetDiff(w http.ResponseWriter, r *http.Request)
+}
+
+type revisionController struct {
+	revisionService service.RevisionService
+	diffService     service.DiffService
+	authzService    service.AuthorizationService
+	saver           service.DocumentSaver
+	hub             *realtime.Hub
+}
+
+func NewRevisionController(revisionService service.RevisionService, diffService service.DiffService, authzService service.AuthorizationService, saver service.DocumentSaver, hub *realtime.Hub) RevisionController {
+	return &revisionController{revisionService: revisionService, diffService: diffService, authzService: authzService, saver: saver, hub: hub}
+}
+
+// GetRevisions lists a document's revisions, newest first
+func (c *revisionController) GetRevisions(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	documentID := mux.Vars(r)["id"]
+	if documentID == "" {
+		http.Error(w, "Document ID is required", http.StatusBadRequest)
+		return
+	}
+
+	if _, err := c.authzService.Authorize(ctx, documentID, middleware.GetUserID(ctx), domain.RoleViewer); err != nil {
+		authorizationError(w, err)
+		return
+	}
+
+	revisions, err := c.revisionService.GetRevisions(ctx, documentID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(revisions)
+}
+
+// GetRevision retrieves a single revision including its content
+func (c *revisionController) GetRevision(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	vars := mux.Vars(r)
+	documentID, revisionID := vars["id"], vars["revisionId"]
+	if documentID == "" || revisionID == "" {
+		http.Error(w, "Document ID and revision ID are required", http.StatusBadRequest)
+		return
+	}
+
+	if _, err := c.authzService.Authorize(ctx, documentID, middleware.GetUserID(ctx), domain.RoleViewer); err != nil {
+		authorizationError(w, err)
+		return
+	}
+
+	revision, err := c.revisionService.GetRevision(ctx, documentID, revisionID)
+	if err != nil {
+		revisionError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(revision)
+}
+
+// RestoreRevision puts the document back to a revision and pushes it to anyone editing it
+func (c *revisionController) RestoreRevision(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	vars := mux.Vars(r)
+	documentID, revisionID := vars["id"], vars["revisionId"]
+	if documentID == "" || revisionID == "" {
+		http.Error(w, "Document ID and revision ID are required", http.StatusBadRequest)
+		return
+	}
+
+	if _, err := c.authzService.Authorize(ctx, documentID, middleware.GetUserID(ctx), domain.RoleEditor); err != nil {
+		authorizationError(w, err)
+		return
+	}
+
+	// The restore starts from the stored document, so it must include every edit made so far
+	c.saver.Flush(documentID)
+	document, updates, err := c.revisionService.RestoreRevision(ctx, documentID, revisionID, middleware.GetUserID(ctx))
+	if err != nil {
+		revisionError(w, err)
+		return
+	}
+
+	// The restored updates are already logged; saving the merged state keeps
+	// any compaction and stops an earlier queued save overwriting the restore
+	save := func(state realtime.State, applied []realtime.Update) {
+		c.saver.Save(documentID, middleware.GetUserID(ctx), state, nil)
+	}
+	if _, err := c.hub.Replace(documentID, document.Title, document.Content, updates, save); err != nil {
+		log.Printf("Failed to push restored revision to editors: %v", err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(document)
+}
+
+// GetDiff compares two revisions, or a revision and the live document when to is omitted
+func (c *revisionController) GetDiff(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	documentID := mux.Vars(r)["id"]
+	if documentID == "" {
+		http.Error(w, "Document ID is required", http.StatusBadRequest)
+		return
+	}
+
+	query := r.URL.Query()
+	if query.Get("from") == "" {
+		http.Error(w, "from is required", http.StatusBadRequest)
+		return
+	}
+
+	if _, err := c.authzService.Authorize(ctx, documentID, middleware.GetUserID(ctx), domain.RoleViewer); err != nil {
+		authorizationError(w, err)
+		return
+	}
+
+	from, to := query.Get("from"), query.Get("to")
+	if from == service.LiveVersion || to == service.LiveVersion || to == "" {
+		// The live side is read from the database, so it must include every edit made so far
+		c.saver.Flush(documentID)
+	}
+
+	diff, err := c.diffService.Diff(ctx, documentID, from, to)
+	if err != nil {
+		revisionError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(diff)
+}
+
+// revisionError writes the status matching an error from the revision or diff service
+func revisionError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, service.ErrRevisionNotFound):
+		http.Error(w, "Revision not found", http.StatusNotFound)
+	case errors.Is(err, service.ErrInvalidVersion):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
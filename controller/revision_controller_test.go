@@ -0,0 +1,164 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"rtdocs/model/domain"
+	"rtdocs/model/web"
+	"rtdocs/realtime"
+	"rtdocs/service"
+	"testing"
+)
+
+type storedRevisions struct {
+	service.RevisionService
+	revisions map[string]*domain.Revision
+	err       error
+}
+
+func (s *storedRevisions) GetRevisions(ctx context.Context, documentID string) ([]*domain.Revision, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	return []*domain.Revision{{ID: "second", DocumentID: documentID}, {ID: "first", DocumentID: documentID}}, nil
+}
+
+func (s *storedRevisions) GetRevision(ctx context.Context, documentID, id string) (*domain.Revision, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	revision, ok := s.revisions[id]
+	if !ok {
+		return nil, service.ErrRevisionNotFound
+	}
+	return revision, nil
+}
+
+func (s *storedRevisions) RestoreRevision(ctx context.Context, documentID, id, authorID string) (*domain.Document, []realtime.Update, error) {
+	revision, err := s.GetRevision(ctx, documentID, id)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &domain.Document{ID: documentID, Title: revision.Title, Content: revision.Content}, nil, nil
+}
+
+func newRevisionController(revisions service.RevisionService, diffs service.DiffService, saver service.DocumentSaver) RevisionController {
+	authz := &roles{of: map[string]string{"editor": domain.RoleEditor, "viewer": domain.RoleViewer}}
+	return NewRevisionController(revisions, diffs, authz, saver, realtime.NewHub())
+}
+
+func TestGetRevisions(t *testing.T) {
+	c := newRevisionController(&storedRevisions{}, nil, &nopSaver{})
+
+	w := serve(c.GetRevisions, "GET", "/document/doc/revisions", "", map[string]string{"id": "doc"}, "viewer")
+	if w.Code != http.StatusOK {
+		t.Fatalf("GetRevisions = %d %s", w.Code, w.Body)
+	}
+	var revisions []domain.Revision
+	if err := json.NewDecoder(w.Body).Decode(&revisions); err != nil {
+		t.Fatalf("decoding revisions: %v", err)
+	}
+	if len(revisions) != 2 || revisions[0].ID != "second" {
+		t.Fatalf("GetRevisions = %+v, want newest first", revisions)
+	}
+
+	if w := serve(c.GetRevisions, "GET", "/document/doc/revisions", "", map[string]string{"id": "doc"}, "stranger"); w.Code != http.StatusForbidden {
+		t.Fatalf("GetRevisions without access = %d, want 403", w.Code)
+	}
+}
+
+func TestRevisionStatus(t *testing.T) {
+	stored := map[string]*domain.Revision{"first": {ID: "first", DocumentID: "doc", Title: "Draft", Content: "hello"}}
+	failure := errors.New("connection refused")
+
+	tests := []struct {
+		name     string
+		handler  func(c RevisionController) http.HandlerFunc
+		method   string
+		revision string
+		err      error
+		want     int
+	}{
+		{"get", func(c RevisionController) http.HandlerFunc { return c.GetRevision }, "GET", "first", nil, http.StatusOK},
+		{"get unknown", func(c RevisionController) http.HandlerFunc { return c.GetRevision }, "GET", "missing", nil, http.StatusNotFound},
+		{"get failing", func(c RevisionController) http.HandlerFunc { return c.GetRevision }, "GET", "first", failure, http.StatusInternalServerError},
+		{"restore", func(c RevisionController) http.HandlerFunc { return c.RestoreRevision }, "POST", "first", nil, http.StatusOK},
+		{"restore unknown", func(c RevisionController) http.HandlerFunc { return c.RestoreRevision }, "POST", "missing", nil, http.StatusNotFound},
+		{"restore failing", func(c RevisionController) http.HandlerFunc { return c.RestoreRevision }, "POST", "first", failure, http.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newRevisionController(&storedRevisions{revisions: stored, err: tt.err}, nil, &nopSaver{})
+
+			vars := map[string]string{"id": "doc", "revisionId": tt.revision}
+			if w := serve(tt.handler(c), tt.method, "/document/doc/revisions/"+tt.revision, "", vars, "editor"); w.Code != tt.want {
+				t.Fatalf("status = %d %s, want %d", w.Code, w.Body, tt.want)
+			}
+		})
+	}
+}
+
+func TestRestoreRevisionFlushesFirst(t *testing.T) {
+	saver := &nopSaver{}
+	revisions := &storedRevisions{revisions: map[string]*domain.Revision{"first": {ID: "first", Content: "hello"}}}
+	c := newRevisionController(revisions, nil, saver)
+
+	vars := map[string]string{"id": "doc", "revisionId": "first"}
+	if w := serve(c.RestoreRevision, "POST", "/document/doc/revisions/first/restore", "", vars, "viewer"); w.Code != http.StatusForbidden {
+		t.Fatalf("restore by a viewer = %d, want 403", w.Code)
+	}
+	if w := serve(c.RestoreRevision, "POST", "/document/doc/revisions/first/restore", "", vars, "editor"); w.Code != http.StatusOK {
+		t.Fatalf("restore = %d %s", w.Code, w.Body)
+	}
+	if len(saver.flushed) != 1 || saver.flushed[0] != "doc" {
+		t.Fatalf("flushed %v before the restore, want doc", saver.flushed)
+	}
+}
+
+type failingDiffs struct {
+	err error
+}
+
+func (d *failingDiffs) Diff(ctx context.Context, documentID, from, to string) (*web.DiffResponse, error) {
+	if d.err != nil {
+		return nil, d.err
+	}
+	return &web.DiffResponse{DocumentID: documentID, From: from, To: to}, nil
+}
+
+func TestGetDiff(t *testing.T) {
+	revision := "5f0c6a4e-4d8a-4b7e-9c1e-1d2f3a4b5c6d"
+	tests := []struct {
+		name    string
+		query   string
+		err     error
+		want    int
+		flushed bool
+	}{
+		{"revision to live", "from=" + revision, nil, http.StatusOK, true},
+		{"live to revision", "from=live&to=" + revision, nil, http.StatusOK, true},
+		{"two revisions", "from=" + revision + "&to=" + revision, nil, http.StatusOK, false},
+		{"missing from", "", nil, http.StatusBadRequest, false},
+		{"malformed", "from=latest", service.ErrInvalidVersion, http.StatusBadRequest, true},
+		{"unknown", "from=" + revision, service.ErrRevisionNotFound, http.StatusNotFound, true},
+		{"failing", "from=" + revision, errors.New("connection refused"), http.StatusInternalServerError, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			saver := &nopSaver{}
+			c := newRevisionController(&storedRevisions{}, &failingDiffs{err: tt.err}, saver)
+
+			w := serve(c.GetDiff, "GET", "/document/doc/diff?"+tt.query, "", map[string]string{"id": "doc"}, "viewer")
+			if w.Code != tt.want {
+				t.Fatalf("status = %d %s, want %d", w.Code, w.Body, tt.want)
+			}
+			if flushed := len(saver.flushed) > 0; flushed != tt.flushed {
+				t.Fatalf("flushed = %v, want %v", flushed, tt.flushed)
+			}
+		})
+	}
+}
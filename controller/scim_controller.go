@@ -0,0 +1,232 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"rtdocs/model/web"
+	"rtdocs/realtime"
+	"rtdocs/service"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+// scimContentType is the media type of SCIM requests and responses
+const scimContentType = "application/scim+json"
+
+type SCIMController interface {
+	GetUsers(w http.ResponseWriter, r *http.Request)
+	GetUser(w http.ResponseWriter, r *http.Request)
+	CreateUser(w http.ResponseWriter, r *http.Request)
+	ReplaceUser(w http.ResponseWriter, r *http.Request)
+	PatchUser(w http.ResponseWriter, r *http.Request)
+	DeleteUser(w http.ResponseWriter, r *http.Request)
+	GetGroups(w http.ResponseWriter, r *http.Request)
+	GetGroup(w http.ResponseWriter, r *http.Request)
+	PatchGroup(w http.ResponseWriter, r *http.Request)
+}
+
+type scimController struct {
+	scimService service.SCIMService
+	hub         *realtime.Hub
+}
+
+func NewSCIMController(scimService service.SCIMService, hub *realtime.Hub) SCIMController {
+	return &scimController{scimService: scimService, hub: hub}
+}
+
+// GetUsers lists users, filtered with ?filter=userName eq "..." and paged with ?startIndex= and ?count=
+func (c *scimController) GetUsers(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	startIndex, count := scimPaging(r)
+	list, err := c.scimService.GetUsers(ctx, r.URL.Query().Get("filter"), startIndex, count)
+	if err != nil {
+		scimError(w, err)
+		return
+	}
+
+	writeSCIM(w, http.StatusOK, list)
+}
+
+func (c *scimController) GetUser(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	user, err := c.scimService.GetUser(ctx, mux.Vars(r)["id"])
+	if err != nil {
+		scimError(w, err)
+		return
+	}
+
+	writeSCIM(w, http.StatusOK, user)
+}
+
+func (c *scimController) CreateUser(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	var req web.SCIMUser
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		scimError(w, service.ErrInvalidSCIMValue)
+		return
+	}
+
+	user, err := c.scimService.CreateUser(ctx, &req)
+	if err != nil {
+		scimError(w, err)
+		return
+	}
+
+	w.Header().Set("Location", user.Meta.Location)
+	writeSCIM(w, http.StatusCreated, user)
+}
+
+func (c *scimController) ReplaceUser(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	var req web.SCIMUser
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		scimError(w, service.ErrInvalidSCIMValue)
+		return
+	}
+
+	user, err := c.scimService.ReplaceUser(ctx, mux.Vars(r)["id"], &req)
+	if err != nil {
+		scimError(w, err)
+		return
+	}
+	c.kickIfInactive(user)
+
+	writeSCIM(w, http.StatusOK, user)
+}
+
+func (c *scimController) PatchUser(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	var req web.SCIMPatchRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		scimError(w, service.ErrInvalidSCIMPatch)
+		return
+	}
+
+	user, err := c.scimService.PatchUser(ctx, mux.Vars(r)["id"], &req)
+	if err != nil {
+		scimError(w, err)
+		return
+	}
+	c.kickIfInactive(user)
+
+	writeSCIM(w, http.StatusOK, user)
+}
+
+// DeleteUser deactivates a user who has left and disconnects their editing sessions
+func (c *scimController) DeleteUser(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	userID := mux.Vars(r)["id"]
+	if err := c.scimService.DeactivateUser(ctx, userID); err != nil {
+		scimError(w, err)
+		return
+	}
+	c.hub.KickUser(userID, kickReasonDisabled)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetGroups lists groups, filtered with ?filter=displayName eq "..." and paged with ?startIndex= and ?count=
+func (c *scimController) GetGroups(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	startIndex, count := scimPaging(r)
+	list, err := c.scimService.GetGroups(ctx, r.URL.Query().Get("filter"), startIndex, count)
+	if err != nil {
+		scimError(w, err)
+		return
+	}
+
+	writeSCIM(w, http.StatusOK, list)
+}
+
+func (c *scimController) GetGroup(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	group, err := c.scimService.GetGroup(ctx, mux.Vars(r)["id"])
+	if err != nil {
+		scimError(w, err)
+		return
+	}
+
+	writeSCIM(w, http.StatusOK, group)
+}
+
+func (c *scimController) PatchGroup(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	var req web.SCIMPatchRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		scimError(w, service.ErrInvalidSCIMPatch)
+		return
+	}
+
+	group, err := c.scimService.PatchGroup(ctx, mux.Vars(r)["id"], &req)
+	if err != nil {
+		scimError(w, err)
+		return
+	}
+
+	writeSCIM(w, http.StatusOK, group)
+}
+
+func (c *scimController) kickIfInactive(user *web.SCIMUser) {
+	if user.Active != nil && !*user.Active {
+		c.hub.KickUser(user.ID, kickReasonDisabled)
+	}
+}
+
+// scimPaging reads ?startIndex= and ?count=; a missing count is returned as -1 for the default page size
+func scimPaging(r *http.Request) (int, int) {
+	query := r.URL.Query()
+	startIndex, _ := strconv.Atoi(query.Get("startIndex"))
+	count, err := strconv.Atoi(query.Get("count"))
+	if err != nil {
+		count = -1
+	}
+	return startIndex, max(count, -1)
+}
+
+func writeSCIM(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", scimContentType)
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+// scimError writes an error in the format of RFC 7644 section 3.12
+func scimError(w http.ResponseWriter, err error) {
+	status, scimType := http.StatusInternalServerError, ""
+	switch {
+	case errors.Is(err, service.ErrUserNotFound), errors.Is(err, service.ErrGroupNotFound):
+		status = http.StatusNotFound
+	case errors.Is(err, service.ErrUsernameTaken), errors.Is(err, service.ErrEmailTaken):
+		status, scimType = http.StatusConflict, "uniqueness"
+	case errors.Is(err, service.ErrInvalidSCIMFilter):
+		status, scimType = http.StatusBadRequest, "invalidFilter"
+	case errors.Is(err, service.ErrInvalidSCIMPatch):
+		status, scimType = http.StatusBadRequest, "invalidSyntax"
+	case errors.Is(err, service.ErrInvalidSCIMValue), errors.Is(err, service.ErrInvalidEmail), errors.Is(err, service.ErrWeakPassword):
+		status, scimType = http.StatusBadRequest, "invalidValue"
+	}
+
+	detail := err.Error()
+	if status == http.StatusInternalServerError {
+		log.Printf("SCIM request failed: %v", err)
+		detail = "Internal server error"
+	}
+
+	writeSCIM(w, status, web.SCIMError{
+		Schemas:  []string{web.SCIMSchemaError},
+		Status:   strconv.Itoa(status),
+		SCIMType: scimType,
+		Detail:   detail,
+	})
+}
@@ -2,10 +2,12 @@ package controller
 
 import (
 	"encoding/json"
-	"log"
+	"errors"
 	"net/http"
 	"rtdocs/model/domain"
 	"rtdocs/service"
+
+	"github.com/gorilla/mux"
 )
 
 type UserController interface {
@@ -27,13 +29,7 @@ func NewUserController(userService service.UserService) UserController {
 func (c *userController) GetUser(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 
-	id := r.PathValue("id")
-	if id == "" {
-		http.Error(w, "User ID is required", http.StatusBadRequest)
-		return
-	}
-
-	log.Println("User ID: ", id)
+	id := mux.Vars(r)["id"]
 	if id == "" {
 		http.Error(w, "User ID is required", http.StatusBadRequest)
 		return
@@ -73,7 +69,7 @@ func (c *userController) CreateUser(w http.ResponseWriter, r *http.Request) {
 
 	newUser, err := c.userService.CreateUser(ctx, &user)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		userError(w, err)
 		return
 	}
 
@@ -91,10 +87,19 @@ func (c *userController) UpdateUser(w http.ResponseWriter, r *http.Request) {
 
 	updatedUser, err := c.userService.UpdateUser(ctx, &user)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		userError(w, err)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(updatedUser)
 }
+
+// userError writes the status matching an error from the user service
+func userError(w http.ResponseWriter, err error) {
+	if errors.Is(err, service.ErrInvalidUserRole) || errors.Is(err, service.ErrWeakPassword) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	http.Error(w, err.Error(), http.StatusInternalServerError)
+}
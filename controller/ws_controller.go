@@ -3,9 +3,13 @@ package controller
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
+	"rtdocs/middleware"
 	"rtdocs/model/domain"
+	"rtdocs/realtime"
 	"rtdocs/service"
 
 	"github.com/gorilla/mux"
@@ -13,122 +17,208 @@ import (
 )
 
 type WebSocketController interface {
-	HandleConnections(ctx context.Context, w http.ResponseWriter, r *http.Request)
-	HandleMessages()
+	HandleConnections(w http.ResponseWriter, r *http.Request)
+	HandleMessages(ctx context.Context)
 }
 
 type webSocketController struct {
-	docService service.DocumentService
-	clients    map[*websocket.Conn]bool
-	broadcast  chan map[string]string
+	docService   service.DocumentService
+	saver        service.DocumentSaver
+	authzService service.AuthorizationService
+	hub          *realtime.Hub
 }
 
+// kickReasonRevoked is sent to a client whose access was revoked while it was connected
+const kickReasonRevoked = "your access to this document has been revoked"
+
 var upgrader = websocket.Upgrader{
 	ReadBufferSize:  1024,
 	WriteBufferSize: 1024,
+	Subprotocols:    []string{middleware.WebSocketTokenProtocol},
 	CheckOrigin: func(r *http.Request) bool {
 		return true
 	},
 }
 
-func NewWebSocketController(docService service.DocumentService) *webSocketController {
+func NewWebSocketController(docService service.DocumentService, saver service.DocumentSaver, authzService service.AuthorizationService, hub *realtime.Hub) *webSocketController {
 	return &webSocketController{
-		docService: docService,
-		clients:    make(map[*websocket.Conn]bool),
-		broadcast:  make(chan map[string]string),
+		docService:   docService,
+		saver:        saver,
+		authzService: authzService,
+		hub:          hub,
 	}
 }
 
-// HandleConnections upgrades HTTP requests to WebSocket and registers clients
+// HandleConnections upgrades HTTP requests to WebSocket and joins the client to the document's room
 func (c *webSocketController) HandleConnections(w http.ResponseWriter, r *http.Request) {
-	ws, err := upgrader.Upgrade(w, r, nil)
-	if err != nil {
-		log.Printf("Upgrade error: %v", err)
-		return
-	}
-	defer ws.Close()
-
-	c.clients[ws] = true
-
 	vars := mux.Vars(r)
 	documentID := vars["id"]
 	if documentID == "" {
-		log.Printf("Document ID is required")
+		http.Error(w, "Document ID is required", http.StatusBadRequest)
 		return
 	}
 
 	// Create a new context with the request context
 	ctx := r.Context()
+	userID := middleware.GetUserID(ctx)
 
-	// Retrieve the current document state and send it to the new client
+	// Retrieve the current document record; its title and content seed the room's shared state
 	document, err := c.docService.GetDocument(ctx, documentID)
-	log.Printf("Document ID: %s", documentID)
+	if err != nil || document == nil {
+		http.Error(w, "Document not found", http.StatusNotFound)
+		return
+	}
+
+	// Refuse the upgrade before joining the room so the client gets a real HTTP status
+	if _, err := c.authzService.Authorize(ctx, documentID, userID, domain.RoleViewer); err != nil {
+		authorizationError(w, err)
+		return
+	}
+
+	ws, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
-		log.Printf("Failed to load document: %v", err)
-		document = &domain.Document{
-			ID:      documentID,
-			Title:   "New Document",
-			Content: "",
+		log.Printf("Upgrade error: %v", err)
+		return
+	}
+	defer ws.Close()
+
+	client := realtime.NewClient(ws, documentID, userID, middleware.GetUsername(ctx))
+	room := c.hub.Join(client)
+	defer func() {
+		// Write the edits of a closing room now rather than after the save delay
+		if c.hub.Leave(client) {
+			c.saver.Close(documentID)
+		}
+	}()
+	go client.WritePump()
+
+	shared, err := room.Document(func() (*realtime.Document, error) {
+		// A room that just closed may still be saving; load what it saved
+		c.saver.Flush(documentID)
+		current, err := c.docService.GetDocument(ctx, documentID)
+		if err != nil {
+			return nil, err
 		}
+		if current == nil {
+			return nil, fmt.Errorf("document %s was deleted", documentID)
+		}
+
+		if current.SyncMode != realtime.ModeCRDT {
+			return realtime.NewDocument(current.Title, current.Content), nil
+		}
+		sequence, err := c.docService.LoadSequence(ctx, current)
+		if err != nil {
+			return nil, err
+		}
+		return realtime.NewSequenceDocument(current.Title, sequence), nil
+	})
+	if err != nil {
+		log.Printf("Failed to load document state: %v", err)
+		return
 	}
 
-	initialState := map[string]string{
-		"type":    "initial",
-		"title":   document.Title,
-		"content": document.Content,
+	// Send the current state through the hub so it is ordered with concurrent edits
+	err = shared.Open(client.ID, func(state realtime.State) {
+		c.hub.SendTo(client, realtime.InitialMessage(state))
+	})
+	if err != nil {
+		log.Printf("Failed to encode document state: %v", err)
+		return
 	}
 
-	ws.WriteJSON(initialState)
+	c.announce(client, shared)
+	defer c.leave(client, shared)
 
 	for {
 		_, msg, err := ws.ReadMessage()
 		if err != nil {
 			log.Printf("Read error: %v", err)
-			delete(c.clients, ws)
 			break
 		}
 
-		var update map[string]string
+		var update realtime.ClientMessage
 		if err := json.Unmarshal(msg, &update); err != nil {
 			log.Printf("Failed to unmarshal message: %v", err)
 			continue
 		}
 
-		if update["type"] == "title" {
-			document.Title = update["title"]
-		} else if update["type"] == "content" {
-			document.Content = update["content"]
+		switch {
+		case update.Type == realtime.MessageCursor && update.Cursor != nil:
+			err = shared.SetCursor(client.ID, update.Revision, *update.Cursor, func(cursor realtime.Cursor, state realtime.State) {
+				c.hub.BroadcastFrom(client, realtime.CursorMessage(client.ID, cursor, state.Revision), nil)
+			})
+		case !c.mayEdit(ctx, client):
+			// Viewers and commenters only receive updates
+			err = realtime.ErrReadOnly
+		case update.Type == realtime.MessageOp:
+			err = shared.Submit(client.ID, update.Revision, update.Op, func(applied realtime.Operation, state realtime.State) {
+				c.saver.Save(documentID, userID, state, nil)
+				c.hub.BroadcastFrom(client, realtime.OpMessage(applied, state.Revision), realtime.AckMessage(state.Revision))
+			})
+		case update.Type == realtime.MessageUpdate:
+			err = shared.Merge(update.Updates, func(applied []realtime.Update, state realtime.State) {
+				c.saver.Save(documentID, userID, state, applied)
+				c.hub.BroadcastFrom(client, realtime.UpdateMessage(applied, state.Revision), realtime.AckMessage(state.Revision))
+			})
+		case update.Type == realtime.MessageTitle:
+			shared.SetTitle(update.Title, func(state realtime.State) {
+				c.saver.Save(documentID, userID, state, nil)
+				c.hub.Broadcast(documentID, realtime.TitleMessage(state))
+			})
+		default:
+			err = fmt.Errorf("unknown message type %q", update.Type)
 		}
 
-		var updatedDoc domain.Document
-		updatedDoc.ID = document.ID
-		updatedDoc.Title = document.Title
-		updatedDoc.Content = document.Content
-
-		// Update the document and broadcast the new content
-		if _, err = c.docService.UpdateDocument(ctx, &updatedDoc); err != nil {
-			log.Printf("Failed to update document: %v", err)
+		if err != nil {
+			log.Printf("Rejected message: %v", err)
+			c.hub.SendTo(client, realtime.ErrorMessage(err, shared.Snapshot().Revision))
 		}
+	}
+}
 
-		c.broadcast <- update
+// mayEdit reports whether the client's user may edit its document. Roles can
+// change while a connection is open, so this is checked on every write, and a
+// user who can no longer even view the document is disconnected.
+func (c *webSocketController) mayEdit(ctx context.Context, client *realtime.Client) bool {
+	role, err := c.authzService.Authorize(ctx, client.DocumentID, client.UserID, domain.RoleEditor)
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, service.ErrForbidden) && role == "", errors.Is(err, service.ErrDocumentNotFound):
+		client.Kick(kickReasonRevoked)
+	case !errors.Is(err, service.ErrForbidden):
+		log.Printf("Failed to check edit access: %v", err)
 	}
+	return false
 }
 
-// HandleMessages listens for new messages on the broadcast channel and sends them to all clients
-func (c *webSocketController) HandleMessages(ctx context.Context) {
-	for {
-		select {
-		case update := <-c.broadcast:
-			for client := range c.clients {
-				if err := client.WriteJSON(update); err != nil {
-					log.Printf("Write error: %v", err)
-					client.Close()
-					delete(c.clients, client)
-				}
+// announce sends the new client the roster of everyone else in the room and tells them it joined
+func (c *webSocketController) announce(client *realtime.Client, shared *realtime.Document) {
+	shared.Roster(func(cursors map[string]realtime.Cursor, state realtime.State) {
+		var others []realtime.Presence
+		for _, other := range c.hub.Clients(client.DocumentID) {
+			if other == client {
+				continue
+			}
+			presence := realtime.PresenceOf(other)
+			if cursor, ok := cursors[other.ID]; ok {
+				presence.Cursor = &cursor
 			}
-		case <-ctx.Done():
-			log.Println("Stopping message broadcasting due to context cancellation")
-			return
+			others = append(others, presence)
 		}
-	}
+
+		c.hub.SendTo(client, realtime.RosterMessage(client.ID, others, state.Revision))
+		c.hub.BroadcastFrom(client, realtime.JoinMessage(realtime.PresenceOf(client), state.Revision), nil)
+	})
+}
+
+// leave drops the client's cursor and tells the rest of the room it left
+func (c *webSocketController) leave(client *realtime.Client, shared *realtime.Document) {
+	shared.Leave(client.ID)
+	c.hub.BroadcastFrom(client, realtime.LeaveMessage(client.ID, shared.Snapshot().Revision), nil)
+}
+
+// HandleMessages runs the hub, delivering each update only to the clients of its document
+func (c *webSocketController) HandleMessages(ctx context.Context) {
+	c.hub.Run(ctx)
 }
@@ -0,0 +1,218 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"rtdocs/middleware"
+	"rtdocs/model/domain"
+	"rtdocs/realtime"
+	"rtdocs/service"
+	"rtdocs/utils"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+)
+
+type openDocuments struct {
+	service.DocumentService
+	documents map[string]*domain.Document
+}
+
+func (d *openDocuments) GetDocument(ctx context.Context, id string) (*domain.Document, error) {
+	return d.documents[id], nil
+}
+
+// newWebSocketServer serves /ws/{id} for a single OT document containing
+// content, authenticating each connection as the user in its user parameter
+func newWebSocketServer(t *testing.T, authz service.AuthorizationService, content string) *httptest.Server {
+	t.Helper()
+
+	docs := &openDocuments{documents: map[string]*domain.Document{
+		"doc": {ID: "doc", Title: "Notes", Content: content, SyncMode: realtime.ModeOT},
+	}}
+	c := NewWebSocketController(docs, &nopSaver{}, authz, realtime.NewHub())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go c.HandleMessages(ctx)
+
+	router := mux.NewRouter()
+	router.HandleFunc("/ws/{id}", func(w http.ResponseWriter, r *http.Request) {
+		user := r.URL.Query().Get("user")
+		principal := &utils.Principal{UserID: user, Username: user}
+		c.HandleConnections(w, r.WithContext(middleware.WithPrincipal(r.Context(), principal)))
+	})
+	server := httptest.NewServer(router)
+
+	t.Cleanup(func() {
+		server.Close()
+		cancel()
+	})
+	return server
+}
+
+func dial(t *testing.T, server *httptest.Server, user string) *websocket.Conn {
+	t.Helper()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/ws/doc?user="+user, nil)
+	if err != nil {
+		t.Fatalf("dialling as %s: %v", user, err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// expect reads frames until one of type messageType arrives, skipping the rest
+func expect(t *testing.T, conn *websocket.Conn, messageType string) *realtime.ServerMessage {
+	t.Helper()
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		var msg realtime.ServerMessage
+		if err := conn.ReadJSON(&msg); err != nil {
+			t.Fatalf("waiting for %q: %v", messageType, err)
+		}
+		if msg.Type == messageType {
+			return &msg
+		}
+	}
+}
+
+func send(t *testing.T, conn *websocket.Conn, msg realtime.ClientMessage) {
+	t.Helper()
+
+	if err := conn.WriteJSON(msg); err != nil {
+		t.Fatalf("sending %q: %v", msg.Type, err)
+	}
+}
+
+func TestEditAccessIsRechecked(t *testing.T) {
+	authz := &roles{of: map[string]string{"ana": domain.RoleEditor}}
+	server := newWebSocketServer(t, authz, "hello")
+	conn := dial(t, server, "ana")
+
+	initial := expect(t, conn, realtime.MessageInitial)
+	if initial.Content == nil || *initial.Content != "hello" {
+		t.Fatalf("initial = %+v, want hello", initial)
+	}
+
+	send(t, conn, realtime.ClientMessage{Type: realtime.MessageOp, Revision: 0, Op: realtime.Operation{{Retain: 5}, {Insert: "!"}}})
+	if ack := expect(t, conn, realtime.MessageAck); ack.Revision != 1 {
+		t.Fatalf("ack revision = %d, want 1", ack.Revision)
+	}
+
+	// Lowered to viewer while connected: writes are refused, the connection stays
+	authz.set("ana", domain.RoleViewer)
+	send(t, conn, realtime.ClientMessage{Type: realtime.MessageOp, Revision: 1, Op: realtime.Operation{{Retain: 6}, {Insert: "?"}}})
+	if msg := expect(t, conn, realtime.MessageError); msg.Error != realtime.ErrReadOnly.Error() {
+		t.Fatalf("op after downgrade: error %q, want %q", msg.Error, realtime.ErrReadOnly)
+	}
+	send(t, conn, realtime.ClientMessage{Type: realtime.MessageTitle, Title: "Renamed"})
+	if msg := expect(t, conn, realtime.MessageError); msg.Error != realtime.ErrReadOnly.Error() {
+		t.Fatalf("title after downgrade: error %q, want %q", msg.Error, realtime.ErrReadOnly)
+	}
+
+	// Revoked while connected: the next write disconnects
+	authz.set("ana", "")
+	send(t, conn, realtime.ClientMessage{Type: realtime.MessageOp, Revision: 1, Op: realtime.Operation{{Retain: 6}, {Insert: "?"}}})
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		var msg realtime.ServerMessage
+		err := conn.ReadJSON(&msg)
+		if err == nil {
+			continue
+		}
+		var closed *websocket.CloseError
+		if !errors.As(err, &closed) || closed.Code != websocket.ClosePolicyViolation || closed.Text != kickReasonRevoked {
+			t.Fatalf("after revoke: %v, want a policy violation close", err)
+		}
+		break
+	}
+}
+
+func TestPresence(t *testing.T) {
+	authz := &roles{of: map[string]string{"ana": domain.RoleEditor, "bo": domain.RoleViewer, "cy": domain.RoleViewer}}
+	server := newWebSocketServer(t, authz, "hello world")
+
+	ana := dial(t, server, "ana")
+	roster := expect(t, ana, realtime.MessageRoster)
+	if len(roster.Clients) != 0 {
+		t.Fatalf("first roster = %+v, want nobody else", roster.Clients)
+	}
+	anaID := roster.ClientID
+
+	bo := dial(t, server, "bo")
+	roster = expect(t, bo, realtime.MessageRoster)
+	if len(roster.Clients) != 1 || roster.Clients[0].ClientID != anaID || roster.Clients[0].Username != "ana" {
+		t.Fatalf("second roster = %+v, want ana", roster.Clients)
+	}
+	boID := roster.ClientID
+	if join := expect(t, ana, realtime.MessageJoin); join.Client == nil || join.Client.ClientID != boID || join.Client.UserID != "bo" {
+		t.Fatalf("join = %+v, want bo", join.Client)
+	}
+
+	// Viewers may move their cursor; it is relayed to everyone else
+	send(t, bo, realtime.ClientMessage{Type: realtime.MessageCursor, Revision: 0, Cursor: &realtime.Cursor{Anchor: 6, Head: 11}})
+	cursor := expect(t, ana, realtime.MessageCursor)
+	if cursor.ClientID != boID || *cursor.Cursor != (realtime.Cursor{Anchor: 6, Head: 11}) {
+		t.Fatalf("cursor = %s %+v, want bo's selection of world", cursor.ClientID, cursor.Cursor)
+	}
+
+	// An edit before the cursor moves it along with the text
+	send(t, ana, realtime.ClientMessage{Type: realtime.MessageOp, Revision: 0, Op: realtime.Operation{{Insert: ">> "}, {Retain: 11}}})
+	expect(t, ana, realtime.MessageAck)
+	expect(t, bo, realtime.MessageOp)
+
+	cy := dial(t, server, "cy")
+	roster = expect(t, cy, realtime.MessageRoster)
+	if roster.Revision != 1 || len(roster.Clients) != 2 {
+		t.Fatalf("third roster = %+v at revision %d, want ana and bo at 1", roster.Clients, roster.Revision)
+	}
+	for _, other := range roster.Clients {
+		switch other.ClientID {
+		case anaID:
+			if other.Cursor != nil {
+				t.Errorf("ana's cursor = %+v, want none", other.Cursor)
+			}
+		case boID:
+			if other.Cursor == nil || *other.Cursor != (realtime.Cursor{Anchor: 9, Head: 14}) {
+				t.Errorf("bo's cursor = %+v, want it moved past the insert", other.Cursor)
+			}
+		default:
+			t.Errorf("unexpected client %+v in roster", other)
+		}
+	}
+
+	bo.Close()
+	for _, conn := range []*websocket.Conn{ana, cy} {
+		if leave := expect(t, conn, realtime.MessageLeave); leave.ClientID != boID {
+			t.Fatalf("leave = %s, want bo", leave.ClientID)
+		}
+	}
+}
+
+func TestCursorAgainstOldRevision(t *testing.T) {
+	authz := &roles{of: map[string]string{"ana": domain.RoleEditor, "bo": domain.RoleViewer}}
+	server := newWebSocketServer(t, authz, "abc")
+
+	ana := dial(t, server, "ana")
+	expect(t, ana, realtime.MessageRoster)
+	bo := dial(t, server, "bo")
+	expect(t, bo, realtime.MessageRoster)
+	expect(t, ana, realtime.MessageJoin)
+
+	// ana deletes the first character before bo's cursor, made at revision 0, arrives
+	send(t, ana, realtime.ClientMessage{Type: realtime.MessageOp, Revision: 0, Op: realtime.Operation{{Delete: 1}, {Retain: 2}}})
+	expect(t, ana, realtime.MessageAck)
+	expect(t, bo, realtime.MessageOp)
+
+	send(t, bo, realtime.ClientMessage{Type: realtime.MessageCursor, Revision: 0, Cursor: &realtime.Cursor{Anchor: 3, Head: 3}})
+	cursor := expect(t, ana, realtime.MessageCursor)
+	if cursor.Revision != 1 || *cursor.Cursor != (realtime.Cursor{Anchor: 2, Head: 2}) {
+		t.Fatalf("cursor = %+v at revision %d, want 2 at revision 1", cursor.Cursor, cursor.Revision)
+	}
+}
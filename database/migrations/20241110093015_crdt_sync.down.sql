@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS idx_document_crdt_updates_document_id;
+DROP TABLE IF EXISTS document_crdt_updates;
+DROP TABLE IF EXISTS document_crdt_states;
+ALTER TABLE docs DROP COLUMN IF EXISTS sync_mode;
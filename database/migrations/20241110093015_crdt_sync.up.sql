@@ -0,0 +1,17 @@
+ALTER TABLE docs
+  ADD COLUMN "sync_mode" VARCHAR(10) CHECK (sync_mode IN ('ot', 'crdt')) NOT NULL DEFAULT 'ot';
+
+CREATE TABLE document_crdt_states (
+    document_id UUID PRIMARY KEY REFERENCES docs(id) ON DELETE CASCADE,
+    state BYTEA NOT NULL,
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE document_crdt_updates (
+    id BIGSERIAL PRIMARY KEY,
+    document_id UUID NOT NULL REFERENCES docs(id) ON DELETE CASCADE,
+    payload BYTEA NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_document_crdt_updates_document_id ON document_crdt_updates(document_id);
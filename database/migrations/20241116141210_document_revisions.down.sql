@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_document_revisions_document_id;
+DROP TABLE IF EXISTS document_revisions;
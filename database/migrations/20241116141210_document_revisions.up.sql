@@ -0,0 +1,10 @@
+CREATE TABLE document_revisions (
+    id UUID PRIMARY KEY,
+    document_id UUID NOT NULL REFERENCES docs(id) ON DELETE CASCADE,
+    title VARCHAR(255) NOT NULL,
+    content TEXT NOT NULL DEFAULT '',
+    author_id UUID,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_document_revisions_document_id ON document_revisions(document_id, created_at DESC);
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_document_permissions_user_id;
+DROP TABLE IF EXISTS document_permissions;
@@ -0,0 +1,11 @@
+CREATE TABLE document_permissions (
+    document_id UUID NOT NULL REFERENCES docs(id) ON DELETE CASCADE,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    role VARCHAR(20) CHECK (role IN ('editor', 'commenter', 'viewer')) NOT NULL,
+    granted_by UUID,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (document_id, user_id)
+);
+
+CREATE INDEX idx_document_permissions_user_id ON document_permissions(user_id);
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS idx_sessions_user_id;
+ALTER TABLE sessions
+  DROP COLUMN IF EXISTS rotated_at,
+  DROP COLUMN IF EXISTS revoked_at;
@@ -0,0 +1,5 @@
+ALTER TABLE sessions
+  ADD COLUMN "rotated_at" TIMESTAMP WITH TIME ZONE,
+  ADD COLUMN "revoked_at" TIMESTAMP WITH TIME ZONE;
+
+CREATE INDEX idx_sessions_user_id ON sessions(user_id);
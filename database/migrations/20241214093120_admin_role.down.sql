@@ -0,0 +1,4 @@
+UPDATE users SET role = 'authenticated' WHERE role = 'admin';
+ALTER TABLE users DROP CONSTRAINT IF EXISTS users_role_check;
+ALTER TABLE users
+  ADD CONSTRAINT users_role_check CHECK (role IN ('authenticated', 'guest'));
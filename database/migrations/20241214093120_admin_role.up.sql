@@ -0,0 +1,5 @@
+-- The first admin has to be promoted by hand:
+--   UPDATE users SET role = 'admin' WHERE username = '...';
+ALTER TABLE users DROP CONSTRAINT IF EXISTS users_role_check;
+ALTER TABLE users
+  ADD CONSTRAINT users_role_check CHECK (role IN ('authenticated', 'guest', 'admin'));
@@ -0,0 +1,3 @@
+ALTER TABLE users
+  ADD COLUMN "disabled_at" TIMESTAMP WITH TIME ZONE,
+  ADD COLUMN "password_reset_required" BOOLEAN NOT NULL DEFAULT FALSE;
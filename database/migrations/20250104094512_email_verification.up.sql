@@ -0,0 +1,16 @@
+ALTER TABLE users
+  ADD COLUMN "verified" BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- Accounts that predate verification keep everything they could already do
+UPDATE users SET verified = TRUE WHERE role <> 'guest';
+
+CREATE TABLE email_verification_tokens (
+    token_hash TEXT PRIMARY KEY,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    email VARCHAR(255) NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    used_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX idx_email_verification_tokens_user_id ON email_verification_tokens(user_id);
@@ -0,0 +1,13 @@
+-- MFA tokens that have been exchanged for a session, so none can be used twice.
+-- Rows are only needed until the token expires.
+CREATE TABLE mfa_challenges (
+    token_id VARCHAR(64) PRIMARY KEY,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+CREATE INDEX idx_mfa_challenges_expires_at ON mfa_challenges(expires_at);
+
+-- Recovery codes are now salted; the old unsalted hashes can no longer be
+-- checked, so users with two-factor authentication must generate new codes
+DELETE FROM mfa_recovery_codes;
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_login_attempts_attempted_at;
+DROP TABLE IF EXISTS login_throttles;
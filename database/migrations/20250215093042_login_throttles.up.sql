@@ -0,0 +1,14 @@
+-- Running failure counts for throttling logins, one row per username and per
+-- IP address. Each attempt is counted with a single upsert, so concurrent
+-- attempts cannot all see the count from before any of them.
+CREATE TABLE login_throttles (
+    key VARCHAR(300) PRIMARY KEY,
+    failures INTEGER NOT NULL,
+    last_failure_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    previous_failure_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX idx_login_throttles_last_failure_at ON login_throttles(last_failure_at);
+
+-- login_attempts is now only an audit log and is pruned by age
+CREATE INDEX idx_login_attempts_attempted_at ON login_attempts(attempted_at);
@@ -0,0 +1,10 @@
+-- Users the identity provider put in a SCIM group. Only these are taken out of
+-- the group's role when it changes the group; admins made by hand or by the
+-- LDAP directory are not its to remove.
+CREATE TABLE scim_group_members (
+    group_id VARCHAR(64) NOT NULL,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    PRIMARY KEY (group_id, user_id)
+);
+
+CREATE INDEX idx_scim_group_members_user_id ON scim_group_members(user_id);
@@ -0,0 +1,195 @@
+package diff
+
+import (
+	"cmp"
+	"slices"
+)
+
+const (
+	Equal  = "equal"
+	Insert = "insert"
+	Delete = "delete"
+)
+
+// maxCost bounds the number of edits searched for at each step. Past it the
+// search settles for the furthest point it reached, so two large unrelated
+// texts still diff in bounded time; the script stays valid but may not be
+// the shortest.
+const maxCost = 1024
+
+// Edit is one token of an edit script that turns a into b
+type Edit struct {
+	Type string
+	Text string
+}
+
+// Diff returns the shortest edit script between a and b using the linear
+// space variant of Myers' algorithm, which splits the problem at the middle
+// of an optimal path and recurses on both halves
+func Diff(a, b []string) []Edit {
+	if len(a)+len(b) == 0 {
+		return nil
+	}
+
+	var edits []Edit
+	compare(a, b, &edits)
+	groupChanges(edits)
+	return edits
+}
+
+// groupChanges puts the deletions of each run of changes before its
+// insertions, as a unified diff shows them
+func groupChanges(edits []Edit) {
+	for start := 0; start < len(edits); {
+		if edits[start].Type == Equal {
+			start++
+			continue
+		}
+		end := start
+		for end < len(edits) && edits[end].Type != Equal {
+			end++
+		}
+		slices.SortStableFunc(edits[start:end], func(x, y Edit) int {
+			return cmp.Compare(changeOrder(x), changeOrder(y))
+		})
+		start = end
+	}
+}
+
+func changeOrder(e Edit) int {
+	if e.Type == Delete {
+		return 0
+	}
+	return 1
+}
+
+// compare appends the edits that turn a into b
+func compare(a, b []string, edits *[]Edit) {
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		*edits = append(*edits, Edit{Type: Equal, Text: a[prefix]})
+		prefix++
+	}
+	a, b = a[prefix:], b[prefix:]
+
+	suffix := 0
+	for suffix < len(a) && suffix < len(b) && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+	common := a[len(a)-suffix:]
+	a, b = a[:len(a)-suffix], b[:len(b)-suffix]
+
+	x, y, ok := 0, 0, len(a) > 0 && len(b) > 0
+	if ok {
+		x, y, ok = split(a, b)
+	}
+	if ok {
+		compare(a[:x], b[:y], edits)
+		compare(a[x:], b[y:], edits)
+	} else {
+		for _, text := range a {
+			*edits = append(*edits, Edit{Type: Delete, Text: text})
+		}
+		for _, text := range b {
+			*edits = append(*edits, Edit{Type: Insert, Text: text})
+		}
+	}
+
+	for _, text := range common {
+		*edits = append(*edits, Edit{Type: Equal, Text: text})
+	}
+}
+
+// split finds a point on a shortest path from the start of a and b to their
+// end by searching from both ends at once until the searches overlap. It
+// reports false when a and b have nothing in common, or when the search gave
+// up at maxCost without reaching a point short of either end.
+func split(a, b []string) (int, int, bool) {
+	n, m := len(a), len(b)
+	delta := n - m
+	odd := delta%2 != 0
+
+	// forward[offset+k] is the furthest x reached from the start on diagonal
+	// k = x - y; backward[offset+k] is how far from the end the search from
+	// the end got on diagonal k, counted the same way from the other corner
+	limit := min((n+m+1)/2, maxCost)
+	offset := limit + 1
+	forward := make([]int, 2*offset+1)
+	backward := make([]int, 2*offset+1)
+	for i := range forward {
+		forward[i], backward[i] = -1, -1
+	}
+	forward[offset+1], backward[offset+1] = 0, 0
+
+	// Diagonals that ran off the edge of the graph are not searched again
+	var forwardStart, forwardEnd, backwardStart, backwardEnd int
+	var bestX, bestY int
+
+	for cost := 0; cost < limit; cost++ {
+		for k := -cost + forwardStart; k <= cost-forwardEnd; k += 2 {
+			var x int
+			if k == -cost || (k != cost && forward[offset+k-1] < forward[offset+k+1]) {
+				x = forward[offset+k+1]
+			} else {
+				x = forward[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			forward[offset+k] = x
+
+			switch {
+			case x > n:
+				forwardEnd += 2
+			case y > m:
+				forwardStart += 2
+			default:
+				if x+y > bestX+bestY {
+					bestX, bestY = x, y
+				}
+				if other := offset + delta - k; odd && other >= 0 && other < len(backward) && backward[other] != -1 {
+					if x >= n-backward[other] {
+						return x, y, true
+					}
+				}
+			}
+		}
+
+		for k := -cost + backwardStart; k <= cost-backwardEnd; k += 2 {
+			var x int
+			if k == -cost || (k != cost && backward[offset+k-1] < backward[offset+k+1]) {
+				x = backward[offset+k+1]
+			} else {
+				x = backward[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[n-x-1] == b[m-y-1] {
+				x++
+				y++
+			}
+			backward[offset+k] = x
+
+			switch {
+			case x > n:
+				backwardEnd += 2
+			case y > m:
+				backwardStart += 2
+			default:
+				if other := offset + delta - k; !odd && other >= 0 && other < len(forward) && forward[other] != -1 {
+					forwardX := forward[other]
+					forwardY := forwardX - (other - offset)
+					if forwardX >= n-x {
+						return forwardX, forwardY, true
+					}
+				}
+			}
+		}
+	}
+
+	// The searches never met or gave up; settle for the furthest point the
+	// forward one reached, which is only a split if it is short of either end
+	ok := bestX+bestY > 0 && bestX+bestY < n+m
+	return bestX, bestY, ok
+}
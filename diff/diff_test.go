@@ -0,0 +1,136 @@
+package diff
+
+import (
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b string
+		want string
+	}{
+		{"empty", "", "", ""},
+		{"identical", "abc", "abc", "=a =b =c"},
+		{"insert only", "", "ab", "+a +b"},
+		{"delete only", "ab", "", "-a -b"},
+		{"insert in middle", "ac", "abc", "=a +b =c"},
+		{"delete in middle", "abc", "ac", "=a -b =c"},
+		{"replace", "abc", "axc", "=a -b +x =c"},
+		{"nothing in common", "ab", "xy", "-a -b +x +y"},
+		{"unicode", "héllo", "hëllo", "=h -é +ë =l =l =o"},
+		{"emoji", "a😀b", "a😀c😀b", "=a =😀 +c +😀 =b"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			edits := Diff(codePoints(tt.a), codePoints(tt.b))
+			if got := script(edits); got != tt.want {
+				t.Fatalf("Diff(%q, %q) = %q, want %q", tt.a, tt.b, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestDiffIsShortest checks random inputs against a quadratic LCS
+func TestDiffIsShortest(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 500; i++ {
+		a, b := randomTokens(rng, rng.Intn(30)), randomTokens(rng, rng.Intn(30))
+		edits := Diff(a, b)
+		assertTransforms(t, a, b, edits)
+
+		changes := 0
+		for _, e := range edits {
+			if e.Type != Equal {
+				changes++
+			}
+		}
+		if want := len(a) + len(b) - 2*lcs(a, b); changes != want {
+			t.Fatalf("Diff(%v, %v) made %d changes, want %d", a, b, changes, want)
+		}
+	}
+}
+
+// TestDiffBoundsCost checks that large unrelated inputs still give a valid script
+func TestDiffBoundsCost(t *testing.T) {
+	rng := rand.New(rand.NewSource(2))
+	a, b := randomTokens(rng, 20000), randomTokens(rng, 20000)
+	assertTransforms(t, a, b, Diff(a, b))
+}
+
+func TestHunks(t *testing.T) {
+	a := "one\ntwo\nthree\nfour\nfive\nsix\nseven\neight\nnine\nten"
+	b := "one\ntwo\nthree\nfour\nfive\nsix\nseven\neight\nnine\nTEN"
+
+	hunks := Hunks(a, b, 3)
+	if len(hunks) != 1 {
+		t.Fatalf("got %d hunks, want 1", len(hunks))
+	}
+	if h := hunks[0]; h.OldStart != 7 || h.OldLines != 4 || h.NewStart != 7 || h.NewLines != 4 {
+		t.Fatalf("hunk = -%d,%d +%d,%d, want -7,4 +7,4", h.OldStart, h.OldLines, h.NewStart, h.NewLines)
+	}
+	if Hunks(a, a, 3) != nil {
+		t.Fatal("identical texts gave hunks")
+	}
+}
+
+// codePoints breaks s into code points
+func codePoints(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, "")
+}
+
+func script(edits []Edit) string {
+	marks := map[string]string{Equal: "=", Insert: "+", Delete: "-"}
+	parts := make([]string, len(edits))
+	for i, e := range edits {
+		parts[i] = marks[e.Type] + e.Text
+	}
+	return strings.Join(parts, " ")
+}
+
+func assertTransforms(t *testing.T, a, b []string, edits []Edit) {
+	t.Helper()
+
+	var gotA, gotB []string
+	for _, e := range edits {
+		if e.Type != Insert {
+			gotA = append(gotA, e.Text)
+		}
+		if e.Type != Delete {
+			gotB = append(gotB, e.Text)
+		}
+	}
+	if strings.Join(gotA, "\x00") != strings.Join(a, "\x00") || strings.Join(gotB, "\x00") != strings.Join(b, "\x00") {
+		t.Fatalf("edit script does not turn a into b")
+	}
+}
+
+func randomTokens(rng *rand.Rand, n int) []string {
+	tokens := make([]string, n)
+	for i := range tokens {
+		tokens[i] = string(rune('a' + rng.Intn(4)))
+	}
+	return tokens
+}
+
+func lcs(a, b []string) int {
+	prev := make([]int, len(b)+1)
+	for i := range a {
+		cur := make([]int, len(b)+1)
+		for j := range b {
+			if a[i] == b[j] {
+				cur[j+1] = prev[j] + 1
+			} else {
+				cur[j+1] = max(prev[j+1], cur[j])
+			}
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
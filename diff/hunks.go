@@ -0,0 +1,230 @@
+package diff
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// Segment is a run of words inside a changed line
+type Segment struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+// Line is one line of a hunk. Changed lines that pair up with a line on the
+// other side carry a word-level breakdown of what changed within them.
+type Line struct {
+	Type  string    `json:"type"`
+	Text  string    `json:"text"`
+	Words []Segment `json:"words,omitempty"`
+}
+
+// Hunk is a group of nearby changes with surrounding context, numbered like
+// a unified diff: starts are 1-based and point at the line before the hunk
+// when its side is empty
+type Hunk struct {
+	OldStart int    `json:"old_start"`
+	OldLines int    `json:"old_lines"`
+	NewStart int    `json:"new_start"`
+	NewLines int    `json:"new_lines"`
+	Lines    []Line `json:"lines"`
+}
+
+// Hunks diffs a and b line by line, keeping context unchanged lines around each change
+func Hunks(a, b string, context int) []Hunk {
+	edits := Diff(splitLines(a), splitLines(b))
+
+	// oldPos[i] and newPos[i] are the 0-based line numbers edit i starts at
+	oldPos := make([]int, len(edits)+1)
+	newPos := make([]int, len(edits)+1)
+	for i, e := range edits {
+		oldPos[i+1], newPos[i+1] = oldPos[i], newPos[i]
+		if e.Type != Insert {
+			oldPos[i+1]++
+		}
+		if e.Type != Delete {
+			newPos[i+1]++
+		}
+	}
+
+	var hunks []Hunk
+	i := 0
+	for i < len(edits) {
+		for i < len(edits) && edits[i].Type == Equal {
+			i++
+		}
+		if i == len(edits) {
+			break
+		}
+
+		start := max(0, i-context)
+		end := i
+		for {
+			for end < len(edits) && edits[end].Type != Equal {
+				end++
+			}
+			run := end
+			for run < len(edits) && edits[run].Type == Equal {
+				run++
+			}
+			// Merge with the next change when the context between them would overlap
+			if run < len(edits) && run-end <= 2*context {
+				end = run
+				continue
+			}
+			end = min(end+context, run)
+			break
+		}
+
+		hunks = append(hunks, buildHunk(edits[start:end], oldPos[start], newPos[start]))
+		i = end
+	}
+
+	return hunks
+}
+
+func buildHunk(edits []Edit, oldPos, newPos int) Hunk {
+	hunk := Hunk{OldStart: oldPos, NewStart: newPos}
+	for _, e := range edits {
+		hunk.Lines = append(hunk.Lines, Line{Type: e.Type, Text: e.Text})
+		if e.Type != Insert {
+			hunk.OldLines++
+		}
+		if e.Type != Delete {
+			hunk.NewLines++
+		}
+	}
+	if hunk.OldLines > 0 {
+		hunk.OldStart++
+	}
+	if hunk.NewLines > 0 {
+		hunk.NewStart++
+	}
+
+	// Pair the deleted and inserted lines of each change block for word diffs
+	for i := 0; i < len(hunk.Lines); {
+		if hunk.Lines[i].Type == Equal {
+			i++
+			continue
+		}
+		var deleted, inserted []int
+		for ; i < len(hunk.Lines) && hunk.Lines[i].Type != Equal; i++ {
+			if hunk.Lines[i].Type == Delete {
+				deleted = append(deleted, i)
+			} else {
+				inserted = append(inserted, i)
+			}
+		}
+		for j := 0; j < len(deleted) && j < len(inserted); j++ {
+			oldLine, newLine := &hunk.Lines[deleted[j]], &hunk.Lines[inserted[j]]
+			for _, s := range Words(oldLine.Text, newLine.Text) {
+				if s.Type != Insert {
+					oldLine.Words = appendSegment(oldLine.Words, s)
+				}
+				if s.Type != Delete {
+					newLine.Words = appendSegment(newLine.Words, s)
+				}
+			}
+		}
+	}
+
+	return hunk
+}
+
+// Words diffs a and b word by word; whitespace runs and punctuation are tokens of their own
+func Words(a, b string) []Segment {
+	var segments []Segment
+	for _, e := range Diff(tokenize(a), tokenize(b)) {
+		segments = appendSegment(segments, Segment{Type: e.Type, Text: e.Text})
+	}
+	return segments
+}
+
+// Unified renders hunks in unified diff format
+func Unified(fromLabel, toLabel string, hunks []Hunk) string {
+	if len(hunks) == 0 {
+		return ""
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "--- %s\n+++ %s\n", fromLabel, toLabel)
+	for _, h := range hunks {
+		fmt.Fprintf(&b, "@@ -%s +%s @@\n", unifiedRange(h.OldStart, h.OldLines), unifiedRange(h.NewStart, h.NewLines))
+		for _, l := range h.Lines {
+			switch l.Type {
+			case Equal:
+				b.WriteByte(' ')
+			case Delete:
+				b.WriteByte('-')
+			case Insert:
+				b.WriteByte('+')
+			}
+			b.WriteString(l.Text)
+			b.WriteByte('\n')
+		}
+	}
+	return b.String()
+}
+
+func unifiedRange(start, lines int) string {
+	if lines == 1 {
+		return fmt.Sprint(start)
+	}
+	return fmt.Sprintf("%d,%d", start, lines)
+}
+
+func appendSegment(segments []Segment, s Segment) []Segment {
+	if last := len(segments) - 1; last >= 0 && segments[last].Type == s.Type {
+		segments[last].Text += s.Text
+		return segments
+	}
+	return append(segments, s)
+}
+
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
+
+func tokenize(s string) []string {
+	var tokens []string
+	var current []rune
+	class := -1
+
+	for _, r := range s {
+		c := runeClass(r)
+		if c != class || c == classOther {
+			if len(current) > 0 {
+				tokens = append(tokens, string(current))
+			}
+			current = current[:0]
+			class = c
+		}
+		current = append(current, r)
+	}
+	if len(current) > 0 {
+		tokens = append(tokens, string(current))
+	}
+
+	return tokens
+}
+
+const (
+	classWord = iota
+	classSpace
+	classOther
+)
+
+func runeClass(r rune) int {
+	switch {
+	case unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_':
+		return classWord
+	case unicode.IsSpace(r):
+		return classSpace
+	default:
+		return classOther
+	}
+}
@@ -0,0 +1,212 @@
+package ldap
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// BER tag classes and the constructed bit, as used by LDAP (X.690)
+const (
+	classUniversal   = 0x00
+	classApplication = 0x40
+	classContext     = 0x80
+	constructed      = 0x20
+)
+
+// Universal tags
+const (
+	tagBoolean     = 0x01
+	tagInteger     = 0x02
+	tagOctetString = 0x04
+	tagEnumerated  = 0x0a
+	tagSequence    = constructed | 0x10
+	tagSet         = constructed | 0x11
+)
+
+// maxPacketSize bounds what a peer can make us allocate for one message
+const maxPacketSize = 16 << 20
+
+var errMalformed = errors.New("ldap: malformed BER packet")
+
+// packet is a decoded BER element. Constructed elements have children;
+// primitive ones have their raw contents in value.
+type packet struct {
+	tag      byte
+	value    []byte
+	children []*packet
+}
+
+func (p *packet) isConstructed() bool {
+	return p.tag&constructed != 0
+}
+
+func (p *packet) child(i int) (*packet, error) {
+	if i >= len(p.children) {
+		return nil, errMalformed
+	}
+	return p.children[i], nil
+}
+
+func (p *packet) str() string {
+	return string(p.value)
+}
+
+func (p *packet) int() int64 {
+	var v int64
+	for i, b := range p.value {
+		if i == 0 && b&0x80 != 0 {
+			v = -1
+		}
+		v = v<<8 | int64(b)
+	}
+	return v
+}
+
+func (p *packet) bool() bool {
+	return len(p.value) > 0 && p.value[0] != 0
+}
+
+// readPacket reads one complete BER element
+func readPacket(r *bufio.Reader) (*packet, error) {
+	tag, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	length, err := readLength(r)
+	if err != nil {
+		return nil, err
+	}
+	if length > maxPacketSize {
+		return nil, fmt.Errorf("ldap: packet of %d bytes is too large", length)
+	}
+
+	contents := make([]byte, length)
+	if _, err := io.ReadFull(r, contents); err != nil {
+		return nil, err
+	}
+	return parsePacket(tag, contents)
+}
+
+func parsePacket(tag byte, contents []byte) (*packet, error) {
+	p := &packet{tag: tag}
+	if tag&constructed == 0 {
+		p.value = contents
+		return p, nil
+	}
+
+	for len(contents) > 0 {
+		if len(contents) < 2 {
+			return nil, errMalformed
+		}
+		childTag := contents[0]
+		length, n, err := parseLength(contents[1:])
+		if err != nil {
+			return nil, err
+		}
+		start := 1 + n
+		if length > len(contents)-start {
+			return nil, errMalformed
+		}
+		child, err := parsePacket(childTag, contents[start:start+length])
+		if err != nil {
+			return nil, err
+		}
+		p.children = append(p.children, child)
+		contents = contents[start+length:]
+	}
+	return p, nil
+}
+
+func readLength(r *bufio.Reader) (int, error) {
+	first, err := r.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	if first&0x80 == 0 {
+		return int(first), nil
+	}
+
+	n := int(first & 0x7f)
+	if n == 0 || n > 4 {
+		return 0, errMalformed
+	}
+	length := 0
+	for i := 0; i < n; i++ {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		length = length<<8 | int(b)
+	}
+	return length, nil
+}
+
+// parseLength decodes a length from the start of b and returns it and how many bytes it took
+func parseLength(b []byte) (int, int, error) {
+	if len(b) == 0 {
+		return 0, 0, errMalformed
+	}
+	if b[0]&0x80 == 0 {
+		return int(b[0]), 1, nil
+	}
+
+	n := int(b[0] & 0x7f)
+	if n == 0 || n > 4 || len(b) < 1+n {
+		return 0, 0, errMalformed
+	}
+	length := 0
+	for _, c := range b[1 : 1+n] {
+		length = length<<8 | int(c)
+	}
+	return length, 1 + n, nil
+}
+
+// encode writes one BER element with the given tag and contents
+func encode(tag byte, contents []byte) []byte {
+	out := []byte{tag}
+	switch n := len(contents); {
+	case n < 0x80:
+		out = append(out, byte(n))
+	case n <= 0xff:
+		out = append(out, 0x81, byte(n))
+	case n <= 0xffff:
+		out = append(out, 0x82, byte(n>>8), byte(n))
+	default:
+		out = append(out, 0x84, byte(n>>24), byte(n>>16), byte(n>>8), byte(n))
+	}
+	return append(out, contents...)
+}
+
+func encodeConstructed(tag byte, children ...[]byte) []byte {
+	var contents []byte
+	for _, child := range children {
+		contents = append(contents, child...)
+	}
+	return encode(tag, contents)
+}
+
+func encodeString(tag byte, s string) []byte {
+	return encode(tag, []byte(s))
+}
+
+func encodeInt(tag byte, v int64) []byte {
+	// Minimal two's complement
+	var b []byte
+	for {
+		b = append([]byte{byte(v)}, b...)
+		v >>= 8
+		if (v == 0 && b[0]&0x80 == 0) || (v == -1 && b[0]&0x80 != 0) {
+			break
+		}
+	}
+	return encode(tag, b)
+}
+
+func encodeBool(tag byte, v bool) []byte {
+	if v {
+		return encode(tag, []byte{0xff})
+	}
+	return encode(tag, []byte{0x00})
+}
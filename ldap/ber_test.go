@@ -0,0 +1,92 @@
+package ldap
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestIntegerRoundTrip(t *testing.T) {
+	tests := []struct {
+		value int64
+		want  []byte
+	}{
+		{0, []byte{0x02, 0x01, 0x00}},
+		{127, []byte{0x02, 0x01, 0x7f}},
+		{128, []byte{0x02, 0x02, 0x00, 0x80}},
+		{256, []byte{0x02, 0x02, 0x01, 0x00}},
+		{-1, []byte{0x02, 0x01, 0xff}},
+		{-128, []byte{0x02, 0x01, 0x80}},
+		{-129, []byte{0x02, 0x02, 0xff, 0x7f}},
+		{1 << 40, []byte{0x02, 0x06, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00}},
+	}
+
+	for _, tt := range tests {
+		encoded := encodeInt(tagInteger, tt.value)
+		if !bytes.Equal(encoded, tt.want) {
+			t.Errorf("encodeInt(%d) = % x, want % x", tt.value, encoded, tt.want)
+		}
+		p, err := readPacket(bufio.NewReader(bytes.NewReader(encoded)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p.int() != tt.value {
+			t.Errorf("decoded %d as %d", tt.value, p.int())
+		}
+	}
+}
+
+func TestLengthForms(t *testing.T) {
+	// Short form, then one, two and four length bytes
+	for _, n := range []int{0, 0x7f, 0x80, 0xff, 0x100, 0xffff, 0x10000} {
+		value := strings.Repeat("a", n)
+		encoded := encodeConstructed(tagSequence, encodeString(tagOctetString, value), encodeBool(tagBoolean, true))
+
+		p, err := readPacket(bufio.NewReader(bytes.NewReader(encoded)))
+		if err != nil {
+			t.Fatalf("%d bytes: %v", n, err)
+		}
+		if len(p.children) != 2 || p.children[0].str() != value || !p.children[1].bool() {
+			t.Fatalf("%d bytes did not survive a round trip", n)
+		}
+	}
+}
+
+func TestMalformedPackets(t *testing.T) {
+	tests := []struct {
+		name  string
+		input []byte
+	}{
+		{"truncated contents", []byte{0x04, 0x05, 'a', 'b'}},
+		{"truncated length", []byte{0x04, 0x82, 0x01}},
+		{"indefinite length", []byte{0x30, 0x80, 0x00, 0x00}},
+		{"length of more than four bytes", []byte{0x04, 0x85, 0, 0, 0, 0, 1, 'a'}},
+		{"too large", []byte{0x04, 0x84, 0x7f, 0xff, 0xff, 0xff}},
+		{"child longer than its parent", []byte{0x30, 0x03, 0x04, 0x05, 'a'}},
+		{"child without a length", []byte{0x30, 0x01, 0x04}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := readPacket(bufio.NewReader(bytes.NewReader(tt.input))); err == nil {
+				t.Fatal("readPacket accepted it")
+			}
+		})
+	}
+}
+
+func TestFilterEncoding(t *testing.T) {
+	// (&(objectClass=person)(|(uid=a\2a)(!(mail=*)))): the "*" in a value is only a character
+	filter := And(Equal("objectClass", "person"), Or(Equal("uid", "a*"), Not(Present("mail"))))
+	want := encodeConstructed(filterAnd,
+		encodeConstructed(filterEquality, encodeString(tagOctetString, "objectClass"), encodeString(tagOctetString, "person")),
+		encodeConstructed(filterOr,
+			encodeConstructed(filterEquality, encodeString(tagOctetString, "uid"), encodeString(tagOctetString, "a*")),
+			encodeConstructed(filterNot, encodeString(filterPresent, "mail")),
+		),
+	)
+	if got := filter.encode(); !bytes.Equal(got, want) {
+		t.Fatalf("encoded filter = % x, want % x", got, want)
+	}
+}
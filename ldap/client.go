@@ -0,0 +1,331 @@
+// Package ldap is a small LDAPv3 client, enough to authenticate users against
+// a directory with a simple bind and an equality search.
+package ldap
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Protocol operation tags (RFC 4511 section 4.2 onwards)
+const (
+	opBindRequest      = classApplication | constructed | 0
+	opBindResponse     = classApplication | constructed | 1
+	opUnbindRequest    = classApplication | 2
+	opSearchRequest    = classApplication | constructed | 3
+	opSearchEntry      = classApplication | constructed | 4
+	opSearchDone       = classApplication | constructed | 5
+	opSearchReference  = classApplication | constructed | 19
+	opExtendedRequest  = classApplication | constructed | 23
+	opExtendedResponse = classApplication | constructed | 24
+
+	tagSimpleAuth   = classContext | 0
+	tagExtendedName = classContext | 0
+
+	startTLSOID = "1.3.6.1.4.1.1466.20037"
+)
+
+// Result codes (RFC 4511 appendix A)
+const (
+	resultSuccess       = 0
+	resultProtocolError = 2
+	resultSizeLimit     = 4
+	resultNoSuchObject  = 32
+	resultInvalidCreds  = 49
+	resultUnwilling     = 53
+)
+
+// Search scopes
+const (
+	ScopeBaseObject   = 0
+	ScopeSingleLevel  = 1
+	ScopeWholeSubtree = 2
+)
+
+// defaultTimeout applies to each operation when the context has no deadline
+const defaultTimeout = 10 * time.Second
+
+var ErrInvalidCredentials = errors.New("ldap: invalid credentials")
+
+// ResultError is an operation the server answered with a result code other than success
+type ResultError struct {
+	Code    int
+	Message string
+}
+
+func (e *ResultError) Error() string {
+	return fmt.Sprintf("ldap: result code %d: %s", e.Code, e.Message)
+}
+
+// Entry is a directory entry returned by a search
+type Entry struct {
+	DN         string
+	Attributes map[string][]string // keyed by lowercased attribute name
+}
+
+// Values returns the values of an attribute, whatever case its name is given in
+func (e *Entry) Values(attr string) []string {
+	return e.Attributes[strings.ToLower(attr)]
+}
+
+// Value returns the first value of an attribute, or ""
+func (e *Entry) Value(attr string) string {
+	if values := e.Values(attr); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+type SearchRequest struct {
+	BaseDN     string
+	Scope      int
+	Filter     Filter
+	Attributes []string
+	SizeLimit  int
+}
+
+// Conn is a connection to a directory server. Operations are sent one at a time.
+type Conn struct {
+	mu     sync.Mutex
+	conn   net.Conn
+	r      *bufio.Reader
+	nextID int64
+}
+
+// Dial connects to an ldap:// or ldaps:// URL
+func Dial(ctx context.Context, rawURL string, tlsConfig *tls.Config) (*Conn, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+
+	host := u.Host
+	var dialer net.Dialer
+	var conn net.Conn
+	switch u.Scheme {
+	case "ldap":
+		if u.Port() == "" {
+			host = net.JoinHostPort(u.Hostname(), "389")
+		}
+		conn, err = dialer.DialContext(ctx, "tcp", host)
+	case "ldaps":
+		if u.Port() == "" {
+			host = net.JoinHostPort(u.Hostname(), "636")
+		}
+		tlsDialer := tls.Dialer{NetDialer: &dialer, Config: withServerName(tlsConfig, u.Hostname())}
+		conn, err = tlsDialer.DialContext(ctx, "tcp", host)
+	default:
+		return nil, fmt.Errorf("ldap: unsupported URL scheme %q", u.Scheme)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &Conn{conn: conn, r: bufio.NewReader(conn), nextID: 1}, nil
+}
+
+// StartTLS upgrades a plain connection to TLS
+func (c *Conn) StartTLS(ctx context.Context, tlsConfig *tls.Config, serverName string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	request := encodeConstructed(opExtendedRequest, encodeString(tagExtendedName, startTLSOID))
+	response, err := c.roundTrip(ctx, request, opExtendedResponse)
+	if err != nil {
+		return err
+	}
+	if err := resultOf(response); err != nil {
+		return err
+	}
+
+	tlsConn := tls.Client(c.conn, withServerName(tlsConfig, serverName))
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		return err
+	}
+	c.conn = tlsConn
+	c.r = bufio.NewReader(tlsConn)
+	return nil
+}
+
+// Bind authenticates the connection with a simple bind. An empty password is
+// refused here, since servers treat it as an anonymous bind that succeeds.
+func (c *Conn) Bind(ctx context.Context, dn, password string) error {
+	if password == "" {
+		return ErrInvalidCredentials
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	request := encodeConstructed(opBindRequest,
+		encodeInt(tagInteger, 3),
+		encodeString(tagOctetString, dn),
+		encodeString(tagSimpleAuth, password),
+	)
+	response, err := c.roundTrip(ctx, request, opBindResponse)
+	if err != nil {
+		return err
+	}
+
+	err = resultOf(response)
+	var resultErr *ResultError
+	if errors.As(err, &resultErr) && resultErr.Code == resultInvalidCreds {
+		return ErrInvalidCredentials
+	}
+	return err
+}
+
+// Search returns the entries matching a search request
+func (c *Conn) Search(ctx context.Context, req *SearchRequest) ([]*Entry, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	attributes := make([][]byte, len(req.Attributes))
+	for i, attr := range req.Attributes {
+		attributes[i] = encodeString(tagOctetString, attr)
+	}
+	request := encodeConstructed(opSearchRequest,
+		encodeString(tagOctetString, req.BaseDN),
+		encodeInt(tagEnumerated, int64(req.Scope)),
+		encodeInt(tagEnumerated, 0), // never dereference aliases
+		encodeInt(tagInteger, int64(req.SizeLimit)),
+		encodeInt(tagInteger, 0),
+		encodeBool(tagBoolean, false),
+		req.Filter.encode(),
+		encodeConstructed(tagSequence, attributes...),
+	)
+
+	id, err := c.send(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []*Entry
+	for {
+		op, err := c.receive(id)
+		if err != nil {
+			return nil, err
+		}
+		switch op.tag {
+		case opSearchEntry:
+			entry, err := decodeEntry(op)
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, entry)
+		case opSearchReference:
+			// Referrals to other servers are not followed
+		case opSearchDone:
+			return entries, resultOf(op)
+		default:
+			return nil, errMalformed
+		}
+	}
+}
+
+// Close unbinds and closes the connection
+func (c *Conn) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.conn.SetWriteDeadline(time.Now().Add(time.Second))
+	c.conn.Write(encodeConstructed(tagSequence, encodeInt(tagInteger, c.nextID), encode(opUnbindRequest, nil)))
+	return c.conn.Close()
+}
+
+// roundTrip sends a request and reads its single response, which must have the tag want
+func (c *Conn) roundTrip(ctx context.Context, request []byte, want byte) (*packet, error) {
+	id, err := c.send(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+	op, err := c.receive(id)
+	if err != nil {
+		return nil, err
+	}
+	if op.tag != want {
+		return nil, errMalformed
+	}
+	return op, nil
+}
+
+// send wraps a protocol operation in an LDAPMessage and writes it, returning its message ID
+func (c *Conn) send(ctx context.Context, op []byte) (int64, error) {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(defaultTimeout)
+	}
+	if err := c.conn.SetDeadline(deadline); err != nil {
+		return 0, err
+	}
+
+	id := c.nextID
+	c.nextID++
+	_, err := c.conn.Write(encodeConstructed(tagSequence, encodeInt(tagInteger, id), op))
+	return id, err
+}
+
+// receive reads the next message, which must answer message id, and returns its protocol operation
+func (c *Conn) receive(id int64) (*packet, error) {
+	message, err := readPacket(c.r)
+	if err != nil {
+		return nil, err
+	}
+	if message.tag != tagSequence || len(message.children) < 2 {
+		return nil, errMalformed
+	}
+	if message.children[0].int() != id {
+		return nil, fmt.Errorf("ldap: response to message %d while waiting for %d", message.children[0].int(), id)
+	}
+	return message.children[1], nil
+}
+
+// resultOf returns the error an LDAPResult reports, if any
+func resultOf(op *packet) error {
+	if len(op.children) < 3 {
+		return errMalformed
+	}
+	code := int(op.children[0].int())
+	if code == resultSuccess {
+		return nil
+	}
+	return &ResultError{Code: code, Message: op.children[2].str()}
+}
+
+func decodeEntry(op *packet) (*Entry, error) {
+	if len(op.children) < 2 {
+		return nil, errMalformed
+	}
+
+	entry := &Entry{DN: op.children[0].str(), Attributes: make(map[string][]string)}
+	for _, attr := range op.children[1].children {
+		if len(attr.children) < 2 {
+			return nil, errMalformed
+		}
+		name := strings.ToLower(attr.children[0].str())
+		for _, value := range attr.children[1].children {
+			entry.Attributes[name] = append(entry.Attributes[name], value.str())
+		}
+	}
+	return entry, nil
+}
+
+func withServerName(config *tls.Config, serverName string) *tls.Config {
+	if config == nil {
+		config = &tls.Config{}
+	} else {
+		config = config.Clone()
+	}
+	if config.ServerName == "" {
+		config.ServerName = serverName
+	}
+	return config
+}
@@ -0,0 +1,212 @@
+package ldap
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"rtdocs/utils"
+	"slices"
+	"strings"
+)
+
+// Defaults used when the corresponding LDAP_* variable is unset
+const (
+	defaultUserAttribute   = "uid"
+	defaultUserObjectClass = "person"
+	defaultEmailAttribute  = "mail"
+)
+
+var ErrUserNotFound = errors.New("ldap: user not found")
+
+type Config struct {
+	URL             string
+	BindDN          string // service account used to look users up; empty for an anonymous search
+	BindPassword    string
+	BaseDN          string
+	UserAttribute   string // holds the username, e.g. uid or sAMAccountName
+	UserObjectClass string
+	EmailAttribute  string
+	GroupBaseDN     string // groups listing users in member, uniqueMember or memberUid; empty to rely on memberOf
+	StartTLS        bool
+}
+
+// Account is a user the directory authenticated
+type Account struct {
+	DN       string
+	Username string
+	Email    string
+	Groups   []string // DNs of the groups the user is a member of
+}
+
+// Directory authenticates users by searching for their entry and binding as it
+type Directory struct {
+	config Config
+}
+
+// FromEnv returns the directory configured by LDAP_URL, LDAP_BIND_DN,
+// LDAP_BIND_PASSWORD, LDAP_BASE_DN, LDAP_USER_ATTRIBUTE,
+// LDAP_USER_OBJECT_CLASS, LDAP_EMAIL_ATTRIBUTE, LDAP_GROUP_BASE_DN and
+// LDAP_START_TLS, or nil when LDAP_URL is unset
+func FromEnv() (*Directory, error) {
+	rawURL := utils.GetEnv("LDAP_URL")
+	if rawURL == "" {
+		return nil, nil
+	}
+
+	config := Config{
+		URL:             rawURL,
+		BindDN:          utils.GetEnv("LDAP_BIND_DN"),
+		BindPassword:    utils.GetEnv("LDAP_BIND_PASSWORD"),
+		BaseDN:          utils.GetEnv("LDAP_BASE_DN"),
+		UserAttribute:   utils.GetEnv("LDAP_USER_ATTRIBUTE"),
+		UserObjectClass: utils.GetEnv("LDAP_USER_OBJECT_CLASS"),
+		EmailAttribute:  utils.GetEnv("LDAP_EMAIL_ATTRIBUTE"),
+		GroupBaseDN:     utils.GetEnv("LDAP_GROUP_BASE_DN"),
+		StartTLS:        utils.GetEnv("LDAP_START_TLS") == "true",
+	}
+	if config.BaseDN == "" {
+		return nil, errors.New("LDAP_BASE_DN is required when LDAP_URL is set")
+	}
+	if _, err := url.Parse(config.URL); err != nil {
+		return nil, fmt.Errorf("invalid LDAP_URL: %w", err)
+	}
+
+	return NewDirectory(config), nil
+}
+
+func NewDirectory(config Config) *Directory {
+	if config.UserAttribute == "" {
+		config.UserAttribute = defaultUserAttribute
+	}
+	if config.UserObjectClass == "" {
+		config.UserObjectClass = defaultUserObjectClass
+	}
+	if config.EmailAttribute == "" {
+		config.EmailAttribute = defaultEmailAttribute
+	}
+	return &Directory{config: config}
+}
+
+// URL identifies the directory; together with a DN it identifies a user
+func (d *Directory) URL() string {
+	return d.config.URL
+}
+
+// Authenticate checks a username and password against the directory. It
+// returns ErrUserNotFound if no single entry has the username, and
+// ErrInvalidCredentials if the password is wrong.
+func (d *Directory) Authenticate(ctx context.Context, username, password string) (*Account, error) {
+	if password == "" {
+		return nil, ErrInvalidCredentials
+	}
+
+	conn, err := d.connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if d.config.BindDN != "" {
+		err := conn.Bind(ctx, d.config.BindDN, d.config.BindPassword)
+		// The service account's password being wrong says nothing about the user's
+		if errors.Is(err, ErrInvalidCredentials) {
+			return nil, errors.New("ldap: service account bind: invalid credentials")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("ldap: service account bind: %w", err)
+		}
+	}
+
+	entries, err := conn.Search(ctx, &SearchRequest{
+		BaseDN: d.config.BaseDN,
+		Scope:  ScopeWholeSubtree,
+		Filter: And(
+			Equal("objectClass", d.config.UserObjectClass),
+			Equal(d.config.UserAttribute, username),
+		),
+		Attributes: []string{d.config.UserAttribute, d.config.EmailAttribute, "memberOf"},
+		SizeLimit:  2,
+	})
+	var resultErr *ResultError
+	if errors.As(err, &resultErr) && resultErr.Code == resultSizeLimit {
+		return nil, ErrUserNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	// Two entries with one username would make the login ambiguous
+	if len(entries) != 1 {
+		return nil, ErrUserNotFound
+	}
+	entry := entries[0]
+
+	account := &Account{
+		DN:       entry.DN,
+		Username: entry.Value(d.config.UserAttribute),
+		Email:    entry.Value(d.config.EmailAttribute),
+		Groups:   entry.Values("memberOf"),
+	}
+	if d.config.GroupBaseDN != "" {
+		groups, err := conn.Search(ctx, &SearchRequest{
+			BaseDN: d.config.GroupBaseDN,
+			Scope:  ScopeWholeSubtree,
+			Filter: Or(
+				Equal("member", entry.DN),
+				Equal("uniqueMember", entry.DN),
+				Equal("memberUid", account.Username),
+			),
+			Attributes: []string{"1.1"}, // no attributes, only DNs
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, group := range groups {
+			account.Groups = append(account.Groups, group.DN)
+		}
+	}
+	account.Groups = uniqueFold(account.Groups)
+
+	// Binding as the user is what checks the password; the connection is not used after
+	if err := conn.Bind(ctx, entry.DN, password); err != nil {
+		return nil, err
+	}
+	return account, nil
+}
+
+func (d *Directory) connect(ctx context.Context) (*Conn, error) {
+	conn, err := Dial(ctx, d.config.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	if d.config.StartTLS {
+		u, _ := url.Parse(d.config.URL)
+		if err := conn.StartTLS(ctx, nil, u.Hostname()); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+// GroupMatches reports whether group, a DN, is the group named by name,
+// which is either its DN or the value of its first RDN such as its cn
+func GroupMatches(group, name string) bool {
+	if strings.EqualFold(group, name) {
+		return true
+	}
+	rdn, _, _ := strings.Cut(group, ",")
+	_, value, ok := strings.Cut(rdn, "=")
+	return ok && strings.EqualFold(strings.TrimSpace(value), strings.TrimSpace(name))
+}
+
+// uniqueFold removes DNs repeated in a different case, as memberOf and a group search usually overlap
+func uniqueFold(values []string) []string {
+	var unique []string
+	for _, value := range values {
+		if !slices.ContainsFunc(unique, func(seen string) bool { return strings.EqualFold(seen, value) }) {
+			unique = append(unique, value)
+		}
+	}
+	return unique
+}
@@ -0,0 +1,165 @@
+package ldap
+
+import (
+	"context"
+	"errors"
+	"rtdocs/ldap/ldaptest"
+	"slices"
+	"testing"
+)
+
+func newTestServer(t *testing.T) *ldaptest.Server {
+	t.Helper()
+	server, err := ldaptest.NewServer(
+		ldaptest.NewEntry("cn=reader,dc=example,dc=com", "userPassword", "reader-password"),
+		ldaptest.NewEntry("uid=alice,ou=people,dc=example,dc=com",
+			"objectClass", "person", "uid", "alice", "mail", "alice@example.com", "userPassword", "alice-password",
+			"memberOf", "cn=editors,ou=groups,dc=example,dc=com"),
+		ldaptest.NewEntry("uid=bob,ou=people,dc=example,dc=com",
+			"objectClass", "person", "uid", "bob", "userPassword", "bob-password"),
+		ldaptest.NewEntry("uid=twin,ou=people,dc=example,dc=com", "objectClass", "person", "uid", "twin", "userPassword", "twin"),
+		ldaptest.NewEntry("uid=twin,ou=contractors,dc=example,dc=com", "objectClass", "person", "uid", "twin", "userPassword", "twin"),
+		ldaptest.NewEntry("cn=admins,ou=groups,dc=example,dc=com", "member", "uid=alice,ou=people,dc=example,dc=com"),
+		ldaptest.NewEntry("cn=staff,ou=groups,dc=example,dc=com", "memberUid", "bob"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { server.Close() })
+	return server
+}
+
+func TestAuthenticate(t *testing.T) {
+	server := newTestServer(t)
+	directory := NewDirectory(Config{
+		URL:          server.URL(),
+		BindDN:       "cn=reader,dc=example,dc=com",
+		BindPassword: "reader-password",
+		BaseDN:       "dc=example,dc=com",
+		GroupBaseDN:  "ou=groups,dc=example,dc=com",
+	})
+
+	tests := []struct {
+		name     string
+		username string
+		password string
+		err      error
+		groups   []string
+	}{
+		{"member through memberOf and member", "alice", "alice-password", nil, []string{"cn=editors,ou=groups,dc=example,dc=com", "cn=admins,ou=groups,dc=example,dc=com"}},
+		{"member through memberUid", "bob", "bob-password", nil, []string{"cn=staff,ou=groups,dc=example,dc=com"}},
+		{"username in another case", "ALICE", "alice-password", nil, []string{"cn=editors,ou=groups,dc=example,dc=com", "cn=admins,ou=groups,dc=example,dc=com"}},
+		{"wrong password", "alice", "bob-password", ErrInvalidCredentials, nil},
+		{"no password", "alice", "", ErrInvalidCredentials, nil},
+		{"unknown user", "carol", "carol-password", ErrUserNotFound, nil},
+		{"a wildcard is not a wildcard", "*", "alice-password", ErrUserNotFound, nil},
+		{"two entries with the username", "twin", "twin", ErrUserNotFound, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			account, err := directory.Authenticate(context.Background(), tt.username, tt.password)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("Authenticate = %v, want %v", err, tt.err)
+			}
+			if err != nil {
+				return
+			}
+			if !slices.Equal(account.Groups, tt.groups) {
+				t.Fatalf("groups = %q, want %q", account.Groups, tt.groups)
+			}
+		})
+	}
+}
+
+func TestAuthenticateWithWrongServiceAccount(t *testing.T) {
+	server := newTestServer(t)
+	directory := NewDirectory(Config{URL: server.URL(), BindDN: "cn=reader,dc=example,dc=com", BindPassword: "wrong", BaseDN: "dc=example,dc=com"})
+
+	// Not the user's fault, so it must not pass for a wrong password
+	_, err := directory.Authenticate(context.Background(), "alice", "alice-password")
+	if err == nil || errors.Is(err, ErrInvalidCredentials) || errors.Is(err, ErrUserNotFound) {
+		t.Fatalf("Authenticate = %v, want a service account error", err)
+	}
+}
+
+func TestSearch(t *testing.T) {
+	server := newTestServer(t)
+	ctx := context.Background()
+	conn, err := Dial(ctx, server.URL(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	tests := []struct {
+		name string
+		req  SearchRequest
+		dns  []string
+		code int
+	}{
+		{"base object", SearchRequest{BaseDN: "uid=bob,ou=people,dc=example,dc=com", Scope: ScopeBaseObject, Filter: Present("objectClass")},
+			[]string{"uid=bob,ou=people,dc=example,dc=com"}, 0},
+		{"single level", SearchRequest{BaseDN: "ou=groups,dc=example,dc=com", Scope: ScopeSingleLevel, Filter: Present("cn")},
+			nil, 0},
+		{"subtree with not", SearchRequest{BaseDN: "ou=people,dc=example,dc=com", Scope: ScopeWholeSubtree, Filter: And(Equal("objectClass", "person"), Not(Present("mail")))},
+			[]string{"uid=bob,ou=people,dc=example,dc=com", "uid=twin,ou=people,dc=example,dc=com"}, 0},
+		{"size limit", SearchRequest{BaseDN: "dc=example,dc=com", Scope: ScopeWholeSubtree, Filter: Equal("uid", "twin"), SizeLimit: 1},
+			nil, resultSizeLimit},
+		{"no such base", SearchRequest{BaseDN: "ou=nowhere,dc=example,dc=com", Scope: ScopeWholeSubtree, Filter: Present("objectClass")},
+			nil, resultNoSuchObject},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entries, err := conn.Search(ctx, &tt.req)
+			var resultErr *ResultError
+			switch {
+			case tt.code != 0:
+				if !errors.As(err, &resultErr) || resultErr.Code != tt.code {
+					t.Fatalf("Search = %v, want result code %d", err, tt.code)
+				}
+				return
+			case err != nil:
+				t.Fatal(err)
+			}
+
+			var dns []string
+			for _, entry := range entries {
+				dns = append(dns, entry.DN)
+				if entry.Values("userPassword") != nil {
+					t.Fatalf("%s came back with its password", entry.DN)
+				}
+			}
+			if !slices.Equal(dns, tt.dns) {
+				t.Fatalf("found %q, want %q", dns, tt.dns)
+			}
+		})
+	}
+}
+
+func TestBind(t *testing.T) {
+	server := newTestServer(t)
+	ctx := context.Background()
+	conn, err := Dial(ctx, server.URL(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if err := conn.Bind(ctx, "uid=alice,ou=people,dc=example,dc=com", "alice-password"); err != nil {
+		t.Fatalf("Bind = %v", err)
+	}
+	if err := conn.Bind(ctx, "uid=alice,ou=people,dc=example,dc=com", "wrong"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("Bind with a wrong password = %v, want ErrInvalidCredentials", err)
+	}
+
+	// An empty password would be an anonymous bind, which servers let through
+	before := server.Binds()
+	if err := conn.Bind(ctx, "uid=alice,ou=people,dc=example,dc=com", ""); !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("Bind without a password = %v, want ErrInvalidCredentials", err)
+	}
+	if server.Binds() != before {
+		t.Fatal("a bind without a password was sent to the server")
+	}
+}
@@ -0,0 +1,45 @@
+package ldap
+
+// Filter is a search filter (RFC 4511 section 4.5.1). Filters are built from
+// values rather than parsed from strings, so a username can never change a
+// filter's meaning the way an unescaped "*" or ")" could.
+type Filter struct {
+	kind     byte
+	children []Filter
+	attr     string
+	value    string
+}
+
+// Filter choice tags
+const (
+	filterAnd      = classContext | constructed | 0
+	filterOr       = classContext | constructed | 1
+	filterNot      = classContext | constructed | 2
+	filterEquality = classContext | constructed | 3
+	filterPresent  = classContext | 7
+)
+
+func And(filters ...Filter) Filter { return Filter{kind: filterAnd, children: filters} }
+
+func Or(filters ...Filter) Filter { return Filter{kind: filterOr, children: filters} }
+
+func Not(filter Filter) Filter { return Filter{kind: filterNot, children: []Filter{filter}} }
+
+func Equal(attr, value string) Filter { return Filter{kind: filterEquality, attr: attr, value: value} }
+
+func Present(attr string) Filter { return Filter{kind: filterPresent, attr: attr} }
+
+func (f Filter) encode() []byte {
+	switch f.kind {
+	case filterAnd, filterOr, filterNot:
+		children := make([][]byte, len(f.children))
+		for i, child := range f.children {
+			children[i] = child.encode()
+		}
+		return encodeConstructed(f.kind, children...)
+	case filterEquality:
+		return encodeConstructed(f.kind, encodeString(tagOctetString, f.attr), encodeString(tagOctetString, f.value))
+	default:
+		return encodeString(filterPresent, f.attr)
+	}
+}
@@ -0,0 +1,129 @@
+package ldaptest
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+)
+
+// The server has a BER codec of its own rather than borrowing the client's,
+// so that a mistake in one shows up as the two failing to understand each other.
+
+const (
+	classApplication = 0x40
+	classContext     = 0x80
+	constructed      = 0x20
+
+	tagInteger     = 0x02
+	tagOctetString = 0x04
+	tagEnumerated  = 0x0a
+	tagSequence    = constructed | 0x10
+	tagSet         = constructed | 0x11
+)
+
+// maxElementSize bounds what a client can make the server allocate
+const maxElementSize = 1 << 20
+
+var errMalformed = errors.New("ldaptest: malformed BER element")
+
+// element is a decoded BER element; constructed ones have children
+type element struct {
+	tag      byte
+	value    []byte
+	children []*element
+}
+
+func (e *element) str() string {
+	return string(e.value)
+}
+
+func (e *element) int() int64 {
+	var v int64
+	for i, b := range e.value {
+		if i == 0 && b&0x80 != 0 {
+			v = -1
+		}
+		v = v<<8 | int64(b)
+	}
+	return v
+}
+
+func readElement(r *bufio.Reader) (*element, error) {
+	tag, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	length, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	size := int(length)
+	if length&0x80 != 0 {
+		n := int(length & 0x7f)
+		if n == 0 || n > 4 {
+			return nil, errMalformed
+		}
+		size = 0
+		for i := 0; i < n; i++ {
+			b, err := r.ReadByte()
+			if err != nil {
+				return nil, err
+			}
+			size = size<<8 | int(b)
+		}
+	}
+	if size > maxElementSize {
+		return nil, errMalformed
+	}
+
+	contents := make([]byte, size)
+	if _, err := io.ReadFull(r, contents); err != nil {
+		return nil, err
+	}
+	e := &element{tag: tag, value: contents}
+	if tag&constructed == 0 {
+		return e, nil
+	}
+
+	inner := bufio.NewReader(bytes.NewReader(contents))
+	for {
+		if _, err := inner.Peek(1); err == io.EOF {
+			return e, nil
+		}
+		child, err := readElement(inner)
+		if err != nil {
+			return nil, errMalformed
+		}
+		e.children = append(e.children, child)
+	}
+}
+
+// build encodes an element from its tag and the encodings of its contents
+func build(tag byte, contents ...[]byte) []byte {
+	body := bytes.Join(contents, nil)
+	var length []byte
+	switch n := len(body); {
+	case n < 0x80:
+		length = []byte{byte(n)}
+	case n < 1<<8:
+		length = []byte{0x81, byte(n)}
+	case n < 1<<16:
+		length = []byte{0x82, byte(n >> 8), byte(n)}
+	default:
+		length = []byte{0x84, byte(n >> 24), byte(n >> 16), byte(n >> 8), byte(n)}
+	}
+	return append(append([]byte{tag}, length...), body...)
+}
+
+func octets(tag byte, s string) []byte {
+	return build(tag, []byte(s))
+}
+
+func integer(tag byte, v int64) []byte {
+	b := []byte{byte(v)}
+	for v >>= 8; !(v == 0 && b[0]&0x80 == 0) && !(v == -1 && b[0]&0x80 != 0); v >>= 8 {
+		b = append([]byte{byte(v)}, b...)
+	}
+	return build(tag, b)
+}
@@ -0,0 +1,66 @@
+package ldaptest
+
+import "strings"
+
+// Filter choice tags (RFC 4511 section 4.5.1)
+const (
+	filterAnd      = classContext | constructed | 0
+	filterOr       = classContext | constructed | 1
+	filterNot      = classContext | constructed | 2
+	filterEquality = classContext | constructed | 3
+	filterPresent  = classContext | 7
+)
+
+// decodeFilter turns a search filter into a test of an entry. Values are
+// compared without regard to case, as most directory attributes are.
+func decodeFilter(e *element) (func(*Entry) bool, error) {
+	switch e.tag {
+	case filterAnd, filterOr, filterNot:
+		var children []func(*Entry) bool
+		for _, child := range e.children {
+			decoded, err := decodeFilter(child)
+			if err != nil {
+				return nil, err
+			}
+			children = append(children, decoded)
+		}
+		switch {
+		case e.tag == filterAnd:
+			return func(entry *Entry) bool {
+				for _, child := range children {
+					if !child(entry) {
+						return false
+					}
+				}
+				return true
+			}, nil
+		case e.tag == filterOr:
+			return func(entry *Entry) bool {
+				for _, child := range children {
+					if child(entry) {
+						return true
+					}
+				}
+				return false
+			}, nil
+		case len(children) == 1:
+			return func(entry *Entry) bool { return !children[0](entry) }, nil
+		}
+	case filterEquality:
+		if len(e.children) == 2 {
+			attr, value := e.children[0].str(), e.children[1].str()
+			return func(entry *Entry) bool {
+				for _, v := range entry.values(attr) {
+					if strings.EqualFold(v, value) {
+						return true
+					}
+				}
+				return false
+			}, nil
+		}
+	case filterPresent:
+		attr := e.str()
+		return func(entry *Entry) bool { return len(entry.values(attr)) > 0 }, nil
+	}
+	return nil, errMalformed
+}
@@ -0,0 +1,288 @@
+// Package ldaptest runs a directory server in memory for tests. It keeps its
+// entries in memory and supports simple binds against their userPassword
+// attribute and searches; it does not support TLS or any writes over the wire.
+package ldaptest
+
+import (
+	"bufio"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// Protocol operation tags and result codes (RFC 4511)
+const (
+	opBindRequest      = classApplication | constructed | 0
+	opBindResponse     = classApplication | constructed | 1
+	opUnbindRequest    = classApplication | 2
+	opSearchRequest    = classApplication | constructed | 3
+	opSearchEntry      = classApplication | constructed | 4
+	opSearchDone       = classApplication | constructed | 5
+	opExtendedRequest  = classApplication | constructed | 23
+	opExtendedResponse = classApplication | constructed | 24
+
+	tagSimpleAuth = classContext | 0
+
+	resultSuccess       = 0
+	resultProtocolError = 2
+	resultSizeLimit     = 4
+	resultNoSuchObject  = 32
+	resultInvalidCreds  = 49
+	resultUnwilling     = 53
+
+	scopeBaseObject   = 0
+	scopeSingleLevel  = 1
+	scopeWholeSubtree = 2
+)
+
+// Entry is a directory entry. Attribute names are lowercased.
+type Entry struct {
+	DN         string
+	Attributes map[string][]string
+}
+
+// NewEntry builds an entry from attribute names and values, given in pairs
+func NewEntry(dn string, attributes ...string) *Entry {
+	entry := &Entry{DN: dn, Attributes: make(map[string][]string)}
+	for i := 0; i+1 < len(attributes); i += 2 {
+		name := strings.ToLower(attributes[i])
+		entry.Attributes[name] = append(entry.Attributes[name], attributes[i+1])
+	}
+	return entry
+}
+
+func (e *Entry) values(attr string) []string {
+	return e.Attributes[strings.ToLower(attr)]
+}
+
+// Server is a running directory server
+type Server struct {
+	listener net.Listener
+
+	mu      sync.RWMutex
+	entries []*Entry
+	binds   int
+	conns   map[net.Conn]struct{}
+	wg      sync.WaitGroup
+}
+
+// NewServer starts a server listening on a random local port; Close stops it
+func NewServer(entries ...*Entry) (*Server, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Server{listener: listener, conns: make(map[net.Conn]struct{})}
+	for _, entry := range entries {
+		s.Add(entry)
+	}
+
+	s.wg.Add(1)
+	go s.serve()
+	return s, nil
+}
+
+// URL is the ldap:// address to connect to
+func (s *Server) URL() string {
+	return "ldap://" + s.listener.Addr().String()
+}
+
+// Add adds an entry, replacing any with the same DN
+func (s *Server) Add(entry *Entry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.entries = slices.DeleteFunc(s.entries, func(e *Entry) bool { return strings.EqualFold(e.DN, entry.DN) })
+	s.entries = append(s.entries, entry)
+}
+
+// Binds counts the bind requests the server has answered
+func (s *Server) Binds() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.binds
+}
+
+// Close stops the server and closes every connection
+func (s *Server) Close() error {
+	err := s.listener.Close()
+
+	s.mu.Lock()
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+
+	s.wg.Wait()
+	return err
+}
+
+func (s *Server) serve() {
+	defer s.wg.Done()
+
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		s.mu.Lock()
+		s.conns[conn] = struct{}{}
+		s.mu.Unlock()
+
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.handle(conn)
+
+			s.mu.Lock()
+			delete(s.conns, conn)
+			s.mu.Unlock()
+			conn.Close()
+		}()
+	}
+}
+
+// handle answers the requests on one connection until the client unbinds or disconnects
+func (s *Server) handle(conn net.Conn) {
+	r := bufio.NewReader(conn)
+	for {
+		message, err := readElement(r)
+		if err != nil || message.tag != tagSequence || len(message.children) < 2 {
+			return
+		}
+		id := message.children[0].int()
+		op := message.children[1]
+
+		var responses [][]byte
+		switch op.tag {
+		case opBindRequest:
+			responses = [][]byte{s.bind(op)}
+		case opSearchRequest:
+			responses = s.search(op)
+		case opUnbindRequest:
+			return
+		case opExtendedRequest:
+			responses = [][]byte{result(opExtendedResponse, resultProtocolError, "extended operations are not supported")}
+		default:
+			return
+		}
+
+		for _, response := range responses {
+			if _, err := conn.Write(build(tagSequence, integer(tagInteger, id), response)); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func (s *Server) bind(op *element) []byte {
+	s.mu.Lock()
+	s.binds++
+	s.mu.Unlock()
+
+	if len(op.children) < 3 || op.children[2].tag != tagSimpleAuth {
+		return result(opBindResponse, resultProtocolError, "only simple binds are supported")
+	}
+	dn, password := op.children[1].str(), op.children[2].str()
+
+	switch {
+	case dn == "" && password == "":
+		return result(opBindResponse, resultSuccess, "")
+	case password == "":
+		// Like real servers, refuse an unauthenticated bind rather than let it pass for a login
+		return result(opBindResponse, resultUnwilling, "unauthenticated binds are not allowed")
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, entry := range s.entries {
+		if strings.EqualFold(entry.DN, dn) && slices.Contains(entry.values("userPassword"), password) {
+			return result(opBindResponse, resultSuccess, "")
+		}
+	}
+	return result(opBindResponse, resultInvalidCreds, "")
+}
+
+func (s *Server) search(op *element) [][]byte {
+	if len(op.children) < 8 {
+		return [][]byte{result(opSearchDone, resultProtocolError, "malformed search request")}
+	}
+	base := op.children[0].str()
+	scope := int(op.children[1].int())
+	sizeLimit := int(op.children[3].int())
+	matches, err := decodeFilter(op.children[6])
+	if err != nil {
+		return [][]byte{result(opSearchDone, resultProtocolError, "unsupported filter")}
+	}
+	var attributes []string
+	for _, attr := range op.children[7].children {
+		attributes = append(attributes, strings.ToLower(attr.str()))
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	baseExists := false
+	var responses [][]byte
+	for _, entry := range s.entries {
+		baseExists = baseExists || inScope(entry.DN, base, scopeWholeSubtree)
+		if !inScope(entry.DN, base, scope) || !matches(entry) {
+			continue
+		}
+		if sizeLimit > 0 && len(responses) == sizeLimit {
+			return append(responses, result(opSearchDone, resultSizeLimit, "size limit exceeded"))
+		}
+		responses = append(responses, encodeEntry(entry, attributes))
+	}
+	if !baseExists && len(responses) == 0 {
+		return [][]byte{result(opSearchDone, resultNoSuchObject, "")}
+	}
+	return append(responses, result(opSearchDone, resultSuccess, ""))
+}
+
+// inScope reports whether dn is within a search of base with scope
+func inScope(dn, base string, scope int) bool {
+	dn, base = strings.ToLower(dn), strings.ToLower(base)
+	switch scope {
+	case scopeBaseObject:
+		return dn == base
+	case scopeSingleLevel:
+		_, parent, _ := strings.Cut(dn, ",")
+		return parent == base
+	default:
+		return base == "" || dn == base || strings.HasSuffix(dn, ","+base)
+	}
+}
+
+// encodeEntry encodes an entry with the requested attributes, never including passwords
+func encodeEntry(entry *Entry, attributes []string) []byte {
+	all := len(attributes) == 0 || slices.Contains(attributes, "*")
+
+	names := make([]string, 0, len(entry.Attributes))
+	for name := range entry.Attributes {
+		if name != "userpassword" && (all || slices.Contains(attributes, name)) {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+
+	encoded := make([][]byte, len(names))
+	for i, name := range names {
+		values := make([][]byte, len(entry.Attributes[name]))
+		for j, value := range entry.Attributes[name] {
+			values[j] = octets(tagOctetString, value)
+		}
+		encoded[i] = build(tagSequence, octets(tagOctetString, name), build(tagSet, values...))
+	}
+
+	return build(opSearchEntry, octets(tagOctetString, entry.DN), build(tagSequence, encoded...))
+}
+
+// result encodes an LDAPResult
+func result(tag byte, code int, message string) []byte {
+	return build(tag, integer(tagEnumerated, int64(code)), octets(tagOctetString, ""), octets(tagOctetString, message))
+}
@@ -0,0 +1,60 @@
+// Package mailer sends the emails rtdocs needs, such as password reset links,
+// through SMTP or, for local development, into a file or the log.
+package mailer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"rtdocs/utils"
+	"strconv"
+)
+
+// Message is a plain text email
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+type Mailer interface {
+	Send(ctx context.Context, message Message) error
+}
+
+// FromEnv returns the mailer selected by MAILER: "smtp", "file" or "log".
+// SMTP is configured with SMTP_HOST, SMTP_PORT, SMTP_USERNAME and
+// SMTP_PASSWORD; the file mailer appends to MAIL_FILE. MAIL_FROM is the
+// sender. There is no default: a server that silently logged its password
+// reset links instead of sending them would be worse than one that refuses
+// to start, so MAILER=log has to be asked for.
+func FromEnv() (Mailer, error) {
+	from := utils.GetEnv("MAIL_FROM")
+	if from == "" {
+		from = "rtdocs <no-reply@localhost>"
+	}
+
+	switch mailer := utils.GetEnv("MAILER"); mailer {
+	case "smtp":
+		host := utils.GetEnv("SMTP_HOST")
+		if host == "" {
+			return nil, errors.New("SMTP_HOST is required when MAILER is smtp")
+		}
+		port, err := strconv.Atoi(utils.GetEnv("SMTP_PORT"))
+		if err != nil {
+			port = 587
+		}
+		return NewSMTPMailer(host, port, utils.GetEnv("SMTP_USERNAME"), utils.GetEnv("SMTP_PASSWORD"), from), nil
+	case "file":
+		path := utils.GetEnv("MAIL_FILE")
+		if path == "" {
+			return nil, errors.New("MAIL_FILE is required when MAILER is file")
+		}
+		return NewFileMailer(path, from), nil
+	case "log":
+		return NewFileMailer("", from), nil
+	case "":
+		return nil, errors.New("MAILER is not set; use smtp, file or log")
+	default:
+		return nil, fmt.Errorf("unknown MAILER %q; use smtp, file or log", mailer)
+	}
+}
@@ -0,0 +1,28 @@
+package mailer
+
+import "testing"
+
+func TestFromEnv(t *testing.T) {
+	tests := []struct {
+		env     map[string]string
+		wantErr bool
+	}{
+		{map[string]string{"MAILER": ""}, true},
+		{map[string]string{"MAILER": "sendmail"}, true},
+		{map[string]string{"MAILER": "log"}, false},
+		{map[string]string{"MAILER": "file", "MAIL_FILE": ""}, true},
+		{map[string]string{"MAILER": "file", "MAIL_FILE": "mail.txt"}, false},
+		{map[string]string{"MAILER": "smtp", "SMTP_HOST": ""}, true},
+		{map[string]string{"MAILER": "smtp", "SMTP_HOST": "mail.example.com"}, false},
+	}
+
+	for _, tt := range tests {
+		for key, value := range tt.env {
+			t.Setenv(key, value)
+		}
+		mailer, err := FromEnv()
+		if (err != nil) != tt.wantErr || (err == nil) != (mailer != nil) {
+			t.Errorf("FromEnv with %v = %v, %v; want error %v", tt.env, mailer, err, tt.wantErr)
+		}
+	}
+}
@@ -6,88 +6,267 @@ import (
 	"net/http"
 	"rtdocs/config"
 	"rtdocs/controller"
+	"rtdocs/ldap"
+	"rtdocs/mailer"
 	"rtdocs/middleware"
+	"rtdocs/model/domain"
+	"rtdocs/oidc"
+	"rtdocs/realtime"
 	"rtdocs/repository"
 	"rtdocs/service"
 	"rtdocs/utils"
+	"strings"
+	"time"
 
 	"github.com/gorilla/mux"
 )
 
 var (
 	secretKey       = utils.GetEnv("SECRET_KEY")
+	guestSecretKey  = utils.GetEnv("GUEST_TOKEN_SECRET")
 	accessDuration  = utils.GetEnv("ACCESS_TOKEN_DURATION")
 	refreshDuration = utils.GetEnv("REFRESH_TOKEN_DURATION")
+	jwtAlgorithm    = utils.GetEnv("JWT_ALGORITHM")
+	jwtKeysDir      = utils.GetEnv("JWT_KEYS_DIR")
+	jwtKeyRotation  = utils.GetEnv("JWT_KEY_ROTATION")
+	authBackends    = utils.GetEnv("AUTH_BACKENDS")
+	scimToken       = utils.GetEnv("SCIM_TOKEN")
 )
 
+// defaultKeyRotation is used when JWT_KEY_ROTATION is unset or invalid
+const defaultKeyRotation = 30 * 24 * time.Hour
+
+// defaultAuthBackends is used when AUTH_BACKENDS is unset
+const defaultAuthBackends = service.BackendLocal
+
 func main() {
 	// Connect to the database
 	dbConfig := config.NewPostgresDatabase()
 
+	ctx := context.Background()
+
 	// Token generator
+	tokenGen := newTokenGenerator(ctx)
 
 	// Set up dependencies
 	docsRepo := repository.NewDocumentRepository(dbConfig)
 	userRepo := repository.NewUserRepository(dbConfig)
+	revisionRepo := repository.NewRevisionRepository(dbConfig)
+	permissionRepo := repository.NewPermissionRepository(dbConfig)
+	sessionRepo := repository.NewSessionRepository(dbConfig)
+	mfaRepo := repository.NewMFARepository(dbConfig)
+	identityRepo := repository.NewIdentityRepository(dbConfig)
+	personalTokenRepo := repository.NewPersonalTokenRepository(dbConfig)
+	loginAttemptRepo := repository.NewLoginAttemptRepository(dbConfig)
+	scimRepo := repository.NewSCIMRepository(dbConfig)
 
-	docsService := service.NewDocumentService(docsRepo)
-	authService := service.NewAuthService(userRepo, utils.NewTokenGenerator(secretKey, accessDuration, refreshDuration))
-	userService := service.NewUserService(userRepo)
+	verificationPolicy := service.NewVerificationPolicy(userRepo)
+	docsService := service.NewDocumentService(docsRepo, verificationPolicy)
+	revisionService := service.NewRevisionService(revisionRepo, docsService)
+	documentSaver := service.NewDocumentSaver(docsService, revisionService)
+	diffService := service.NewDiffService(revisionRepo, docsService)
+	authzService := service.NewAuthorizationService(permissionRepo, docsService, verificationPolicy)
+	revocationService := service.NewRevocationService(sessionRepo)
+	mfaService := service.NewMFAService(mfaRepo, userRepo)
+	oidcProvider, err := oidc.FromEnv()
+	if err != nil {
+		log.Fatalf("Invalid single sign-on configuration: %v", err)
+	}
+	if err := utils.TrustProxies(utils.GetEnv("TRUSTED_PROXIES")); err != nil {
+		log.Fatalf("Invalid TRUSTED_PROXIES: %v", err)
+	}
+	mail, err := mailer.FromEnv()
+	if err != nil {
+		log.Fatalf("Invalid mailer configuration: %v", err)
+	}
+	loginThrottle := service.NewLoginThrottle(loginAttemptRepo)
+	passwordPolicy := service.NewPasswordPolicy()
+	credentialBackends := newCredentialBackends(userRepo, identityRepo)
+	authService := service.NewAuthService(userRepo, sessionRepo, identityRepo, revocationService, mfaService, loginThrottle, passwordPolicy, credentialBackends, tokenGen, mail, oidcProvider)
+	personalTokenService := service.NewPersonalTokenService(personalTokenRepo, userRepo)
+	userService := service.NewUserService(userRepo, passwordPolicy)
+	sweeper := service.NewSweeper(userRepo, loginAttemptRepo)
+	adminService := service.NewAdminService(userService, docsService, revocationService)
+	scimService := service.NewSCIMService(userRepo, scimRepo, revocationService, passwordPolicy)
 
-	docsController := controller.NewDocumentController(docsService)
+	hub := realtime.NewHub()
+
+	docsController := controller.NewDocumentController(docsService, revisionService, authzService, documentSaver, hub)
+	revisionController := controller.NewRevisionController(revisionService, diffService, authzService, documentSaver, hub)
+	permissionController := controller.NewPermissionController(authzService)
 	authController := controller.NewAuthController(authService)
+	mfaController := controller.NewMFAController(mfaService)
+	personalTokenController := controller.NewPersonalTokenController(personalTokenService)
 	userController := controller.NewUserController(userService)
-	wsController := controller.NewWebSocketController(docsService)
-
-	ctx := context.Background()
+	adminController := controller.NewAdminController(adminService, hub)
+	scimController := controller.NewSCIMController(scimService, hub)
+	wsController := controller.NewWebSocketController(docsService, documentSaver, authzService, hub)
 
 	// Start the WebSocket message handler in a goroutine
 	go wsController.HandleMessages(ctx)
 
+	// Delete expired guests, their documents and old login attempts in the background
+	go sweeper.Run(ctx)
+
 	// Create a new router
 	router := mux.NewRouter()
 
 	// Set up HTTP handler for WebSocket connections
-	router.HandleFunc("/ws/{id}", wsController.HandleConnections)
-
-	// Set up HTTP handlers for document operations
-	router.HandleFunc("/api/documents", docsController.GetAllDocuments)
-	router.HandleFunc("/api/document/create", docsController.CreateDocument)
-	router.HandleFunc("/api/document/save", docsController.UpdateDocument)
-	router.HandleFunc("/api/document/{id}", docsController.GetDocument)
+	router.Handle("/ws/{id}", middleware.WebSocketAuthMiddleware(tokenGen, revocationService, authService)(http.HandlerFunc(wsController.HandleConnections)))
 
 	// Set up HTTP handlers for authentication operations
 	router.HandleFunc("/api/auth/register", authController.Register)
 	router.HandleFunc("/api/auth/login", authController.Login)
+	router.HandleFunc("/api/auth/login/mfa", authController.LoginMFA).Methods("POST")
+	router.HandleFunc("/api/auth/oidc/login", authController.OIDCLogin).Methods("GET")
+	router.HandleFunc("/api/auth/oidc/callback", authController.OIDCCallback).Methods("POST")
 	router.HandleFunc("/api/auth/logout", authController.Logout)
+	router.HandleFunc("/api/auth/refresh", authController.Refresh).Methods("POST")
 	router.HandleFunc("/api/auth/guest", authController.Guest)
-
-	// Set up HTTP handlers for user operations
-	router.HandleFunc("/api/user/{id}", userController.GetUser)
-	router.HandleFunc("/api/users", userController.GetAllUsers)
-	router.HandleFunc("/api/user/create", userController.CreateUser)
-	router.HandleFunc("/api/user/update", userController.UpdateUser)
+	router.HandleFunc("/api/auth/password/forgot", authController.ForgotPassword).Methods("POST")
+	router.HandleFunc("/api/auth/password/reset", authController.ResetPassword).Methods("POST")
+	router.HandleFunc("/api/auth/email/verify", authController.VerifyEmail).Methods("POST")
+	router.HandleFunc("/.well-known/jwks.json", authController.JWKS).Methods("GET")
 
 	// Wrap the HTTP handler with the middlewares
-	corsHandler := middleware.CORSMiddleware(router)
+	corsHandler := middleware.CORSMiddleware(service.AppURL())(router)
 
 	// Create a subrouter for the routes that require authentication
 	authRouter := router.PathPrefix("/api").Subrouter()
-	authRouter.Use(middleware.AuthMiddleware)
-
-	// Set up HTTP handlers for the routes that require authentication
-	authRouter.HandleFunc("/documents", docsController.GetAllDocuments).Methods("GET")
-	authRouter.HandleFunc("/document/{id}", docsController.GetDocument).Methods("GET")
-	authRouter.HandleFunc("/document/create", docsController.CreateDocument).Methods("POST")
-	authRouter.HandleFunc("/document/save", docsController.UpdateDocument).Methods("PUT")
-	authRouter.HandleFunc("/auth/logout", authController.Logout).Methods("POST")
-	authRouter.HandleFunc("/user/{id}", userController.GetUser).Methods("GET")
-	authRouter.HandleFunc("/users", userController.GetAllUsers).Methods("GET")
-	authRouter.HandleFunc("/user/create", userController.CreateUser).Methods("POST")
-	authRouter.HandleFunc("/user/update", userController.UpdateUser).Methods("PUT")
+	authRouter.Use(middleware.AuthMiddleware(tokenGen, revocationService, authService, personalTokenService))
+
+	// Set up HTTP handlers for the routes that require authentication. Personal
+	// access tokens may use the document routes within their scopes.
+	docsRouter := authRouter.NewRoute().Subrouter()
+	docsRouter.Use(middleware.RequireScope(domain.ScopeDocsRead, domain.ScopeDocsWrite))
+	docsRouter.HandleFunc("/documents", docsController.GetAllDocuments).Methods("GET")
+	docsRouter.HandleFunc("/document/{id}", docsController.GetDocument).Methods("GET")
+	docsRouter.HandleFunc("/document/create", docsController.CreateDocument).Methods("POST")
+	docsRouter.HandleFunc("/document/save", docsController.UpdateDocument).Methods("PUT")
+	docsRouter.HandleFunc("/document/{id}/revisions", revisionController.GetRevisions).Methods("GET")
+	docsRouter.HandleFunc("/document/{id}/revisions/{revisionId}", revisionController.GetRevision).Methods("GET")
+	docsRouter.HandleFunc("/document/{id}/revisions/{revisionId}/restore", revisionController.RestoreRevision).Methods("POST")
+	docsRouter.HandleFunc("/document/{id}/diff", revisionController.GetDiff).Methods("GET")
+	docsRouter.HandleFunc("/document/{id}/share", docsController.ShareDocument).Methods("PUT")
+	docsRouter.HandleFunc("/document/{id}/permissions", permissionController.GetPermissions).Methods("GET")
+	docsRouter.HandleFunc("/document/{id}/permissions/{userId}", permissionController.GrantPermission).Methods("PUT")
+	docsRouter.HandleFunc("/document/{id}/permissions/{userId}", permissionController.RevokePermission).Methods("DELETE")
+
+	// Account management is only for interactive logins
+	accountRouter := authRouter.NewRoute().Subrouter()
+	accountRouter.Use(middleware.RejectPersonalTokens)
+	accountRouter.HandleFunc("/auth/logout", authController.Logout).Methods("POST")
+	accountRouter.HandleFunc("/auth/logout/all", authController.LogoutAll).Methods("POST")
+	accountRouter.HandleFunc("/auth/sessions", authController.GetSessions).Methods("GET")
+	accountRouter.HandleFunc("/auth/sessions/{id}", authController.RevokeSession).Methods("DELETE")
+	accountRouter.HandleFunc("/auth/email/resend", authController.ResendVerification).Methods("POST")
+	accountRouter.HandleFunc("/auth/mfa/totp", mfaController.Enroll).Methods("POST")
+	accountRouter.HandleFunc("/auth/mfa/totp/confirm", mfaController.Confirm).Methods("POST")
+	accountRouter.HandleFunc("/auth/mfa/totp", mfaController.Disable).Methods("DELETE")
+	accountRouter.HandleFunc("/auth/mfa/recovery-codes", mfaController.RegenerateRecoveryCodes).Methods("POST")
+	accountRouter.HandleFunc("/auth/tokens", personalTokenController.CreateToken).Methods("POST")
+	accountRouter.HandleFunc("/auth/tokens", personalTokenController.GetTokens).Methods("GET")
+	accountRouter.HandleFunc("/auth/tokens/{id}", personalTokenController.RevokeToken).Methods("DELETE")
+
+	// User management and the admin console are for admins only
+	adminRouter := accountRouter.NewRoute().Subrouter()
+	adminRouter.Use(middleware.RequireRole(userService, domain.UserRoleAdmin))
+	adminRouter.HandleFunc("/user/{id}", userController.GetUser).Methods("GET")
+	adminRouter.HandleFunc("/users", userController.GetAllUsers).Methods("GET")
+	adminRouter.HandleFunc("/user/create", userController.CreateUser).Methods("POST")
+	adminRouter.HandleFunc("/user/update", userController.UpdateUser).Methods("PUT")
+	adminRouter.HandleFunc("/admin/users", adminController.SearchUsers).Methods("GET")
+	adminRouter.HandleFunc("/admin/users/{id}/disable", adminController.DisableUser).Methods("POST")
+	adminRouter.HandleFunc("/admin/users/{id}/enable", adminController.EnableUser).Methods("POST")
+	adminRouter.HandleFunc("/admin/users/{id}/reset-password", adminController.ForcePasswordReset).Methods("POST")
+	adminRouter.HandleFunc("/admin/users/{id}/transfer", adminController.TransferDocuments).Methods("POST")
+	adminRouter.HandleFunc("/admin/documents/{id}", adminController.GetDocument).Methods("GET")
+	adminRouter.HandleFunc("/admin/documents/{id}", adminController.DeleteDocument).Methods("DELETE")
+	adminRouter.HandleFunc("/admin/documents/{id}/kick", adminController.KickClients).Methods("POST")
+
+	// SCIM provisioning by the identity provider, which authenticates with SCIM_TOKEN
+	if scimToken != "" {
+		scimRouter := router.PathPrefix("/scim/v2").Subrouter()
+		scimRouter.Use(middleware.SCIMAuth(scimToken))
+		scimRouter.HandleFunc("/Users", scimController.GetUsers).Methods("GET")
+		scimRouter.HandleFunc("/Users", scimController.CreateUser).Methods("POST")
+		scimRouter.HandleFunc("/Users/{id}", scimController.GetUser).Methods("GET")
+		scimRouter.HandleFunc("/Users/{id}", scimController.ReplaceUser).Methods("PUT")
+		scimRouter.HandleFunc("/Users/{id}", scimController.PatchUser).Methods("PATCH")
+		scimRouter.HandleFunc("/Users/{id}", scimController.DeleteUser).Methods("DELETE")
+		scimRouter.HandleFunc("/Groups", scimController.GetGroups).Methods("GET")
+		scimRouter.HandleFunc("/Groups/{id}", scimController.GetGroup).Methods("GET")
+		scimRouter.HandleFunc("/Groups/{id}", scimController.PatchGroup).Methods("PATCH")
+	}
 
 	log.Println("Starting server on :8080")
 	if err := http.ListenAndServe("localhost:8080", corsHandler); err != nil {
 		log.Fatalf("Server error: %v", err)
 	}
 }
+
+// newTokenGenerator signs with the shared secrets unless JWT_ALGORITHM selects
+// RS256 or EdDSA, in which case it signs with a rotating keyring kept in
+// JWT_KEYS_DIR and publishes the public keys at /.well-known/jwks.json.
+func newTokenGenerator(ctx context.Context) utils.TokenGenerator {
+	if jwtAlgorithm == "" || jwtAlgorithm == utils.AlgorithmHS256 {
+		return utils.NewTokenGenerator(secretKey, guestSecretKey, accessDuration, refreshDuration)
+	}
+
+	rotation, err := time.ParseDuration(jwtKeyRotation)
+	if err != nil || rotation <= 0 {
+		rotation = defaultKeyRotation
+	}
+	// Old keys must outlive every token they signed, guest tokens included
+	retain, err := time.ParseDuration(refreshDuration)
+	if err != nil {
+		log.Fatalf("Invalid REFRESH_TOKEN_DURATION: %v", err)
+	}
+	retain = max(retain, utils.GuestTokenDuration)
+
+	if jwtKeysDir == "" {
+		log.Println("JWT_KEYS_DIR is not set; signing keys are kept in memory and tokens will not survive a restart")
+	}
+	keyring, err := utils.NewKeyring(jwtAlgorithm, jwtKeysDir, rotation, retain)
+	if err != nil {
+		log.Fatalf("Failed to set up signing keys: %v", err)
+	}
+	go keyring.Run(ctx)
+
+	return utils.NewKeyringTokenGenerator(keyring, accessDuration, refreshDuration)
+}
+
+// newCredentialBackends returns the backends named in AUTH_BACKENDS, such as
+// "ldap,local", in the order logins should try them. They are built last to
+// first so the local backend knows which directories are tried after it.
+func newCredentialBackends(userRepo repository.UserRepository, identityRepo repository.IdentityRepository) []service.CredentialBackend {
+	names := authBackends
+	if names == "" {
+		names = defaultAuthBackends
+	}
+	order := strings.Split(names, ",")
+
+	var backends []service.CredentialBackend
+	var nextIssuers []string
+	for i := len(order) - 1; i >= 0; i-- {
+		var backend service.CredentialBackend
+		switch name := strings.TrimSpace(order[i]); name {
+		case service.BackendLocal:
+			backend = service.NewLocalBackend(userRepo, identityRepo, nextIssuers)
+		case service.BackendLDAP:
+			directory, err := ldap.FromEnv()
+			if err != nil {
+				log.Fatalf("Invalid LDAP configuration: %v", err)
+			}
+			if directory == nil {
+				log.Fatalf("AUTH_BACKENDS includes ldap but LDAP_URL is not set")
+			}
+			backend = service.NewLDAPBackend(directory, userRepo, identityRepo)
+			nextIssuers = append(nextIssuers, directory.URL())
+		default:
+			log.Fatalf("Unknown authentication backend %q in AUTH_BACKENDS", name)
+		}
+		backends = append([]service.CredentialBackend{backend}, backends...)
+	}
+	return backends
+}
@@ -2,58 +2,123 @@ package middleware
 
 import (
 	"context"
+	"errors"
 	"net/http"
 	"rtdocs/utils"
 	"strings"
-	"time"
-
-	"github.com/golang-jwt/jwt/v5"
-	"github.com/google/uuid"
 )
 
 type contextKey string
 
-const userContextKey contextKey = "user"
+const principalContextKey contextKey = "principal"
+
+// SessionChecker reports whether the session a token was issued for has been logged out
+type SessionChecker interface {
+	IsRevoked(ctx context.Context, sessionID string) (bool, error)
+}
+
+// GuestChecker reports whether a guest's account still exists. Guest tokens
+// outlive accounts that have been swept or merged into a real one.
+type GuestChecker interface {
+	GuestExists(ctx context.Context, guestID string) (bool, error)
+}
+
+// PersonalTokenAuthenticator looks up the principal behind a personal access token
+type PersonalTokenAuthenticator interface {
+	Authenticate(ctx context.Context, token string) (*utils.Principal, error)
+}
 
-var guestTokenSecret = utils.GetEnv("GUEST_TOKEN_SECRET")
+// AuthMiddleware authenticates a request with the access, guest or personal
+// access token in the Authorization header, rejecting tokens whose session
+// has been revoked or whose guest no longer exists. Clients without an
+// account get a guest token from /api/auth/guest first.
+func AuthMiddleware(tokens utils.TokenVerifier, sessions SessionChecker, guests GuestChecker, personalTokens PersonalTokenAuthenticator) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fields := strings.Fields(r.Header.Get("Authorization"))
+			if len(fields) != 2 || !strings.EqualFold(fields[0], "Bearer") {
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
 
-func AuthMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		authHeader := r.Header.Get("Authorization")
-		if authHeader == "" {
-			guestClaims := jwt.MapClaims{
-				"user_id":  uuid.New().String(),
-				"username": "guestFromMiddleware",
-				"role":     "guest",
-				"exp":      time.Now().Add(time.Hour * 24).Unix(),
+			var principal *utils.Principal
+			var err error
+			if strings.HasPrefix(fields[1], utils.PersonalTokenPrefix) {
+				principal, err = personalTokens.Authenticate(r.Context(), fields[1])
+			} else {
+				principal, err = authenticate(r.Context(), tokens, sessions, guests, fields[1])
 			}
-			guestToken := jwt.NewWithClaims(jwt.SigningMethodHS256, guestClaims)
-			tokenStr, err := guestToken.SignedString([]byte(guestTokenSecret))
 			if err != nil {
-				http.Error(w, "Failed to generate guest token", http.StatusInternalServerError)
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
 				return
 			}
 
-			// Send the token back to the client
-			w.Header().Set("Authorization", "Bearer "+tokenStr)
+			next.ServeHTTP(w, r.WithContext(WithPrincipal(r.Context(), principal)))
+		})
+	}
+}
+
+// authenticate verifies an access or guest token and checks that its session,
+// or for a guest its account, is still live
+func authenticate(ctx context.Context, tokens utils.TokenVerifier, sessions SessionChecker, guests GuestChecker, tokenStr string) (*utils.Principal, error) {
+	principal, err := tokens.Verify(tokenStr, utils.TokenTypeAccess, utils.TokenTypeGuest)
+	if err != nil {
+		return nil, err
+	}
 
-			// Set the user context with guest account information
-			ctx := context.WithValue(r.Context(), userContextKey, guestClaims)
-			next.ServeHTTP(w, r.WithContext(ctx))
-			return
+	// Guest tokens are not tied to a session
+	if principal.SessionID == "" {
+		exists, err := guests.GuestExists(ctx, principal.UserID)
+		if err != nil {
+			return nil, err
 		}
+		if !exists {
+			return nil, errors.New("guest no longer exists")
+		}
+		return principal, nil
+	}
 
-		tokenStr := strings.Split(authHeader, " ")[1]
-		token, err := jwt.Parse(tokenStr, func(token *jwt.Token) (interface{}, error) {
-			return []byte(guestTokenSecret), nil // Replace with env variable
-		})
+	revoked, err := sessions.IsRevoked(ctx, principal.SessionID)
+	if err != nil {
+		return nil, err
+	}
+	if revoked {
+		return nil, errors.New("session has been revoked")
+	}
+	return principal, nil
+}
 
-		if err != nil || !token.Valid {
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
-			return
-		}
+// WithPrincipal returns a context carrying the authenticated principal
+func WithPrincipal(ctx context.Context, principal *utils.Principal) context.Context {
+	return context.WithValue(ctx, principalContextKey, principal)
+}
+
+// GetPrincipal returns the principal making the request, or nil if there is none
+func GetPrincipal(ctx context.Context) *utils.Principal {
+	principal, _ := ctx.Value(principalContextKey).(*utils.Principal)
+	return principal
+}
+
+// GetUserID returns the ID of the user making the request, or "" if there is none
+func GetUserID(ctx context.Context) string {
+	if principal := GetPrincipal(ctx); principal != nil {
+		return principal.UserID
+	}
+	return ""
+}
+
+// GetUsername returns the username of the user making the request, or "" if there is none
+func GetUsername(ctx context.Context) string {
+	if principal := GetPrincipal(ctx); principal != nil {
+		return principal.Username
+	}
+	return ""
+}
 
-		ctx := context.WithValue(r.Context(), userContextKey, token.Claims.(jwt.MapClaims))
-		next.ServeHTTP(w, r.WithContext(ctx))
-	})
+// GetSessionID returns the session the request's token was issued for, or "" for guest tokens
+func GetSessionID(ctx context.Context) string {
+	if principal := GetPrincipal(ctx); principal != nil {
+		return principal.SessionID
+	}
+	return ""
 }
@@ -0,0 +1,81 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"rtdocs/utils"
+	"testing"
+)
+
+type revokedSessions map[string]bool
+
+func (s revokedSessions) IsRevoked(ctx context.Context, sessionID string) (bool, error) {
+	return s[sessionID], nil
+}
+
+type liveGuests map[string]bool
+
+func (g liveGuests) GuestExists(ctx context.Context, guestID string) (bool, error) {
+	return g[guestID], nil
+}
+
+func TestAuthMiddleware(t *testing.T) {
+	tokens := utils.NewTokenGenerator("secret", "guest-secret", "15m", "24h")
+	access, err := tokens.GenerateToken("user", "alice", "live")
+	if err != nil {
+		t.Fatal(err)
+	}
+	revoked, err := tokens.GenerateToken("user", "alice", "revoked")
+	if err != nil {
+		t.Fatal(err)
+	}
+	guest, err := tokens.GenerateGuestToken("guest", "guest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	swept, err := tokens.GenerateGuestToken("swept", "guest")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handler := AuthMiddleware(tokens, revokedSessions{"revoked": true}, liveGuests{"guest": true}, nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(GetUserID(r.Context())))
+	}))
+
+	tests := []struct {
+		name   string
+		header string
+		status int
+		user   string
+	}{
+		{"no token", "", http.StatusUnauthorized, ""},
+		{"not bearer", "Basic " + access.AccessToken, http.StatusUnauthorized, ""},
+		{"access token", "Bearer " + access.AccessToken, http.StatusOK, "user"},
+		{"revoked session", "Bearer " + revoked.AccessToken, http.StatusUnauthorized, ""},
+		{"refresh token", "Bearer " + access.RefreshToken, http.StatusUnauthorized, ""},
+		{"guest token", "Bearer " + guest, http.StatusOK, "guest"},
+		{"swept guest", "Bearer " + swept, http.StatusUnauthorized, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/api/documents", nil)
+			if tt.header != "" {
+				r.Header.Set("Authorization", tt.header)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d", w.Code, tt.status)
+			}
+			if tt.status == http.StatusOK && w.Body.String() != tt.user {
+				t.Fatalf("user = %q, want %q", w.Body.String(), tt.user)
+			}
+			if w.Header().Get("Authorization") != "" {
+				t.Fatal("the middleware handed out a token")
+			}
+		})
+	}
+}
@@ -0,0 +1,39 @@
+package middleware
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"slices"
+)
+
+// RoleLookup returns the account role of a user, or "" if the user does not exist
+type RoleLookup interface {
+	GetRole(ctx context.Context, userID string) (string, error)
+}
+
+// RequireRole lets a request through only if the authenticated user has one of
+// roles. It must run after AuthMiddleware. The role is read from the database
+// rather than the token so that revoking it takes effect straight away.
+func RequireRole(users RoleLookup, roles ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			userID := GetUserID(r.Context())
+			if userID == "" {
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+
+			role, err := users.GetRole(r.Context(), userID)
+			if err != nil {
+				log.Printf("Failed to look up role of user %s: %v", userID, err)
+			}
+			if err != nil || !slices.Contains(roles, role) {
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,74 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"rtdocs/utils"
+	"testing"
+)
+
+type accountRoles struct {
+	roles map[string]string
+	err   error
+}
+
+func (a *accountRoles) GetRole(ctx context.Context, userID string) (string, error) {
+	return a.roles[userID], a.err
+}
+
+func TestRequireRole(t *testing.T) {
+	tests := []struct {
+		name   string
+		userID string
+		err    error
+		status int
+	}{
+		{"anonymous", "", nil, http.StatusUnauthorized},
+		{"admin", "admin", nil, http.StatusOK},
+		{"user", "user", nil, http.StatusForbidden},
+		{"guest", "guest", nil, http.StatusForbidden},
+		{"deleted user", "deleted", nil, http.StatusForbidden},
+		{"failing lookup", "admin", errors.New("connection refused"), http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			users := &accountRoles{roles: map[string]string{"admin": "admin", "user": "authenticated", "guest": "guest"}, err: tt.err}
+			handler := RequireRole(users, "admin")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+			r := httptest.NewRequest(http.MethodGet, "/api/users", nil)
+			if tt.userID != "" {
+				// The token claims admin for everyone; only the stored role counts
+				r = r.WithContext(WithPrincipal(r.Context(), &utils.Principal{UserID: tt.userID, Role: "admin"}))
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d", w.Code, tt.status)
+			}
+		})
+	}
+}
+
+func TestRequireRoleSeesDemotionStraightAway(t *testing.T) {
+	users := &accountRoles{roles: map[string]string{"alice": "admin"}}
+	handler := RequireRole(users, "admin")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	request := func() int {
+		r := httptest.NewRequest(http.MethodGet, "/api/users", nil)
+		r = r.WithContext(WithPrincipal(r.Context(), &utils.Principal{UserID: "alice"}))
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	if status := request(); status != http.StatusOK {
+		t.Fatalf("admin status = %d, want 200", status)
+	}
+	users.roles["alice"] = "authenticated"
+	if status := request(); status != http.StatusForbidden {
+		t.Fatalf("status after demotion = %d, want 403", status)
+	}
+}
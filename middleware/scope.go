@@ -0,0 +1,41 @@
+package middleware
+
+import (
+	"net/http"
+)
+
+// RequireScope limits what personal access tokens can do: reads need the read
+// scope and anything else the write scope. Other tokens are not restricted.
+// It must run after AuthMiddleware.
+func RequireScope(read, write string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			scope := write
+			if r.Method == http.MethodGet || r.Method == http.MethodHead {
+				scope = read
+			}
+
+			if principal := GetPrincipal(r.Context()); principal != nil && !principal.HasScope(scope) {
+				http.Error(w, "Token is missing the "+scope+" scope", http.StatusForbidden)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RejectPersonalTokens keeps personal access tokens away from routes that
+// manage the account itself, such as sessions, 2FA and the tokens themselves,
+// so a leaked token cannot be used to take the account over.
+// It must run after AuthMiddleware.
+func RejectPersonalTokens(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if principal := GetPrincipal(r.Context()); principal != nil && principal.IsPersonalToken() {
+			http.Error(w, "Personal access tokens cannot be used here", http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,55 @@
+package middleware
+
+import (
+	"net/http"
+	"rtdocs/utils"
+	"strings"
+
+	"github.com/gorilla/websocket"
+)
+
+// WebSocketTokenProtocol is the subprotocol a browser offers before its token,
+// e.g. new WebSocket(url, ["bearer", token]). The server selects it when upgrading.
+const WebSocketTokenProtocol = "bearer"
+
+// WebSocketAuthMiddleware authenticates WebSocket upgrades. Browsers cannot
+// set headers on WebSocket requests, so the access or guest token is read
+// from the access_token query parameter or from Sec-WebSocket-Protocol as
+// the protocol following "bearer", and only then from Authorization.
+func WebSocketAuthMiddleware(tokens utils.TokenVerifier, sessions SessionChecker, guests GuestChecker) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			tokenStr := webSocketToken(r)
+			if tokenStr == "" {
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+
+			principal, err := authenticate(r.Context(), tokens, sessions, guests, tokenStr)
+			if err != nil {
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+
+			next.ServeHTTP(w, r.WithContext(WithPrincipal(r.Context(), principal)))
+		})
+	}
+}
+
+func webSocketToken(r *http.Request) string {
+	if token := r.URL.Query().Get("access_token"); token != "" {
+		return token
+	}
+
+	protocols := websocket.Subprotocols(r)
+	for i, protocol := range protocols {
+		if protocol == WebSocketTokenProtocol && i+1 < len(protocols) {
+			return protocols[i+1]
+		}
+	}
+
+	if fields := strings.Fields(r.Header.Get("Authorization")); len(fields) == 2 {
+		return fields[1]
+	}
+	return ""
+}
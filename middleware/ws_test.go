@@ -0,0 +1,68 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"rtdocs/utils"
+	"testing"
+)
+
+func TestWebSocketAuthMiddleware(t *testing.T) {
+	tokens := utils.NewTokenGenerator("secret", "guest-secret", "15m", "24h")
+	pair, err := tokens.GenerateToken("user", "alice", "live")
+	if err != nil {
+		t.Fatal(err)
+	}
+	guest, err := tokens.GenerateGuestToken("guest", "Guest 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var principal *utils.Principal
+	handler := WebSocketAuthMiddleware(tokens, revokedSessions{}, liveGuests{"guest": true})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		principal = GetPrincipal(r.Context())
+	}))
+
+	tests := []struct {
+		name     string
+		query    string
+		protocol string
+		header   string
+		want     *utils.Principal // nil when the upgrade must be refused
+	}{
+		{"no token", "", "", "", nil},
+		{"query", "?access_token=" + pair.AccessToken, "", "", &utils.Principal{UserID: "user", Type: utils.TokenTypeAccess, SessionID: "live"}},
+		{"protocol", "", "bearer, " + pair.AccessToken, "", &utils.Principal{UserID: "user", Type: utils.TokenTypeAccess, SessionID: "live"}},
+		{"protocol without a token", "", "bearer", "", nil},
+		{"header", "", "", "Bearer " + guest, &utils.Principal{UserID: "guest", Type: utils.TokenTypeGuest}},
+		{"refresh token", "?access_token=" + pair.RefreshToken, "", "", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			principal = nil
+			r := httptest.NewRequest(http.MethodGet, "/ws/doc"+tt.query, nil)
+			if tt.protocol != "" {
+				r.Header.Set("Sec-WebSocket-Protocol", tt.protocol)
+			}
+			if tt.header != "" {
+				r.Header.Set("Authorization", tt.header)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+
+			if tt.want == nil {
+				if w.Code != http.StatusUnauthorized {
+					t.Fatalf("status = %d, want 401", w.Code)
+				}
+				return
+			}
+			if principal == nil {
+				t.Fatalf("status = %d with no principal", w.Code)
+			}
+			if principal.UserID != tt.want.UserID || principal.Type != tt.want.Type || principal.SessionID != tt.want.SessionID {
+				t.Fatalf("principal = %+v, want %+v", principal, tt.want)
+			}
+		})
+	}
+}
@@ -8,15 +8,135 @@ type Document struct {
 	Content   string    `json:"content"`
 	OwnerID   string    `json:"owner_id"` // ID of user who created it
 	IsPublic  bool      `json:"is_public"`
-	CanEdit   bool      `json:"can_edit"` // For access control
+	CanEdit   bool      `json:"can_edit"`  // For access control
+	SyncMode  string    `json:"sync_mode"` // "ot" or "crdt"
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
 }
 
+// Document roles, from least to most privileged. Owners are recorded on the
+// document itself; the other roles are granted per user.
+const (
+	RoleViewer    = "viewer"
+	RoleCommenter = "commenter"
+	RoleEditor    = "editor"
+	RoleOwner     = "owner"
+)
+
+type Permission struct {
+	DocumentID string    `json:"document_id"`
+	UserID     string    `json:"user_id"`
+	Role       string    `json:"role"`
+	GrantedBy  string    `json:"granted_by"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+type Revision struct {
+	ID         string    `json:"id"`
+	DocumentID string    `json:"document_id"`
+	Title      string    `json:"title"`
+	Content    string    `json:"content,omitempty"` // Omitted when listing revisions
+	AuthorID   string    `json:"author_id"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// Account roles. Guests are temporary accounts that expire with their token
+// unless they register or log in, which moves their data to the real account.
+// Admins can manage every user.
+const (
+	UserRoleAuthenticated = "authenticated"
+	UserRoleGuest         = "guest"
+	UserRoleAdmin         = "admin"
+)
+
 type User struct {
 	ID        string    `json:"id"`
 	Username  string    `json:"username"`
-	Password  string    `json:"password"`
+	Email     string    `json:"email"`
+	Verified  bool      `json:"verified"`           // Whether the user has confirmed they own Email
+	Password  string    `json:"password,omitempty"` // Only ever sent by clients, never returned
 	Role      string    `json:"role"`
 	CreatedAt time.Time `json:"created_at"`
+
+	DisabledAt            *time.Time `json:"disabled_at,omitempty"` // Disabled users cannot log in
+	PasswordResetRequired bool       `json:"password_reset_required"`
+}
+
+// Reasons a login attempt failed, recorded for auditing
+const (
+	LoginFailureUnknownUser     = "unknown_user"
+	LoginFailureBadPassword     = "bad_password"
+	LoginFailureBadMFACode      = "bad_mfa_code"
+	LoginFailureAccountDisabled = "account_disabled"
+	LoginFailureThrottled       = "throttled"
+)
+
+// LoginAttempt records one check of a user's credentials. UserID is empty
+// when the username did not match an account.
+type LoginAttempt struct {
+	ID          int64     `json:"id"`
+	Username    string    `json:"username"`
+	UserID      string    `json:"user_id,omitempty"`
+	IPAddress   string    `json:"ip_address"`
+	UserAgent   string    `json:"user_agent"`
+	Succeeded   bool      `json:"succeeded"`
+	Reason      string    `json:"reason,omitempty"`
+	AttemptedAt time.Time `json:"attempted_at"`
+}
+
+// Scopes a personal access token can be given
+const (
+	ScopeDocsRead  = "docs:read"  // read documents, revisions and permissions
+	ScopeDocsWrite = "docs:write" // create, edit and share documents
+)
+
+// PersonalToken is a long-lived credential for scripts and CI jobs. Only a
+// hash of the token is stored; the token itself is shown once, when created.
+type PersonalToken struct {
+	ID         string     `json:"id"`
+	UserID     string     `json:"user_id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	TokenHash  string     `json:"-"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+}
+
+// Identity links a user to their account at an external identity provider
+type Identity struct {
+	Issuer    string    `json:"issuer"`
+	Subject   string    `json:"subject"`
+	UserID    string    `json:"user_id"`
+	Email     string    `json:"email"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// TOTP is a user's authenticator app enrollment. Two-factor authentication
+// is only on once EnabledAt is set.
+type TOTP struct {
+	UserID    string     `json:"-"`
+	Secret    string     `json:"-"`
+	EnabledAt *time.Time `json:"enabled_at,omitempty"`
+	LastStep  int64      `json:"-"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// Session is a refresh token family. Token holds a hash of the only refresh
+// token in the family that may still be used.
+type Session struct {
+	ID         string     `json:"id"`
+	UserID     string     `json:"user_id"`
+	Token      string     `json:"-"`
+	Device     string     `json:"device"`
+	IPAddress  string     `json:"ip_address"`
+	UserAgent  string     `json:"user_agent"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastSeenAt time.Time  `json:"last_seen_at"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	RotatedAt  *time.Time `json:"rotated_at,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
 }
@@ -0,0 +1,22 @@
+package web
+
+import "rtdocs/model/domain"
+
+type UserPage struct {
+	Users  []*domain.User `json:"users"`
+	Total  int            `json:"total"` // Number of users matching the search across all pages
+	Limit  int            `json:"limit"`
+	Offset int            `json:"offset"`
+}
+
+type TransferDocuments struct {
+	ToUserID string `json:"to_user_id"`
+}
+
+type TransferResult struct {
+	Transferred int64 `json:"transferred"`
+}
+
+type KickResult struct {
+	Kicked int `json:"kicked"`
+}
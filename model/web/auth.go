@@ -1,18 +1,32 @@
 package web
 
+import (
+	"rtdocs/model/domain"
+	"time"
+)
+
 type LoginRequest struct {
-	Username string `json:"username"`
-	Password string `json:"password"`
+	Username   string `json:"username"`
+	Password   string `json:"password"`
+	GuestToken string `json:"guest_token"` // optional; moves the guest's data to this account
+	Client     Client `json:"-"`
 }
 
+// LoginResponse holds either a token pair or, when the account has two-factor
+// authentication on, the MFA token to send with the second factor
 type LoginResponse struct {
-	AccessToken  string `json:"access_token"`
-	RefreshToken string `json:"refresh_token"`
+	AccessToken  string `json:"access_token,omitempty"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	MFARequired  bool   `json:"mfa_required,omitempty"`
+	MFAToken     string `json:"mfa_token,omitempty"`
 }
 
 type RegisterRequest struct {
-	Username string `json:"username"`
-	Password string `json:"password"`
+	Username   string `json:"username"`
+	Email      string `json:"email"`
+	Password   string `json:"password"`
+	GuestToken string `json:"guest_token"` // optional; moves the guest's data to this account
+	Client     Client `json:"-"`
 }
 
 type RegisterResponse struct {
@@ -24,3 +38,78 @@ type RegisterResponse struct {
 type LogoutRequest struct {
 	AccessToken string `json:"access_token"`
 }
+
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+type ForgotPasswordRequest struct {
+	Email string `json:"email"`
+}
+
+type ResetPasswordRequest struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+type VerifyEmailRequest struct {
+	Token string `json:"token"`
+}
+
+// Client describes where a login came from; it is filled in from the request, not the body
+type Client struct {
+	IPAddress string
+	UserAgent string
+}
+
+type SessionResponse struct {
+	*domain.Session
+	Current bool `json:"current"` // the session of the token making the request
+}
+
+// MFALoginRequest completes a login that needs a second factor
+type MFALoginRequest struct {
+	MFAToken   string `json:"mfa_token"`
+	Code       string `json:"code"`        // from an authenticator app, or a recovery code
+	GuestToken string `json:"guest_token"` // optional; moves the guest's data to this account
+	Client     Client `json:"-"`
+}
+
+// OIDCCallbackRequest carries the query parameters the identity provider
+// redirected back to the app with
+type OIDCCallbackRequest struct {
+	Code       string `json:"code"`
+	State      string `json:"state"`
+	Error      string `json:"error"`       // set instead of Code when the provider refused the sign-in
+	GuestToken string `json:"guest_token"` // optional; moves the guest's data to this account
+	// BrowserState is the state the sign-in was started with, from the
+	// browser's cookie; it must match State
+	BrowserState string `json:"-"`
+	Client       Client `json:"-"`
+}
+
+type TOTPEnrollment struct {
+	Secret          string `json:"secret"`
+	ProvisioningURI string `json:"provisioning_uri"`
+}
+
+type MFACodeRequest struct {
+	Code string `json:"code"`
+}
+
+// RecoveryCodes are shown to the user once; only their hashes are kept
+type RecoveryCodes struct {
+	Codes []string `json:"recovery_codes"`
+}
+
+type CreatePersonalToken struct {
+	Name      string     `json:"name"`
+	Scopes    []string   `json:"scopes"`
+	ExpiresAt *time.Time `json:"expires_at"` // optional; tokens without one last until revoked
+}
+
+// CreatedPersonalToken is the only response that includes the token itself
+type CreatedPersonalToken struct {
+	*domain.PersonalToken
+	Token string `json:"token"`
+}
@@ -0,0 +1,11 @@
+package web
+
+import "rtdocs/diff"
+
+type DiffResponse struct {
+	DocumentID string      `json:"document_id"`
+	From       string      `json:"from"` // Revision ID or "live"
+	To         string      `json:"to"`   // Revision ID or "live"
+	Unified    string      `json:"unified"`
+	Hunks      []diff.Hunk `json:"hunks"`
+}
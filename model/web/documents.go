@@ -1,7 +1,17 @@
 package web
 
 type CreateDocument struct {
-	Title   string `json:"title"`
-	Content string `json:"content"`
-	OwnerID string `json:"owner_id"` // ID of user who created it
+	Title    string `json:"title"`
+	Content  string `json:"content"`
+	OwnerID  string `json:"owner_id"`  // ID of user who created it
+	SyncMode string `json:"sync_mode"` // "ot" (default) or "crdt"
+}
+
+type ShareDocument struct {
+	IsPublic bool `json:"is_public"`
+	CanEdit  bool `json:"can_edit"`
+}
+
+type GrantPermission struct {
+	Role string `json:"role"` // "editor", "commenter" or "viewer"
 }
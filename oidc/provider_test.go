@@ -0,0 +1,129 @@
+package oidc
+
+import (
+	"context"
+	"errors"
+	"rtdocs/oidc/oidctest"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func newTestProvider(idp *oidctest.Server) *Provider {
+	return NewProvider(Config{
+		Issuer:       idp.URL,
+		ClientID:     idp.ClientID,
+		ClientSecret: idp.ClientSecret,
+		RedirectURL:  idp.RedirectURL,
+		Scopes:       []string{"openid", "email"},
+	}, idp.Client())
+}
+
+func TestSignIn(t *testing.T) {
+	idp := oidctest.NewServer()
+	defer idp.Close()
+	provider := newTestProvider(idp)
+	ctx := context.Background()
+
+	identity := oidctest.Identity{Subject: "alice", Email: "alice@example.com", EmailVerified: true, PreferredUsername: "alice"}
+	authURL, err := provider.AuthCodeURL(ctx, "state", "nonce", "verifier")
+	if err != nil {
+		t.Fatal(err)
+	}
+	redirect, err := idp.Authorize(authURL, identity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if redirect.Query().Get("state") != "state" {
+		t.Fatalf("state = %q, want it passed through", redirect.Query().Get("state"))
+	}
+	code := redirect.Query().Get("code")
+
+	claims, err := provider.Exchange(ctx, code, "verifier", "nonce")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Claims{Issuer: idp.URL, Subject: "alice", Email: "alice@example.com", EmailVerified: true, PreferredUsername: "alice"}
+	if *claims != want {
+		t.Fatalf("claims = %+v, want %+v", *claims, want)
+	}
+
+	// Codes are single use
+	if _, err := provider.Exchange(ctx, code, "verifier", "nonce"); err == nil {
+		t.Fatal("an authorization code was exchanged twice")
+	}
+}
+
+func TestExchangeChecksPKCE(t *testing.T) {
+	idp := oidctest.NewServer()
+	defer idp.Close()
+	provider := newTestProvider(idp)
+	ctx := context.Background()
+
+	authURL, err := provider.AuthCodeURL(ctx, "state", "nonce", "verifier")
+	if err != nil {
+		t.Fatal(err)
+	}
+	redirect, err := idp.Authorize(authURL, oidctest.Identity{Subject: "alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Someone who intercepted the code does not have the verifier
+	if _, err := provider.Exchange(ctx, redirect.Query().Get("code"), "another verifier", "nonce"); err == nil {
+		t.Fatal("the code was exchanged without its verifier")
+	}
+}
+
+func TestVerifyIDToken(t *testing.T) {
+	idp := oidctest.NewServer()
+	defer idp.Close()
+	provider := newTestProvider(idp)
+	identity := oidctest.Identity{Subject: "alice", Email: "alice@example.com"}
+
+	tests := []struct {
+		name   string
+		nonce  string
+		claims jwt.MapClaims
+		ok     bool
+	}{
+		{"valid", "nonce", nil, true},
+		{"other nonce", "another nonce", nil, false},
+		{"other audience", "nonce", jwt.MapClaims{"aud": "another client"}, false},
+		{"other issuer", "nonce", jwt.MapClaims{"iss": "https://idp.example.com"}, false},
+		{"expired", "nonce", jwt.MapClaims{"exp": time.Now().Add(-time.Hour).Unix()}, false},
+		{"no subject", "nonce", jwt.MapClaims{"sub": ""}, false},
+		{"several audiences without azp", "nonce", jwt.MapClaims{"aud": []string{idp.ClientID, "another client"}}, false},
+		{"several audiences with azp", "nonce", jwt.MapClaims{"aud": []string{idp.ClientID, "another client"}, "azp": idp.ClientID}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := provider.VerifyIDToken(context.Background(), idp.IDToken(identity, "nonce", tt.claims), tt.nonce)
+			if (err == nil) != tt.ok {
+				t.Fatalf("VerifyIDToken = %v, want ok %v", err, tt.ok)
+			}
+			if err != nil && !errors.Is(err, ErrInvalidIDToken) {
+				t.Fatalf("VerifyIDToken = %v, want ErrInvalidIDToken", err)
+			}
+		})
+	}
+}
+
+func TestEmailVerifiedAsString(t *testing.T) {
+	idp := oidctest.NewServer()
+	defer idp.Close()
+	provider := newTestProvider(idp)
+
+	for value, want := range map[string]bool{"true": true, "false": false} {
+		raw := idp.IDToken(oidctest.Identity{Subject: "alice"}, "nonce", jwt.MapClaims{"email_verified": value})
+		claims, err := provider.VerifyIDToken(context.Background(), raw, "nonce")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if claims.EmailVerified != want {
+			t.Errorf("email_verified %q read as %v", value, claims.EmailVerified)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package realtime
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	writeWait      = 10 * time.Second
+	sendBufferSize = 256
+)
+
+// Client is a single WebSocket connection subscribed to one document. ID
+// tells apart several connections by the same user, e.g. two browser tabs.
+type Client struct {
+	ID         string
+	DocumentID string
+	UserID     string
+	Username   string
+
+	conn        *websocket.Conn
+	send        chan interface{}
+	done        chan struct{}
+	closeOnce   sync.Once
+	closeReason string
+}
+
+func NewClient(conn *websocket.Conn, documentID, userID, username string) *Client {
+	return &Client{
+		ID:         uuid.New().String(),
+		DocumentID: documentID,
+		UserID:     userID,
+		Username:   username,
+		conn:       conn,
+		send:       make(chan interface{}, sendBufferSize),
+		done:       make(chan struct{}),
+	}
+}
+
+// Send queues a payload for this client only. It returns false if the client
+// is closed or its buffer is full.
+func (c *Client) Send(payload interface{}) bool {
+	select {
+	case <-c.done:
+		return false
+	default:
+	}
+
+	select {
+	case c.send <- payload:
+		return true
+	default:
+		return false
+	}
+}
+
+// Close stops the write pump. It is safe to call more than once.
+func (c *Client) Close() {
+	c.closeOnce.Do(func() {
+		close(c.done)
+	})
+}
+
+// Kick closes the connection with a policy violation close frame carrying
+// reason, so the client knows not to reconnect straight away
+func (c *Client) Kick(reason string) {
+	c.closeOnce.Do(func() {
+		c.closeReason = reason
+		close(c.done)
+	})
+}
+
+// WritePump serialises all writes to the connection; gorilla/websocket
+// connections support only one concurrent writer.
+func (c *Client) WritePump() {
+	defer c.conn.Close()
+
+	for {
+		select {
+		case payload := <-c.send:
+			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := c.conn.WriteJSON(payload); err != nil {
+				log.Printf("Write error: %v", err)
+				return
+			}
+		case <-c.done:
+			message := []byte{}
+			if c.closeReason != "" {
+				message = websocket.FormatCloseMessage(websocket.ClosePolicyViolation, c.closeReason)
+			}
+			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			c.conn.WriteMessage(websocket.CloseMessage, message)
+			return
+		}
+	}
+}
@@ -0,0 +1,272 @@
+package realtime
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	UpdateInsert = "insert"
+	UpdateDelete = "delete"
+)
+
+// ServerSite is the CRDT site of changes made by the server rather than an editor
+const ServerSite = "server"
+
+var ErrInvalidUpdate = errors.New("invalid CRDT update")
+
+// ID identifies an element by the Lamport clock and site that inserted it.
+// The zero ID stands for the start of the document.
+type ID struct {
+	Clock int    `json:"clock"`
+	Site  string `json:"site"`
+}
+
+func (a ID) less(b ID) bool {
+	if a.Clock != b.Clock {
+		return a.Clock < b.Clock
+	}
+	return a.Site < b.Site
+}
+
+func (a ID) isZero() bool {
+	return a.Clock == 0 && a.Site == ""
+}
+
+// Element is one code point of a sequence. Deleted elements are kept as
+// tombstones so later inserts can still reference them.
+type Element struct {
+	ID      ID     `json:"id"`
+	Origin  ID     `json:"origin"`
+	Value   string `json:"value"`
+	Deleted bool   `json:"deleted,omitempty"`
+}
+
+// Update is a single change a peer made to a sequence. An insert places
+// Value immediately after Origin; a delete tombstones the element with ID.
+type Update struct {
+	Type   string `json:"type"`
+	ID     ID     `json:"id"`
+	Origin ID     `json:"origin"`
+	Value  string `json:"value,omitempty"`
+}
+
+// Sequence is a replicated growable array (RGA). Updates commute, so peers
+// that have integrated the same set of updates hold the same sequence
+// regardless of the order they arrived in.
+type Sequence struct {
+	elements []Element
+	present  map[ID]bool
+	pending  []Update
+
+	// onChange, if set, is told the visible index and length change of every
+	// integrated update
+	onChange func(index, delta int)
+}
+
+func NewSequence() *Sequence {
+	return &Sequence{present: make(map[ID]bool)}
+}
+
+// SequenceFromText builds a sequence holding text as if site had typed it
+// from left to right
+func SequenceFromText(text, site string) *Sequence {
+	s := NewSequence()
+	origin := ID{}
+	clock := 0
+	for _, r := range text {
+		clock++
+		id := ID{Clock: clock, Site: site}
+		s.elements = append(s.elements, Element{ID: id, Origin: origin, Value: string(r)})
+		s.present[id] = true
+		origin = id
+	}
+	return s
+}
+
+// DecodeSequence restores a sequence from the output of Encode
+func DecodeSequence(data []byte) (*Sequence, error) {
+	s := NewSequence()
+	if err := json.Unmarshal(data, &s.elements); err != nil {
+		return nil, err
+	}
+	for _, e := range s.elements {
+		s.present[e.ID] = true
+	}
+	return s, nil
+}
+
+// Encode serialises the sequence, including tombstones
+func (s *Sequence) Encode() ([]byte, error) {
+	if s.elements == nil {
+		return []byte("[]"), nil
+	}
+	return json.Marshal(s.elements)
+}
+
+// Elements returns a copy of the sequence's elements
+func (s *Sequence) Elements() []Element {
+	elements := make([]Element, len(s.elements))
+	copy(elements, s.elements)
+	return elements
+}
+
+// Text returns the visible content of the sequence
+func (s *Sequence) Text() string {
+	var b strings.Builder
+	for _, e := range s.elements {
+		if !e.Deleted {
+			b.WriteString(e.Value)
+		}
+	}
+	return b.String()
+}
+
+// Replace returns the updates that turn the visible text into text, as made
+// by site, and integrates them. Clocks start after the highest clock in the
+// sequence so the new IDs cannot collide with existing ones.
+func (s *Sequence) Replace(text, site string) []Update {
+	clock := 0
+	var updates []Update
+	for _, e := range s.elements {
+		clock = max(clock, e.ID.Clock)
+		if !e.Deleted {
+			updates = append(updates, Update{Type: UpdateDelete, ID: e.ID})
+		}
+	}
+
+	origin := ID{}
+	for _, r := range text {
+		clock++
+		id := ID{Clock: clock, Site: site}
+		updates = append(updates, Update{Type: UpdateInsert, ID: id, Origin: origin, Value: string(r)})
+		origin = id
+	}
+
+	s.Integrate(updates)
+	return updates
+}
+
+// Integrate merges updates into the sequence and returns the ones that
+// changed it. Duplicates are ignored, and updates whose origin or target
+// has not arrived yet are held back until it does.
+func (s *Sequence) Integrate(updates []Update) ([]Update, error) {
+	for _, u := range updates {
+		if err := u.validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	var applied []Update
+	queue := append(s.pending, updates...)
+	s.pending = nil
+
+	for {
+		var deferred []Update
+		progress := false
+		for _, u := range queue {
+			ok, ready := s.integrate(u)
+			if !ready {
+				deferred = append(deferred, u)
+				continue
+			}
+			progress = true
+			if ok {
+				applied = append(applied, u)
+			}
+		}
+		queue = deferred
+		if !progress || len(queue) == 0 {
+			break
+		}
+	}
+	s.pending = queue
+
+	return applied, nil
+}
+
+// integrate applies a single update. ready is false when the element it
+// depends on is missing; applied is false when the update was a no-op.
+func (s *Sequence) integrate(u Update) (applied, ready bool) {
+	switch u.Type {
+	case UpdateInsert:
+		if s.present[u.ID] {
+			return false, true
+		}
+		pos := 0
+		if !u.Origin.isZero() {
+			i := s.indexOf(u.Origin)
+			if i < 0 {
+				return false, false
+			}
+			pos = i + 1
+		}
+		// Skip over concurrent inserts at the same origin that sort after this one,
+		// along with everything inserted after them
+		for pos < len(s.elements) && u.ID.less(s.elements[pos].ID) {
+			pos++
+		}
+		s.elements = append(s.elements, Element{})
+		copy(s.elements[pos+1:], s.elements[pos:])
+		s.elements[pos] = Element{ID: u.ID, Origin: u.Origin, Value: u.Value}
+		s.present[u.ID] = true
+		s.changed(pos, 1)
+		return true, true
+	case UpdateDelete:
+		i := s.indexOf(u.ID)
+		if i < 0 {
+			return false, false
+		}
+		if s.elements[i].Deleted {
+			return false, true
+		}
+		s.elements[i].Deleted = true
+		s.changed(i, -1)
+		return true, true
+	}
+	return false, true
+}
+
+// changed reports a change at element i to onChange in visible positions
+func (s *Sequence) changed(i, delta int) {
+	if s.onChange == nil {
+		return
+	}
+	visible := 0
+	for _, e := range s.elements[:i] {
+		if !e.Deleted {
+			visible++
+		}
+	}
+	s.onChange(visible, delta)
+}
+
+func (s *Sequence) indexOf(id ID) int {
+	if !s.present[id] {
+		return -1
+	}
+	for i, e := range s.elements {
+		if e.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+func (u Update) validate() error {
+	if u.ID.isZero() {
+		return ErrInvalidUpdate
+	}
+	switch u.Type {
+	case UpdateInsert:
+		if utf8.RuneCountInString(u.Value) != 1 {
+			return ErrInvalidUpdate
+		}
+	case UpdateDelete:
+	default:
+		return ErrInvalidUpdate
+	}
+	return nil
+}
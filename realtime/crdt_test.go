@@ -0,0 +1,251 @@
+package realtime
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"testing"
+)
+
+func insert(clock int, site string, origin ID, value string) Update {
+	return Update{Type: UpdateInsert, ID: ID{Clock: clock, Site: site}, Origin: origin, Value: value}
+}
+
+func remove(clock int, site string) Update {
+	return Update{Type: UpdateDelete, ID: ID{Clock: clock, Site: site}}
+}
+
+func TestIntegrate(t *testing.T) {
+	a1 := ID{Clock: 1, Site: "a"}
+	tests := []struct {
+		name    string
+		updates []Update
+		want    string
+		applied int
+	}{
+		{"empty", nil, "", 0},
+		{"typing", []Update{insert(1, "a", ID{}, "h"), insert(2, "a", a1, "i")}, "hi", 2},
+		{"delete", []Update{insert(1, "a", ID{}, "h"), remove(1, "a")}, "", 2},
+		{"duplicate insert", []Update{insert(1, "a", ID{}, "h"), insert(1, "a", ID{}, "h")}, "h", 1},
+		{"duplicate delete", []Update{insert(1, "a", ID{}, "h"), remove(1, "a"), remove(1, "a")}, "", 2},
+		{"origin arrives later", []Update{insert(2, "a", a1, "i"), insert(1, "a", ID{}, "h")}, "hi", 2},
+		{"delete arrives first", []Update{remove(1, "a"), insert(1, "a", ID{}, "h")}, "", 2},
+		{"missing origin", []Update{insert(2, "a", ID{Clock: 9, Site: "z"}, "x")}, "", 0},
+		{"concurrent inserts", []Update{insert(1, "a", ID{}, "x"), insert(1, "b", ID{}, "y")}, "yx", 2},
+		{"unicode", []Update{insert(1, "a", ID{}, "é"), insert(2, "a", a1, "😀")}, "é😀", 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewSequence()
+			applied, err := s.Integrate(tt.updates)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := s.Text(); got != tt.want {
+				t.Fatalf("Text = %q, want %q", got, tt.want)
+			}
+			if len(applied) != tt.applied {
+				t.Fatalf("applied %d updates, want %d", len(applied), tt.applied)
+			}
+		})
+	}
+}
+
+func TestIntegrateRejectsInvalidUpdates(t *testing.T) {
+	invalid := []Update{
+		{Type: UpdateInsert, Value: "x"},
+		insert(1, "a", ID{}, ""),
+		insert(1, "a", ID{}, "xy"),
+		{Type: "move", ID: ID{Clock: 1, Site: "a"}},
+	}
+	for _, u := range invalid {
+		if _, err := NewSequence().Integrate([]Update{u}); !errors.Is(err, ErrInvalidUpdate) {
+			t.Errorf("Integrate(%+v) error = %v, want ErrInvalidUpdate", u, err)
+		}
+	}
+}
+
+// TestIntegrateCommutes checks that every delivery order of concurrent edits
+// from several sites gives the same text
+func TestIntegrateCommutes(t *testing.T) {
+	base := SequenceFromText("hello", "server")
+	var updates []Update
+	for _, site := range []string{"a", "b", "c"} {
+		replica := decodeCopy(t, base)
+		updates = append(updates, replica.Replace(site+" "+replica.Text(), site)...)
+	}
+
+	reference := decodeCopy(t, base)
+	if _, err := reference.Integrate(updates); err != nil {
+		t.Fatal(err)
+	}
+
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 50; i++ {
+		shuffled := append([]Update(nil), updates...)
+		rng.Shuffle(len(shuffled), func(i, j int) { shuffled[i], shuffled[j] = shuffled[j], shuffled[i] })
+
+		replica := decodeCopy(t, base)
+		// Deliver in small batches so some updates wait for their origin
+		for len(shuffled) > 0 {
+			n := min(len(shuffled), 1+rng.Intn(3))
+			if _, err := replica.Integrate(shuffled[:n]); err != nil {
+				t.Fatal(err)
+			}
+			shuffled = shuffled[n:]
+		}
+		if replica.Text() != reference.Text() {
+			t.Fatalf("replica diverged: %q vs %q", replica.Text(), reference.Text())
+		}
+	}
+}
+
+func TestReplace(t *testing.T) {
+	s := SequenceFromText("abc", "server")
+	updates := s.Replace("xyz", "restore")
+	if s.Text() != "xyz" {
+		t.Fatalf("Text = %q, want \"xyz\"", s.Text())
+	}
+
+	// A peer merging the updates reaches the same text
+	peer := SequenceFromText("abc", "server")
+	if _, err := peer.Integrate(updates); err != nil {
+		t.Fatal(err)
+	}
+	if peer.Text() != "xyz" {
+		t.Fatalf("peer Text = %q, want \"xyz\"", peer.Text())
+	}
+
+	// New IDs must not reuse clocks already in the sequence
+	for _, u := range updates {
+		if u.Type == UpdateInsert && u.ID.Clock <= 3 {
+			t.Fatalf("insert %+v reuses an existing clock", u)
+		}
+	}
+}
+
+func TestEncodeRoundTrip(t *testing.T) {
+	s := SequenceFromText("añb", "server")
+	s.Integrate([]Update{remove(2, "server")})
+
+	decoded := decodeCopy(t, s)
+	if decoded.Text() != "ab" || len(decoded.Elements()) != 3 {
+		t.Fatalf("decoded %q with %d elements, want \"ab\" with the tombstone", decoded.Text(), len(decoded.Elements()))
+	}
+
+	empty, err := NewSequence().Encode()
+	if err != nil || string(empty) != "[]" {
+		t.Fatalf("Encode of an empty sequence = %q, %v", empty, err)
+	}
+}
+
+func TestMergeCompacts(t *testing.T) {
+	d := NewSequenceDocument("title", NewSequence())
+
+	var encoded int
+	origin := ID{}
+	for i := 1; i <= compactInterval; i++ {
+		u := insert(i, "a", origin, "x")
+		origin = u.ID
+		err := d.Merge([]Update{u}, func(applied []Update, state State) {
+			if state.Encoded != nil {
+				encoded = i
+			}
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if encoded != compactInterval {
+		t.Fatalf("encoded state handed over after update %d, want %d", encoded, compactInterval)
+	}
+}
+
+func TestHubReplaceSavesCompaction(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hub := NewHub()
+	go hub.Run(ctx)
+
+	room := hub.Join(NewClient(nil, "doc", "user", "alice"))
+	sequence := SequenceFromText("", "server")
+	document, _ := room.Document(func() (*Document, error) { return NewSequenceDocument("title", sequence), nil })
+
+	// Restoring a long revision merges enough updates to compact
+	text := make([]rune, compactInterval)
+	for i := range text {
+		text[i] = 'r'
+	}
+	updates := decodeCopy(t, sequence).Replace(string(text), "restore")
+
+	var saved []State
+	open, err := hub.Replace("doc", "restored", string(text), updates, func(state State, applied []Update) {
+		saved = append(saved, state)
+	})
+	if err != nil || !open {
+		t.Fatalf("Replace = %v, %v", open, err)
+	}
+
+	if len(saved) != 2 || saved[0].Encoded == nil {
+		t.Fatalf("saved %d states, want the compacted merge and the title", len(saved))
+	}
+	if got := document.Snapshot(); got.Content != string(text) || got.Title != "restored" {
+		t.Fatalf("document = %q %q", got.Title, got.Content)
+	}
+	if saved[1].Title != "restored" {
+		t.Fatalf("last saved title = %q", saved[1].Title)
+	}
+}
+
+func decodeCopy(t *testing.T, s *Sequence) *Sequence {
+	t.Helper()
+
+	encoded, err := s.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	copied, err := DecodeSequence(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return copied
+}
+
+func TestHubReplaceRewritesOpenSequence(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hub := NewHub()
+	go hub.Run(ctx)
+
+	if open, err := hub.Replace("doc", "title", "text", nil, func(State, []Update) {}); open || err != nil {
+		t.Fatalf("Replace of a closed document = %v, %v", open, err)
+	}
+
+	room := hub.Join(NewClient(nil, "doc", "user", "alice"))
+	document, _ := room.Document(func() (*Document, error) {
+		return NewSequenceDocument("title", SequenceFromText("old", ServerSite)), nil
+	})
+
+	var logged []Update
+	open, err := hub.Replace("doc", "title", "new", nil, func(state State, applied []Update) {
+		logged = append(logged, applied...)
+	})
+	if err != nil || !open {
+		t.Fatalf("Replace = %v, %v", open, err)
+	}
+	if got := document.Snapshot().Content; got != "new" {
+		t.Fatalf("content = %q, want \"new\"", got)
+	}
+
+	// The updates handed to save rebuild the same text from the old sequence
+	replica := SequenceFromText("old", ServerSite)
+	if _, err := replica.Integrate(logged); err != nil {
+		t.Fatal(err)
+	}
+	if replica.Text() != "new" {
+		t.Fatalf("replayed text = %q, want \"new\"", replica.Text())
+	}
+}
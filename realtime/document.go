@@ -0,0 +1,273 @@
+package realtime
+
+import (
+	"errors"
+	"sync"
+	"unicode/utf8"
+)
+
+const (
+	ModeOT   = "ot"
+	ModeCRDT = "crdt"
+
+	// compactInterval is how many CRDT updates are merged before Merge hands
+	// the caller an encoded state to replace the update log with
+	compactInterval = 100
+
+	// maxHistory is how many OT operations are kept for a client that has
+	// fallen behind; operations against older revisions are refused
+	maxHistory = 1000
+)
+
+var (
+	ErrInvalidRevision = errors.New("revision is ahead of the server or unknown")
+	ErrWrongMode       = errors.New("message does not match the document's sync mode")
+)
+
+// Document is the authoritative state of a document while its room is open.
+// In OT mode every accepted operation bumps the revision and is kept in the
+// history so operations made against older revisions can be transformed
+// forward. history starts at revision base: operations no client can still
+// build on are dropped. In CRDT mode the content is derived from a sequence
+// that peers merge updates into directly.
+type Document struct {
+	mu       sync.Mutex
+	mode     string
+	title    string
+	content  string
+	revision int
+	history  []Operation
+	base     int
+
+	// seen is the oldest revision each open client may still send operations against
+	seen map[string]int
+
+	sequence    *Sequence
+	uncompacted int
+
+	cursors map[string]Cursor
+}
+
+func NewDocument(title, content string) *Document {
+	return &Document{mode: ModeOT, title: title, content: content}
+}
+
+func NewSequenceDocument(title string, sequence *Sequence) *Document {
+	d := &Document{mode: ModeCRDT, title: title, content: sequence.Text(), sequence: sequence}
+	sequence.onChange = d.shiftCursors
+	return d
+}
+
+// State is a point-in-time copy of a document. Encoded holds the CRDT state
+// and is only set where noted.
+type State struct {
+	Mode     string
+	Title    string
+	Content  string
+	Revision int
+	Encoded  []byte
+}
+
+// Snapshot returns the current state
+func (d *Document) Snapshot() State {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.state()
+}
+
+// Open calls fn with the current state, including the encoded CRDT state,
+// while the document is locked, so no commit can be observed between the
+// state and anything fn sends. The client is tracked from that revision
+// until it leaves.
+func (d *Document) Open(clientID string, fn func(state State)) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	state := d.state()
+	if d.mode == ModeCRDT {
+		encoded, err := d.sequence.Encode()
+		if err != nil {
+			return err
+		}
+		state.Encoded = encoded
+	}
+
+	d.see(clientID, d.revision)
+	fn(state)
+	return nil
+}
+
+// Leave stops tracking a client and forgets its cursor
+func (d *Document) Leave(clientID string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	delete(d.seen, clientID)
+	delete(d.cursors, clientID)
+	d.trim()
+}
+
+func (d *Document) state() State {
+	return State{Mode: d.mode, Title: d.title, Content: d.content, Revision: d.revision}
+}
+
+// Submit transforms an operation a client made against revision over every
+// operation accepted since, applies it and calls commit with the transformed
+// operation and the new state. commit runs while the document is locked so
+// that commits are observed in revision order.
+func (d *Document) Submit(clientID string, revision int, op Operation, commit func(applied Operation, state State)) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.mode != ModeOT {
+		return ErrWrongMode
+	}
+	if revision < d.base || revision > d.revision {
+		return ErrInvalidRevision
+	}
+
+	var err error
+	for _, concurrent := range d.history[revision-d.base:] {
+		if op, _, err = Transform(op, concurrent); err != nil {
+			return err
+		}
+	}
+
+	content, err := op.Apply(d.content)
+	if err != nil {
+		return err
+	}
+
+	d.content = content
+	d.see(clientID, revision)
+	d.record(op)
+
+	commit(op, d.state())
+	return nil
+}
+
+// Replace swaps the whole content for content with a single operation, as
+// when a revision is restored, and calls commit like Submit does
+func (d *Document) Replace(content string, commit func(applied Operation, state State)) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.mode != ModeOT {
+		return ErrWrongMode
+	}
+
+	op := Operation{}.delete(utf8.RuneCountInString(d.content)).insert(content)
+	if len(op) == 0 {
+		return nil
+	}
+
+	d.content = content
+	d.record(op)
+
+	commit(op, d.state())
+	return nil
+}
+
+// record adds an applied operation to the history; d.mu must be held
+func (d *Document) record(op Operation) {
+	d.history = append(d.history, op)
+	d.revision++
+	d.transformCursors(op)
+	d.trim()
+}
+
+// see notes that a client has seen revision, so it will not send operations
+// against an older one; d.mu must be held
+func (d *Document) see(clientID string, revision int) {
+	if d.seen == nil {
+		d.seen = make(map[string]int)
+	}
+	if seen, ok := d.seen[clientID]; !ok || revision > seen {
+		d.seen[clientID] = revision
+	}
+}
+
+// trim drops the operations every open client has moved past, and any beyond
+// maxHistory; d.mu must be held
+func (d *Document) trim() {
+	oldest := d.revision
+	for _, revision := range d.seen {
+		oldest = min(oldest, revision)
+	}
+	oldest = max(oldest, d.revision-maxHistory)
+
+	if oldest > d.base {
+		d.history = d.history[oldest-d.base:]
+		d.base = oldest
+	}
+}
+
+// Merge integrates CRDT updates and calls commit with the updates that
+// changed the document and the new state, unless none did. Every
+// compactInterval updates the state passed to commit carries the encoded
+// sequence so the caller can persist it in place of the update log. commit
+// runs while the document is locked.
+func (d *Document) Merge(updates []Update, commit func(applied []Update, state State)) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.mode != ModeCRDT {
+		return ErrWrongMode
+	}
+
+	applied, err := d.sequence.Integrate(updates)
+	if err != nil {
+		return err
+	}
+	return d.merged(applied, commit)
+}
+
+// Rewrite turns a CRDT document's content into content with updates made by
+// site, as when the whole document is saved at once, and calls commit like
+// Merge does
+func (d *Document) Rewrite(content, site string, commit func(applied []Update, state State)) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.mode != ModeCRDT {
+		return ErrWrongMode
+	}
+	if content == d.content {
+		return nil
+	}
+
+	return d.merged(d.sequence.Replace(content, site), commit)
+}
+
+// merged commits updates integrated into the sequence; d.mu must be held
+func (d *Document) merged(applied []Update, commit func(applied []Update, state State)) error {
+	if len(applied) == 0 {
+		return nil
+	}
+
+	var err error
+	d.content = d.sequence.Text()
+	d.revision++
+	d.uncompacted += len(applied)
+
+	state := d.state()
+	if d.uncompacted >= compactInterval {
+		if state.Encoded, err = d.sequence.Encode(); err != nil {
+			return err
+		}
+		d.uncompacted = 0
+	}
+
+	commit(applied, state)
+	return nil
+}
+
+// SetTitle replaces the title and calls commit while the document is locked
+func (d *Document) SetTitle(title string, commit func(state State)) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.title = title
+	commit(d.state())
+}
@@ -0,0 +1,87 @@
+package realtime
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestSubmitTransformsConcurrentOperations(t *testing.T) {
+	d := NewDocument("title", "abc")
+	d.Open("alice", func(State) {})
+	d.Open("bob", func(State) {})
+
+	noop := func(Operation, State) {}
+	if err := d.Submit("alice", 0, Operation{}.insert("x").retain(3), noop); err != nil {
+		t.Fatal(err)
+	}
+	// Bob has not seen Alice's insert yet
+	if err := d.Submit("bob", 0, Operation{}.retain(3).insert("y"), noop); err != nil {
+		t.Fatal(err)
+	}
+
+	state := d.Snapshot()
+	if state.Content != "xabcy" || state.Revision != 2 {
+		t.Fatalf("state = %q at %d, want \"xabcy\" at 2", state.Content, state.Revision)
+	}
+	if err := d.Submit("bob", 3, Operation{}.retain(5), noop); !errors.Is(err, ErrInvalidRevision) {
+		t.Fatalf("Submit ahead of the server: %v, want ErrInvalidRevision", err)
+	}
+}
+
+func TestHistoryIsTrimmedToOldestClient(t *testing.T) {
+	d := NewDocument("title", "")
+	d.Open("alice", func(State) {})
+	d.Open("bob", func(State) {})
+
+	noop := func(Operation, State) {}
+	for i := 0; i < 5; i++ {
+		if err := d.Submit("alice", i, Operation{}.retain(i).insert("a"), noop); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Bob is still at revision 0, so everything must be kept
+	if d.base != 0 || len(d.history) != 5 {
+		t.Fatalf("history starts at %d with %d operations, want 0 and 5", d.base, len(d.history))
+	}
+
+	if err := d.Submit("bob", 0, Operation{}.insert("b"), noop); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.SetCursor("bob", 6, Cursor{}, func(Cursor, State) {}); err != nil {
+		t.Fatal(err)
+	}
+	// Alice last built on revision 4
+	if d.base != 4 || len(d.history) != 2 {
+		t.Fatalf("history starts at %d with %d operations, want 4 and 2", d.base, len(d.history))
+	}
+	if err := d.Submit("bob", 3, Operation{}.retain(3), noop); !errors.Is(err, ErrInvalidRevision) {
+		t.Fatalf("Submit against a trimmed revision: %v, want ErrInvalidRevision", err)
+	}
+
+	d.Leave("alice")
+	d.Leave("bob")
+	if d.base != d.revision || len(d.history) != 0 {
+		t.Fatalf("history starts at %d with %d operations after everyone left", d.base, len(d.history))
+	}
+	if got := d.Snapshot().Content; got != "baaaaa" {
+		t.Fatalf("content = %q", got)
+	}
+}
+
+func TestHistoryIsCapped(t *testing.T) {
+	d := NewDocument("title", "")
+	d.Open("idle", func(State) {})
+
+	noop := func(Operation, State) {}
+	for i := 0; i < maxHistory+10; i++ {
+		if err := d.Submit("writer", i, Operation{}.retain(i).insert("a"), noop); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(d.history) != maxHistory {
+		t.Fatalf("kept %d operations, want %d", len(d.history), maxHistory)
+	}
+	if err := d.Submit("idle", 0, Operation{}.insert("b"), noop); !errors.Is(err, ErrInvalidRevision) {
+		t.Fatalf("Submit from a client too far behind: %v, want ErrInvalidRevision", err)
+	}
+}
@@ -0,0 +1,285 @@
+package realtime
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+)
+
+var ErrRoomNotFound = errors.New("room not found")
+
+// Message is a payload addressed to every client in a document's room. When
+// Sender is set it does not receive Payload and is sent Ack instead, so that
+// the sender sees its acknowledgement in order with the other room traffic.
+// When Recipient is set only that client receives Payload.
+type Message struct {
+	DocumentID string
+	Payload    interface{}
+	Sender     *Client
+	Ack        interface{}
+	Recipient  *Client
+}
+
+// Room holds the clients currently subscribed to a single document and the
+// document's shared state while anyone is editing it
+type Room struct {
+	DocumentID string
+	clients    map[*Client]bool
+
+	docMu    sync.Mutex
+	document *Document
+}
+
+// Document returns the room's shared state, calling load the first time it is needed
+func (r *Room) Document(load func() (*Document, error)) (*Document, error) {
+	r.docMu.Lock()
+	defer r.docMu.Unlock()
+
+	if r.document == nil {
+		document, err := load()
+		if err != nil {
+			return nil, err
+		}
+		r.document = document
+	}
+	return r.document, nil
+}
+
+// Hub tracks one room per document and routes messages only to that room's clients
+type Hub struct {
+	mu        sync.RWMutex
+	rooms     map[string]*Room
+	broadcast chan *Message
+}
+
+func NewHub() *Hub {
+	return &Hub{
+		rooms:     make(map[string]*Room),
+		broadcast: make(chan *Message),
+	}
+}
+
+// Run delivers broadcasts until the context is cancelled
+func (h *Hub) Run(ctx context.Context) {
+	for {
+		select {
+		case msg := <-h.broadcast:
+			h.deliver(msg)
+		case <-ctx.Done():
+			log.Println("Stopping hub due to context cancellation")
+			h.closeAll()
+			return
+		}
+	}
+}
+
+// Join adds the client to the room of its document, creating the room if
+// needed, and returns the room. The client is in the room when Join returns,
+// so it cannot miss broadcasts sent after it loads the document.
+func (h *Hub) Join(client *Client) *Room {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	room, ok := h.rooms[client.DocumentID]
+	if !ok {
+		room = &Room{DocumentID: client.DocumentID, clients: make(map[*Client]bool)}
+		h.rooms[client.DocumentID] = room
+		log.Printf("Created room for document %s", client.DocumentID)
+	}
+	room.clients[client] = true
+	return room
+}
+
+// Leave removes the client from its room, tearing the room down when it
+// empties, and reports whether the document's room is now closed
+func (h *Hub) Leave(client *Client) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.remove(client)
+	_, open := h.rooms[client.DocumentID]
+	return !open
+}
+
+// Broadcast sends the payload to every client in the document's room
+func (h *Hub) Broadcast(documentID string, payload interface{}) {
+	h.broadcast <- &Message{DocumentID: documentID, Payload: payload}
+}
+
+// BroadcastFrom sends the payload to everyone in the sender's room except the
+// sender, who is sent ack instead if it is not nil
+func (h *Hub) BroadcastFrom(sender *Client, payload, ack interface{}) {
+	h.broadcast <- &Message{DocumentID: sender.DocumentID, Payload: payload, Sender: sender, Ack: ack}
+}
+
+// SendTo sends the payload to a single client through the hub, ordered with
+// the broadcasts to its room
+func (h *Hub) SendTo(client *Client, payload interface{}) {
+	h.broadcast <- &Message{DocumentID: client.DocumentID, Payload: payload, Recipient: client}
+}
+
+// Room returns the open room for a document
+func (h *Hub) Room(documentID string) (*Room, error) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	room, ok := h.rooms[documentID]
+	if !ok {
+		return nil, ErrRoomNotFound
+	}
+	return room, nil
+}
+
+// Replace pushes new content into a document that is open for editing, as
+// when a revision is restored or the whole document is saved, and tells
+// everyone in its room. OT documents take content directly. CRDT documents
+// merge updates, or when updates is nil, the updates that turn their content
+// into content. save is called with each new state and the CRDT updates
+// applied while the document is locked, like the commit of an edit, so the
+// caller can store them along with any compacted CRDT state. It reports
+// whether the document was open; nothing happens if it is not.
+func (h *Hub) Replace(documentID, title, content string, updates []Update, save func(state State, applied []Update)) (bool, error) {
+	room, err := h.Room(documentID)
+	if err != nil {
+		return false, nil
+	}
+
+	room.docMu.Lock()
+	document := room.document
+	room.docMu.Unlock()
+	if document == nil {
+		return false, nil
+	}
+
+	merge := func(applied []Update, state State) {
+		save(state, applied)
+		h.Broadcast(documentID, UpdateMessage(applied, state.Revision))
+	}
+	switch {
+	case document.Snapshot().Mode != ModeCRDT:
+		err = document.Replace(content, func(applied Operation, state State) {
+			save(state, nil)
+			h.Broadcast(documentID, OpMessage(applied, state.Revision))
+		})
+	case updates == nil:
+		err = document.Rewrite(content, ServerSite, merge)
+	default:
+		err = document.Merge(updates, merge)
+	}
+	if err != nil {
+		return true, err
+	}
+
+	document.SetTitle(title, func(state State) {
+		save(state, nil)
+		h.Broadcast(documentID, TitleMessage(state))
+	})
+	return true, nil
+}
+
+// Clients returns a snapshot of the clients currently in the document's room
+func (h *Hub) Clients(documentID string) []*Client {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	room, ok := h.rooms[documentID]
+	if !ok {
+		return nil
+	}
+
+	clients := make([]*Client, 0, len(room.clients))
+	for client := range room.clients {
+		clients = append(clients, client)
+	}
+	return clients
+}
+
+// Kick disconnects every client in the document's room and returns how many
+// there were. They leave the room as their connections close.
+func (h *Hub) Kick(documentID, reason string) int {
+	clients := h.Clients(documentID)
+	for _, client := range clients {
+		client.Kick(reason)
+	}
+	return len(clients)
+}
+
+// KickUser disconnects every connection a user has open, in any room
+func (h *Hub) KickUser(userID, reason string) int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	kicked := 0
+	for _, room := range h.rooms {
+		for client := range room.clients {
+			if client.UserID == userID {
+				client.Kick(reason)
+				kicked++
+			}
+		}
+	}
+	return kicked
+}
+
+// remove must be called with h.mu held
+func (h *Hub) remove(client *Client) {
+	room, ok := h.rooms[client.DocumentID]
+	if !ok {
+		return
+	}
+	if _, ok := room.clients[client]; !ok {
+		return
+	}
+
+	delete(room.clients, client)
+	client.Close()
+
+	if len(room.clients) == 0 {
+		delete(h.rooms, client.DocumentID)
+		log.Printf("Closed room for document %s", client.DocumentID)
+	}
+}
+
+func (h *Hub) deliver(msg *Message) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	room, ok := h.rooms[msg.DocumentID]
+	if !ok {
+		return
+	}
+
+	if msg.Recipient != nil {
+		if room.clients[msg.Recipient] && !msg.Recipient.Send(msg.Payload) {
+			h.remove(msg.Recipient)
+		}
+		return
+	}
+
+	for client := range room.clients {
+		payload := msg.Payload
+		if client == msg.Sender {
+			if msg.Ack == nil {
+				continue
+			}
+			payload = msg.Ack
+		}
+		if !client.Send(payload) {
+			log.Printf("Dropping slow client from document %s", msg.DocumentID)
+			h.remove(client)
+		}
+	}
+}
+
+func (h *Hub) closeAll() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for id, room := range h.rooms {
+		for client := range room.clients {
+			client.Close()
+		}
+		delete(h.rooms, id)
+	}
+}
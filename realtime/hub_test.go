@@ -0,0 +1,151 @@
+package realtime
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestJoinIsImmediate(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hub := NewHub()
+	go hub.Run(ctx)
+
+	client := NewClient(nil, "doc", "user", "alice")
+	room := hub.Join(client)
+	if room == nil || room.DocumentID != "doc" {
+		t.Fatalf("Join returned room %+v", room)
+	}
+	if got, err := hub.Room("doc"); err != nil || got != room {
+		t.Fatalf("Room = %v, %v; want the joined room", got, err)
+	}
+
+	// Nothing sent after Join returns may be lost
+	hub.Broadcast("doc", "hello")
+	select {
+	case payload := <-client.send:
+		if payload != "hello" {
+			t.Fatalf("got %v, want hello", payload)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("broadcast sent after Join was not delivered")
+	}
+}
+
+func TestLeaveReportsClosedRoom(t *testing.T) {
+	hub := NewHub()
+	first := NewClient(nil, "doc", "user", "alice")
+	second := NewClient(nil, "doc", "user", "alice")
+
+	if hub.Join(first) != hub.Join(second) {
+		t.Fatal("clients of one document joined different rooms")
+	}
+	if hub.Leave(first) {
+		t.Fatal("room closed while a client was still in it")
+	}
+	if !hub.Leave(second) {
+		t.Fatal("room still open after the last client left")
+	}
+	if _, err := hub.Room("doc"); err != ErrRoomNotFound {
+		t.Fatalf("Room after close: %v, want ErrRoomNotFound", err)
+	}
+	// Leaving twice is harmless
+	if !hub.Leave(second) {
+		t.Fatal("Leave of a departed client reported an open room")
+	}
+}
+
+// drain returns every payload queued for the client
+func drain(client *Client) []interface{} {
+	var payloads []interface{}
+	for {
+		select {
+		case payload := <-client.send:
+			payloads = append(payloads, payload)
+		default:
+			return payloads
+		}
+	}
+}
+
+func TestRouting(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hub := NewHub()
+	go hub.Run(ctx)
+
+	alice := NewClient(nil, "doc", "alice", "alice")
+	bob := NewClient(nil, "doc", "bob", "bob")
+	carol := NewClient(nil, "other", "carol", "carol")
+	for _, client := range []*Client{alice, bob, carol} {
+		hub.Join(client)
+	}
+	// The hub delivers in order, so once a later message is taken the earlier one is delivered
+	flush := func() { hub.Broadcast("closed", nil) }
+
+	tests := []struct {
+		name       string
+		send       func()
+		alice, bob []interface{}
+		carol      []interface{}
+	}{
+		{"broadcast stays in its room", func() { hub.Broadcast("doc", "edit") }, []interface{}{"edit"}, []interface{}{"edit"}, nil},
+		{"other room", func() { hub.Broadcast("other", "edit") }, nil, nil, []interface{}{"edit"}},
+		{"sender gets the ack", func() { hub.BroadcastFrom(alice, "edit", "ack") }, []interface{}{"ack"}, []interface{}{"edit"}, nil},
+		{"sender without ack", func() { hub.BroadcastFrom(alice, "edit", nil) }, nil, []interface{}{"edit"}, nil},
+		{"send to one client", func() { hub.SendTo(bob, "state") }, nil, []interface{}{"state"}, nil},
+		{"send to a client of another room", func() { hub.SendTo(carol, "state") }, nil, nil, []interface{}{"state"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.send()
+			flush()
+
+			for _, c := range []struct {
+				client *Client
+				want   []interface{}
+			}{{alice, tt.alice}, {bob, tt.bob}, {carol, tt.carol}} {
+				if got := drain(c.client); !reflect.DeepEqual(got, c.want) {
+					t.Errorf("%s received %v, want %v", c.client.Username, got, c.want)
+				}
+			}
+		})
+	}
+}
+
+func TestSlowClientIsDropped(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hub := NewHub()
+	go hub.Run(ctx)
+
+	slow := NewClient(nil, "doc", "slow", "slow")
+	fast := NewClient(nil, "doc", "fast", "fast")
+	hub.Join(slow)
+	hub.Join(fast)
+
+	for i := 0; i < sendBufferSize; i++ {
+		slow.send <- "backlog"
+	}
+	hub.Broadcast("doc", "edit")
+	hub.Broadcast("closed", nil)
+
+	clients := hub.Clients("doc")
+	if len(clients) != 1 || clients[0] != fast {
+		t.Fatalf("clients after a full buffer = %v, want only the fast one", clients)
+	}
+	select {
+	case <-slow.done:
+	default:
+		t.Fatal("dropped client was not closed")
+	}
+	if got := drain(fast); !reflect.DeepEqual(got, []interface{}{"edit"}) {
+		t.Fatalf("fast client received %v", got)
+	}
+}
@@ -0,0 +1,262 @@
+package realtime
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"unicode/utf8"
+)
+
+// Component is a single step of an operation. Exactly one field is set:
+// Retain skips characters, Insert adds text at the cursor and Delete removes
+// characters after the cursor. Lengths are counted in Unicode code points.
+type Component struct {
+	Retain int    `json:"retain,omitempty"`
+	Insert string `json:"insert,omitempty"`
+	Delete int    `json:"delete,omitempty"`
+}
+
+// Operation is a sequence of components that walks the whole document once
+type Operation []Component
+
+var (
+	ErrInvalidComponent = errors.New("component must set exactly one of retain, insert or delete")
+	ErrLengthMismatch   = errors.New("operation length does not match document length")
+)
+
+func (c Component) length() int {
+	switch {
+	case c.Retain > 0:
+		return c.Retain
+	case c.Delete > 0:
+		return c.Delete
+	default:
+		return utf8.RuneCountInString(c.Insert)
+	}
+}
+
+// Validate checks that every component sets exactly one field and that the
+// base and target lengths fit in an int, so a client cannot make BaseLen wrap
+// around to the length of the document
+func (o Operation) Validate() error {
+	base, target := 0, 0
+	for _, c := range o {
+		set := 0
+		if c.Retain > 0 {
+			set++
+		}
+		if c.Insert != "" {
+			set++
+		}
+		if c.Delete > 0 {
+			set++
+		}
+		if set != 1 || c.Retain < 0 || c.Delete < 0 {
+			return ErrInvalidComponent
+		}
+
+		n := c.length()
+		if c.Insert == "" {
+			if n > math.MaxInt-base {
+				return ErrLengthMismatch
+			}
+			base += n
+		}
+		if c.Delete == 0 {
+			if n > math.MaxInt-target {
+				return ErrLengthMismatch
+			}
+			target += n
+		}
+	}
+	return nil
+}
+
+// BaseLen is the length of the document the operation applies to
+func (o Operation) BaseLen() int {
+	n := 0
+	for _, c := range o {
+		if c.Insert == "" {
+			n += c.length()
+		}
+	}
+	return n
+}
+
+// TargetLen is the length of the document after the operation is applied
+func (o Operation) TargetLen() int {
+	n := 0
+	for _, c := range o {
+		if c.Delete == 0 {
+			n += c.length()
+		}
+	}
+	return n
+}
+
+// Apply returns the result of applying the operation to content
+func (o Operation) Apply(content string) (string, error) {
+	if err := o.Validate(); err != nil {
+		return "", err
+	}
+
+	runes := []rune(content)
+	if o.BaseLen() != len(runes) {
+		return "", ErrLengthMismatch
+	}
+
+	result := make([]rune, 0, o.TargetLen())
+	pos := 0
+	for _, c := range o {
+		// BaseLen matched, but never slice past the end on its word alone
+		if c.Insert == "" && c.length() > len(runes)-pos {
+			return "", ErrLengthMismatch
+		}
+
+		switch {
+		case c.Retain > 0:
+			result = append(result, runes[pos:pos+c.Retain]...)
+			pos += c.Retain
+		case c.Insert != "":
+			result = append(result, []rune(c.Insert)...)
+		case c.Delete > 0:
+			pos += c.Delete
+		}
+	}
+
+	return string(result), nil
+}
+
+// TransformIndex maps a position in the document before the operation to the
+// same place after it. Text inserted exactly at the position pushes it right.
+func (o Operation) TransformIndex(index int) int {
+	newIndex := index
+	pos := 0
+	for _, c := range o {
+		if pos > index {
+			break
+		}
+		switch {
+		case c.Retain > 0:
+			pos += c.Retain
+		case c.Insert != "":
+			newIndex += c.length()
+		case c.Delete > 0:
+			newIndex -= min(c.Delete, index-pos)
+			pos += c.Delete
+		}
+	}
+	return newIndex
+}
+
+// Transform takes two operations a and b made against the same document and
+// returns a' and b' such that applying a then b' equals applying b then a'.
+// When both insert at the same position, a's insert is placed first.
+func Transform(a, b Operation) (Operation, Operation, error) {
+	if err := a.Validate(); err != nil {
+		return nil, nil, err
+	}
+	if err := b.Validate(); err != nil {
+		return nil, nil, err
+	}
+	if a.BaseLen() != b.BaseLen() {
+		return nil, nil, fmt.Errorf("cannot transform operations with base lengths %d and %d", a.BaseLen(), b.BaseLen())
+	}
+
+	var aPrime, bPrime Operation
+	ia, ib := 0, 0
+	var ca, cb *Component
+	next := func(op Operation, i *int) *Component {
+		if *i >= len(op) {
+			return nil
+		}
+		c := op[*i]
+		*i++
+		return &c
+	}
+	ca, cb = next(a, &ia), next(b, &ib)
+
+	for ca != nil || cb != nil {
+		if ca != nil && ca.Insert != "" {
+			aPrime = aPrime.insert(ca.Insert)
+			bPrime = bPrime.retain(ca.length())
+			ca = next(a, &ia)
+			continue
+		}
+		if cb != nil && cb.Insert != "" {
+			aPrime = aPrime.retain(cb.length())
+			bPrime = bPrime.insert(cb.Insert)
+			cb = next(b, &ib)
+			continue
+		}
+		if ca == nil || cb == nil {
+			return nil, nil, ErrLengthMismatch
+		}
+
+		n := min(ca.length(), cb.length())
+		switch {
+		case ca.Retain > 0 && cb.Retain > 0:
+			aPrime = aPrime.retain(n)
+			bPrime = bPrime.retain(n)
+		case ca.Delete > 0 && cb.Retain > 0:
+			aPrime = aPrime.delete(n)
+		case ca.Retain > 0 && cb.Delete > 0:
+			bPrime = bPrime.delete(n)
+		}
+		// When both delete the same range there is nothing left to do
+
+		if ca.length() == n {
+			ca = next(a, &ia)
+		} else {
+			ca.shorten(n)
+		}
+		if cb.length() == n {
+			cb = next(b, &ib)
+		} else {
+			cb.shorten(n)
+		}
+	}
+
+	return aPrime, bPrime, nil
+}
+
+func (c *Component) shorten(n int) {
+	if c.Retain > 0 {
+		c.Retain -= n
+	} else {
+		c.Delete -= n
+	}
+}
+
+func (o Operation) retain(n int) Operation {
+	if n == 0 {
+		return o
+	}
+	if last := len(o) - 1; last >= 0 && o[last].Retain > 0 {
+		o[last].Retain += n
+		return o
+	}
+	return append(o, Component{Retain: n})
+}
+
+func (o Operation) insert(s string) Operation {
+	if s == "" {
+		return o
+	}
+	if last := len(o) - 1; last >= 0 && o[last].Insert != "" {
+		o[last].Insert += s
+		return o
+	}
+	return append(o, Component{Insert: s})
+}
+
+func (o Operation) delete(n int) Operation {
+	if n == 0 {
+		return o
+	}
+	if last := len(o) - 1; last >= 0 && o[last].Delete > 0 {
+		o[last].Delete += n
+		return o
+	}
+	return append(o, Component{Delete: n})
+}
@@ -0,0 +1,178 @@
+package realtime
+
+import (
+	"errors"
+	"math/rand"
+	"testing"
+)
+
+func TestApply(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		op      Operation
+		want    string
+		wantErr error
+	}{
+		{"empty", "", Operation{}, "", nil},
+		{"insert into empty", "", Operation{}.insert("hi"), "hi", nil},
+		{"retain all", "abc", Operation{}.retain(3), "abc", nil},
+		{"insert in middle", "ac", Operation{}.retain(1).insert("b").retain(1), "abc", nil},
+		{"delete", "abc", Operation{}.retain(1).delete(1).retain(1), "ac", nil},
+		{"replace", "abc", Operation{}.delete(3).insert("xyz"), "xyz", nil},
+		{"code points", "héllo wörld", Operation{}.retain(6).delete(5).insert("мир"), "héllo мир", nil},
+		{"emoji", "a😀b", Operation{}.retain(1).delete(1).retain(1), "ab", nil},
+		{"too short", "abc", Operation{}.retain(2), "", ErrLengthMismatch},
+		{"too long", "abc", Operation{}.retain(4), "", ErrLengthMismatch},
+		{"two fields", "abc", Operation{{Retain: 3, Insert: "x"}}, "", ErrInvalidComponent},
+		{"no fields", "abc", Operation{{}}, "", ErrInvalidComponent},
+		{"retains that overflow", "", Operation{{Retain: 1 << 62}, {Retain: 1 << 62}, {Retain: 1 << 62}, {Retain: 1 << 62}}, "", ErrLengthMismatch},
+		{"deletes that overflow", "ab", Operation{{Delete: 1 << 62}, {Delete: 1 << 62}, {Delete: 1 << 62}, {Delete: 1 << 62}, {Retain: 2}}, "", ErrLengthMismatch},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.op.Apply(tt.content)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Apply error = %v, want %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Fatalf("Apply = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTransform(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		a, b    Operation
+		want    string
+	}{
+		{"inserts at different places", "abc", Operation{}.insert("x").retain(3), Operation{}.retain(3).insert("y"), "xabcy"},
+		{"inserts at the same place", "abc", Operation{}.retain(1).insert("x").retain(2), Operation{}.retain(1).insert("y").retain(2), "axybc"},
+		{"insert inside a deletion", "abcd", Operation{}.retain(2).insert("x").retain(2), Operation{}.retain(1).delete(2).retain(1), "axd"},
+		{"overlapping deletions", "abcdef", Operation{}.retain(1).delete(3).retain(2), Operation{}.retain(2).delete(3).retain(1), "af"},
+		{"same deletion", "abc", Operation{}.delete(1).retain(2), Operation{}.delete(1).retain(2), "bc"},
+		{"delete all against an edit", "abc", Operation{}.delete(3), Operation{}.retain(1).delete(1).insert("z").retain(1), "z"},
+		{"unicode", "añb", Operation{}.retain(2).insert("é").retain(1), Operation{}.delete(1).retain(2), "ñéb"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assertConverges(t, tt.content, tt.a, tt.b, tt.want)
+		})
+	}
+}
+
+func TestTransformRejectsMismatchedOperations(t *testing.T) {
+	if _, _, err := Transform(Operation{}.retain(2), Operation{}.retain(3)); err == nil {
+		t.Fatal("Transform accepted operations on documents of different lengths")
+	}
+	if _, _, err := Transform(Operation{{Retain: 1, Delete: 1}}, Operation{}.retain(1)); !errors.Is(err, ErrInvalidComponent) {
+		t.Fatalf("Transform error = %v, want ErrInvalidComponent", err)
+	}
+}
+
+// TestTransformConverges checks convergence on random concurrent edits
+func TestTransformConverges(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 500; i++ {
+		content := randomText(rng, rng.Intn(12))
+		a, b := randomOperation(rng, content), randomOperation(rng, content)
+		assertConverges(t, content, a, b, "")
+	}
+}
+
+func TestTransformIndex(t *testing.T) {
+	tests := []struct {
+		name  string
+		op    Operation
+		index int
+		want  int
+	}{
+		{"insert before", Operation{}.insert("ab").retain(4), 2, 4},
+		{"insert at", Operation{}.retain(2).insert("ab").retain(2), 2, 4},
+		{"insert after", Operation{}.retain(3).insert("ab").retain(1), 2, 2},
+		{"delete before", Operation{}.delete(1).retain(3), 2, 1},
+		{"delete around", Operation{}.retain(1).delete(2).retain(1), 2, 1},
+		{"delete after", Operation{}.retain(3).delete(1), 2, 2},
+		{"end of document", Operation{}.retain(4).insert("ab"), 4, 6},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.op.TransformIndex(tt.index); got != tt.want {
+				t.Fatalf("TransformIndex(%d) = %d, want %d", tt.index, got, tt.want)
+			}
+		})
+	}
+}
+
+// assertConverges checks that a then b' and b then a' give the same text,
+// and that it is want unless want is empty
+func assertConverges(t *testing.T, content string, a, b Operation, want string) {
+	t.Helper()
+
+	aPrime, bPrime, err := Transform(a, b)
+	if err != nil {
+		t.Fatalf("Transform(%v, %v): %v", a, b, err)
+	}
+
+	afterA, err := a.Apply(content)
+	if err != nil {
+		t.Fatalf("apply a: %v", err)
+	}
+	viaA, err := bPrime.Apply(afterA)
+	if err != nil {
+		t.Fatalf("apply b' %v: %v", bPrime, err)
+	}
+
+	afterB, err := b.Apply(content)
+	if err != nil {
+		t.Fatalf("apply b: %v", err)
+	}
+	viaB, err := aPrime.Apply(afterB)
+	if err != nil {
+		t.Fatalf("apply a' %v: %v", aPrime, err)
+	}
+
+	if viaA != viaB {
+		t.Fatalf("%q with a=%v b=%v diverged: %q vs %q", content, a, b, viaA, viaB)
+	}
+	if want != "" && viaA != want {
+		t.Fatalf("got %q, want %q", viaA, want)
+	}
+}
+
+func randomText(rng *rand.Rand, n int) string {
+	alphabet := []rune("abcdé😀")
+	text := make([]rune, n)
+	for i := range text {
+		text[i] = alphabet[rng.Intn(len(alphabet))]
+	}
+	return string(text)
+}
+
+func randomOperation(rng *rand.Rand, content string) Operation {
+	var op Operation
+	remaining := len([]rune(content))
+	for remaining > 0 {
+		n := 1 + rng.Intn(remaining)
+		switch rng.Intn(3) {
+		case 0:
+			op = op.retain(n)
+		case 1:
+			op = op.delete(n)
+		default:
+			op = op.insert(randomText(rng, 1+rng.Intn(3)))
+			continue
+		}
+		remaining -= n
+	}
+	if rng.Intn(2) == 0 {
+		op = op.insert(randomText(rng, 1+rng.Intn(3)))
+	}
+	return op
+}
@@ -0,0 +1,88 @@
+package realtime
+
+// Cursor is a selection from Anchor to Head; they are equal for a caret.
+// Positions are counted in Unicode code points.
+type Cursor struct {
+	Anchor int `json:"anchor"`
+	Head   int `json:"head"`
+}
+
+// Presence describes a client in a room. Cursor is nil until the client sends one.
+type Presence struct {
+	ClientID string  `json:"client_id"`
+	UserID   string  `json:"user_id"`
+	Username string  `json:"username"`
+	Cursor   *Cursor `json:"cursor,omitempty"`
+}
+
+func PresenceOf(client *Client) Presence {
+	return Presence{ClientID: client.ID, UserID: client.UserID, Username: client.Username}
+}
+
+// SetCursor records a client's cursor, made against revision in OT mode, and
+// calls commit with the cursor mapped onto the current revision while the
+// document is locked. CRDT clients send positions in the current text.
+func (d *Document) SetCursor(clientID string, revision int, cursor Cursor, commit func(cursor Cursor, state State)) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.mode == ModeOT {
+		if revision < d.base || revision > d.revision {
+			return ErrInvalidRevision
+		}
+		for _, op := range d.history[revision-d.base:] {
+			cursor = transformCursor(cursor, op)
+		}
+		d.see(clientID, revision)
+		d.trim()
+	}
+
+	length := len([]rune(d.content))
+	cursor.Anchor = min(max(cursor.Anchor, 0), length)
+	cursor.Head = min(max(cursor.Head, 0), length)
+
+	if d.cursors == nil {
+		d.cursors = make(map[string]Cursor)
+	}
+	d.cursors[clientID] = cursor
+
+	commit(cursor, d.state())
+	return nil
+}
+
+// Roster calls fn with the current state and every known cursor while the document is locked
+func (d *Document) Roster(fn func(cursors map[string]Cursor, state State)) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	cursors := make(map[string]Cursor, len(d.cursors))
+	for id, cursor := range d.cursors {
+		cursors[id] = cursor
+	}
+	fn(cursors, d.state())
+}
+
+// transformCursors moves every stored cursor over an accepted operation; d.mu must be held
+func (d *Document) transformCursors(op Operation) {
+	for id, cursor := range d.cursors {
+		d.cursors[id] = transformCursor(cursor, op)
+	}
+}
+
+// shiftCursors moves stored cursors after a CRDT change of delta code points
+// at index; d.mu must be held
+func (d *Document) shiftCursors(index, delta int) {
+	shift := func(pos int) int {
+		if delta > 0 && pos >= index || delta < 0 && pos > index {
+			return pos + delta
+		}
+		return pos
+	}
+	for id, cursor := range d.cursors {
+		d.cursors[id] = Cursor{Anchor: shift(cursor.Anchor), Head: shift(cursor.Head)}
+	}
+}
+
+func transformCursor(cursor Cursor, op Operation) Cursor {
+	return Cursor{Anchor: op.TransformIndex(cursor.Anchor), Head: op.TransformIndex(cursor.Head)}
+}
@@ -0,0 +1,159 @@
+package realtime
+
+import (
+	"encoding/json"
+	"errors"
+)
+
+// WebSocket protocol for /ws/{id}
+//
+// Connecting requires an access or guest token, passed as the access_token
+// query parameter or as the subprotocol after "bearer" (new WebSocket(url,
+// ["bearer", token])). The upgrade is refused with 401 without a valid token
+// and 403 without at least viewer access. Viewers and commenters receive
+// every update but each frame they send is answered with an "error". Access
+// is checked again on every edit: a user whose role was lowered gets an
+// "error", and one who lost access is disconnected with a policy violation.
+//
+// Every frame is a JSON object with a "type" field. Positions and lengths in
+// operations are counted in Unicode code points. A document syncs either with
+// operational transform ("ot", the default) or with a sequence CRDT ("crdt");
+// the mode is fixed when the document is created and announced in "initial".
+//
+// Server to client:
+//
+//	{"type":"initial","mode":"ot","revision":3,"title":"Notes","content":"hello"}
+//	    Sent once after connecting. The client's edits must be based on revision.
+//	    In CRDT mode "state" also carries every element of the sequence,
+//	    tombstones included: [{"id":{"clock":1,"site":"a"},"origin":{"clock":0,"site":""},"value":"h"}]
+//	{"type":"op","revision":4,"op":[{"retain":5},{"insert":"!"}]}
+//	    Another client's operation, already transformed; apply it and move to revision.
+//	{"type":"ack","revision":4}
+//	    The client's outstanding operation was accepted as revision.
+//	{"type":"title","revision":4,"title":"Meeting notes"}
+//	    The title changed.
+//	{"type":"update","revision":5,"updates":[...]}
+//	    CRDT mode: updates another peer made, already deduplicated.
+//	{"type":"roster","revision":4,"client_id":"c1","clients":[{"client_id":"c2","user_id":"u2","username":"ana","cursor":{"anchor":3,"head":3}}]}
+//	    Sent once after "initial": this connection's client_id and everyone
+//	    else in the room, with cursors as of revision. "clients" is omitted
+//	    when nobody else is connected.
+//	{"type":"join","revision":4,"client":{"client_id":"c3","user_id":"u3","username":"bo"}}
+//	{"type":"leave","revision":4,"client_id":"c3"}
+//	    Someone connected to or disconnected from the document.
+//	{"type":"cursor","revision":4,"client_id":"c2","cursor":{"anchor":3,"head":7}}
+//	    Another client moved its cursor or selection, as of revision. Clients
+//	    keep remote cursors in place by transforming them over every later
+//	    "op", pushing a cursor right when text is inserted exactly at it.
+//	{"type":"error","revision":4,"error":"..."}
+//	    The client's last frame was rejected.
+//
+// Client to server:
+//
+//	{"type":"op","revision":3,"op":[{"retain":5},{"insert":"!"}]}
+//	    An operation made against revision. A client keeps at most one
+//	    operation outstanding and buffers further edits until it is acked,
+//	    transforming its outstanding and buffered operations over every
+//	    incoming "op" with its own operation taking priority on ties, which
+//	    matches the order the server uses.
+//	{"type":"update","updates":[{"type":"insert","id":{"clock":7,"site":"b"},"origin":{"clock":1,"site":"a"},"value":"x"},
+//	                            {"type":"delete","id":{"clock":2,"site":"a"}}]}
+//	    CRDT mode: updates made locally, in the order they were made. Each
+//	    peer uses a unique site and a Lamport clock greater than every clock it
+//	    has seen. Clients that were offline send everything they made while
+//	    disconnected; duplicates are ignored, so resending is safe.
+//	{"type":"title","title":"Meeting notes"}
+//	    Rename the document.
+//	{"type":"cursor","revision":3,"cursor":{"anchor":5,"head":5}}
+//	    Move this client's cursor or selection, in the document at revision.
+//	    In CRDT mode positions are in the client's current text. Cursors are
+//	    relayed, never persisted, and viewers may send them too.
+
+var ErrReadOnly = errors.New("you can only view this document")
+
+const (
+	MessageInitial = "initial"
+	MessageOp      = "op"
+	MessageAck     = "ack"
+	MessageTitle   = "title"
+	MessageUpdate  = "update"
+	MessageRoster  = "roster"
+	MessageJoin    = "join"
+	MessageLeave   = "leave"
+	MessageCursor  = "cursor"
+	MessageError   = "error"
+)
+
+// ClientMessage is a frame sent by a client
+type ClientMessage struct {
+	Type     string    `json:"type"`
+	Revision int       `json:"revision"`
+	Op       Operation `json:"op,omitempty"`
+	Updates  []Update  `json:"updates,omitempty"`
+	Title    string    `json:"title,omitempty"`
+	Cursor   *Cursor   `json:"cursor,omitempty"`
+}
+
+// ServerMessage is a frame sent by the server
+type ServerMessage struct {
+	Type     string          `json:"type"`
+	Mode     string          `json:"mode,omitempty"`
+	Revision int             `json:"revision"`
+	Op       Operation       `json:"op,omitempty"`
+	Updates  []Update        `json:"updates,omitempty"`
+	Title    string          `json:"title,omitempty"`
+	Content  *string         `json:"content,omitempty"`
+	State    json.RawMessage `json:"state,omitempty"`
+	ClientID string          `json:"client_id,omitempty"`
+	Client   *Presence       `json:"client,omitempty"`
+	Clients  []Presence      `json:"clients,omitempty"`
+	Cursor   *Cursor         `json:"cursor,omitempty"`
+	Error    string          `json:"error,omitempty"`
+}
+
+func InitialMessage(state State) *ServerMessage {
+	return &ServerMessage{
+		Type:     MessageInitial,
+		Mode:     state.Mode,
+		Revision: state.Revision,
+		Title:    state.Title,
+		Content:  &state.Content,
+		State:    state.Encoded,
+	}
+}
+
+func OpMessage(op Operation, revision int) *ServerMessage {
+	return &ServerMessage{Type: MessageOp, Revision: revision, Op: op}
+}
+
+func UpdateMessage(updates []Update, revision int) *ServerMessage {
+	return &ServerMessage{Type: MessageUpdate, Revision: revision, Updates: updates}
+}
+
+func AckMessage(revision int) *ServerMessage {
+	return &ServerMessage{Type: MessageAck, Revision: revision}
+}
+
+func TitleMessage(state State) *ServerMessage {
+	return &ServerMessage{Type: MessageTitle, Revision: state.Revision, Title: state.Title}
+}
+
+func RosterMessage(clientID string, clients []Presence, revision int) *ServerMessage {
+	return &ServerMessage{Type: MessageRoster, Revision: revision, ClientID: clientID, Clients: clients}
+}
+
+func JoinMessage(client Presence, revision int) *ServerMessage {
+	return &ServerMessage{Type: MessageJoin, Revision: revision, Client: &client}
+}
+
+func LeaveMessage(clientID string, revision int) *ServerMessage {
+	return &ServerMessage{Type: MessageLeave, Revision: revision, ClientID: clientID}
+}
+
+func CursorMessage(clientID string, cursor Cursor, revision int) *ServerMessage {
+	return &ServerMessage{Type: MessageCursor, Revision: revision, ClientID: clientID, Cursor: &cursor}
+}
+
+func ErrorMessage(err error, revision int) *ServerMessage {
+	return &ServerMessage{Type: MessageError, Revision: revision, Error: err.Error()}
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"log"
 	"rtdocs/model/domain"
+	"time"
 
 	"github.com/jackc/pgx/v4"
 	"github.com/jackc/pgx/v4/pgxpool"
@@ -14,9 +15,26 @@ import (
 type DocumentRepository interface {
 	GetDocument(ctx context.Context, id string) (*domain.Document, error)
 	GetAllDocuments(ctx context.Context) ([]*domain.Document, error)
+	GetDocumentsForUser(ctx context.Context, userID string) ([]*domain.Document, error)
 	CreateDocument(ctx context.Context, document *domain.Document) (*domain.Document, error)
 	UpdateDocument(ctx context.Context, document *domain.Document) (*domain.Document, error)
+	SaveContent(ctx context.Context, id, title, content string, updatedAt time.Time) (*domain.Document, error)
 	ShareDocument(ctx context.Context, document *domain.Document) (*domain.Document, error)
+	GetCRDTState(ctx context.Context, id string) ([]byte, [][]byte, error)
+	AppendCRDTUpdate(ctx context.Context, id string, update []byte) error
+	SaveCRDTState(ctx context.Context, id string, state []byte) error
+	DeleteDocument(ctx context.Context, id string) error
+	TransferDocuments(ctx context.Context, fromUserID, toUserID string) (int64, error)
+}
+
+const documentColumns = "id, title, content, owner_id, is_public, can_edit, sync_mode, created_at, updated_at"
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanDocument(row scanner, document *domain.Document) error {
+	return row.Scan(&document.ID, &document.Title, &document.Content, &document.OwnerID, &document.IsPublic, &document.CanEdit, &document.SyncMode, &document.CreatedAt, &document.UpdatedAt)
 }
 
 type documentRepository struct {
@@ -31,12 +49,12 @@ func (q *documentRepository) GetDocument(ctx context.Context, id string) (*domai
 	if id == "" {
 		return nil, nil
 	}
-	query := "SELECT * FROM docs WHERE id = $1"
+	query := "SELECT " + documentColumns + " FROM docs WHERE id = $1"
 
 	var document domain.Document
 	row := q.db.QueryRow(ctx, query, id)
 
-	if err := row.Scan(&document.ID, &document.Title, &document.Content, &document.OwnerID, &document.IsPublic, &document.CanEdit, &document.CreatedAt, &document.UpdatedAt); err != nil {
+	if err := scanDocument(row, &document); err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
 			return nil, fmt.Errorf("document not found: %w", err)
 		}
@@ -47,7 +65,7 @@ func (q *documentRepository) GetDocument(ctx context.Context, id string) (*domai
 }
 
 func (q *documentRepository) GetAllDocuments(ctx context.Context) ([]*domain.Document, error) {
-	query := "SELECT * FROM docs"
+	query := "SELECT " + documentColumns + " FROM docs"
 	rows, err := q.db.Query(ctx, query)
 	if err != nil {
 		return nil, err
@@ -57,7 +75,29 @@ func (q *documentRepository) GetAllDocuments(ctx context.Context) ([]*domain.Doc
 	var documents []*domain.Document
 	for rows.Next() {
 		var document domain.Document
-		if err := rows.Scan(&document.ID, &document.Title, &document.Content, &document.OwnerID, &document.IsPublic, &document.CanEdit, &document.CreatedAt, &document.UpdatedAt); err != nil {
+		if err := scanDocument(rows, &document); err != nil {
+			return nil, err
+		}
+		documents = append(documents, &document)
+	}
+
+	return documents, nil
+}
+
+// GetDocumentsForUser lists the documents a user owns, has been granted a role on, or that are public
+func (q *documentRepository) GetDocumentsForUser(ctx context.Context, userID string) ([]*domain.Document, error) {
+	query := "SELECT " + documentColumns + " FROM docs d WHERE d.is_public OR d.owner_id = NULLIF($1, '')::uuid " +
+		"OR EXISTS (SELECT 1 FROM document_permissions p WHERE p.document_id = d.id AND p.user_id = NULLIF($1, '')::uuid)"
+	rows, err := q.db.Query(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var documents []*domain.Document
+	for rows.Next() {
+		var document domain.Document
+		if err := scanDocument(rows, &document); err != nil {
 			return nil, err
 		}
 		documents = append(documents, &document)
@@ -68,9 +108,9 @@ func (q *documentRepository) GetAllDocuments(ctx context.Context) ([]*domain.Doc
 
 func (q *documentRepository) CreateDocument(ctx context.Context, document *domain.Document) (*domain.Document, error) {
 	var newDoc domain.Document
-	query := "INSERT INTO docs (id, title, content, owner_id, is_public, can_edit, created_at, updated_at) VALUES ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING id, title, content, owner_id, is_public, can_edit, created_at, updated_at"
-	row := q.db.QueryRow(ctx, query, document.ID, document.Title, document.Content, document.OwnerID, document.IsPublic, document.CanEdit, document.CreatedAt, document.UpdatedAt)
-	if err := row.Scan(&newDoc.ID, &newDoc.Title, &newDoc.Content, &newDoc.OwnerID, &newDoc.IsPublic, &newDoc.CanEdit, &newDoc.CreatedAt, &newDoc.UpdatedAt); err != nil {
+	query := "INSERT INTO docs (id, title, content, owner_id, is_public, can_edit, sync_mode, created_at, updated_at) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9) RETURNING " + documentColumns
+	row := q.db.QueryRow(ctx, query, document.ID, document.Title, document.Content, document.OwnerID, document.IsPublic, document.CanEdit, document.SyncMode, document.CreatedAt, document.UpdatedAt)
+	if err := scanDocument(row, &newDoc); err != nil {
 		log.Println(err)
 		// return nil, err
 	}
@@ -92,28 +132,134 @@ func (q *documentRepository) UpdateDocument(ctx context.Context, document *domai
 		return nil, errors.New("document ID is required")
 	}
 
-	query := "UPDATE docs SET title = $1, content = $2, owner_id = $3, is_public = $4, can_edit = $5, updated_at = $6 WHERE id = $7 RETURNING id, title, content, owner_id, is_public, can_edit, created_at, updated_at"
+	query := "UPDATE docs SET title = $1, content = $2, owner_id = $3, is_public = $4, can_edit = $5, updated_at = $6 WHERE id = $7 RETURNING " + documentColumns
 
 	row := q.db.QueryRow(ctx, query, document.Title, document.Content, document.OwnerID, document.IsPublic, document.CanEdit, document.UpdatedAt, document.ID)
-	if err := row.Scan(&updatedDoc.ID, &updatedDoc.Title, &updatedDoc.Content, &updatedDoc.OwnerID, &updatedDoc.IsPublic, &updatedDoc.CanEdit, &updatedDoc.CreatedAt, &updatedDoc.UpdatedAt); err != nil {
+	if err := scanDocument(row, &updatedDoc); err != nil {
 		return nil, err
 	}
 
 	return &updatedDoc, nil
 }
 
+// SaveContent writes an edited title and content without touching ownership
+// or sharing, which may have changed since the editor loaded the document.
+// It returns nil if the document no longer exists.
+func (q *documentRepository) SaveContent(ctx context.Context, id, title, content string, updatedAt time.Time) (*domain.Document, error) {
+	query := "UPDATE docs SET title = $2, content = $3, updated_at = $4 WHERE id = $1 RETURNING " + documentColumns
+
+	var document domain.Document
+	row := q.db.QueryRow(ctx, query, id, title, content, updatedAt)
+	if err := scanDocument(row, &document); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return &document, nil
+}
+
 func (q *documentRepository) ShareDocument(ctx context.Context, document *domain.Document) (*domain.Document, error) {
 	var sharedDoc domain.Document
 	if document.ID == "" {
 		return nil, errors.New("document ID is required")
 	}
 
-	query := "UPDATE docs SET is_public = $1, can_edit = $2, updated_at = $3 WHERE id = $4 RETURNING id, title, content, owner_id, is_public, can_edit, created_at, updated_at"
+	query := "UPDATE docs SET is_public = $1, can_edit = $2, updated_at = $3 WHERE id = $4 RETURNING " + documentColumns
 
 	row := q.db.QueryRow(ctx, query, document.IsPublic, document.CanEdit, document.UpdatedAt, document.ID)
-	if err := row.Scan(&sharedDoc.ID, &sharedDoc.Title, &sharedDoc.Content, &sharedDoc.OwnerID, &sharedDoc.IsPublic, &sharedDoc.CanEdit, &sharedDoc.CreatedAt, &sharedDoc.UpdatedAt); err != nil {
+	if err := scanDocument(row, &sharedDoc); err != nil {
 		return nil, err
 	}
 
 	return &sharedDoc, nil
 }
+
+// GetCRDTState returns the last compacted CRDT state of a document and the updates logged since
+func (q *documentRepository) GetCRDTState(ctx context.Context, id string) ([]byte, [][]byte, error) {
+	if id == "" {
+		return nil, nil, errors.New("document ID is required")
+	}
+
+	var state []byte
+	query := "SELECT state FROM document_crdt_states WHERE document_id = $1"
+	if err := q.db.QueryRow(ctx, query, id).Scan(&state); err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil, err
+	}
+
+	query = "SELECT payload FROM document_crdt_updates WHERE document_id = $1 ORDER BY id"
+	rows, err := q.db.Query(ctx, query, id)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	var updates [][]byte
+	for rows.Next() {
+		var update []byte
+		if err := rows.Scan(&update); err != nil {
+			return nil, nil, err
+		}
+		updates = append(updates, update)
+	}
+
+	return state, updates, rows.Err()
+}
+
+// AppendCRDTUpdate adds an update to the document's log
+func (q *documentRepository) AppendCRDTUpdate(ctx context.Context, id string, update []byte) error {
+	query := "INSERT INTO document_crdt_updates (document_id, payload) VALUES ($1, $2)"
+	_, err := q.db.Exec(ctx, query, id, update)
+	return err
+}
+
+// SaveCRDTState stores a compacted state and clears the update log it replaces
+func (q *documentRepository) SaveCRDTState(ctx context.Context, id string, state []byte) error {
+	tx, err := q.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	query := "INSERT INTO document_crdt_states (document_id, state, updated_at) VALUES ($1, $2, CURRENT_TIMESTAMP) ON CONFLICT (document_id) DO UPDATE SET state = EXCLUDED.state, updated_at = EXCLUDED.updated_at"
+	if _, err := tx.Exec(ctx, query, id, state); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(ctx, "DELETE FROM document_crdt_updates WHERE document_id = $1", id); err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+// DeleteDocument deletes a document; its history, sync state and grants go with it through ON DELETE CASCADE
+func (q *documentRepository) DeleteDocument(ctx context.Context, id string) error {
+	query := "DELETE FROM docs WHERE id = $1"
+	_, err := q.db.Exec(ctx, query, id)
+	return err
+}
+
+// TransferDocuments makes toUserID the owner of every document owned by
+// fromUserID and returns how many there were. Grants the new owner had on
+// those documents are dropped, as ownership supersedes them.
+func (q *documentRepository) TransferDocuments(ctx context.Context, fromUserID, toUserID string) (int64, error) {
+	tx, err := q.db.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	tag, err := tx.Exec(ctx, "UPDATE docs SET owner_id = $2, updated_at = CURRENT_TIMESTAMP WHERE owner_id = $1", fromUserID, toUserID)
+	if err != nil {
+		return 0, err
+	}
+
+	query := "DELETE FROM document_permissions p USING docs d WHERE d.id = p.document_id AND d.owner_id = $1 AND p.user_id = $1"
+	if _, err := tx.Exec(ctx, query, toUserID); err != nil {
+		return 0, err
+	}
+
+	return tag.RowsAffected(), tx.Commit(ctx)
+}
@@ -0,0 +1,88 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"rtdocs/model/domain"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+type IdentityRepository interface {
+	GetIdentityUser(ctx context.Context, issuer, subject string) (*domain.User, error)
+	LinkIdentity(ctx context.Context, identity *domain.Identity) error
+	HasIdentity(ctx context.Context, userID string, issuers []string) (bool, error)
+	CreateOIDCLogin(ctx context.Context, stateHash, nonce, codeVerifier string, expiresAt time.Time) error
+	ConsumeOIDCLogin(ctx context.Context, stateHash string) (string, string, error)
+}
+
+type identityRepository struct {
+	db *pgxpool.Pool
+}
+
+func NewIdentityRepository(db *pgxpool.Pool) IdentityRepository {
+	return &identityRepository{db: db}
+}
+
+// GetIdentityUser returns the user linked to an external identity, or nil if it is not linked
+func (q *identityRepository) GetIdentityUser(ctx context.Context, issuer, subject string) (*domain.User, error) {
+	query := "SELECT " + userColumns + " FROM users WHERE id = (SELECT user_id FROM user_identities WHERE issuer = $1 AND subject = $2)"
+
+	var user domain.User
+	row := q.db.QueryRow(ctx, query, issuer, subject)
+	if err := scanUser(row, &user); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+func (q *identityRepository) LinkIdentity(ctx context.Context, identity *domain.Identity) error {
+	query := "INSERT INTO user_identities (issuer, subject, user_id, email) VALUES ($1, $2, $3, NULLIF($4, ''))"
+	_, err := q.db.Exec(ctx, query, identity.Issuer, identity.Subject, identity.UserID, identity.Email)
+	return err
+}
+
+// HasIdentity reports whether an identity from one of issuers is linked to a user
+func (q *identityRepository) HasIdentity(ctx context.Context, userID string, issuers []string) (bool, error) {
+	var linked bool
+	err := q.db.QueryRow(ctx, "SELECT EXISTS (SELECT 1 FROM user_identities WHERE user_id = $1 AND issuer = ANY($2))", userID, issuers).Scan(&linked)
+	return linked, err
+}
+
+// CreateOIDCLogin remembers a sign-in that is being sent to the identity provider
+func (q *identityRepository) CreateOIDCLogin(ctx context.Context, stateHash, nonce, codeVerifier string, expiresAt time.Time) error {
+	// Abandoned sign-ins are cleaned up as new ones start
+	if _, err := q.db.Exec(ctx, "DELETE FROM oidc_logins WHERE expires_at <= CURRENT_TIMESTAMP"); err != nil {
+		return err
+	}
+
+	query := "INSERT INTO oidc_logins (state_hash, nonce, code_verifier, expires_at) VALUES ($1, $2, $3, $4)"
+	_, err := q.db.Exec(ctx, query, stateHash, nonce, codeVerifier, expiresAt)
+	return err
+}
+
+// ConsumeOIDCLogin removes a pending sign-in and returns its nonce and code
+// verifier, or empty strings if the state is unknown or has expired
+func (q *identityRepository) ConsumeOIDCLogin(ctx context.Context, stateHash string) (string, string, error) {
+	query := "DELETE FROM oidc_logins WHERE state_hash = $1 RETURNING nonce, code_verifier, expires_at"
+
+	var nonce, codeVerifier string
+	var expiresAt time.Time
+	if err := q.db.QueryRow(ctx, query, stateHash).Scan(&nonce, &codeVerifier, &expiresAt); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return "", "", nil
+		}
+		return "", "", err
+	}
+	if time.Now().After(expiresAt) {
+		return "", "", nil
+	}
+
+	return nonce, codeVerifier, nil
+}
@@ -0,0 +1,86 @@
+package repository
+
+import (
+	"context"
+	"rtdocs/model/domain"
+	"time"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+type LoginAttemptRepository interface {
+	RecordLoginAttempt(ctx context.Context, attempt *domain.LoginAttempt) error
+	DeleteLoginAttemptsBefore(ctx context.Context, before time.Time) (int64, error)
+	CountFailure(ctx context.Context, key string, now, since time.Time) (int, *time.Time, error)
+	ForgiveFailure(ctx context.Context, key string) error
+	ClearFailures(ctx context.Context, key string) error
+	DeleteFailuresBefore(ctx context.Context, before time.Time) (int64, error)
+}
+
+type loginAttemptRepository struct {
+	db *pgxpool.Pool
+}
+
+func NewLoginAttemptRepository(db *pgxpool.Pool) LoginAttemptRepository {
+	return &loginAttemptRepository{db: db}
+}
+
+func (q *loginAttemptRepository) RecordLoginAttempt(ctx context.Context, attempt *domain.LoginAttempt) error {
+	query := "INSERT INTO login_attempts (username, user_id, ip_address, user_agent, succeeded, reason, attempted_at) VALUES ($1, NULLIF($2, '')::uuid, $3, $4, $5, $6, $7)"
+	_, err := q.db.Exec(ctx, query, attempt.Username, attempt.UserID, attempt.IPAddress, attempt.UserAgent, attempt.Succeeded, attempt.Reason, attempt.AttemptedAt)
+	return err
+}
+
+// DeleteLoginAttemptsBefore deletes the audit log of attempts made before a cutoff
+func (q *loginAttemptRepository) DeleteLoginAttemptsBefore(ctx context.Context, before time.Time) (int64, error) {
+	tag, err := q.db.Exec(ctx, "DELETE FROM login_attempts WHERE attempted_at < $1", before)
+	if err != nil {
+		return 0, err
+	}
+
+	return tag.RowsAffected(), nil
+}
+
+// CountFailure counts a failure against key at now, starting over if the last
+// one was at or before since. It returns how many failures there were before
+// this one and when the latest of them was, nil if none. The count is taken and
+// incremented in one statement, so concurrent attempts each see the others.
+func (q *loginAttemptRepository) CountFailure(ctx context.Context, key string, now, since time.Time) (int, *time.Time, error) {
+	// SET expressions see the row as it was, so previous_failure_at gets the old last_failure_at
+	query := `INSERT INTO login_throttles AS t (key, failures, last_failure_at) VALUES ($1, 1, $2)
+		ON CONFLICT (key) DO UPDATE SET
+			failures = CASE WHEN t.last_failure_at > $3 THEN t.failures + 1 ELSE 1 END,
+			previous_failure_at = CASE WHEN t.last_failure_at > $3 THEN t.last_failure_at END,
+			last_failure_at = $2
+		RETURNING failures - 1, previous_failure_at`
+
+	var count int
+	var last *time.Time
+	if err := q.db.QueryRow(ctx, query, key, now, since).Scan(&count, &last); err != nil {
+		return 0, nil, err
+	}
+	return count, last, nil
+}
+
+// ForgiveFailure takes back one failure counted against key
+func (q *loginAttemptRepository) ForgiveFailure(ctx context.Context, key string) error {
+	_, err := q.db.Exec(ctx, "UPDATE login_throttles SET failures = failures - 1 WHERE key = $1 AND failures > 0", key)
+	return err
+}
+
+// ClearFailures forgets every failure counted against key
+func (q *loginAttemptRepository) ClearFailures(ctx context.Context, key string) error {
+	_, err := q.db.Exec(ctx, "DELETE FROM login_throttles WHERE key = $1", key)
+	return err
+}
+
+// DeleteFailuresBefore deletes the counts whose last failure was before a
+// cutoff. CountFailure would start them over anyway.
+func (q *loginAttemptRepository) DeleteFailuresBefore(ctx context.Context, before time.Time) (int64, error) {
+	tag, err := q.db.Exec(ctx, "DELETE FROM login_throttles WHERE last_failure_at < $1", before)
+	if err != nil {
+		return 0, err
+	}
+
+	return tag.RowsAffected(), nil
+}
@@ -0,0 +1,184 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"rtdocs/model/domain"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+type MFARepository interface {
+	GetTOTP(ctx context.Context, userID string) (*domain.TOTP, error)
+	SaveTOTP(ctx context.Context, userID, secret string) error
+	EnableTOTP(ctx context.Context, userID string, step int64, recoveryCodeHashes []string) (bool, error)
+	UseTOTPStep(ctx context.Context, userID string, step int64) (bool, error)
+	GetRecoveryCodes(ctx context.Context, userID string) ([]string, error)
+	UseRecoveryCode(ctx context.Context, userID, codeHash string) (bool, error)
+	ReplaceRecoveryCodes(ctx context.Context, userID string, codeHashes []string) error
+	DeleteTOTP(ctx context.Context, userID string) error
+	UseChallenge(ctx context.Context, tokenID, userID string, expiresAt time.Time) (bool, error)
+}
+
+type mfaRepository struct {
+	db *pgxpool.Pool
+}
+
+func NewMFARepository(db *pgxpool.Pool) MFARepository {
+	return &mfaRepository{db: db}
+}
+
+// GetTOTP returns a user's TOTP enrollment, or nil if they have not started one
+func (q *mfaRepository) GetTOTP(ctx context.Context, userID string) (*domain.TOTP, error) {
+	query := "SELECT user_id, secret, enabled_at, last_step, created_at FROM user_totp WHERE user_id = $1"
+
+	var totp domain.TOTP
+	row := q.db.QueryRow(ctx, query, userID)
+	if err := row.Scan(&totp.UserID, &totp.Secret, &totp.EnabledAt, &totp.LastStep, &totp.CreatedAt); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return &totp, nil
+}
+
+// SaveTOTP starts a new, not yet enabled, enrollment, replacing one that was never confirmed
+func (q *mfaRepository) SaveTOTP(ctx context.Context, userID, secret string) error {
+	query := `INSERT INTO user_totp (user_id, secret) VALUES ($1, $2)
+		ON CONFLICT (user_id) DO UPDATE SET secret = EXCLUDED.secret, last_step = 0, created_at = CURRENT_TIMESTAMP
+		WHERE user_totp.enabled_at IS NULL`
+	_, err := q.db.Exec(ctx, query, userID, secret)
+	return err
+}
+
+// EnableTOTP turns on an enrollment with the step of the code that confirmed
+// it and stores the user's recovery codes. It returns false if the enrollment
+// is missing or already enabled.
+func (q *mfaRepository) EnableTOTP(ctx context.Context, userID string, step int64, recoveryCodeHashes []string) (bool, error) {
+	tx, err := q.db.Begin(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback(ctx)
+
+	query := "UPDATE user_totp SET enabled_at = CURRENT_TIMESTAMP, last_step = $2 WHERE user_id = $1 AND enabled_at IS NULL"
+	tag, err := tx.Exec(ctx, query, userID, step)
+	if err != nil {
+		return false, err
+	}
+	if tag.RowsAffected() == 0 {
+		return false, nil
+	}
+
+	if err := replaceRecoveryCodes(ctx, tx, userID, recoveryCodeHashes); err != nil {
+		return false, err
+	}
+
+	return true, tx.Commit(ctx)
+}
+
+// UseTOTPStep records that a code from the given step was used. It returns
+// false if a code from that step or a later one was already used.
+func (q *mfaRepository) UseTOTPStep(ctx context.Context, userID string, step int64) (bool, error) {
+	query := "UPDATE user_totp SET last_step = $2 WHERE user_id = $1 AND enabled_at IS NOT NULL AND last_step < $2"
+	tag, err := q.db.Exec(ctx, query, userID, step)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// GetRecoveryCodes returns the hashes of a user's unused recovery codes
+func (q *mfaRepository) GetRecoveryCodes(ctx context.Context, userID string) ([]string, error) {
+	rows, err := q.db.Query(ctx, "SELECT code_hash FROM mfa_recovery_codes WHERE user_id = $1 AND used_at IS NULL", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var hashes []string
+	for rows.Next() {
+		var hash string
+		if err := rows.Scan(&hash); err != nil {
+			return nil, err
+		}
+		hashes = append(hashes, hash)
+	}
+
+	return hashes, rows.Err()
+}
+
+// UseRecoveryCode uses up one of a user's recovery codes, returning false if it is unknown or used
+func (q *mfaRepository) UseRecoveryCode(ctx context.Context, userID, codeHash string) (bool, error) {
+	query := "UPDATE mfa_recovery_codes SET used_at = CURRENT_TIMESTAMP WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL"
+	tag, err := q.db.Exec(ctx, query, userID, codeHash)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// ReplaceRecoveryCodes swaps all of a user's recovery codes for new ones
+func (q *mfaRepository) ReplaceRecoveryCodes(ctx context.Context, userID string, codeHashes []string) error {
+	tx, err := q.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if err := replaceRecoveryCodes(ctx, tx, userID, codeHashes); err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+// DeleteTOTP turns two-factor authentication off, removing the secret and recovery codes
+func (q *mfaRepository) DeleteTOTP(ctx context.Context, userID string) error {
+	tx, err := q.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, "DELETE FROM mfa_recovery_codes WHERE user_id = $1", userID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(ctx, "DELETE FROM user_totp WHERE user_id = $1", userID); err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+// UseChallenge records that an MFA token has been exchanged for a session.
+// It returns false if the token was already used. Records of tokens that have
+// expired, and so can no longer be presented, are cleared on the way.
+func (q *mfaRepository) UseChallenge(ctx context.Context, tokenID, userID string, expiresAt time.Time) (bool, error) {
+	if _, err := q.db.Exec(ctx, "DELETE FROM mfa_challenges WHERE expires_at < CURRENT_TIMESTAMP"); err != nil {
+		return false, err
+	}
+
+	query := "INSERT INTO mfa_challenges (token_id, user_id, expires_at) VALUES ($1, $2, $3) ON CONFLICT (token_id) DO NOTHING"
+	tag, err := q.db.Exec(ctx, query, tokenID, userID, expiresAt)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+func replaceRecoveryCodes(ctx context.Context, tx pgx.Tx, userID string, codeHashes []string) error {
+	if _, err := tx.Exec(ctx, "DELETE FROM mfa_recovery_codes WHERE user_id = $1", userID); err != nil {
+		return err
+	}
+	for _, hash := range codeHashes {
+		if _, err := tx.Exec(ctx, "INSERT INTO mfa_recovery_codes (user_id, code_hash) VALUES ($1, $2)", userID, hash); err != nil {
+			return err
+		}
+	}
+	return nil
+}
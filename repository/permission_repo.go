@@ -0,0 +1,95 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"rtdocs/model/domain"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+type PermissionRepository interface {
+	GetPermission(ctx context.Context, documentID, userID string) (*domain.Permission, error)
+	GetPermissions(ctx context.Context, documentID string) ([]*domain.Permission, error)
+	SavePermission(ctx context.Context, permission *domain.Permission) (*domain.Permission, error)
+	DeletePermission(ctx context.Context, documentID, userID string) error
+}
+
+type permissionRepository struct {
+	db *pgxpool.Pool
+}
+
+func NewPermissionRepository(db *pgxpool.Pool) PermissionRepository {
+	return &permissionRepository{db: db}
+}
+
+const permissionColumns = "document_id, user_id, role, COALESCE(granted_by::text, ''), created_at, updated_at"
+
+func scanPermission(row scanner, permission *domain.Permission) error {
+	return row.Scan(&permission.DocumentID, &permission.UserID, &permission.Role, &permission.GrantedBy, &permission.CreatedAt, &permission.UpdatedAt)
+}
+
+// GetPermission returns the role granted to a user on a document, or nil if there is none
+func (q *permissionRepository) GetPermission(ctx context.Context, documentID, userID string) (*domain.Permission, error) {
+	query := "SELECT " + permissionColumns + " FROM document_permissions WHERE document_id = $1 AND user_id = $2"
+
+	var permission domain.Permission
+	row := q.db.QueryRow(ctx, query, documentID, userID)
+
+	if err := scanPermission(row, &permission); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return &permission, nil
+}
+
+func (q *permissionRepository) GetPermissions(ctx context.Context, documentID string) ([]*domain.Permission, error) {
+	query := "SELECT " + permissionColumns + " FROM document_permissions WHERE document_id = $1 ORDER BY created_at"
+	rows, err := q.db.Query(ctx, query, documentID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var permissions []*domain.Permission
+	for rows.Next() {
+		var permission domain.Permission
+		if err := scanPermission(rows, &permission); err != nil {
+			return nil, err
+		}
+		permissions = append(permissions, &permission)
+	}
+
+	return permissions, nil
+}
+
+// SavePermission grants a role, replacing any role the user already had on the document
+func (q *permissionRepository) SavePermission(ctx context.Context, permission *domain.Permission) (*domain.Permission, error) {
+	var saved domain.Permission
+	query := "INSERT INTO document_permissions (document_id, user_id, role, granted_by, created_at, updated_at) VALUES ($1, $2, $3, NULLIF($4, '')::uuid, $5, $6) " +
+		"ON CONFLICT (document_id, user_id) DO UPDATE SET role = EXCLUDED.role, granted_by = EXCLUDED.granted_by, updated_at = EXCLUDED.updated_at " +
+		"RETURNING " + permissionColumns
+	row := q.db.QueryRow(ctx, query, permission.DocumentID, permission.UserID, permission.Role, permission.GrantedBy, permission.CreatedAt, permission.UpdatedAt)
+
+	if err := scanPermission(row, &saved); err != nil {
+		return nil, err
+	}
+
+	return &saved, nil
+}
+
+func (q *permissionRepository) DeletePermission(ctx context.Context, documentID, userID string) error {
+	query := "DELETE FROM document_permissions WHERE document_id = $1 AND user_id = $2"
+	tag, err := q.db.Exec(ctx, query, documentID, userID)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return errors.New("permission not found")
+	}
+	return nil
+}
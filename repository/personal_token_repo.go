@@ -0,0 +1,94 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"rtdocs/model/domain"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+type PersonalTokenRepository interface {
+	CreatePersonalToken(ctx context.Context, token *domain.PersonalToken) (*domain.PersonalToken, error)
+	GetPersonalTokens(ctx context.Context, userID string) ([]*domain.PersonalToken, error)
+	UsePersonalToken(ctx context.Context, tokenHash string) (*domain.PersonalToken, error)
+	RevokePersonalToken(ctx context.Context, userID, id string) (bool, error)
+}
+
+type personalTokenRepository struct {
+	db *pgxpool.Pool
+}
+
+func NewPersonalTokenRepository(db *pgxpool.Pool) PersonalTokenRepository {
+	return &personalTokenRepository{db: db}
+}
+
+const personalTokenColumns = "id, user_id, name, prefix, token_hash, scopes, created_at, last_used_at, expires_at, revoked_at"
+
+func scanPersonalToken(row scanner, token *domain.PersonalToken) error {
+	return row.Scan(&token.ID, &token.UserID, &token.Name, &token.Prefix, &token.TokenHash, &token.Scopes,
+		&token.CreatedAt, &token.LastUsedAt, &token.ExpiresAt, &token.RevokedAt)
+}
+
+func (q *personalTokenRepository) CreatePersonalToken(ctx context.Context, token *domain.PersonalToken) (*domain.PersonalToken, error) {
+	query := "INSERT INTO personal_access_tokens (id, user_id, name, prefix, token_hash, scopes, created_at, expires_at) VALUES ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING " + personalTokenColumns
+	row := q.db.QueryRow(ctx, query, token.ID, token.UserID, token.Name, token.Prefix, token.TokenHash, token.Scopes, token.CreatedAt, token.ExpiresAt)
+
+	var created domain.PersonalToken
+	if err := scanPersonalToken(row, &created); err != nil {
+		return nil, err
+	}
+
+	return &created, nil
+}
+
+// GetPersonalTokens returns a user's tokens that have not been revoked, newest first
+func (q *personalTokenRepository) GetPersonalTokens(ctx context.Context, userID string) ([]*domain.PersonalToken, error) {
+	query := "SELECT " + personalTokenColumns + " FROM personal_access_tokens WHERE user_id = $1 AND revoked_at IS NULL ORDER BY created_at DESC"
+	rows, err := q.db.Query(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tokens []*domain.PersonalToken
+	for rows.Next() {
+		var token domain.PersonalToken
+		if err := scanPersonalToken(rows, &token); err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, &token)
+	}
+
+	return tokens, rows.Err()
+}
+
+// UsePersonalToken looks up a live token by its hash and records that it was
+// used. It returns nil if the token is unknown, revoked or expired.
+func (q *personalTokenRepository) UsePersonalToken(ctx context.Context, tokenHash string) (*domain.PersonalToken, error) {
+	query := `UPDATE personal_access_tokens SET last_used_at = CURRENT_TIMESTAMP
+		WHERE token_hash = $1 AND revoked_at IS NULL AND (expires_at IS NULL OR expires_at > CURRENT_TIMESTAMP)
+		RETURNING ` + personalTokenColumns
+
+	var token domain.PersonalToken
+	row := q.db.QueryRow(ctx, query, tokenHash)
+	if err := scanPersonalToken(row, &token); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return &token, nil
+}
+
+// RevokePersonalToken revokes one of a user's tokens, returning false if they have no such live token
+func (q *personalTokenRepository) RevokePersonalToken(ctx context.Context, userID, id string) (bool, error) {
+	query := "UPDATE personal_access_tokens SET revoked_at = CURRENT_TIMESTAMP WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL"
+	tag, err := q.db.Exec(ctx, query, id, userID)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
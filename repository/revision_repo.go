@@ -0,0 +1,91 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"rtdocs/model/domain"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+type RevisionRepository interface {
+	CreateRevision(ctx context.Context, revision *domain.Revision) (*domain.Revision, error)
+	GetRevision(ctx context.Context, documentID, id string) (*domain.Revision, error)
+	GetLatestRevision(ctx context.Context, documentID string) (*domain.Revision, error)
+	GetRevisions(ctx context.Context, documentID string) ([]*domain.Revision, error)
+}
+
+type revisionRepository struct {
+	db *pgxpool.Pool
+}
+
+func NewRevisionRepository(db *pgxpool.Pool) RevisionRepository {
+	return &revisionRepository{db: db}
+}
+
+func (q *revisionRepository) CreateRevision(ctx context.Context, revision *domain.Revision) (*domain.Revision, error) {
+	query := "INSERT INTO document_revisions (id, document_id, title, content, author_id, created_at) VALUES ($1, $2, $3, $4, NULLIF($5, '')::uuid, $6) RETURNING id"
+	row := q.db.QueryRow(ctx, query, revision.ID, revision.DocumentID, revision.Title, revision.Content, revision.AuthorID, revision.CreatedAt)
+
+	if err := row.Scan(&revision.ID); err != nil {
+		return nil, err
+	}
+
+	return revision, nil
+}
+
+// GetRevision returns a revision of a document, or nil if the document has no such revision
+func (q *revisionRepository) GetRevision(ctx context.Context, documentID, id string) (*domain.Revision, error) {
+	query := "SELECT id, document_id, title, content, COALESCE(author_id::text, ''), created_at FROM document_revisions WHERE document_id = $1 AND id = $2"
+
+	var revision domain.Revision
+	row := q.db.QueryRow(ctx, query, documentID, id)
+
+	if err := row.Scan(&revision.ID, &revision.DocumentID, &revision.Title, &revision.Content, &revision.AuthorID, &revision.CreatedAt); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return &revision, nil
+}
+
+// GetLatestRevision returns the newest revision of a document, or nil if it has none
+func (q *revisionRepository) GetLatestRevision(ctx context.Context, documentID string) (*domain.Revision, error) {
+	query := "SELECT id, document_id, title, content, COALESCE(author_id::text, ''), created_at FROM document_revisions WHERE document_id = $1 ORDER BY created_at DESC LIMIT 1"
+
+	var revision domain.Revision
+	row := q.db.QueryRow(ctx, query, documentID)
+
+	if err := row.Scan(&revision.ID, &revision.DocumentID, &revision.Title, &revision.Content, &revision.AuthorID, &revision.CreatedAt); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return &revision, nil
+}
+
+// GetRevisions lists a document's revisions, newest first, without their content
+func (q *revisionRepository) GetRevisions(ctx context.Context, documentID string) ([]*domain.Revision, error) {
+	query := "SELECT id, document_id, title, COALESCE(author_id::text, ''), created_at FROM document_revisions WHERE document_id = $1 ORDER BY created_at DESC"
+	rows, err := q.db.Query(ctx, query, documentID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var revisions []*domain.Revision
+	for rows.Next() {
+		var revision domain.Revision
+		if err := rows.Scan(&revision.ID, &revision.DocumentID, &revision.Title, &revision.AuthorID, &revision.CreatedAt); err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, &revision)
+	}
+
+	return revisions, nil
+}
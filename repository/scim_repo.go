@@ -0,0 +1,95 @@
+package repository
+
+import (
+	"context"
+	"rtdocs/model/domain"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// SCIMRepository keeps the membership of SCIM groups. A group stands for a
+// role, and a member is a user the identity provider added who still has it.
+type SCIMRepository interface {
+	GetGroupMembers(ctx context.Context, groupID, role string) ([]*domain.User, error)
+	GetGroupsOf(ctx context.Context, userIDs []string) (map[string][]string, error)
+	UpdateGroupMembers(ctx context.Context, groupID, role string, add, remove []string) error
+}
+
+type scimRepository struct {
+	db *pgxpool.Pool
+}
+
+func NewSCIMRepository(db *pgxpool.Pool) SCIMRepository {
+	return &scimRepository{db: db}
+}
+
+func (q *scimRepository) GetGroupMembers(ctx context.Context, groupID, role string) ([]*domain.User, error) {
+	query := "SELECT " + userColumns + " FROM users WHERE role = $2 AND id IN (SELECT user_id FROM scim_group_members WHERE group_id = $1) ORDER BY created_at, id"
+	rows, err := q.db.Query(ctx, query, groupID, role)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []*domain.User
+	for rows.Next() {
+		var user domain.User
+		if err := scanUser(rows, &user); err != nil {
+			return nil, err
+		}
+		users = append(users, &user)
+	}
+
+	return users, rows.Err()
+}
+
+// GetGroupsOf returns the IDs of the groups each user was added to, keyed by
+// user ID. Whether they still have the group's role is up to the caller.
+func (q *scimRepository) GetGroupsOf(ctx context.Context, userIDs []string) (map[string][]string, error) {
+	rows, err := q.db.Query(ctx, "SELECT user_id, group_id FROM scim_group_members WHERE user_id = ANY($1::uuid[]) ORDER BY group_id", userIDs)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	groups := make(map[string][]string)
+	for rows.Next() {
+		var userID, groupID string
+		if err := rows.Scan(&userID, &groupID); err != nil {
+			return nil, err
+		}
+		groups[userID] = append(groups[userID], groupID)
+	}
+
+	return groups, rows.Err()
+}
+
+// UpdateGroupMembers adds users to a group, giving them its role, and removes
+// others, who go back to the default role if they still have it. Either every
+// change is made or none is.
+func (q *scimRepository) UpdateGroupMembers(ctx context.Context, groupID, role string, add, remove []string) error {
+	tx, err := q.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	for _, userID := range remove {
+		if _, err := tx.Exec(ctx, "DELETE FROM scim_group_members WHERE group_id = $1 AND user_id = $2", groupID, userID); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(ctx, "UPDATE users SET role = $1 WHERE id = $2 AND role = $3", domain.UserRoleAuthenticated, userID, role); err != nil {
+			return err
+		}
+	}
+	for _, userID := range add {
+		if _, err := tx.Exec(ctx, "INSERT INTO scim_group_members (group_id, user_id) VALUES ($1, $2) ON CONFLICT DO NOTHING", groupID, userID); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(ctx, "UPDATE users SET role = $1 WHERE id = $2 AND role <> $3", role, userID, domain.UserRoleGuest); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit(ctx)
+}
@@ -0,0 +1,132 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"rtdocs/model/domain"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+type SessionRepository interface {
+	CreateSession(ctx context.Context, session *domain.Session) (*domain.Session, error)
+	GetSession(ctx context.Context, id string) (*domain.Session, error)
+	GetUserSessions(ctx context.Context, userID string) ([]*domain.Session, error)
+	TouchSession(ctx context.Context, id string) error
+	RotateSession(ctx context.Context, id, oldToken, newToken string, expiresAt time.Time) (bool, error)
+	RevokeSession(ctx context.Context, id string) error
+	RevokeUserSessions(ctx context.Context, userID string) ([]*domain.Session, error)
+}
+
+type sessionRepository struct {
+	db *pgxpool.Pool
+}
+
+func NewSessionRepository(db *pgxpool.Pool) SessionRepository {
+	return &sessionRepository{db: db}
+}
+
+const sessionColumns = "id, user_id, token, device, ip_address, user_agent, created_at, last_seen_at, expires_at, rotated_at, revoked_at"
+
+func scanSession(row scanner, session *domain.Session) error {
+	return row.Scan(&session.ID, &session.UserID, &session.Token, &session.Device, &session.IPAddress, &session.UserAgent,
+		&session.CreatedAt, &session.LastSeenAt, &session.ExpiresAt, &session.RotatedAt, &session.RevokedAt)
+}
+
+func (q *sessionRepository) CreateSession(ctx context.Context, session *domain.Session) (*domain.Session, error) {
+	query := "INSERT INTO sessions (id, user_id, token, device, ip_address, user_agent, created_at, last_seen_at, expires_at) VALUES ($1, $2, $3, $4, $5, $6, $7, $7, $8) RETURNING id"
+	row := q.db.QueryRow(ctx, query, session.ID, session.UserID, session.Token, session.Device, session.IPAddress, session.UserAgent, session.CreatedAt, session.ExpiresAt)
+
+	if err := row.Scan(&session.ID); err != nil {
+		return nil, err
+	}
+
+	return session, nil
+}
+
+// GetSession returns a session by its ID, or nil if there is none
+func (q *sessionRepository) GetSession(ctx context.Context, id string) (*domain.Session, error) {
+	query := "SELECT " + sessionColumns + " FROM sessions WHERE id = $1"
+
+	var session domain.Session
+	row := q.db.QueryRow(ctx, query, id)
+
+	if err := scanSession(row, &session); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return &session, nil
+}
+
+// GetUserSessions returns the sessions of a user that are neither revoked nor expired, most recently used first
+func (q *sessionRepository) GetUserSessions(ctx context.Context, userID string) ([]*domain.Session, error) {
+	query := "SELECT " + sessionColumns + " FROM sessions WHERE user_id = $1 AND revoked_at IS NULL AND expires_at > CURRENT_TIMESTAMP ORDER BY last_seen_at DESC"
+	rows, err := q.db.Query(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sessions []*domain.Session
+	for rows.Next() {
+		var session domain.Session
+		if err := scanSession(rows, &session); err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, &session)
+	}
+
+	return sessions, rows.Err()
+}
+
+// TouchSession records that a session has just been used
+func (q *sessionRepository) TouchSession(ctx context.Context, id string) error {
+	query := "UPDATE sessions SET last_seen_at = CURRENT_TIMESTAMP WHERE id = $1"
+	_, err := q.db.Exec(ctx, query, id)
+	return err
+}
+
+// RotateSession swaps the session's current token for a new one. It reports
+// false without changing anything if oldToken is no longer current or the
+// session has been revoked, so two concurrent refreshes cannot both succeed.
+func (q *sessionRepository) RotateSession(ctx context.Context, id, oldToken, newToken string, expiresAt time.Time) (bool, error) {
+	query := "UPDATE sessions SET token = $3, expires_at = $4, rotated_at = CURRENT_TIMESTAMP, last_seen_at = CURRENT_TIMESTAMP WHERE id = $1 AND token = $2 AND revoked_at IS NULL"
+	tag, err := q.db.Exec(ctx, query, id, oldToken, newToken, expiresAt)
+	if err != nil {
+		return false, err
+	}
+
+	return tag.RowsAffected() == 1, nil
+}
+
+func (q *sessionRepository) RevokeSession(ctx context.Context, id string) error {
+	query := "UPDATE sessions SET revoked_at = CURRENT_TIMESTAMP WHERE id = $1 AND revoked_at IS NULL"
+	_, err := q.db.Exec(ctx, query, id)
+	return err
+}
+
+// RevokeUserSessions revokes every active session of a user and returns them
+func (q *sessionRepository) RevokeUserSessions(ctx context.Context, userID string) ([]*domain.Session, error) {
+	query := "UPDATE sessions SET revoked_at = CURRENT_TIMESTAMP WHERE user_id = $1 AND revoked_at IS NULL RETURNING " + sessionColumns
+	rows, err := q.db.Query(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sessions []*domain.Session
+	for rows.Next() {
+		var session domain.Session
+		if err := scanSession(rows, &session); err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, &session)
+	}
+
+	return sessions, rows.Err()
+}
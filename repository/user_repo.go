@@ -6,7 +6,10 @@ import (
 	"fmt"
 	"log"
 	"rtdocs/model/domain"
+	"strings"
+	"time"
 
+	"github.com/jackc/pgconn"
 	"github.com/jackc/pgx/v4"
 	"github.com/jackc/pgx/v4/pgxpool"
 )
@@ -16,8 +19,30 @@ type UserRepository interface {
 	GetAllUsers(ctx context.Context) ([]*domain.User, error)
 	CreateUser(ctx context.Context, user *domain.User) (*domain.User, error)
 	UpdateUser(ctx context.Context, user *domain.User) (*domain.User, error)
+	GetUserByUsername(ctx context.Context, username string) (*domain.User, error)
+	CreateUserFromGuest(ctx context.Context, user *domain.User, guestID string) (*domain.User, error)
+	GuestExists(ctx context.Context, id string) (bool, error)
+	MergeGuest(ctx context.Context, guestID, userID string) (bool, error)
+	DeleteExpiredGuests(ctx context.Context, createdBefore time.Time) (int64, error)
+	SearchUsers(ctx context.Context, search string, limit, offset int) ([]*domain.User, int, error)
+	ListUsers(ctx context.Context, limit, offset int) ([]*domain.User, int, error)
+	GetUsersByUsernameFold(ctx context.Context, username string) ([]*domain.User, error)
+	SetDisabled(ctx context.Context, id string, disabled bool) error
+	SetPasswordResetRequired(ctx context.Context, id string, required bool) error
+	GetUserByEmail(ctx context.Context, email string) (*domain.User, error)
+	CreatePasswordResetToken(ctx context.Context, userID, tokenHash string, expiresAt time.Time) error
+	GetPasswordResetUser(ctx context.Context, tokenHash string) (*domain.User, error)
+	ResetPassword(ctx context.Context, tokenHash, password string) (string, error)
+	CreateVerificationToken(ctx context.Context, userID, email, tokenHash string, expiresAt time.Time) error
+	VerifyEmail(ctx context.Context, tokenHash string) (string, error)
 }
 
+// Returned when saving a user would give them another user's username or email address
+var (
+	ErrDuplicateUsername = errors.New("username is already in use")
+	ErrDuplicateEmail    = errors.New("email address is already in use")
+)
+
 type userRepository struct {
 	db *pgxpool.Pool
 }
@@ -26,17 +51,23 @@ func NewUserRepository(db *pgxpool.Pool) UserRepository {
 	return &userRepository{db: db}
 }
 
+const userColumns = "id, username, COALESCE(email, ''), verified, password, role, created_at, disabled_at, password_reset_required"
+
+func scanUser(row scanner, user *domain.User) error {
+	return row.Scan(&user.ID, &user.Username, &user.Email, &user.Verified, &user.Password, &user.Role, &user.CreatedAt, &user.DisabledAt, &user.PasswordResetRequired)
+}
+
 func (q *userRepository) GetUser(ctx context.Context, id string) (*domain.User, error) {
 	if id == "" {
 		log.Println("User ID is required")
 		return nil, nil
 	}
-	query := "SELECT * FROM users WHERE id = $1"
+	query := "SELECT " + userColumns + " FROM users WHERE id = $1"
 
 	var user domain.User
 	row := q.db.QueryRow(ctx, query, id)
 
-	if err := row.Scan(&user.ID, &user.Username, &user.Password, &user.CreatedAt); err != nil {
+	if err := scanUser(row, &user); err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
 			return nil, fmt.Errorf("user not found: %w", err)
 		}
@@ -47,7 +78,7 @@ func (q *userRepository) GetUser(ctx context.Context, id string) (*domain.User,
 }
 
 func (q *userRepository) GetAllUsers(ctx context.Context) ([]*domain.User, error) {
-	query := "SELECT * FROM users"
+	query := "SELECT " + userColumns + " FROM users"
 	rows, err := q.db.Query(ctx, query)
 	if err != nil {
 		return nil, err
@@ -57,7 +88,7 @@ func (q *userRepository) GetAllUsers(ctx context.Context) ([]*domain.User, error
 	var users []*domain.User
 	for rows.Next() {
 		var user domain.User
-		if err := rows.Scan(&user.ID, &user.Username, &user.Password, &user.CreatedAt); err != nil {
+		if err := scanUser(rows, &user); err != nil {
 			return nil, err
 		}
 		users = append(users, &user)
@@ -67,23 +98,394 @@ func (q *userRepository) GetAllUsers(ctx context.Context) ([]*domain.User, error
 }
 
 func (q *userRepository) CreateUser(ctx context.Context, user *domain.User) (*domain.User, error) {
-	query := "INSERT INTO users (id, username, password) VALUES ($1, $2, $3) RETURNING id"
-	row := q.db.QueryRow(ctx, query, user.ID, user.Username, user.Password)
+	if user.Role == "" {
+		user.Role = domain.UserRoleAuthenticated
+	}
+
+	query := "INSERT INTO users (id, username, email, verified, password, role) VALUES ($1, $2, NULLIF($3, ''), $4, $5, $6) RETURNING id"
+	row := q.db.QueryRow(ctx, query, user.ID, user.Username, user.Email, user.Verified, user.Password, user.Role)
 
 	if err := row.Scan(&user.ID); err != nil {
-		return nil, err
+		return nil, duplicateError(err)
 	}
 
 	return user, nil
 }
 
 func (q *userRepository) UpdateUser(ctx context.Context, user *domain.User) (*domain.User, error) {
-	query := "UPDATE users SET username = $2, password = $3 WHERE id = $1 RETURNING id, username, password"
-	row := q.db.QueryRow(ctx, query, user.ID, user.Username, user.Password)
+	query := "UPDATE users SET username = $2, email = NULLIF($3, ''), verified = $4, password = $5, role = $6 WHERE id = $1 RETURNING " + userColumns
+	row := q.db.QueryRow(ctx, query, user.ID, user.Username, user.Email, user.Verified, user.Password, user.Role)
 
-	if err := row.Scan(&user.ID, &user.Username, &user.Password); err != nil {
-		return nil, err
+	if err := scanUser(row, user); err != nil {
+		return nil, duplicateError(err)
 	}
 
 	return user, nil
 }
+
+// GetUserByUsername returns the user with a username, or nil if there is none
+func (q *userRepository) GetUserByUsername(ctx context.Context, username string) (*domain.User, error) {
+	query := "SELECT " + userColumns + " FROM users WHERE username = $1"
+
+	var user domain.User
+	row := q.db.QueryRow(ctx, query, username)
+
+	if err := scanUser(row, &user); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+// CreateUserFromGuest creates a user and merges a guest into it in one
+// transaction, so a failed merge leaves no account behind. It returns nil if
+// the guest no longer exists.
+func (q *userRepository) CreateUserFromGuest(ctx context.Context, user *domain.User, guestID string) (*domain.User, error) {
+	if user.Role == "" {
+		user.Role = domain.UserRoleAuthenticated
+	}
+
+	tx, err := q.db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	query := "INSERT INTO users (id, username, email, verified, password, role) VALUES ($1, $2, NULLIF($3, ''), $4, $5, $6) RETURNING id"
+	row := tx.QueryRow(ctx, query, user.ID, user.Username, user.Email, user.Verified, user.Password, user.Role)
+	if err := row.Scan(&user.ID); err != nil {
+		return nil, duplicateError(err)
+	}
+
+	merged, err := mergeGuest(ctx, tx, guestID, user.ID)
+	if err != nil || !merged {
+		return nil, err
+	}
+
+	return user, tx.Commit(ctx)
+}
+
+// GuestExists reports whether a guest account is still there, or has been
+// swept or merged into a real account
+func (q *userRepository) GuestExists(ctx context.Context, id string) (bool, error) {
+	var exists bool
+	query := "SELECT EXISTS (SELECT 1 FROM users WHERE id = $1 AND role = $2)"
+	if err := q.db.QueryRow(ctx, query, id, domain.UserRoleGuest).Scan(&exists); err != nil {
+		return false, err
+	}
+
+	return exists, nil
+}
+
+// MergeGuest moves everything a guest owns, has been granted or has written
+// to another user and then deletes the guest, all in one transaction.
+// Grants the user already has, or no longer needs because they now own the
+// document, are not overwritten. It reports false if the guest no longer exists.
+func (q *userRepository) MergeGuest(ctx context.Context, guestID, userID string) (bool, error) {
+	tx, err := q.db.Begin(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback(ctx)
+
+	merged, err := mergeGuest(ctx, tx, guestID, userID)
+	if err != nil || !merged {
+		return false, err
+	}
+
+	return true, tx.Commit(ctx)
+}
+
+func mergeGuest(ctx context.Context, tx pgx.Tx, guestID, userID string) (bool, error) {
+	var role string
+	if err := tx.QueryRow(ctx, "SELECT role FROM users WHERE id = $1 FOR UPDATE", guestID).Scan(&role); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return false, nil
+		}
+		return false, err
+	}
+	if role != domain.UserRoleGuest {
+		return false, fmt.Errorf("user %s is not a guest", guestID)
+	}
+
+	statements := []string{
+		"UPDATE docs SET owner_id = $2 WHERE owner_id = $1",
+		`INSERT INTO document_permissions (document_id, user_id, role, granted_by, created_at, updated_at)
+			SELECT p.document_id, $2::uuid, p.role, p.granted_by, p.created_at, p.updated_at
+			FROM document_permissions p JOIN docs d ON d.id = p.document_id
+			WHERE p.user_id = $1::uuid AND d.owner_id IS DISTINCT FROM $2::uuid
+			ON CONFLICT (document_id, user_id) DO NOTHING`,
+		"DELETE FROM document_permissions WHERE user_id = $1",
+		"DELETE FROM document_permissions p USING docs d WHERE d.id = p.document_id AND d.owner_id = $2 AND p.user_id = $2",
+		"UPDATE document_revisions SET author_id = $2 WHERE author_id = $1",
+		"DELETE FROM users WHERE id = $1",
+	}
+	for _, statement := range statements {
+		if _, err := tx.Exec(ctx, statement, guestID, userID); err != nil {
+			return false, err
+		}
+	}
+
+	return true, nil
+}
+
+// DeleteExpiredGuests deletes guests created before a cutoff. Their documents,
+// with the documents' history, and the grants they were given go with them
+// through ON DELETE CASCADE.
+func (q *userRepository) DeleteExpiredGuests(ctx context.Context, createdBefore time.Time) (int64, error) {
+	query := "DELETE FROM users WHERE role = $1 AND created_at < $2"
+	tag, err := q.db.Exec(ctx, query, domain.UserRoleGuest, createdBefore)
+	if err != nil {
+		return 0, err
+	}
+
+	return tag.RowsAffected(), nil
+}
+
+// SearchUsers returns a page of users whose username contains search, newest
+// first, and the number of matching users in total
+func (q *userRepository) SearchUsers(ctx context.Context, search string, limit, offset int) ([]*domain.User, int, error) {
+	pattern := "%" + likeEscaper.Replace(search) + "%"
+
+	var total int
+	if err := q.db.QueryRow(ctx, "SELECT COUNT(*) FROM users WHERE username ILIKE $1", pattern).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	query := "SELECT " + userColumns + " FROM users WHERE username ILIKE $1 ORDER BY created_at DESC, id LIMIT $2 OFFSET $3"
+	rows, err := q.db.Query(ctx, query, pattern, limit, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	var users []*domain.User
+	for rows.Next() {
+		var user domain.User
+		if err := scanUser(rows, &user); err != nil {
+			return nil, 0, err
+		}
+		users = append(users, &user)
+	}
+
+	return users, total, rows.Err()
+}
+
+// ListUsers returns a page of every user except guests, oldest first so that
+// pages stay stable as users are added, and the number of such users in total
+func (q *userRepository) ListUsers(ctx context.Context, limit, offset int) ([]*domain.User, int, error) {
+	var total int
+	if err := q.db.QueryRow(ctx, "SELECT COUNT(*) FROM users WHERE role <> $1", domain.UserRoleGuest).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	query := "SELECT " + userColumns + " FROM users WHERE role <> $1 ORDER BY created_at, id LIMIT $2 OFFSET $3"
+	rows, err := q.db.Query(ctx, query, domain.UserRoleGuest, limit, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	var users []*domain.User
+	for rows.Next() {
+		var user domain.User
+		if err := scanUser(rows, &user); err != nil {
+			return nil, 0, err
+		}
+		users = append(users, &user)
+	}
+
+	return users, total, rows.Err()
+}
+
+// GetUsersByUsernameFold returns the users, other than guests, whose username
+// is username in any case. Usernames are unique only as written, so there may be several.
+func (q *userRepository) GetUsersByUsernameFold(ctx context.Context, username string) ([]*domain.User, error) {
+	query := "SELECT " + userColumns + " FROM users WHERE LOWER(username) = LOWER($1) AND role <> $2 ORDER BY created_at, id"
+	rows, err := q.db.Query(ctx, query, username, domain.UserRoleGuest)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []*domain.User
+	for rows.Next() {
+		var user domain.User
+		if err := scanUser(rows, &user); err != nil {
+			return nil, err
+		}
+		users = append(users, &user)
+	}
+
+	return users, rows.Err()
+}
+
+// duplicateError turns the unique violation of a username or email address
+// that another request took first into ErrDuplicateUsername or ErrDuplicateEmail
+func duplicateError(err error) error {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) || pgErr.Code != "23505" {
+		return err
+	}
+	switch pgErr.ConstraintName {
+	case "users_username_key":
+		return ErrDuplicateUsername
+	case "idx_users_email":
+		return ErrDuplicateEmail
+	}
+	return err
+}
+
+// likeEscaper makes user input match literally inside a LIKE pattern
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+func (q *userRepository) SetDisabled(ctx context.Context, id string, disabled bool) error {
+	query := "UPDATE users SET disabled_at = CASE WHEN $2 THEN COALESCE(disabled_at, CURRENT_TIMESTAMP) END WHERE id = $1"
+	_, err := q.db.Exec(ctx, query, id, disabled)
+	return err
+}
+
+func (q *userRepository) SetPasswordResetRequired(ctx context.Context, id string, required bool) error {
+	query := "UPDATE users SET password_reset_required = $2 WHERE id = $1"
+	_, err := q.db.Exec(ctx, query, id, required)
+	return err
+}
+
+// GetUserByEmail returns the user with an email address, ignoring case, or nil if there is none
+func (q *userRepository) GetUserByEmail(ctx context.Context, email string) (*domain.User, error) {
+	query := "SELECT " + userColumns + " FROM users WHERE LOWER(email) = LOWER($1)"
+
+	var user domain.User
+	row := q.db.QueryRow(ctx, query, email)
+
+	if err := scanUser(row, &user); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+// CreatePasswordResetToken stores a new reset token for a user, replacing any
+// the user has not used yet so only the latest email works
+func (q *userRepository) CreatePasswordResetToken(ctx context.Context, userID, tokenHash string, expiresAt time.Time) error {
+	tx, err := q.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, "DELETE FROM password_reset_tokens WHERE user_id = $1 AND used_at IS NULL", userID); err != nil {
+		return err
+	}
+	query := "INSERT INTO password_reset_tokens (token_hash, user_id, expires_at) VALUES ($1, $2, $3)"
+	if _, err := tx.Exec(ctx, query, tokenHash, userID, expiresAt); err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+// GetPasswordResetUser returns the user a reset token is for, or nil if the
+// token is unknown, used or expired
+func (q *userRepository) GetPasswordResetUser(ctx context.Context, tokenHash string) (*domain.User, error) {
+	query := "SELECT " + userColumns + " FROM users WHERE id = (SELECT user_id FROM password_reset_tokens WHERE token_hash = $1 AND used_at IS NULL AND expires_at > CURRENT_TIMESTAMP)"
+
+	var user domain.User
+	row := q.db.QueryRow(ctx, query, tokenHash)
+	if err := scanUser(row, &user); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+// ResetPassword uses up a reset token and sets the password of its user, who
+// no longer has to reset it. The user's personal access tokens are revoked, as
+// they may have been made by whoever knew the old password. It returns the
+// user's ID, or "" if the token is unknown, used or expired.
+func (q *userRepository) ResetPassword(ctx context.Context, tokenHash, password string) (string, error) {
+	tx, err := q.db.Begin(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback(ctx)
+
+	var userID string
+	query := "UPDATE password_reset_tokens SET used_at = CURRENT_TIMESTAMP WHERE token_hash = $1 AND used_at IS NULL AND expires_at > CURRENT_TIMESTAMP RETURNING user_id"
+	if err := tx.QueryRow(ctx, query, tokenHash).Scan(&userID); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return "", nil
+		}
+		return "", err
+	}
+
+	query = "UPDATE users SET password = $2, password_reset_required = FALSE WHERE id = $1"
+	if _, err := tx.Exec(ctx, query, userID, password); err != nil {
+		return "", err
+	}
+	query = "UPDATE personal_access_tokens SET revoked_at = CURRENT_TIMESTAMP WHERE user_id = $1 AND revoked_at IS NULL"
+	if _, err := tx.Exec(ctx, query, userID); err != nil {
+		return "", err
+	}
+
+	return userID, tx.Commit(ctx)
+}
+
+// CreateVerificationToken stores a token confirming that a user owns an email
+// address, replacing any earlier unused one
+func (q *userRepository) CreateVerificationToken(ctx context.Context, userID, email, tokenHash string, expiresAt time.Time) error {
+	tx, err := q.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, "DELETE FROM email_verification_tokens WHERE user_id = $1 AND used_at IS NULL", userID); err != nil {
+		return err
+	}
+	query := "INSERT INTO email_verification_tokens (token_hash, user_id, email, expires_at) VALUES ($1, $2, $3, $4)"
+	if _, err := tx.Exec(ctx, query, tokenHash, userID, email, expiresAt); err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+// VerifyEmail uses up a verification token and marks its user verified, as
+// long as the user's address is still the one the token was sent to. It
+// returns the user's ID, or "" if the token is unknown, used, expired or stale.
+func (q *userRepository) VerifyEmail(ctx context.Context, tokenHash string) (string, error) {
+	tx, err := q.db.Begin(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback(ctx)
+
+	var userID, email string
+	query := "UPDATE email_verification_tokens SET used_at = CURRENT_TIMESTAMP WHERE token_hash = $1 AND used_at IS NULL AND expires_at > CURRENT_TIMESTAMP RETURNING user_id, email"
+	if err := tx.QueryRow(ctx, query, tokenHash).Scan(&userID, &email); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return "", nil
+		}
+		return "", err
+	}
+
+	tag, err := tx.Exec(ctx, "UPDATE users SET verified = TRUE WHERE id = $1 AND LOWER(email) = LOWER($2)", userID, email)
+	if err != nil {
+		return "", err
+	}
+	if tag.RowsAffected() == 0 {
+		return "", nil
+	}
+
+	return userID, tx.Commit(ctx)
+}
@@ -0,0 +1,141 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"rtdocs/model/domain"
+	"rtdocs/model/web"
+
+	"github.com/jackc/pgx/v4"
+)
+
+const (
+	defaultPageSize = 50
+	maxPageSize     = 200
+)
+
+var (
+	ErrUserNotFound     = errors.New("user not found")
+	ErrDocumentNotFound = errors.New("document not found")
+	ErrSelfModeration   = errors.New("admins cannot disable or reset their own account")
+)
+
+// AdminService is what the admin console can do to any user or document,
+// regardless of document permissions
+type AdminService interface {
+	SearchUsers(ctx context.Context, search string, limit, offset int) (*web.UserPage, error)
+	DisableUser(ctx context.Context, adminID, userID string) error
+	EnableUser(ctx context.Context, userID string) error
+	ForcePasswordReset(ctx context.Context, adminID, userID string) error
+	TransferDocuments(ctx context.Context, fromUserID, toUserID string) (int64, error)
+	GetDocument(ctx context.Context, documentID string) (*domain.Document, error)
+	DeleteDocument(ctx context.Context, documentID string) error
+}
+
+type adminService struct {
+	userService UserService
+	docService  DocumentService
+	revocations RevocationService
+}
+
+func NewAdminService(userService UserService, docService DocumentService, revocations RevocationService) AdminService {
+	return &adminService{userService: userService, docService: docService, revocations: revocations}
+}
+
+// SearchUsers returns a page of users; limit defaults to 50 and is capped at 200
+func (s *adminService) SearchUsers(ctx context.Context, search string, limit, offset int) (*web.UserPage, error) {
+	if limit <= 0 {
+		limit = defaultPageSize
+	}
+	limit = min(limit, maxPageSize)
+	offset = max(offset, 0)
+
+	users, total, err := s.userService.SearchUsers(ctx, search, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	if users == nil {
+		users = []*domain.User{}
+	}
+
+	return &web.UserPage{Users: users, Total: total, Limit: limit, Offset: offset}, nil
+}
+
+// DisableUser stops a user logging in and logs them out everywhere
+func (s *adminService) DisableUser(ctx context.Context, adminID, userID string) error {
+	if adminID == userID {
+		return ErrSelfModeration
+	}
+	if err := s.requireUser(ctx, userID); err != nil {
+		return err
+	}
+
+	if err := s.userService.SetDisabled(ctx, userID, true); err != nil {
+		return err
+	}
+	return s.revocations.RevokeAll(ctx, userID)
+}
+
+func (s *adminService) EnableUser(ctx context.Context, userID string) error {
+	if err := s.requireUser(ctx, userID); err != nil {
+		return err
+	}
+
+	return s.userService.SetDisabled(ctx, userID, false)
+}
+
+// ForcePasswordReset logs a user out everywhere and stops them logging in, or
+// using their personal access tokens, until they have reset their password.
+// The reset then revokes the tokens for good.
+func (s *adminService) ForcePasswordReset(ctx context.Context, adminID, userID string) error {
+	if adminID == userID {
+		return ErrSelfModeration
+	}
+	if err := s.requireUser(ctx, userID); err != nil {
+		return err
+	}
+
+	if err := s.userService.RequirePasswordReset(ctx, userID); err != nil {
+		return err
+	}
+	return s.revocations.RevokeAll(ctx, userID)
+}
+
+// TransferDocuments gives every document owned by one user to another
+func (s *adminService) TransferDocuments(ctx context.Context, fromUserID, toUserID string) (int64, error) {
+	if err := s.requireUser(ctx, fromUserID); err != nil {
+		return 0, err
+	}
+	if err := s.requireUser(ctx, toUserID); err != nil {
+		return 0, err
+	}
+
+	return s.docService.TransferDocuments(ctx, fromUserID, toUserID)
+}
+
+func (s *adminService) GetDocument(ctx context.Context, documentID string) (*domain.Document, error) {
+	document, err := s.docService.GetDocument(ctx, documentID)
+	if errors.Is(err, pgx.ErrNoRows) || err == nil && document == nil {
+		return nil, ErrDocumentNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return document, nil
+}
+
+func (s *adminService) DeleteDocument(ctx context.Context, documentID string) error {
+	if _, err := s.GetDocument(ctx, documentID); err != nil {
+		return err
+	}
+
+	return s.docService.DeleteDocument(ctx, documentID)
+}
+
+func (s *adminService) requireUser(ctx context.Context, userID string) error {
+	user, err := s.userService.GetUser(ctx, userID)
+	if errors.Is(err, pgx.ErrNoRows) || err == nil && user == nil {
+		return ErrUserNotFound
+	}
+	return err
+}
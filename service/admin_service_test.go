@@ -0,0 +1,217 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"rtdocs/model/domain"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+)
+
+// moderatedUsers records what the admin console did to each user
+type moderatedUsers struct {
+	UserService
+	users    map[string]bool
+	err      error
+	disabled map[string]bool
+	reset    map[string]bool
+	search   [3]interface{}
+}
+
+func newModeratedUsers(ids ...string) *moderatedUsers {
+	m := &moderatedUsers{users: make(map[string]bool), disabled: make(map[string]bool), reset: make(map[string]bool)}
+	for _, id := range ids {
+		m.users[id] = true
+	}
+	return m
+}
+
+// GetUser fails for unknown users the way the repository does
+func (m *moderatedUsers) GetUser(ctx context.Context, id string) (*domain.User, error) {
+	if m.err != nil {
+		return nil, m.err
+	}
+	if !m.users[id] {
+		return nil, fmt.Errorf("user not found: %w", pgx.ErrNoRows)
+	}
+	return &domain.User{ID: id}, nil
+}
+
+func (m *moderatedUsers) SearchUsers(ctx context.Context, search string, limit, offset int) ([]*domain.User, int, error) {
+	m.search = [3]interface{}{search, limit, offset}
+	return nil, 0, m.err
+}
+
+func (m *moderatedUsers) SetDisabled(ctx context.Context, id string, disabled bool) error {
+	m.disabled[id] = disabled
+	return nil
+}
+
+func (m *moderatedUsers) RequirePasswordReset(ctx context.Context, id string) error {
+	m.reset[id] = true
+	return nil
+}
+
+type ownedDocuments struct {
+	storedDocuments
+	err         error
+	deleted     []string
+	transferred [2]string
+}
+
+func (o *ownedDocuments) GetDocument(ctx context.Context, id string) (*domain.Document, error) {
+	if o.err != nil {
+		return nil, o.err
+	}
+	return o.storedDocuments.GetDocument(ctx, id)
+}
+
+func (o *ownedDocuments) DeleteDocument(ctx context.Context, id string) error {
+	o.deleted = append(o.deleted, id)
+	return nil
+}
+
+func (o *ownedDocuments) TransferDocuments(ctx context.Context, fromUserID, toUserID string) (int64, error) {
+	o.transferred = [2]string{fromUserID, toUserID}
+	return 3, nil
+}
+
+func newAdminTestService(users *moderatedUsers, docs *ownedDocuments) (AdminService, *memorySessions) {
+	sessions := newMemorySessions()
+	sessions.CreateSession(context.Background(), &domain.Session{ID: "bob-phone", UserID: "bob", ExpiresAt: time.Now().Add(time.Hour)})
+	return NewAdminService(users, docs, NewRevocationService(sessions)), sessions
+}
+
+func TestSearchUsersPage(t *testing.T) {
+	tests := []struct {
+		name                  string
+		limit, offset         int
+		wantLimit, wantOffset int
+	}{
+		{"defaults", 0, 0, defaultPageSize, 0},
+		{"within bounds", 10, 20, 10, 20},
+		{"too large", 1000, 0, maxPageSize, 0},
+		{"negative", -5, -10, defaultPageSize, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			users := newModeratedUsers()
+			admin, _ := newAdminTestService(users, &ownedDocuments{})
+
+			page, err := admin.SearchUsers(context.Background(), "ali", tt.limit, tt.offset)
+			if err != nil {
+				t.Fatalf("SearchUsers: %v", err)
+			}
+			if page.Limit != tt.wantLimit || page.Offset != tt.wantOffset || page.Users == nil {
+				t.Fatalf("page = %+v, want limit %d offset %d and no nil users", page, tt.wantLimit, tt.wantOffset)
+			}
+			if want := [3]interface{}{"ali", tt.wantLimit, tt.wantOffset}; users.search != want {
+				t.Fatalf("searched %v, want %v", users.search, want)
+			}
+		})
+	}
+}
+
+func TestModerateUser(t *testing.T) {
+	ctx := context.Background()
+	failure := errors.New("connection refused")
+
+	tests := []struct {
+		name      string
+		moderate  func(AdminService) error
+		usersErr  error
+		want      error
+		disabled  bool
+		reset     bool
+		loggedOut bool
+	}{
+		{"disable", func(a AdminService) error { return a.DisableUser(ctx, "admin", "bob") }, nil, nil, true, false, true},
+		{"disable self", func(a AdminService) error { return a.DisableUser(ctx, "admin", "admin") }, nil, ErrSelfModeration, false, false, false},
+		{"disable unknown", func(a AdminService) error { return a.DisableUser(ctx, "admin", "nobody") }, nil, ErrUserNotFound, false, false, false},
+		{"disable failing", func(a AdminService) error { return a.DisableUser(ctx, "admin", "bob") }, failure, failure, false, false, false},
+		{"enable unknown", func(a AdminService) error { return a.EnableUser(ctx, "nobody") }, nil, ErrUserNotFound, false, false, false},
+		{"reset", func(a AdminService) error { return a.ForcePasswordReset(ctx, "admin", "bob") }, nil, nil, false, true, true},
+		{"reset self", func(a AdminService) error { return a.ForcePasswordReset(ctx, "admin", "admin") }, nil, ErrSelfModeration, false, false, false},
+		{"reset unknown", func(a AdminService) error { return a.ForcePasswordReset(ctx, "admin", "nobody") }, nil, ErrUserNotFound, false, false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			users := newModeratedUsers("admin", "bob")
+			users.err = tt.usersErr
+			admin, sessions := newAdminTestService(users, &ownedDocuments{})
+
+			if err := tt.moderate(admin); !errors.Is(err, tt.want) {
+				t.Fatalf("err = %v, want %v", err, tt.want)
+			}
+			if users.disabled["bob"] != tt.disabled || users.reset["bob"] != tt.reset {
+				t.Fatalf("disabled = %v, reset = %v; want %v, %v", users.disabled["bob"], users.reset["bob"], tt.disabled, tt.reset)
+			}
+			if loggedOut := sessions.revoked("bob-phone"); loggedOut != tt.loggedOut {
+				t.Fatalf("logged out = %v, want %v", loggedOut, tt.loggedOut)
+			}
+		})
+	}
+
+	users := newModeratedUsers("bob")
+	users.disabled["bob"] = true
+	admin, _ := newAdminTestService(users, &ownedDocuments{})
+	if err := admin.EnableUser(ctx, "bob"); err != nil || users.disabled["bob"] {
+		t.Fatalf("EnableUser = %v, disabled = %v; want enabled", err, users.disabled["bob"])
+	}
+}
+
+func TestTransferDocuments(t *testing.T) {
+	ctx := context.Background()
+	docs := &ownedDocuments{}
+	admin, _ := newAdminTestService(newModeratedUsers("alice", "bob"), docs)
+
+	if _, err := admin.TransferDocuments(ctx, "alice", "nobody"); !errors.Is(err, ErrUserNotFound) {
+		t.Fatalf("TransferDocuments to an unknown user = %v, want ErrUserNotFound", err)
+	}
+	if docs.transferred != [2]string{} {
+		t.Fatalf("transferred %v to an unknown user", docs.transferred)
+	}
+
+	transferred, err := admin.TransferDocuments(ctx, "alice", "bob")
+	if err != nil || transferred != 3 || docs.transferred != [2]string{"alice", "bob"} {
+		t.Fatalf("TransferDocuments = %d, %v, moved %v; want 3 from alice to bob", transferred, err, docs.transferred)
+	}
+}
+
+func TestModerateDocument(t *testing.T) {
+	ctx := context.Background()
+	failure := errors.New("connection refused")
+
+	tests := []struct {
+		name string
+		id   string
+		err  error
+		want error
+	}{
+		{"stored", "doc", nil, nil},
+		{"unknown", "missing", nil, ErrDocumentNotFound},
+		{"failing", "doc", failure, failure},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			docs := &ownedDocuments{storedDocuments: storedDocuments{documents: map[string]*domain.Document{"doc": {ID: "doc", OwnerID: "alice"}}}, err: tt.err}
+			admin, _ := newAdminTestService(newModeratedUsers(), docs)
+
+			if _, err := admin.GetDocument(ctx, tt.id); !errors.Is(err, tt.want) {
+				t.Fatalf("GetDocument = %v, want %v", err, tt.want)
+			}
+			if err := admin.DeleteDocument(ctx, tt.id); !errors.Is(err, tt.want) {
+				t.Fatalf("DeleteDocument = %v, want %v", err, tt.want)
+			}
+			if deleted := len(docs.deleted) > 0; deleted != (tt.want == nil) {
+				t.Fatalf("deleted %v", docs.deleted)
+			}
+		})
+	}
+}
@@ -2,11 +2,22 @@ package service
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
 	"errors"
+	"net/mail"
+	"net/url"
+	"rtdocs/mailer"
 	"rtdocs/model/domain"
 	"rtdocs/model/web"
+	"rtdocs/oidc"
 	"rtdocs/repository"
 	"rtdocs/utils"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/google/uuid"
@@ -15,32 +26,131 @@ import (
 
 type AuthService interface {
 	Register(ctx context.Context, req *web.RegisterRequest) (*web.RegisterResponse, error)
-	GuestRegister(ctx context.Context, req *web.RegisterRequest) (*web.RegisterResponse, error)
+	IssueGuest(ctx context.Context) (string, error)
+	GuestExists(ctx context.Context, guestID string) (bool, error)
 	Login(ctx context.Context, req *web.LoginRequest) (*web.LoginResponse, error)
+	LoginMFA(ctx context.Context, req *web.MFALoginRequest) (*web.LoginResponse, error)
+	StartOIDCLogin(ctx context.Context) (string, string, error)
+	OIDCCallback(ctx context.Context, req *web.OIDCCallbackRequest) (*web.LoginResponse, error)
 	Logout(ctx context.Context, accessToken string) error
+	LogoutAll(ctx context.Context, userID string) error
+	GetSessions(ctx context.Context, userID string) ([]*domain.Session, error)
+	RevokeSession(ctx context.Context, userID, sessionID string) error
+	Refresh(ctx context.Context, refreshToken string) (*web.LoginResponse, error)
+	JWKS() utils.JWKSet
+	ForgotPassword(ctx context.Context, email string) error
+	ResetPassword(ctx context.Context, token, password string) error
+	VerifyEmail(ctx context.Context, token string) error
+	ResendVerification(ctx context.Context, userID string) error
 }
 
+const (
+	// defaultAppURL is used when APP_URL, the address of the web app that links in emails point to, is unset
+	defaultAppURL = "http://localhost:3000"
+
+	passwordResetDuration     = time.Hour
+	emailVerificationDuration = 7 * 24 * time.Hour
+	mailTimeout               = 30 * time.Second
+	// OIDCLoginDuration is how long a user has to sign in at the identity provider
+	OIDCLoginDuration = 10 * time.Minute
+)
+
+var (
+	ErrMissingCredentials    = errors.New("username and password are required")
+	ErrInvalidCredentials    = errors.New("invalid username or password")
+	ErrInvalidGuestToken     = errors.New("invalid guest token")
+	ErrAccountDisabled       = errors.New("this account has been disabled")
+	ErrPasswordResetRequired = errors.New("you must reset your password before logging in")
+	ErrInvalidResetToken     = errors.New("this password reset link is invalid or has expired")
+	ErrInvalidEmail          = errors.New("a valid email address is required")
+	ErrEmailTaken            = errors.New("an account with this email address already exists")
+	ErrInvalidVerifyToken    = errors.New("this verification link is invalid or has expired")
+	ErrAlreadyVerified       = errors.New("this email address has already been verified")
+	ErrInvalidMFAToken       = errors.New("this login has expired; please sign in again")
+	ErrOIDCDisabled          = errors.New("single sign-on is not configured")
+	ErrInvalidOIDCState      = errors.New("this sign-in has expired; please try again")
+	ErrOIDCLoginFailed       = errors.New("sign-in with the identity provider failed")
+	ErrInvalidAccessToken    = errors.New("invalid access token")
+	ErrInvalidRefreshToken   = errors.New("invalid refresh token")
+	ErrRefreshTokenReused    = errors.New("refresh token has already been used; the session has been revoked")
+	ErrSessionNotFound       = errors.New("session not found")
+)
+
 type authService struct {
-	userRepo repository.UserRepository
-	tokenGen utils.TokenGenerator
+	userRepo     repository.UserRepository
+	sessionRepo  repository.SessionRepository
+	identityRepo repository.IdentityRepository
+	revocations  RevocationService
+	mfa          MFAService
+	throttle     LoginThrottle
+	passwords    PasswordPolicy
+	backends     []CredentialBackend
+	provisioner  *accountProvisioner
+	tokenGen     utils.TokenGenerator
+	mailer       mailer.Mailer
+	oidc         *oidc.Provider // nil when single sign-on is off
+	appURL       string
 }
 
-func NewAuthService(userRepo repository.UserRepository, tokenGen utils.TokenGenerator) AuthService {
+func NewAuthService(userRepo repository.UserRepository, sessionRepo repository.SessionRepository, identityRepo repository.IdentityRepository, revocations RevocationService, mfa MFAService, throttle LoginThrottle, passwords PasswordPolicy, backends []CredentialBackend, tokenGen utils.TokenGenerator, mail mailer.Mailer, oidcProvider *oidc.Provider) AuthService {
 	return &authService{
-		userRepo: userRepo,
-		tokenGen: tokenGen,
+		userRepo:     userRepo,
+		sessionRepo:  sessionRepo,
+		identityRepo: identityRepo,
+		revocations:  revocations,
+		mfa:          mfa,
+		throttle:     throttle,
+		passwords:    passwords,
+		backends:     backends,
+		provisioner:  newAccountProvisioner(userRepo, identityRepo),
+		tokenGen:     tokenGen,
+		mailer:       mail,
+		oidc:         oidcProvider,
+		appURL:       AppURL(),
+	}
+}
+
+// AppURL returns the address of the web app, from APP_URL, without a trailing slash
+func AppURL() string {
+	appURL := strings.TrimSuffix(utils.GetEnv("APP_URL"), "/")
+	if appURL == "" {
+		appURL = defaultAppURL
 	}
+	return appURL
 }
 
+// Register creates a user and emails them a link to verify their address.
+// With a guest token, the guest's documents, permissions and history are
+// moved to the new account.
 func (s *authService) Register(ctx context.Context, req *web.RegisterRequest) (*web.RegisterResponse, error) {
 	if req.Username == "" || req.Password == "" {
 		return nil, errors.New("username and password are required")
 	}
+	if !validEmail(req.Email) {
+		return nil, ErrInvalidEmail
+	}
+	if err := s.passwords.Validate(req.Password, req.Username); err != nil {
+		return nil, err
+	}
+
+	existing, err := s.userRepo.GetUserByEmail(ctx, req.Email)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil {
+		return nil, ErrEmailTaken
+	}
+
+	guest, err := s.verifyGuest(ctx, req.GuestToken)
+	if err != nil {
+		return nil, err
+	}
 
 	user := &domain.User{
 		ID:        uuid.New().String(),
 		Username:  req.Username,
-		Role:      "user",
+		Email:     req.Email,
+		Role:      domain.UserRoleAuthenticated,
 		CreatedAt: time.Now(),
 	}
 
@@ -50,12 +160,32 @@ func (s *authService) Register(ctx context.Context, req *web.RegisterRequest) (*
 	}
 	user.Password = string(hashedPassword)
 
-	createdUser, err := s.userRepo.CreateUser(ctx, user)
+	var createdUser *domain.User
+	if guest != nil {
+		createdUser, err = s.userRepo.CreateUserFromGuest(ctx, user, guest.UserID)
+		if err == nil && createdUser == nil {
+			// The guest was swept, or merged by another login, after it was checked
+			err = ErrInvalidGuestToken
+		}
+	} else {
+		createdUser, err = s.userRepo.CreateUser(ctx, user)
+	}
+	// Another registration may have taken the email address since it was checked
+	if errors.Is(err, repository.ErrDuplicateEmail) {
+		return nil, ErrEmailTaken
+	}
+	if errors.Is(err, repository.ErrDuplicateUsername) {
+		return nil, ErrUsernameTaken
+	}
 	if err != nil {
 		return nil, err
 	}
 
-	token, err := s.tokenGen.GenerateToken(user.ID, user.Username)
+	if err := s.sendVerification(ctx, createdUser); err != nil {
+		return nil, err
+	}
+
+	token, err := s.issueTokens(ctx, user, req.Client)
 	if err != nil {
 		return nil, err
 	}
@@ -67,44 +197,255 @@ func (s *authService) Register(ctx context.Context, req *web.RegisterRequest) (*
 	}, nil
 }
 
-func (s *authService) GuestRegister(ctx context.Context, req *web.RegisterRequest) (*web.RegisterResponse, error) {
-	var guestUser domain.User
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+// IssueGuest creates a guest account and returns its token. Guests have no
+// password: they can only use their token, and the local backend refuses them.
+func (s *authService) IssueGuest(ctx context.Context) (string, error) {
+	guest := &domain.User{
+		ID:        uuid.New().String(),
+		Role:      domain.UserRoleGuest,
+		CreatedAt: time.Now(),
+	}
+	guest.Username = "guest" + guest.ID
+
+	if _, err := s.userRepo.CreateUser(ctx, guest); err != nil {
+		return "", err
+	}
+	return s.tokenGen.GenerateGuestToken(guest.ID, "guest")
+}
+
+// GuestExists reports whether a guest's account is still there. A guest token
+// outlives its account once the account has been swept or merged into a real one.
+func (s *authService) GuestExists(ctx context.Context, guestID string) (bool, error) {
+	return s.userRepo.GuestExists(ctx, guestID)
+}
+
+// Login authenticates a user with the configured credential backends. With a
+// guest token, the guest's documents, permissions and history are moved to the
+// user's account. If the user has two-factor authentication on, only an MFA
+// token is returned, and the login is finished by LoginMFA. Repeated failures
+// are throttled, and an unknown username fails exactly like a wrong password.
+func (s *authService) Login(ctx context.Context, req *web.LoginRequest) (*web.LoginResponse, error) {
+	if req.Username == "" || req.Password == "" {
+		return nil, ErrMissingCredentials
+	}
+
+	if err := s.throttle.Check(ctx, req.Username, req.Client); err != nil {
+		return nil, err
+	}
+
+	guest, err := s.verifyGuest(ctx, req.GuestToken)
 	if err != nil {
 		return nil, err
 	}
 
-	guestUser.ID = uuid.New().String()
-	guestUser.Username = "guest" + guestUser.ID
-	guestUser.Password = string(hashedPassword)
-	guestUser.Role = "guest"
-	guestUser.CreatedAt = time.Now()
+	user, err := s.authenticate(ctx, req.Username, req.Password, req.Client)
+	if err != nil {
+		return nil, err
+	}
+	if user.DisabledAt != nil {
+		s.throttle.RecordFailure(ctx, req.Username, user.ID, req.Client, domain.LoginFailureAccountDisabled)
+		return nil, ErrAccountDisabled
+	}
+	if user.PasswordResetRequired {
+		s.throttle.Forgive(ctx, req.Username, req.Client)
+		return nil, ErrPasswordResetRequired
+	}
 
-	createdGuest, err := s.userRepo.CreateUser(ctx, &guestUser)
+	mfaEnabled, err := s.mfa.IsEnabled(ctx, user.ID)
 	if err != nil {
 		return nil, err
 	}
+	if mfaEnabled {
+		mfaToken, err := s.tokenGen.GenerateMFAToken(user.ID, user.Username)
+		if err != nil {
+			return nil, err
+		}
+		// The password was right; LoginMFA counts the attempt at the code
+		s.throttle.Forgive(ctx, req.Username, req.Client)
+		return &web.LoginResponse{MFARequired: true, MFAToken: mfaToken}, nil
+	}
 
-	return &web.RegisterResponse{
-		UserID: createdGuest.ID,
-	}, nil
+	s.throttle.RecordSuccess(ctx, req.Username, user.ID, req.Client)
+	return s.completeLogin(ctx, user, guest, req.Client)
 }
 
-func (s *authService) Login(ctx context.Context, req *web.LoginRequest) (*web.LoginResponse, error) {
-	if req.Username == "" || req.Password == "" {
-		return nil, errors.New("username and password are required")
+// authenticate tries each credential backend in turn until one knows the
+// username, and records a failure if none accepts the password
+func (s *authService) authenticate(ctx context.Context, username, password string, client web.Client) (*domain.User, error) {
+	reason := domain.LoginFailureUnknownUser
+	for _, backend := range s.backends {
+		user, err := backend.Authenticate(ctx, username, password)
+		if err == nil {
+			return user, nil
+		}
+		if errors.Is(err, ErrInvalidCredentials) {
+			reason = domain.LoginFailureBadPassword
+			break
+		}
+		if !errors.Is(err, ErrUnknownUser) {
+			return nil, err
+		}
 	}
 
-	user, err := s.userRepo.GetUser(ctx, req.Username)
-	if err != nil || user == nil {
+	var userID string
+	if reason == domain.LoginFailureBadPassword {
+		user, err := s.userRepo.GetUserByUsername(ctx, username)
+		if err != nil {
+			return nil, err
+		}
+		if user != nil {
+			userID = user.ID
+		}
+	}
+	s.throttle.RecordFailure(ctx, username, userID, client, reason)
+	return nil, ErrInvalidCredentials
+}
+
+// LoginMFA finishes a login with the MFA token from Login and a code from the
+// user's authenticator app or one of their recovery codes. Each MFA token can
+// finish one login.
+func (s *authService) LoginMFA(ctx context.Context, req *web.MFALoginRequest) (*web.LoginResponse, error) {
+	principal, err := s.tokenGen.Verify(req.MFAToken, utils.TokenTypeMFA)
+	if err != nil {
+		return nil, ErrInvalidMFAToken
+	}
+
+	guest, err := s.verifyGuest(ctx, req.GuestToken)
+	if err != nil {
 		return nil, err
 	}
 
-	if err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.Password)); err != nil {
+	// Codes are throttled like passwords, or the six digits could be guessed
+	if err := s.throttle.Check(ctx, principal.Username, req.Client); err != nil {
 		return nil, err
 	}
 
-	token, err := s.tokenGen.GenerateToken(user.ID, user.Username)
+	user, err := s.userRepo.GetUser(ctx, principal.UserID)
+	if err != nil {
+		return nil, err
+	}
+	// The account may have been locked in the minutes since the password was checked
+	if user.DisabledAt != nil {
+		return nil, ErrAccountDisabled
+	}
+	if user.PasswordResetRequired {
+		return nil, ErrPasswordResetRequired
+	}
+
+	if err := s.mfa.Verify(ctx, user.ID, req.Code); err != nil {
+		if errors.Is(err, ErrInvalidMFACode) {
+			s.throttle.RecordFailure(ctx, user.Username, user.ID, req.Client, domain.LoginFailureBadMFACode)
+		}
+		return nil, err
+	}
+
+	// The code was right; make sure this token has not already been exchanged
+	fresh, err := s.mfa.UseChallenge(ctx, principal)
+	if err != nil {
+		return nil, err
+	}
+	if !fresh {
+		return nil, ErrInvalidMFAToken
+	}
+
+	s.throttle.RecordSuccess(ctx, user.Username, user.ID, req.Client)
+	return s.completeLogin(ctx, user, guest, req.Client)
+}
+
+// StartOIDCLogin begins a sign-in with the identity provider and returns the
+// address to send the user to, and the state the browser must keep to finish
+// it. Binding the sign-in to the browser that started it keeps an attacker
+// from finishing their own sign-in in someone else's browser.
+func (s *authService) StartOIDCLogin(ctx context.Context) (string, string, error) {
+	if s.oidc == nil {
+		return "", "", ErrOIDCDisabled
+	}
+
+	var values [3]string
+	for i := range values {
+		value, err := oidc.GenerateVerifier()
+		if err != nil {
+			return "", "", err
+		}
+		values[i] = value
+	}
+	state, nonce, verifier := values[0], values[1], values[2]
+
+	if err := s.identityRepo.CreateOIDCLogin(ctx, hashToken(state), nonce, verifier, time.Now().Add(OIDCLoginDuration)); err != nil {
+		return "", "", err
+	}
+	authURL, err := s.oidc.AuthCodeURL(ctx, state, nonce, verifier)
+	if err != nil {
+		return "", "", err
+	}
+	return authURL, state, nil
+}
+
+// OIDCCallback finishes a sign-in with the identity provider. The external
+// identity is linked to the account with the same verified email address, or
+// to a new account, the first time it is used.
+func (s *authService) OIDCCallback(ctx context.Context, req *web.OIDCCallbackRequest) (*web.LoginResponse, error) {
+	if s.oidc == nil {
+		return nil, ErrOIDCDisabled
+	}
+	// The state must come back to the browser that started the sign-in
+	if req.State == "" || subtle.ConstantTimeCompare([]byte(req.State), []byte(req.BrowserState)) != 1 {
+		return nil, ErrInvalidOIDCState
+	}
+
+	nonce, verifier, err := s.identityRepo.ConsumeOIDCLogin(ctx, hashToken(req.State))
+	if err != nil {
+		return nil, err
+	}
+	if nonce == "" {
+		return nil, ErrInvalidOIDCState
+	}
+	if req.Error != "" || req.Code == "" {
+		return nil, ErrOIDCLoginFailed
+	}
+
+	guest, err := s.verifyGuest(ctx, req.GuestToken)
+	if err != nil {
+		return nil, err
+	}
+
+	claims, err := s.oidc.Exchange(ctx, req.Code, verifier, nonce)
+	if err != nil {
+		utils.NewLogger().Warnw("OIDC sign-in failed", "error", err)
+		return nil, ErrOIDCLoginFailed
+	}
+
+	user, err := s.provisioner.userFor(ctx, &externalAccount{
+		Issuer:        claims.Issuer,
+		Subject:       claims.Subject,
+		Username:      claims.PreferredUsername,
+		Email:         claims.Email,
+		EmailVerified: claims.EmailVerified,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if user.DisabledAt != nil {
+		return nil, ErrAccountDisabled
+	}
+
+	// The identity provider is responsible for any second factor
+	return s.completeLogin(ctx, user, guest, req.Client)
+}
+
+// completeLogin claims the guest's data, if any, and starts a session for a fully authenticated user
+func (s *authService) completeLogin(ctx context.Context, user *domain.User, guest *utils.Principal, client web.Client) (*web.LoginResponse, error) {
+	if guest != nil {
+		merged, err := s.userRepo.MergeGuest(ctx, guest.UserID, user.ID)
+		if err != nil {
+			return nil, err
+		}
+		if !merged {
+			return nil, ErrInvalidGuestToken
+		}
+	}
+
+	token, err := s.issueTokens(ctx, user, client)
 	if err != nil {
 		return nil, err
 	}
@@ -115,11 +456,316 @@ func (s *authService) Login(ctx context.Context, req *web.LoginRequest) (*web.Lo
 	}, nil
 }
 
+// Logout revokes the session the access token belongs to, which also
+// invalidates its refresh token
 func (s *authService) Logout(ctx context.Context, accessToken string) error {
-	_, err := s.tokenGen.ValidateToken(accessToken)
+	principal, err := s.tokenGen.Verify(strings.TrimPrefix(accessToken, "Bearer "), utils.TokenTypeAccess)
+	if err != nil {
+		return ErrInvalidAccessToken
+	}
+
+	return s.revocations.Revoke(ctx, principal.SessionID)
+}
+
+// LogoutAll revokes every session of a user
+func (s *authService) LogoutAll(ctx context.Context, userID string) error {
+	return s.revocations.RevokeAll(ctx, userID)
+}
+
+// GetSessions lists the sessions a user is logged in with
+func (s *authService) GetSessions(ctx context.Context, userID string) ([]*domain.Session, error) {
+	return s.sessionRepo.GetUserSessions(ctx, userID)
+}
+
+// RevokeSession logs one of a user's sessions out
+func (s *authService) RevokeSession(ctx context.Context, userID, sessionID string) error {
+	session, err := s.sessionRepo.GetSession(ctx, sessionID)
 	if err != nil {
 		return err
 	}
+	if session == nil || session.UserID != userID {
+		return ErrSessionNotFound
+	}
+
+	return s.revocations.Revoke(ctx, sessionID)
+}
 
+// Refresh exchanges a refresh token for a new token pair. Each refresh token
+// can be used once: presenting one that has already been rotated means it was
+// copied, so the whole session is revoked and both holders must log in again.
+func (s *authService) Refresh(ctx context.Context, refreshToken string) (*web.LoginResponse, error) {
+	principal, err := s.tokenGen.Verify(refreshToken, utils.TokenTypeRefresh)
+	if err != nil {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	session, err := s.sessionRepo.GetSession(ctx, principal.SessionID)
+	if err != nil {
+		return nil, err
+	}
+	if session == nil || session.UserID != principal.UserID || session.RevokedAt != nil || time.Now().After(session.ExpiresAt) {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	oldHash := hashToken(refreshToken)
+	if session.Token != oldHash {
+		if err := s.revocations.Revoke(ctx, session.ID); err != nil {
+			return nil, err
+		}
+		return nil, ErrRefreshTokenReused
+	}
+
+	token, err := s.tokenGen.GenerateToken(principal.UserID, principal.Username, session.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Another request may have rotated the same token since it was read
+	rotated, err := s.sessionRepo.RotateSession(ctx, session.ID, oldHash, hashToken(token.RefreshToken), token.RefreshExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+	if !rotated {
+		if err := s.revocations.Revoke(ctx, session.ID); err != nil {
+			return nil, err
+		}
+		return nil, ErrRefreshTokenReused
+	}
+
+	return &web.LoginResponse{
+		AccessToken:  token.AccessToken,
+		RefreshToken: token.RefreshToken,
+	}, nil
+}
+
+// ForgotPassword emails a single-use password reset link to the account with
+// the address, if there is one. Looking the account up and storing the token
+// happen in the background, so the response neither says nor, by how long it
+// takes, hints at which addresses have accounts.
+func (s *authService) ForgotPassword(ctx context.Context, email string) error {
+	if email == "" {
+		return errors.New("email is required")
+	}
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), mailTimeout)
+		defer cancel()
+		if err := s.sendPasswordReset(ctx, email); err != nil {
+			utils.NewLogger().Errorw("Failed to send password reset", "error", err)
+		}
+	}()
+	return nil
+}
+
+// sendPasswordReset stores a reset token for the account with the address and
+// emails it the link; addresses without an account that can reset are ignored
+func (s *authService) sendPasswordReset(ctx context.Context, email string) error {
+	user, err := s.userRepo.GetUserByEmail(ctx, email)
+	if err != nil {
+		return err
+	}
+	if user == nil || user.Role == domain.UserRoleGuest || user.DisabledAt != nil {
+		return nil
+	}
+
+	token, err := randomToken()
+	if err != nil {
+		return err
+	}
+	if err := s.userRepo.CreatePasswordResetToken(ctx, user.ID, hashToken(token), time.Now().Add(passwordResetDuration)); err != nil {
+		return err
+	}
+
+	message := mailer.Message{
+		To:      user.Email,
+		Subject: "Reset your rtdocs password",
+		Body: "Hi " + user.Username + ",\n\n" +
+			"Someone asked to reset the password of your rtdocs account. To choose a new one, open this link within the next hour:\n\n" +
+			s.appURL + "/reset-password?token=" + url.QueryEscape(token) + "\n\n" +
+			"If it wasn't you, you can ignore this email; your password has not been changed.\n",
+	}
+	return s.mailer.Send(ctx, message)
+}
+
+// ResetPassword sets a new password with a token from a reset email and logs
+// the user out everywhere, revoking their personal access tokens too, in case
+// the old password was compromised
+func (s *authService) ResetPassword(ctx context.Context, token, password string) error {
+	if token == "" {
+		return ErrInvalidResetToken
+	}
+	if password == "" {
+		return errors.New("password is required")
+	}
+
+	user, err := s.userRepo.GetPasswordResetUser(ctx, hashToken(token))
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		return ErrInvalidResetToken
+	}
+	if err := s.passwords.Validate(password, user.Username); err != nil {
+		return err
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+
+	userID, err := s.userRepo.ResetPassword(ctx, hashToken(token), string(hashedPassword))
+	if err != nil {
+		return err
+	}
+	if userID == "" {
+		return ErrInvalidResetToken
+	}
+
+	return s.revocations.RevokeAll(ctx, userID)
+}
+
+// VerifyEmail marks a user's address as verified with a token from a verification email
+func (s *authService) VerifyEmail(ctx context.Context, token string) error {
+	if token == "" {
+		return ErrInvalidVerifyToken
+	}
+
+	userID, err := s.userRepo.VerifyEmail(ctx, hashToken(token))
+	if err != nil {
+		return err
+	}
+	if userID == "" {
+		return ErrInvalidVerifyToken
+	}
 	return nil
 }
+
+// ResendVerification emails a new verification link, invalidating the previous one
+func (s *authService) ResendVerification(ctx context.Context, userID string) error {
+	user, err := s.userRepo.GetUser(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if user.Role == domain.UserRoleGuest || user.Email == "" {
+		return ErrInvalidEmail
+	}
+	if user.Verified {
+		return ErrAlreadyVerified
+	}
+
+	return s.sendVerification(ctx, user)
+}
+
+// sendVerification stores a verification token for the user's current address and emails it to them
+func (s *authService) sendVerification(ctx context.Context, user *domain.User) error {
+	token, err := randomToken()
+	if err != nil {
+		return err
+	}
+	if err := s.userRepo.CreateVerificationToken(ctx, user.ID, user.Email, hashToken(token), time.Now().Add(emailVerificationDuration)); err != nil {
+		return err
+	}
+
+	s.sendMail(user.ID, mailer.Message{
+		To:      user.Email,
+		Subject: "Verify your rtdocs email address",
+		Body: "Hi " + user.Username + ",\n\n" +
+			"Please confirm that this is your email address by opening this link within the next 7 days:\n\n" +
+			s.appURL + "/verify-email?token=" + url.QueryEscape(token) + "\n\n" +
+			"If you didn't create an rtdocs account, you can ignore this email.\n",
+	})
+	return nil
+}
+
+// sendMail sends a message in the background so that a slow mail server does
+// not hold up the request; failures are logged
+func (s *authService) sendMail(userID string, message mailer.Message) {
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), mailTimeout)
+		defer cancel()
+		if err := s.mailer.Send(ctx, message); err != nil {
+			utils.NewLogger().Errorw("Failed to send email", "user_id", userID, "subject", message.Subject, "error", err)
+		}
+	}()
+}
+
+// verifyGuest checks an optional guest token sent along with a login or
+// registration, and that its guest has not been swept
+func (s *authService) verifyGuest(ctx context.Context, guestToken string) (*utils.Principal, error) {
+	if guestToken == "" {
+		return nil, nil
+	}
+
+	principal, err := s.tokenGen.Verify(guestToken, utils.TokenTypeGuest)
+	if err != nil {
+		return nil, ErrInvalidGuestToken
+	}
+
+	exists, err := s.userRepo.GuestExists(ctx, principal.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, ErrInvalidGuestToken
+	}
+	return principal, nil
+}
+
+// JWKS returns the public keys other services can verify our tokens with
+func (s *authService) JWKS() utils.JWKSet {
+	return s.tokenGen.JWKS()
+}
+
+// issueTokens starts a new session for the user and returns its first token pair
+func (s *authService) issueTokens(ctx context.Context, user *domain.User, client web.Client) (*utils.Token, error) {
+	sessionID := uuid.New().String()
+	token, err := s.tokenGen.GenerateToken(user.ID, user.Username, sessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	session := &domain.Session{
+		ID:        sessionID,
+		UserID:    user.ID,
+		Token:     hashToken(token.RefreshToken),
+		Device:    utils.DeviceName(client.UserAgent),
+		IPAddress: client.IPAddress,
+		UserAgent: client.UserAgent,
+		CreatedAt: time.Now(),
+		ExpiresAt: token.RefreshExpiresAt,
+	}
+	if _, err := s.sessionRepo.CreateSession(ctx, session); err != nil {
+		return nil, err
+	}
+
+	return token, nil
+}
+
+// dummyPasswordHash is compared against when a username does not exist, so
+// that the response takes as long as for a wrong password
+var dummyPasswordHash = sync.OnceValue(func() []byte {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("rtdocs-dummy-password"), bcrypt.DefaultCost)
+	return hash
+})
+
+// validEmail reports whether s is a bare email address, without a display name
+func validEmail(s string) bool {
+	address, err := mail.ParseAddress(s)
+	return err == nil && address.Address == s
+}
+
+// randomToken returns an unguessable URL-safe token
+func randomToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hashToken is what is stored for a refresh token, so a database leak does not leak usable tokens
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
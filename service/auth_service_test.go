@@ -0,0 +1,462 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"rtdocs/mailer"
+	"rtdocs/model/domain"
+	"rtdocs/model/web"
+	"rtdocs/oidc"
+	"rtdocs/oidc/oidctest"
+	"rtdocs/repository"
+	"rtdocs/utils"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// slowUsers is a user store that answers lookups by email once release is closed
+type slowUsers struct {
+	repository.UserRepository
+	release chan struct{}
+	users   map[string]*domain.User
+}
+
+func (u *slowUsers) GetUserByEmail(ctx context.Context, email string) (*domain.User, error) {
+	<-u.release
+	return u.users[email], nil
+}
+
+func (u *slowUsers) CreatePasswordResetToken(ctx context.Context, userID, tokenHash string, expiresAt time.Time) error {
+	return nil
+}
+
+// sentMail collects the messages a mailer was asked to send
+type sentMail chan mailer.Message
+
+func (m sentMail) Send(ctx context.Context, message mailer.Message) error {
+	m <- message
+	return nil
+}
+
+func TestForgotPasswordAnswersBeforeLookingUpTheAccount(t *testing.T) {
+	users := &slowUsers{
+		release: make(chan struct{}),
+		users:   map[string]*domain.User{"alice@example.com": {ID: "alice", Username: "alice", Email: "alice@example.com"}},
+	}
+	mail := make(sentMail, 2)
+	auth := &authService{userRepo: users, mailer: mail, appURL: defaultAppURL}
+
+	for _, email := range []string{"nobody@example.com", "alice@example.com"} {
+		if err := auth.ForgotPassword(context.Background(), email); err != nil {
+			t.Fatalf("ForgotPassword(%s) = %v", email, err)
+		}
+	}
+	close(users.release)
+
+	select {
+	case message := <-mail:
+		if message.To != "alice@example.com" {
+			t.Fatalf("reset link sent to %s", message.To)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no reset link was sent")
+	}
+	select {
+	case message := <-mail:
+		t.Fatalf("a second reset link was sent to %s", message.To)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+// racedUsers is a user store in which every address looks free but another
+// registration takes it, or the username, before the insert
+type racedUsers struct {
+	repository.UserRepository
+	err error
+}
+
+func (u *racedUsers) GetUserByEmail(ctx context.Context, email string) (*domain.User, error) {
+	return nil, nil
+}
+
+func (u *racedUsers) CreateUser(ctx context.Context, user *domain.User) (*domain.User, error) {
+	return nil, u.err
+}
+
+type anyPassword struct{}
+
+func (anyPassword) Validate(password, username string) error {
+	return nil
+}
+
+func TestRegisterReportsRacedDuplicates(t *testing.T) {
+	tests := []struct {
+		err, want error
+	}{
+		{repository.ErrDuplicateEmail, ErrEmailTaken},
+		{repository.ErrDuplicateUsername, ErrUsernameTaken},
+	}
+
+	for _, tt := range tests {
+		auth := &authService{userRepo: &racedUsers{err: tt.err}, passwords: anyPassword{}}
+		_, err := auth.Register(context.Background(), &web.RegisterRequest{Username: "alice", Email: "alice@example.com", Password: "password"})
+		if !errors.Is(err, tt.want) {
+			t.Errorf("Register when the insert fails with %v = %v, want %v", tt.err, err, tt.want)
+		}
+	}
+}
+
+type unthrottled struct{}
+
+func (unthrottled) Check(ctx context.Context, username string, client web.Client) error {
+	return nil
+}
+
+func (unthrottled) RecordFailure(ctx context.Context, username, userID string, client web.Client, reason string) {
+}
+
+func (unthrottled) RecordSuccess(ctx context.Context, username, userID string, client web.Client) {}
+
+func (unthrottled) Forgive(ctx context.Context, username string, client web.Client) {}
+
+type acceptedSessions struct {
+	repository.SessionRepository
+}
+
+func (acceptedSessions) CreateSession(ctx context.Context, session *domain.Session) (*domain.Session, error) {
+	return session, nil
+}
+
+func TestMFATokenFinishesOneLogin(t *testing.T) {
+	tokens := utils.NewTokenGenerator("secret", "guest-secret", "15m", "24h")
+	repo := newMemoryMFA()
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo.ReplaceRecoveryCodes(context.Background(), "user", hashes)
+
+	users := &knownUsers{users: map[string]*domain.User{"user": {ID: "user", Username: "alice"}}}
+	auth := &authService{
+		userRepo:    users,
+		sessionRepo: acceptedSessions{},
+		mfa:         &mfaService{mfaRepo: repo, userRepo: users},
+		throttle:    unthrottled{},
+		tokenGen:    tokens,
+	}
+
+	mfaToken, err := tokens.GenerateMFAToken("user", "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := auth.LoginMFA(context.Background(), &web.MFALoginRequest{MFAToken: mfaToken, Code: codes[0]}); err != nil {
+		t.Fatalf("LoginMFA = %v", err)
+	}
+
+	// Another valid code does not make the same token good for a second session
+	if _, err := auth.LoginMFA(context.Background(), &web.MFALoginRequest{MFAToken: mfaToken, Code: codes[1]}); !errors.Is(err, ErrInvalidMFAToken) {
+		t.Fatalf("replaying the MFA token: %v, want ErrInvalidMFAToken", err)
+	}
+}
+
+func newOIDCTestService(t *testing.T) (*authService, *oidctest.Server, *memoryUsers) {
+	idp := oidctest.NewServer()
+	t.Cleanup(idp.Close)
+
+	users := &memoryUsers{users: make(map[string]*domain.User)}
+	identities := newMemoryIdentities(users)
+	provider := oidc.NewProvider(oidc.Config{
+		Issuer:       idp.URL,
+		ClientID:     idp.ClientID,
+		ClientSecret: idp.ClientSecret,
+		RedirectURL:  idp.RedirectURL,
+		Scopes:       []string{"openid", "email", "profile"},
+	}, idp.Client())
+
+	return &authService{
+		userRepo:     users,
+		sessionRepo:  acceptedSessions{},
+		identityRepo: identities,
+		provisioner:  newAccountProvisioner(users, identities),
+		tokenGen:     utils.NewTokenGenerator("secret", "guest-secret", "15m", "24h"),
+		oidc:         provider,
+	}, idp, users
+}
+
+// signInAt starts a sign-in and plays the user authenticating at the provider,
+// returning the callback the app would post and the state cookie it was started with
+func signInAt(t *testing.T, auth *authService, idp *oidctest.Server, identity oidctest.Identity) (*web.OIDCCallbackRequest, string) {
+	authURL, state, err := auth.StartOIDCLogin(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	redirect, err := idp.Authorize(authURL, identity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &web.OIDCCallbackRequest{Code: redirect.Query().Get("code"), State: redirect.Query().Get("state")}, state
+}
+
+func TestOIDCCallback(t *testing.T) {
+	auth, idp, users := newOIDCTestService(t)
+	identity := oidctest.Identity{Subject: "alice", Email: "alice@example.com", EmailVerified: true, PreferredUsername: strings.Repeat("alice", 20)}
+
+	callback, cookie := signInAt(t, auth, idp, identity)
+	callback.BrowserState = cookie
+	response, err := auth.OIDCCallback(context.Background(), callback)
+	if err != nil {
+		t.Fatalf("OIDCCallback = %v", err)
+	}
+	if response.AccessToken == "" {
+		t.Fatal("no access token")
+	}
+	if len(users.users) != 1 {
+		t.Fatalf("%d accounts, want one provisioned", len(users.users))
+	}
+
+	// The state is used up with the sign-in
+	if _, err := auth.OIDCCallback(context.Background(), callback); !errors.Is(err, ErrInvalidOIDCState) {
+		t.Fatalf("replaying the callback: %v, want ErrInvalidOIDCState", err)
+	}
+}
+
+func TestOIDCCallbackNeedsTheBrowserThatStarted(t *testing.T) {
+	auth, idp, _ := newOIDCTestService(t)
+
+	// An attacker signs in as themselves and gets a victim's browser to post
+	// the callback; the victim's browser has no state, or that of its own sign-in
+	attack, _ := signInAt(t, auth, idp, oidctest.Identity{Subject: "mallory"})
+	_, victimCookie := signInAt(t, auth, idp, oidctest.Identity{Subject: "victim"})
+
+	for _, cookie := range []string{"", victimCookie} {
+		attack.BrowserState = cookie
+		if _, err := auth.OIDCCallback(context.Background(), attack); !errors.Is(err, ErrInvalidOIDCState) {
+			t.Fatalf("callback with state cookie %q: %v, want ErrInvalidOIDCState", cookie, err)
+		}
+	}
+}
+
+func TestOIDCCallbackRejectsForgedCode(t *testing.T) {
+	auth, idp, users := newOIDCTestService(t)
+
+	callback, cookie := signInAt(t, auth, idp, oidctest.Identity{Subject: "alice"})
+	callback.BrowserState = cookie
+	callback.Code = "forged"
+	if _, err := auth.OIDCCallback(context.Background(), callback); !errors.Is(err, ErrOIDCLoginFailed) {
+		t.Fatalf("OIDCCallback with a forged code = %v, want ErrOIDCLoginFailed", err)
+	}
+	if len(users.users) != 0 {
+		t.Fatal("an account was provisioned for a failed sign-in")
+	}
+}
+
+// memorySessions is a session store that rotates tokens atomically, like the
+// conditional update of the real one
+type memorySessions struct {
+	repository.SessionRepository
+	mu       sync.Mutex
+	sessions map[string]*domain.Session
+}
+
+func newMemorySessions() *memorySessions {
+	return &memorySessions{sessions: make(map[string]*domain.Session)}
+}
+
+func (m *memorySessions) CreateSession(ctx context.Context, session *domain.Session) (*domain.Session, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stored := *session
+	m.sessions[session.ID] = &stored
+	return session, nil
+}
+
+func (m *memorySessions) GetSession(ctx context.Context, id string) (*domain.Session, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	session, ok := m.sessions[id]
+	if !ok {
+		return nil, nil
+	}
+	found := *session
+	return &found, nil
+}
+
+func (m *memorySessions) TouchSession(ctx context.Context, id string) error {
+	return nil
+}
+
+func (m *memorySessions) RotateSession(ctx context.Context, id, oldToken, newToken string, expiresAt time.Time) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	session, ok := m.sessions[id]
+	if !ok || session.Token != oldToken || session.RevokedAt != nil {
+		return false, nil
+	}
+	now := time.Now()
+	session.Token, session.ExpiresAt, session.RotatedAt = newToken, expiresAt, &now
+	return true, nil
+}
+
+func (m *memorySessions) RevokeSession(ctx context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if session, ok := m.sessions[id]; ok && session.RevokedAt == nil {
+		now := time.Now()
+		session.RevokedAt = &now
+	}
+	return nil
+}
+
+func (m *memorySessions) RevokeUserSessions(ctx context.Context, userID string) ([]*domain.Session, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var revoked []*domain.Session
+	for _, session := range m.sessions {
+		if session.UserID == userID && session.RevokedAt == nil {
+			now := time.Now()
+			session.RevokedAt = &now
+			found := *session
+			revoked = append(revoked, &found)
+		}
+	}
+	return revoked, nil
+}
+
+func (m *memorySessions) revoked(id string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.sessions[id].RevokedAt != nil
+}
+
+func newSessionTestService() (*authService, *memorySessions) {
+	sessions := newMemorySessions()
+	return &authService{
+		sessionRepo: sessions,
+		revocations: NewRevocationService(sessions),
+		tokenGen:    utils.NewTokenGenerator("secret", "guest-secret", "15m", "24h"),
+	}, sessions
+}
+
+func TestRefreshRotates(t *testing.T) {
+	ctx := context.Background()
+	auth, sessions := newSessionTestService()
+	token, err := auth.issueTokens(ctx, &domain.User{ID: "user", Username: "alice"}, web.Client{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first, err := auth.Refresh(ctx, token.RefreshToken)
+	if err != nil {
+		t.Fatalf("Refresh = %v", err)
+	}
+	if first.RefreshToken == token.RefreshToken {
+		t.Fatal("Refresh returned the same refresh token")
+	}
+	second, err := auth.Refresh(ctx, first.RefreshToken)
+	if err != nil {
+		t.Fatalf("Refresh of the rotated token = %v", err)
+	}
+
+	principal, err := auth.tokenGen.Verify(second.AccessToken, utils.TokenTypeAccess)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions.sessions) != 1 || principal.UserID != "user" || sessions.revoked(principal.SessionID) {
+		t.Fatalf("after two refreshes: %d sessions, principal %+v; want the one live session", len(sessions.sessions), principal)
+	}
+}
+
+func TestRefreshReuseRevokesSession(t *testing.T) {
+	ctx := context.Background()
+	auth, sessions := newSessionTestService()
+	token, err := auth.issueTokens(ctx, &domain.User{ID: "user", Username: "alice"}, web.Client{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	principal, _ := auth.tokenGen.Verify(token.AccessToken, utils.TokenTypeAccess)
+
+	rotated, err := auth.Refresh(ctx, token.RefreshToken)
+	if err != nil {
+		t.Fatalf("Refresh = %v", err)
+	}
+
+	// Presenting the old token again means it was copied: both holders are logged out
+	if _, err := auth.Refresh(ctx, token.RefreshToken); !errors.Is(err, ErrRefreshTokenReused) {
+		t.Fatalf("reusing a rotated token: %v, want ErrRefreshTokenReused", err)
+	}
+	if !sessions.revoked(principal.SessionID) {
+		t.Fatal("session still live after reuse")
+	}
+	if _, err := auth.Refresh(ctx, rotated.RefreshToken); !errors.Is(err, ErrInvalidRefreshToken) {
+		t.Fatalf("refreshing the rotated token after reuse: %v, want ErrInvalidRefreshToken", err)
+	}
+	if revoked, err := auth.revocations.IsRevoked(ctx, principal.SessionID); err != nil || !revoked {
+		t.Fatalf("IsRevoked after reuse = %v, %v; want true", revoked, err)
+	}
+}
+
+func TestConcurrentRefreshSucceedsOnce(t *testing.T) {
+	ctx := context.Background()
+	auth, _ := newSessionTestService()
+	token, err := auth.issueTokens(ctx, &domain.User{ID: "user", Username: "alice"}, web.Client{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const attempts = 8
+	errs := make(chan error, attempts)
+	start := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < attempts; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			_, err := auth.Refresh(ctx, token.RefreshToken)
+			errs <- err
+		}()
+	}
+	close(start)
+	wg.Wait()
+	close(errs)
+
+	succeeded := 0
+	for err := range errs {
+		switch {
+		case err == nil:
+			succeeded++
+		case !errors.Is(err, ErrRefreshTokenReused) && !errors.Is(err, ErrInvalidRefreshToken):
+			t.Errorf("concurrent Refresh = %v", err)
+		}
+	}
+	if succeeded != 1 {
+		t.Fatalf("%d concurrent refreshes of one token succeeded, want 1", succeeded)
+	}
+}
+
+func TestRevokeSession(t *testing.T) {
+	ctx := context.Background()
+	auth, sessions := newSessionTestService()
+	sessions.CreateSession(ctx, &domain.Session{ID: "phone", UserID: "alice", ExpiresAt: time.Now().Add(time.Hour)})
+	sessions.CreateSession(ctx, &domain.Session{ID: "laptop", UserID: "bob", ExpiresAt: time.Now().Add(time.Hour)})
+
+	tests := []struct {
+		name, userID, sessionID string
+		want                    error
+	}{
+		{"another user's", "alice", "laptop", ErrSessionNotFound},
+		{"unknown", "alice", "tablet", ErrSessionNotFound},
+		{"own", "alice", "phone", nil},
+	}
+	for _, tt := range tests {
+		if err := auth.RevokeSession(ctx, tt.userID, tt.sessionID); !errors.Is(err, tt.want) {
+			t.Errorf("RevokeSession of %s session = %v, want %v", tt.name, err, tt.want)
+		}
+	}
+
+	if !sessions.revoked("phone") || sessions.revoked("laptop") {
+		t.Fatalf("revoked phone = %v, laptop = %v; want only phone", sessions.revoked("phone"), sessions.revoked("laptop"))
+	}
+}
@@ -0,0 +1,144 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"rtdocs/model/domain"
+	"rtdocs/repository"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+)
+
+var (
+	ErrForbidden   = errors.New("forbidden")
+	ErrInvalidRole = errors.New("role must be editor, commenter or viewer")
+	ErrOwnerRole   = errors.New("the owner's role cannot be changed")
+)
+
+// roleRank orders document roles; a role satisfies every role ranked at or below it
+var roleRank = map[string]int{
+	domain.RoleViewer:    1,
+	domain.RoleCommenter: 2,
+	domain.RoleEditor:    3,
+	domain.RoleOwner:     4,
+}
+
+type AuthorizationService interface {
+	ResolveRole(ctx context.Context, documentID, userID string) (string, error)
+	Authorize(ctx context.Context, documentID, userID, required string) (string, error)
+	GetPermissions(ctx context.Context, documentID, actorID string) ([]*domain.Permission, error)
+	GrantPermission(ctx context.Context, documentID, actorID, userID, role string) (*domain.Permission, error)
+	RevokePermission(ctx context.Context, documentID, actorID, userID string) error
+}
+
+type authorizationService struct {
+	permissionRepo repository.PermissionRepository
+	docService     DocumentService
+	policy         VerificationPolicy
+}
+
+func NewAuthorizationService(permissionRepo repository.PermissionRepository, docService DocumentService, policy VerificationPolicy) AuthorizationService {
+	return &authorizationService{permissionRepo: permissionRepo, docService: docService, policy: policy}
+}
+
+// ResolveRole returns the highest role a user has on a document, taking
+// ownership, explicit grants and public sharing into account. It returns ""
+// when the user has no access.
+func (s *authorizationService) ResolveRole(ctx context.Context, documentID, userID string) (string, error) {
+	document, err := s.docService.GetDocument(ctx, documentID)
+	if errors.Is(err, pgx.ErrNoRows) || err == nil && document == nil {
+		return "", ErrDocumentNotFound
+	}
+	if err != nil {
+		return "", err
+	}
+
+	if userID != "" && document.OwnerID == userID {
+		return domain.RoleOwner, nil
+	}
+
+	role := ""
+	if document.IsPublic {
+		role = domain.RoleViewer
+		if document.CanEdit {
+			role = domain.RoleEditor
+		}
+	}
+
+	if userID != "" {
+		permission, err := s.permissionRepo.GetPermission(ctx, documentID, userID)
+		if err != nil {
+			return "", err
+		}
+		if permission != nil && roleRank[permission.Role] > roleRank[role] {
+			role = permission.Role
+		}
+	}
+
+	return role, nil
+}
+
+// Authorize resolves the user's role and returns ErrForbidden unless it is at least required
+func (s *authorizationService) Authorize(ctx context.Context, documentID, userID, required string) (string, error) {
+	role, err := s.ResolveRole(ctx, documentID, userID)
+	if err != nil {
+		return "", err
+	}
+
+	if !HasRole(role, required) {
+		return role, ErrForbidden
+	}
+	return role, nil
+}
+
+// GetPermissions lists the roles granted on a document; anyone who can view it may see them
+func (s *authorizationService) GetPermissions(ctx context.Context, documentID, actorID string) ([]*domain.Permission, error) {
+	if _, err := s.Authorize(ctx, documentID, actorID, domain.RoleViewer); err != nil {
+		return nil, err
+	}
+
+	return s.permissionRepo.GetPermissions(ctx, documentID)
+}
+
+// GrantPermission gives a user a role on a document, or changes the role they
+// have. Only the owner may do this, and ownership itself cannot be granted.
+func (s *authorizationService) GrantPermission(ctx context.Context, documentID, actorID, userID, role string) (*domain.Permission, error) {
+	if role == domain.RoleOwner || roleRank[role] == 0 {
+		return nil, ErrInvalidRole
+	}
+	if _, err := s.Authorize(ctx, documentID, actorID, domain.RoleOwner); err != nil {
+		return nil, err
+	}
+	if userID == actorID {
+		return nil, ErrOwnerRole
+	}
+	if err := s.policy.Require(ctx, actorID, ActionGrantPermission); err != nil {
+		return nil, err
+	}
+
+	permission := &domain.Permission{
+		DocumentID: documentID,
+		UserID:     userID,
+		Role:       role,
+		GrantedBy:  actorID,
+		CreatedAt:  time.Now(),
+		UpdatedAt:  time.Now(),
+	}
+
+	return s.permissionRepo.SavePermission(ctx, permission)
+}
+
+// RevokePermission removes a user's granted role. Only the owner may do this.
+func (s *authorizationService) RevokePermission(ctx context.Context, documentID, actorID, userID string) error {
+	if _, err := s.Authorize(ctx, documentID, actorID, domain.RoleOwner); err != nil {
+		return err
+	}
+
+	return s.permissionRepo.DeletePermission(ctx, documentID, userID)
+}
+
+// HasRole reports whether role satisfies required
+func HasRole(role, required string) bool {
+	return role != "" && roleRank[role] >= roleRank[required]
+}
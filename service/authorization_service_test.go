@@ -0,0 +1,74 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"rtdocs/model/domain"
+	"rtdocs/repository"
+	"testing"
+
+	"github.com/jackc/pgx/v4"
+)
+
+type storedDocuments struct {
+	DocumentService
+	documents map[string]*domain.Document
+}
+
+// GetDocument fails for unknown documents the way the repository does
+func (s *storedDocuments) GetDocument(ctx context.Context, id string) (*domain.Document, error) {
+	document, ok := s.documents[id]
+	if !ok {
+		return nil, fmt.Errorf("document not found: %w", pgx.ErrNoRows)
+	}
+	return document, nil
+}
+
+type storedPermissions struct {
+	repository.PermissionRepository
+	roles map[string]string
+}
+
+func (s *storedPermissions) GetPermission(ctx context.Context, documentID, userID string) (*domain.Permission, error) {
+	role, ok := s.roles[userID]
+	if !ok {
+		return nil, nil
+	}
+	return &domain.Permission{DocumentID: documentID, UserID: userID, Role: role}, nil
+}
+
+func TestResolveRole(t *testing.T) {
+	docs := &storedDocuments{documents: map[string]*domain.Document{
+		"private": {ID: "private", OwnerID: "owner"},
+		"public":  {ID: "public", OwnerID: "owner", IsPublic: true},
+		"open":    {ID: "open", OwnerID: "owner", IsPublic: true, CanEdit: true},
+	}}
+	permissions := &storedPermissions{roles: map[string]string{"commenter": domain.RoleCommenter}}
+	authz := NewAuthorizationService(permissions, docs, nil)
+
+	tests := []struct {
+		document, user string
+		want           string
+		wantErr        error
+	}{
+		{"private", "owner", domain.RoleOwner, nil},
+		{"private", "commenter", domain.RoleCommenter, nil},
+		{"private", "stranger", "", nil},
+		{"public", "", domain.RoleViewer, nil},
+		{"public", "commenter", domain.RoleCommenter, nil},
+		{"open", "commenter", domain.RoleEditor, nil},
+		{"missing", "owner", "", ErrDocumentNotFound},
+	}
+
+	for _, tt := range tests {
+		role, err := authz.ResolveRole(context.Background(), tt.document, tt.user)
+		if !errors.Is(err, tt.wantErr) || role != tt.want {
+			t.Errorf("ResolveRole(%s, %q) = %q, %v; want %q, %v", tt.document, tt.user, role, err, tt.want, tt.wantErr)
+		}
+	}
+
+	if _, err := authz.Authorize(context.Background(), "private", "stranger", domain.RoleViewer); !errors.Is(err, ErrForbidden) {
+		t.Errorf("Authorize without access: %v, want ErrForbidden", err)
+	}
+}
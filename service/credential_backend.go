@@ -0,0 +1,185 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"rtdocs/ldap"
+	"rtdocs/model/domain"
+	"rtdocs/repository"
+	"rtdocs/utils"
+	"slices"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Credential backend names, as listed in AUTH_BACKENDS
+const (
+	BackendLocal = "local"
+	BackendLDAP  = "ldap"
+)
+
+// ErrUnknownUser is returned by a credential backend that has no such user,
+// so that the next backend is tried
+var ErrUnknownUser = errors.New("unknown user")
+
+// CredentialBackend checks a username and password. Login tries each
+// configured backend in turn: ErrUnknownUser moves on to the next one, and
+// any other error, ErrInvalidCredentials included, ends the login. A wrong
+// password for a known user must not be tried against another backend, where
+// the same username may belong to someone else.
+type CredentialBackend interface {
+	Name() string
+	Authenticate(ctx context.Context, username, password string) (*domain.User, error)
+}
+
+// localBackend checks passwords against the hashes in the database
+type localBackend struct {
+	userRepo     repository.UserRepository
+	identityRepo repository.IdentityRepository
+	nextIssuers  []string
+}
+
+// NewLocalBackend returns a backend for the accounts in the database.
+// nextIssuers identifies the directories of the backends tried after it, which
+// a wrong password for an account linked to one of them is left to.
+func NewLocalBackend(userRepo repository.UserRepository, identityRepo repository.IdentityRepository, nextIssuers []string) CredentialBackend {
+	return &localBackend{userRepo: userRepo, identityRepo: identityRepo, nextIssuers: nextIssuers}
+}
+
+func (b *localBackend) Name() string {
+	return BackendLocal
+}
+
+func (b *localBackend) Authenticate(ctx context.Context, username, password string) (*domain.User, error) {
+	user, err := b.userRepo.GetUserByUsername(ctx, username)
+	if err != nil {
+		return nil, err
+	}
+	// Guest accounts all share a throwaway password and can only be used through their token
+	if user == nil || user.Role == domain.UserRoleGuest {
+		bcrypt.CompareHashAndPassword(dummyPasswordHash(), []byte(password))
+		return nil, ErrUnknownUser
+	}
+
+	// An account without a password of its own can only sign in elsewhere
+	if user.Password == "" {
+		bcrypt.CompareHashAndPassword(dummyPasswordHash(), []byte(password))
+		return nil, ErrUnknownUser
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)); err != nil {
+		// The password may be the account's directory password, which only a
+		// later backend can check. Accounts linked to anything else, such as
+		// an OIDC provider, have nowhere else to try.
+		if len(b.nextIssuers) == 0 {
+			return nil, ErrInvalidCredentials
+		}
+		linked, err := b.identityRepo.HasIdentity(ctx, user.ID, b.nextIssuers)
+		if err != nil {
+			return nil, err
+		}
+		if linked {
+			return nil, ErrUnknownUser
+		}
+		return nil, ErrInvalidCredentials
+	}
+	return user, nil
+}
+
+// ldapBackend authenticates against an LDAP directory. A directory user gets
+// an account the first time they log in. When LDAP_ADMIN_GROUPS is set, their
+// role follows their groups on every login, members being admins and everyone
+// else not; without it, roles are left to be managed here. When
+// LDAP_USER_GROUPS is set, only its members and admins may log in at all.
+type ldapBackend struct {
+	directory   *ldap.Directory
+	userRepo    repository.UserRepository
+	provisioner *accountProvisioner
+	adminGroups []string
+	userGroups  []string
+}
+
+// NewLDAPBackend returns a backend for directory; the group lists are read
+// from LDAP_ADMIN_GROUPS and LDAP_USER_GROUPS, separated by semicolons since
+// DNs contain commas
+func NewLDAPBackend(directory *ldap.Directory, userRepo repository.UserRepository, identityRepo repository.IdentityRepository) CredentialBackend {
+	return &ldapBackend{
+		directory:   directory,
+		userRepo:    userRepo,
+		provisioner: newAccountProvisioner(userRepo, identityRepo),
+		adminGroups: splitGroups(utils.GetEnv("LDAP_ADMIN_GROUPS")),
+		userGroups:  splitGroups(utils.GetEnv("LDAP_USER_GROUPS")),
+	}
+}
+
+func (b *ldapBackend) Name() string {
+	return BackendLDAP
+}
+
+func (b *ldapBackend) Authenticate(ctx context.Context, username, password string) (*domain.User, error) {
+	account, err := b.directory.Authenticate(ctx, username, password)
+	switch {
+	case errors.Is(err, ldap.ErrUserNotFound):
+		return nil, ErrUnknownUser
+	case errors.Is(err, ldap.ErrInvalidCredentials):
+		return nil, ErrInvalidCredentials
+	case err != nil:
+		// An unreachable directory must not stop local accounts logging in
+		utils.NewLogger().Warnw("LDAP authentication failed", "error", err)
+		return nil, ErrUnknownUser
+	}
+
+	role := b.role(account.Groups)
+	if role == "" {
+		return nil, ErrInvalidCredentials
+	}
+
+	user, err := b.provisioner.userFor(ctx, &externalAccount{
+		Issuer:   b.directory.URL(),
+		Subject:  strings.ToLower(account.DN),
+		Username: account.Username,
+		Email:    account.Email,
+		// The directory is run by the organisation, so its addresses are trusted
+		EmailVerified: account.Email != "",
+		Role:          role,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(b.adminGroups) > 0 && user.Role != role {
+		user.Role = role
+		if user, err = b.userRepo.UpdateUser(ctx, user); err != nil {
+			return nil, err
+		}
+	}
+	return user, nil
+}
+
+// role maps a user's groups to their role, or "" if they may not log in
+func (b *ldapBackend) role(groups []string) string {
+	if inAnyGroup(groups, b.adminGroups) {
+		return domain.UserRoleAdmin
+	}
+	if len(b.userGroups) > 0 && !inAnyGroup(groups, b.userGroups) {
+		return ""
+	}
+	return domain.UserRoleAuthenticated
+}
+
+func inAnyGroup(groups, names []string) bool {
+	return slices.ContainsFunc(groups, func(group string) bool {
+		return slices.ContainsFunc(names, func(name string) bool { return ldap.GroupMatches(group, name) })
+	})
+}
+
+func splitGroups(s string) []string {
+	var groups []string
+	for _, group := range strings.Split(s, ";") {
+		if group = strings.TrimSpace(group); group != "" {
+			groups = append(groups, group)
+		}
+	}
+	return groups
+}
@@ -0,0 +1,167 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"rtdocs/ldap"
+	"rtdocs/ldap/ldaptest"
+	"rtdocs/model/domain"
+	"rtdocs/model/web"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// stubBackend answers every login the same way and counts how often it was asked
+type stubBackend struct {
+	user  *domain.User
+	err   error
+	calls int
+}
+
+func (b *stubBackend) Name() string {
+	return "stub"
+}
+
+func (b *stubBackend) Authenticate(ctx context.Context, username, password string) (*domain.User, error) {
+	b.calls++
+	return b.user, b.err
+}
+
+func TestBackendOrder(t *testing.T) {
+	alice := &domain.User{ID: "alice", Username: "alice"}
+
+	tests := []struct {
+		name      string
+		first     error
+		err       error
+		triedNext bool
+	}{
+		{"unknown user moves on", ErrUnknownUser, nil, true},
+		{"wrong password ends the login", ErrInvalidCredentials, ErrInvalidCredentials, false},
+		{"an outage ends the login", errors.New("database is down"), errors.New("database is down"), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			first, next := &stubBackend{err: tt.first}, &stubBackend{user: alice}
+			auth := &authService{
+				userRepo: &memoryUsers{users: map[string]*domain.User{"alice": alice}},
+				throttle: unthrottled{},
+				backends: []CredentialBackend{first, next},
+			}
+
+			_, err := auth.authenticate(context.Background(), "alice", "password", web.Client{})
+			if (err == nil) != (tt.err == nil) || (err != nil && err.Error() != tt.err.Error()) {
+				t.Fatalf("authenticate = %v, want %v", err, tt.err)
+			}
+			if triedNext := next.calls > 0; triedNext != tt.triedNext {
+				t.Fatalf("tried the next backend = %v, want %v", triedNext, tt.triedNext)
+			}
+		})
+	}
+}
+
+func TestLocalBackendLeavesLinkedAccounts(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("local-password"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	directory := "ldap://directory"
+
+	tests := []struct {
+		name        string
+		password    string // the account's hash, or "" for none
+		linkedTo    string // the issuer of the account's identity, if any
+		nextIssuers []string
+		want        error // for a password other than the account's own
+	}{
+		{"unlinked", string(hash), "", []string{directory}, ErrInvalidCredentials},
+		{"linked to the next directory", string(hash), directory, []string{directory}, ErrUnknownUser},
+		{"linked to another directory", string(hash), "ldap://elsewhere", []string{directory}, ErrInvalidCredentials},
+		{"linked to an OIDC provider", string(hash), "https://idp.example.com", []string{directory}, ErrInvalidCredentials},
+		{"linked with no backend after", string(hash), directory, nil, ErrInvalidCredentials},
+		{"without a password", "", "", nil, ErrUnknownUser},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			users := &memoryUsers{users: map[string]*domain.User{
+				"alice": {ID: "alice", Username: "alice", Password: tt.password, Role: domain.UserRoleAuthenticated},
+			}}
+			identities := newMemoryIdentities(users)
+			if tt.linkedTo != "" {
+				identities.identities[tt.linkedTo+" uid=alice"] = "alice"
+			}
+			backend := NewLocalBackend(users, identities, tt.nextIssuers)
+
+			if tt.password != "" {
+				if _, err := backend.Authenticate(context.Background(), "alice", "local-password"); err != nil {
+					t.Fatalf("the account's own password was refused: %v", err)
+				}
+			}
+			if _, err := backend.Authenticate(context.Background(), "alice", "directory-password"); !errors.Is(err, tt.want) {
+				t.Fatalf("another password gave %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestLDAPRoles(t *testing.T) {
+	server, err := ldaptest.NewServer(
+		ldaptest.NewEntry("uid=alice,dc=example,dc=com", "objectClass", "person", "uid", "alice", "userPassword", "password",
+			"memberOf", "cn=admins,dc=example,dc=com", "memberOf", "cn=staff,dc=example,dc=com"),
+		ldaptest.NewEntry("uid=bob,dc=example,dc=com", "objectClass", "person", "uid", "bob", "userPassword", "password",
+			"memberOf", "cn=staff,dc=example,dc=com"),
+		ldaptest.NewEntry("uid=carol,dc=example,dc=com", "objectClass", "person", "uid", "carol", "userPassword", "password"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	directory := ldap.NewDirectory(ldap.Config{URL: server.URL(), BaseDN: "dc=example,dc=com"})
+
+	tests := []struct {
+		name        string
+		username    string
+		roleBefore  string // of an account already linked, if any
+		adminGroups []string
+		userGroups  []string
+		role        string
+		err         error
+	}{
+		{"no mapping leaves a hand-made admin", "bob", domain.UserRoleAdmin, nil, nil, domain.UserRoleAdmin, nil},
+		{"no mapping provisions users", "bob", "", nil, nil, domain.UserRoleAuthenticated, nil},
+		{"admin group promotes", "alice", domain.UserRoleAuthenticated, []string{"admins"}, nil, domain.UserRoleAdmin, nil},
+		{"admin group demotes", "bob", domain.UserRoleAdmin, []string{"admins"}, nil, domain.UserRoleAuthenticated, nil},
+		{"user groups alone leave a hand-made admin", "bob", domain.UserRoleAdmin, nil, []string{"staff"}, domain.UserRoleAdmin, nil},
+		{"user groups keep others out", "carol", "", nil, []string{"staff"}, "", ErrInvalidCredentials},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			users := &memoryUsers{users: make(map[string]*domain.User)}
+			identities := newMemoryIdentities(users)
+			if tt.roleBefore != "" {
+				users.users["existing"] = &domain.User{ID: "existing", Username: tt.username, Role: tt.roleBefore}
+				identities.identities[server.URL()+" "+strings.ToLower("uid="+tt.username+",dc=example,dc=com")] = "existing"
+			}
+			backend := &ldapBackend{
+				directory:   directory,
+				userRepo:    users,
+				provisioner: newAccountProvisioner(users, identities),
+				adminGroups: tt.adminGroups,
+				userGroups:  tt.userGroups,
+			}
+
+			user, err := backend.Authenticate(context.Background(), tt.username, "password")
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("Authenticate = %v, want %v", err, tt.err)
+			}
+			if err == nil && user.Role != tt.role {
+				t.Fatalf("role = %q, want %q", user.Role, tt.role)
+			}
+		})
+	}
+}
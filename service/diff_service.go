@@ -0,0 +1,85 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"rtdocs/diff"
+	"rtdocs/model/web"
+	"rtdocs/repository"
+
+	"github.com/google/uuid"
+)
+
+// LiveVersion refers to the current content of a document rather than a revision
+const LiveVersion = "live"
+
+// diffContext is the number of unchanged lines kept around each change
+const diffContext = 3
+
+var ErrInvalidVersion = errors.New("versions must be revision IDs or live")
+
+type DiffService interface {
+	Diff(ctx context.Context, documentID, from, to string) (*web.DiffResponse, error)
+}
+
+type diffService struct {
+	revisionRepo repository.RevisionRepository
+	docService   DocumentService
+}
+
+func NewDiffService(revisionRepo repository.RevisionRepository, docService DocumentService) DiffService {
+	return &diffService{revisionRepo: revisionRepo, docService: docService}
+}
+
+// Diff compares two versions of a document. Each version is a revision ID or
+// LiveVersion; an empty to means the live document. It returns
+// ErrInvalidVersion for anything else and ErrRevisionNotFound for revisions
+// the document does not have.
+func (s *diffService) Diff(ctx context.Context, documentID, from, to string) (*web.DiffResponse, error) {
+	if to == "" {
+		to = LiveVersion
+	}
+	for _, version := range []string{from, to} {
+		if _, err := uuid.Parse(version); err != nil && version != LiveVersion {
+			return nil, ErrInvalidVersion
+		}
+	}
+
+	oldContent, err := s.content(ctx, documentID, from)
+	if err != nil {
+		return nil, err
+	}
+	newContent, err := s.content(ctx, documentID, to)
+	if err != nil {
+		return nil, err
+	}
+
+	hunks := diff.Hunks(oldContent, newContent, diffContext)
+	if hunks == nil {
+		hunks = []diff.Hunk{}
+	}
+
+	return &web.DiffResponse{
+		DocumentID: documentID,
+		From:       from,
+		To:         to,
+		Unified:    diff.Unified(from, to, hunks),
+		Hunks:      hunks,
+	}, nil
+}
+
+func (s *diffService) content(ctx context.Context, documentID, version string) (string, error) {
+	if version == LiveVersion {
+		document, err := s.docService.GetDocument(ctx, documentID)
+		if err != nil {
+			return "", err
+		}
+		return document.Content, nil
+	}
+
+	revision, err := getRevision(ctx, s.revisionRepo, documentID, version)
+	if err != nil {
+		return "", err
+	}
+	return revision.Content, nil
+}
@@ -0,0 +1,51 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"rtdocs/model/domain"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestDiffVersions(t *testing.T) {
+	ctx := context.Background()
+	revisions := &memoryRevisions{}
+	docs := &storedDocuments{documents: map[string]*domain.Document{
+		"doc": {ID: "doc", Content: "one\nthree\n"},
+	}}
+	s := NewDiffService(revisions, docs)
+
+	first, _ := revisions.CreateRevision(ctx, &domain.Revision{ID: uuid.New().String(), DocumentID: "doc", Content: "one\n"})
+	second, _ := revisions.CreateRevision(ctx, &domain.Revision{ID: uuid.New().String(), DocumentID: "doc", Content: "one\ntwo\n"})
+	other, _ := revisions.CreateRevision(ctx, &domain.Revision{ID: uuid.New().String(), DocumentID: "other", Content: "elsewhere\n"})
+
+	tests := []struct {
+		name     string
+		from, to string
+		hunks    int
+		wantErr  error
+	}{
+		{"two revisions", first.ID, second.ID, 1, nil},
+		{"revision to live", second.ID, "", 1, nil},
+		{"live to live", LiveVersion, LiveVersion, 0, nil},
+		{"unknown revision", uuid.New().String(), "", 0, ErrRevisionNotFound},
+		{"another document's revision", first.ID, other.ID, 0, ErrRevisionNotFound},
+		{"malformed from", "not-a-uuid", "", 0, ErrInvalidVersion},
+		{"malformed to", first.ID, "latest", 0, ErrInvalidVersion},
+		{"missing from", "", "", 0, ErrInvalidVersion},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			diff, err := s.Diff(ctx, "doc", tt.from, tt.to)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Diff error = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && len(diff.Hunks) != tt.hunks {
+				t.Fatalf("Diff has %d hunks, want %d:\n%s", len(diff.Hunks), tt.hunks, diff.Unified)
+			}
+		})
+	}
+}
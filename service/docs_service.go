@@ -2,8 +2,11 @@ package service
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"rtdocs/model/domain"
 	"rtdocs/model/web"
+	"rtdocs/realtime"
 	"rtdocs/repository"
 	"time"
 
@@ -13,16 +16,25 @@ import (
 type DocumentService interface {
 	GetDocument(ctx context.Context, id string) (*domain.Document, error)
 	GetAllDocuments(ctx context.Context) ([]*domain.Document, error)
+	GetDocumentsForUser(ctx context.Context, userID string) ([]*domain.Document, error)
 	CreateDocument(ctx context.Context, newDoc *web.CreateDocument) (*domain.Document, error)
 	UpdateDocument(ctx context.Context, updatedDoc *domain.Document) (*domain.Document, error)
+	SaveContent(ctx context.Context, id, title, content string) (*domain.Document, error)
+	ShareDocument(ctx context.Context, id, actorID string, request *web.ShareDocument) (*domain.Document, error)
+	LoadSequence(ctx context.Context, document *domain.Document) (*realtime.Sequence, error)
+	AppendSequenceUpdates(ctx context.Context, id string, updates []realtime.Update) error
+	SaveSequence(ctx context.Context, id string, state []byte) error
+	DeleteDocument(ctx context.Context, id string) error
+	TransferDocuments(ctx context.Context, fromUserID, toUserID string) (int64, error)
 }
 
 type documentService struct {
-	repo repository.DocumentRepository
+	repo   repository.DocumentRepository
+	policy VerificationPolicy
 }
 
-func NewDocumentService(repo repository.DocumentRepository) DocumentService {
-	return &documentService{repo: repo}
+func NewDocumentService(repo repository.DocumentRepository, policy VerificationPolicy) DocumentService {
+	return &documentService{repo: repo, policy: policy}
 }
 
 func (s *documentService) GetDocument(ctx context.Context, id string) (*domain.Document, error) {
@@ -33,7 +45,15 @@ func (s *documentService) GetAllDocuments(ctx context.Context) ([]*domain.Docume
 	return s.repo.GetAllDocuments(ctx)
 }
 
+func (s *documentService) GetDocumentsForUser(ctx context.Context, userID string) ([]*domain.Document, error) {
+	return s.repo.GetDocumentsForUser(ctx, userID)
+}
+
 func (s *documentService) CreateDocument(ctx context.Context, request *web.CreateDocument) (*domain.Document, error) {
+	if err := s.policy.Require(ctx, request.OwnerID, ActionCreateDocument); err != nil {
+		return nil, err
+	}
+
 	var newDoc domain.Document
 	newDoc.ID = uuid.New().String()
 	if request.Title == "" {
@@ -43,6 +63,14 @@ func (s *documentService) CreateDocument(ctx context.Context, request *web.Creat
 	}
 	newDoc.Content = request.Content
 	newDoc.OwnerID = request.OwnerID
+	switch request.SyncMode {
+	case "":
+		newDoc.SyncMode = realtime.ModeOT
+	case realtime.ModeOT, realtime.ModeCRDT:
+		newDoc.SyncMode = request.SyncMode
+	default:
+		return nil, errors.New("sync mode must be ot or crdt")
+	}
 	newDoc.IsPublic = false
 	newDoc.CanEdit = true
 	newDoc.CreatedAt = time.Now()
@@ -58,3 +86,93 @@ func (s *documentService) UpdateDocument(ctx context.Context, updatedDoc *domain
 
 	return s.repo.UpdateDocument(ctx, updatedDoc)
 }
+
+// SaveContent saves edits made in the editor; it returns nil if the document was deleted
+func (s *documentService) SaveContent(ctx context.Context, id, title, content string) (*domain.Document, error) {
+	if title == "" {
+		title = "Untitled Document"
+	}
+
+	return s.repo.SaveContent(ctx, id, title, content, time.Now())
+}
+
+// ShareDocument changes whether a document is public; making it public may
+// need the actor to have verified their email address
+func (s *documentService) ShareDocument(ctx context.Context, id, actorID string, request *web.ShareDocument) (*domain.Document, error) {
+	if request.IsPublic {
+		if err := s.policy.Require(ctx, actorID, ActionSharePublic); err != nil {
+			return nil, err
+		}
+	}
+
+	document := &domain.Document{
+		ID:        id,
+		IsPublic:  request.IsPublic,
+		CanEdit:   request.CanEdit,
+		UpdatedAt: time.Now(),
+	}
+
+	return s.repo.ShareDocument(ctx, document)
+}
+
+// LoadSequence restores a CRDT document from its last compacted state and the
+// updates logged since. A document without any CRDT state yet is seeded from
+// its content and the seed is saved so the update log always has a base.
+func (s *documentService) LoadSequence(ctx context.Context, document *domain.Document) (*realtime.Sequence, error) {
+	state, updates, err := s.repo.GetCRDTState(ctx, document.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	if state == nil && len(updates) == 0 {
+		sequence := realtime.SequenceFromText(document.Content, realtime.ServerSite)
+		encoded, err := sequence.Encode()
+		if err != nil {
+			return nil, err
+		}
+		if err := s.repo.SaveCRDTState(ctx, document.ID, encoded); err != nil {
+			return nil, err
+		}
+		return sequence, nil
+	}
+
+	sequence := realtime.NewSequence()
+	if state != nil {
+		if sequence, err = realtime.DecodeSequence(state); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, payload := range updates {
+		var batch []realtime.Update
+		if err := json.Unmarshal(payload, &batch); err != nil {
+			return nil, err
+		}
+		if _, err := sequence.Integrate(batch); err != nil {
+			return nil, err
+		}
+	}
+
+	return sequence, nil
+}
+
+func (s *documentService) AppendSequenceUpdates(ctx context.Context, id string, updates []realtime.Update) error {
+	payload, err := json.Marshal(updates)
+	if err != nil {
+		return err
+	}
+	return s.repo.AppendCRDTUpdate(ctx, id, payload)
+}
+
+func (s *documentService) SaveSequence(ctx context.Context, id string, state []byte) error {
+	return s.repo.SaveCRDTState(ctx, id, state)
+}
+
+func (s *documentService) DeleteDocument(ctx context.Context, id string) error {
+	return s.repo.DeleteDocument(ctx, id)
+}
+
+// TransferDocuments gives every document of one user to another
+func (s *documentService) TransferDocuments(ctx context.Context, fromUserID, toUserID string) (int64, error) {
+	return s.repo.TransferDocuments(ctx, fromUserID, toUserID)
+}
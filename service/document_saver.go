@@ -0,0 +1,189 @@
+package service
+
+import (
+	"context"
+	"log"
+	"rtdocs/realtime"
+	"rtdocs/utils"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultSaveDelay is used when SAVE_DELAY is unset or invalid
+	defaultSaveDelay = 2 * time.Second
+
+	// saveTimeout bounds the writes of one save, which outlive the request that queued them
+	saveTimeout = 30 * time.Second
+)
+
+// DocumentSaver persists the shared state of open documents off the edit
+// path. Edits are committed while the document is locked, so instead of
+// writing to the database there they queue the new state, and the saver
+// writes the latest one once edits pause for SAVE_DELAY. Writes for a
+// document happen one at a time and in order.
+type DocumentSaver interface {
+	// Save queues state to be written. updates are the CRDT updates merged
+	// into it; when state carries an encoded sequence it replaces the log.
+	Save(documentID, authorID string, state realtime.State, updates []realtime.Update)
+	// Flush writes anything queued for the document and waits until it is
+	// stored, as before the document is read back from the database
+	Flush(documentID string)
+	// Close flushes a document whose room has closed and records a revision
+	// of its final content, which the revision interval may have held back
+	Close(documentID string)
+}
+
+type documentSaver struct {
+	docService      DocumentService
+	revisionService RevisionService
+	delay           time.Duration
+
+	mu      sync.Mutex
+	pending map[string]*pendingSave
+	// authors is who last edited each document since it was opened
+	authors map[string]string
+}
+
+// pendingSave is what is queued for one document. write is held while it is
+// being written so that writes for the document never overlap.
+type pendingSave struct {
+	write sync.Mutex
+
+	dirty    bool
+	authorID string
+	state    realtime.State
+	encoded  []byte
+	updates  []realtime.Update
+	timer    *time.Timer
+}
+
+func NewDocumentSaver(docService DocumentService, revisionService RevisionService) DocumentSaver {
+	delay, err := time.ParseDuration(utils.GetEnv("SAVE_DELAY"))
+	if err != nil || delay <= 0 {
+		delay = defaultSaveDelay
+	}
+
+	return &documentSaver{
+		docService:      docService,
+		revisionService: revisionService,
+		delay:           delay,
+		pending:         make(map[string]*pendingSave),
+		authors:         make(map[string]string),
+	}
+}
+
+func (s *documentSaver) Save(documentID, authorID string, state realtime.State, updates []realtime.Update) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	save, ok := s.pending[documentID]
+	if !ok {
+		save = &pendingSave{}
+		s.pending[documentID] = save
+	}
+
+	s.authors[documentID] = authorID
+	save.dirty = true
+	save.authorID = authorID
+	save.state = state
+	if state.Encoded != nil {
+		// The encoded sequence already holds every update queued so far
+		save.encoded = state.Encoded
+		save.updates = nil
+	} else {
+		save.updates = append(save.updates, updates...)
+	}
+
+	if save.timer == nil {
+		save.timer = time.AfterFunc(s.delay, func() { s.Flush(documentID) })
+	}
+}
+
+func (s *documentSaver) Flush(documentID string) {
+	s.mu.Lock()
+	save, ok := s.pending[documentID]
+	s.mu.Unlock()
+	if !ok {
+		return
+	}
+
+	save.write.Lock()
+	defer save.write.Unlock()
+
+	s.mu.Lock()
+	dirty, authorID, state, encoded, updates := save.dirty, save.authorID, save.state, save.encoded, save.updates
+	save.dirty, save.encoded, save.updates = false, nil, nil
+	if save.timer != nil {
+		save.timer.Stop()
+		save.timer = nil
+	}
+	s.mu.Unlock()
+
+	if dirty {
+		s.write(documentID, authorID, state, encoded, updates)
+	}
+
+	s.mu.Lock()
+	if !save.dirty && s.pending[documentID] == save {
+		delete(s.pending, documentID)
+	}
+	s.mu.Unlock()
+}
+
+func (s *documentSaver) Close(documentID string) {
+	s.Flush(documentID)
+
+	s.mu.Lock()
+	authorID, edited := s.authors[documentID]
+	delete(s.authors, documentID)
+	s.mu.Unlock()
+	if !edited {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), saveTimeout)
+	defer cancel()
+
+	document, err := s.docService.GetDocument(ctx, documentID)
+	if err != nil || document == nil {
+		return
+	}
+	if _, err := s.revisionService.Checkpoint(ctx, document, authorID); err != nil {
+		log.Printf("Failed to snapshot revision: %v", err)
+	}
+}
+
+// write stores the CRDT log, then the title and content, and snapshots a
+// revision when one is due
+func (s *documentSaver) write(documentID, authorID string, state realtime.State, encoded []byte, updates []realtime.Update) {
+	ctx, cancel := context.WithTimeout(context.Background(), saveTimeout)
+	defer cancel()
+
+	if encoded != nil {
+		if err := s.docService.SaveSequence(ctx, documentID, encoded); err != nil {
+			log.Printf("Failed to save document state: %v", err)
+			return
+		}
+	}
+	if len(updates) > 0 {
+		if err := s.docService.AppendSequenceUpdates(ctx, documentID, updates); err != nil {
+			log.Printf("Failed to save document updates: %v", err)
+			return
+		}
+	}
+
+	document, err := s.docService.SaveContent(ctx, documentID, state.Title, state.Content)
+	if err != nil {
+		log.Printf("Failed to update document: %v", err)
+		return
+	}
+	if document == nil {
+		log.Printf("Document %s was deleted while being edited", documentID)
+		return
+	}
+
+	if _, err := s.revisionService.Snapshot(ctx, document, authorID); err != nil {
+		log.Printf("Failed to snapshot revision: %v", err)
+	}
+}
@@ -0,0 +1,171 @@
+package service
+
+import (
+	"context"
+	"rtdocs/model/domain"
+	"rtdocs/realtime"
+	"sync"
+	"testing"
+	"time"
+)
+
+// savedDocuments records what a documentSaver writes
+type savedDocuments struct {
+	DocumentService
+
+	mu      sync.Mutex
+	writes  []string
+	content string
+	updates []realtime.Update
+	encoded []byte
+}
+
+func (s *savedDocuments) SaveContent(ctx context.Context, id, title, content string) (*domain.Document, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.writes = append(s.writes, "content")
+	s.content = content
+	return &domain.Document{ID: id, Title: title, Content: content}, nil
+}
+
+func (s *savedDocuments) AppendSequenceUpdates(ctx context.Context, id string, updates []realtime.Update) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.writes = append(s.writes, "updates")
+	s.updates = append(s.updates, updates...)
+	return nil
+}
+
+func (s *savedDocuments) SaveSequence(ctx context.Context, id string, state []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.writes = append(s.writes, "sequence")
+	s.encoded, s.updates = state, nil
+	return nil
+}
+
+func (s *savedDocuments) GetDocument(ctx context.Context, id string) (*domain.Document, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return &domain.Document{ID: id, Content: s.content}, nil
+}
+
+// checkpoints records the revisions a documentSaver asks for outside the interval
+type checkpoints struct {
+	RevisionService
+	contents []string
+}
+
+func (*checkpoints) Snapshot(ctx context.Context, document *domain.Document, authorID string) (*domain.Revision, error) {
+	return nil, nil
+}
+
+func (c *checkpoints) Checkpoint(ctx context.Context, document *domain.Document, authorID string) (*domain.Revision, error) {
+	c.contents = append(c.contents, document.Content)
+	return nil, nil
+}
+
+func newTestSaver(delay time.Duration) (*documentSaver, *savedDocuments) {
+	docs := &savedDocuments{}
+	return &documentSaver{
+		docService:      docs,
+		revisionService: &checkpoints{},
+		delay:           delay,
+		pending:         make(map[string]*pendingSave),
+		authors:         make(map[string]string),
+	}, docs
+}
+
+func TestSaverWritesLatestStateOnce(t *testing.T) {
+	saver, docs := newTestSaver(time.Hour)
+
+	for _, content := range []string{"a", "ab", "abc"} {
+		saver.Save("doc", "user", realtime.State{Content: content}, nil)
+	}
+	if len(docs.writes) != 0 {
+		t.Fatalf("wrote %v before the delay", docs.writes)
+	}
+
+	saver.Flush("doc")
+	if len(docs.writes) != 1 || docs.content != "abc" {
+		t.Fatalf("writes = %v with content %q, want one write of \"abc\"", docs.writes, docs.content)
+	}
+
+	saver.Flush("doc")
+	if len(docs.writes) != 1 {
+		t.Fatalf("a second flush wrote again: %v", docs.writes)
+	}
+	if len(saver.pending) != 0 {
+		t.Fatal("a flushed document is still pending")
+	}
+}
+
+func TestSaverWritesAfterDelay(t *testing.T) {
+	saver, docs := newTestSaver(10 * time.Millisecond)
+	saver.Save("doc", "user", realtime.State{Content: "a"}, nil)
+
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		docs.mu.Lock()
+		content := docs.content
+		docs.mu.Unlock()
+		if content == "a" {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatal("nothing was written after the save delay")
+}
+
+func TestSaverKeepsUpdatesAfterCompaction(t *testing.T) {
+	saver, docs := newTestSaver(time.Hour)
+
+	first := []realtime.Update{{}}
+	second := []realtime.Update{{}, {}}
+	saver.Save("doc", "user", realtime.State{Content: "a"}, first)
+	saver.Save("doc", "user", realtime.State{Content: "ab", Encoded: []byte("state")}, second)
+	saver.Save("doc", "user", realtime.State{Content: "abc"}, first)
+	saver.Flush("doc")
+
+	want := []string{"sequence", "updates", "content"}
+	if len(docs.writes) != len(want) {
+		t.Fatalf("writes = %v, want %v", docs.writes, want)
+	}
+	for i := range want {
+		if docs.writes[i] != want[i] {
+			t.Fatalf("writes = %v, want %v", docs.writes, want)
+		}
+	}
+	if string(docs.encoded) != "state" || len(docs.updates) != 1 {
+		t.Fatalf("stored state %q with %d updates, want the compacted state and 1 update", docs.encoded, len(docs.updates))
+	}
+}
+
+func TestSaverCheckpointsOnClose(t *testing.T) {
+	saver, docs := newTestSaver(time.Hour)
+	revisions := saver.revisionService.(*checkpoints)
+
+	// Nobody edited, so there is nothing to record
+	saver.Close("doc")
+	if len(revisions.contents) != 0 {
+		t.Fatalf("checkpointed %v without edits", revisions.contents)
+	}
+
+	saver.Save("doc", "user", realtime.State{Content: "final"}, nil)
+	saver.Close("doc")
+	if docs.content != "final" {
+		t.Fatalf("content = %q, want the queued edit written", docs.content)
+	}
+	if len(revisions.contents) != 1 || revisions.contents[0] != "final" {
+		t.Fatalf("checkpoints = %v, want one of the final content", revisions.contents)
+	}
+
+	saver.Close("doc")
+	if len(revisions.contents) != 1 {
+		t.Fatal("a second close checkpointed again")
+	}
+}
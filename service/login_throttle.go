@@ -0,0 +1,179 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"rtdocs/model/domain"
+	"rtdocs/model/web"
+	"rtdocs/repository"
+	"rtdocs/utils"
+	"strings"
+	"time"
+)
+
+// throttlePolicy slows down and then locks out logins after repeated failures.
+// After backoffAfter failures each further attempt must wait twice as long as
+// the last, up to maxBackoff; after lockoutAfter no attempt is allowed until
+// lockout has passed since the last failure. Failures older than lockout are forgotten.
+type throttlePolicy struct {
+	backoffAfter int
+	lockoutAfter int
+	maxBackoff   time.Duration
+	lockout      time.Duration
+}
+
+var (
+	// usernameThrottle protects a single account from password guessing
+	usernameThrottle = throttlePolicy{backoffAfter: 3, lockoutAfter: 10, maxBackoff: time.Minute, lockout: 15 * time.Minute}
+	// ipThrottle stops one address guessing across many accounts. It is looser
+	// because many users can share an address behind NAT.
+	ipThrottle = throttlePolicy{backoffAfter: 20, lockoutAfter: 100, maxBackoff: time.Minute, lockout: 15 * time.Minute}
+)
+
+var ErrTooManyAttempts = errors.New("too many failed login attempts")
+
+// ThrottledError is returned while a login is being held back; RetryAfter is
+// how long until the next attempt is allowed
+type ThrottledError struct {
+	RetryAfter time.Duration
+}
+
+func (e *ThrottledError) Error() string {
+	return fmt.Sprintf("%v; try again in %s", ErrTooManyAttempts, e.RetryAfter.Round(time.Second))
+}
+
+func (e *ThrottledError) Unwrap() error {
+	return ErrTooManyAttempts
+}
+
+// LoginThrottle tracks failed logins per username and per IP address and
+// holds back further attempts with exponential backoff and then a lockout.
+// Every attempt is also recorded in an audit log.
+type LoginThrottle interface {
+	Check(ctx context.Context, username string, client web.Client) error
+	RecordFailure(ctx context.Context, username, userID string, client web.Client, reason string)
+	RecordSuccess(ctx context.Context, username, userID string, client web.Client)
+	Forgive(ctx context.Context, username string, client web.Client)
+}
+
+type loginThrottle struct {
+	attemptRepo repository.LoginAttemptRepository
+}
+
+func NewLoginThrottle(attemptRepo repository.LoginAttemptRepository) LoginThrottle {
+	return &loginThrottle{attemptRepo: attemptRepo}
+}
+
+// Check counts the attempt as a failure up front, then returns a
+// *ThrottledError if the username or the client's address has to wait before
+// trying again. Counting first means concurrent attempts cannot all get in on
+// the count from before any of them failed. Refused attempts count as well and
+// are recorded, so trying again early only makes the wait longer.
+func (t *loginThrottle) Check(ctx context.Context, username string, client web.Client) error {
+	now := time.Now()
+	username = normalizeUsername(username)
+
+	failures, last, err := t.attemptRepo.CountFailure(ctx, usernameKey(username), now, now.Add(-usernameThrottle.lockout))
+	if err != nil {
+		return err
+	}
+	wait := usernameThrottle.wait(failures, last, now)
+
+	if client.IPAddress != "" {
+		failures, last, err := t.attemptRepo.CountFailure(ctx, ipKey(client.IPAddress), now, now.Add(-ipThrottle.lockout))
+		if err != nil {
+			return err
+		}
+		wait = max(wait, ipThrottle.wait(failures, last, now))
+	}
+
+	if wait <= 0 {
+		return nil
+	}
+	t.record(ctx, username, "", client, false, domain.LoginFailureThrottled)
+	return &ThrottledError{RetryAfter: wait}
+}
+
+// RecordFailure records a failed attempt, which Check has already counted
+func (t *loginThrottle) RecordFailure(ctx context.Context, username, userID string, client web.Client, reason string) {
+	t.record(ctx, normalizeUsername(username), userID, client, false, reason)
+}
+
+// RecordSuccess records a completed login, which clears the username's
+// failures. The address only gets back the attempt Check counted, so logging
+// in to one account cannot be used to keep guessing at others.
+func (t *loginThrottle) RecordSuccess(ctx context.Context, username, userID string, client web.Client) {
+	username = normalizeUsername(username)
+	t.record(ctx, username, userID, client, true, "")
+
+	if err := t.attemptRepo.ClearFailures(ctx, usernameKey(username)); err != nil {
+		utils.NewLogger().Errorw("Failed to clear login failures", "username", username, "error", err)
+	}
+	if client.IPAddress != "" {
+		t.forgive(ctx, ipKey(client.IPAddress))
+	}
+}
+
+// Forgive takes back the failure Check counted for an attempt that neither
+// failed nor completed a login, such as a right password waiting for a
+// second factor
+func (t *loginThrottle) Forgive(ctx context.Context, username string, client web.Client) {
+	t.forgive(ctx, usernameKey(normalizeUsername(username)))
+	if client.IPAddress != "" {
+		t.forgive(ctx, ipKey(client.IPAddress))
+	}
+}
+
+func (t *loginThrottle) forgive(ctx context.Context, key string) {
+	if err := t.attemptRepo.ForgiveFailure(ctx, key); err != nil {
+		utils.NewLogger().Errorw("Failed to forgive login failure", "key", key, "error", err)
+	}
+}
+
+// record stores an attempt. A failure to do so is logged rather than
+// failing the login, which has already been decided.
+func (t *loginThrottle) record(ctx context.Context, username, userID string, client web.Client, succeeded bool, reason string) {
+	attempt := &domain.LoginAttempt{
+		Username:    username,
+		UserID:      userID,
+		IPAddress:   client.IPAddress,
+		UserAgent:   client.UserAgent,
+		Succeeded:   succeeded,
+		Reason:      reason,
+		AttemptedAt: time.Now(),
+	}
+	if err := t.attemptRepo.RecordLoginAttempt(ctx, attempt); err != nil {
+		utils.NewLogger().Errorw("Failed to record login attempt", "username", username, "error", err)
+	}
+}
+
+// wait returns how long after now the next attempt must wait, given the
+// number of recent failures and when the last one was
+func (p throttlePolicy) wait(failures int, last *time.Time, now time.Time) time.Duration {
+	if last == nil || failures < p.backoffAfter {
+		return 0
+	}
+
+	delay := p.lockout
+	if failures < p.lockoutAfter {
+		// Capping the shift keeps it from overflowing; maxBackoff is far below it anyway
+		delay = min(time.Second<<min(failures-p.backoffAfter, 30), p.maxBackoff)
+	}
+	return last.Add(delay).Sub(now)
+}
+
+// usernameKey and ipKey name the failure counts of a username and an address.
+// Usernames are cut short to fit; no account has one that long anyway.
+func usernameKey(username string) string {
+	return "username:" + truncate(username, 255)
+}
+
+func ipKey(ipAddress string) string {
+	return "ip:" + ipAddress
+}
+
+// normalizeUsername makes attempts on "Alice" and "alice" count against the same username
+func normalizeUsername(username string) string {
+	return strings.ToLower(strings.TrimSpace(username))
+}
@@ -0,0 +1,165 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"rtdocs/model/domain"
+	"rtdocs/model/web"
+	"rtdocs/repository"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memoryAttempts keeps failure counts in memory. Its mutex makes CountFailure
+// atomic, as the upsert is in the database.
+type memoryAttempts struct {
+	repository.LoginAttemptRepository
+
+	mu       sync.Mutex
+	counts   map[string]int
+	last     map[string]time.Time
+	attempts []*domain.LoginAttempt
+}
+
+func newMemoryAttempts() *memoryAttempts {
+	return &memoryAttempts{counts: make(map[string]int), last: make(map[string]time.Time)}
+}
+
+func (m *memoryAttempts) RecordLoginAttempt(ctx context.Context, attempt *domain.LoginAttempt) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.attempts = append(m.attempts, attempt)
+	return nil
+}
+
+func (m *memoryAttempts) CountFailure(ctx context.Context, key string, now, since time.Time) (int, *time.Time, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	count, previous := 0, (*time.Time)(nil)
+	if last, ok := m.last[key]; ok && last.After(since) {
+		count, previous = m.counts[key], &last
+	}
+	m.counts[key] = count + 1
+	m.last[key] = now
+	return count, previous, nil
+}
+
+func (m *memoryAttempts) ForgiveFailure(ctx context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.counts[key] > 0 {
+		m.counts[key]--
+	}
+	return nil
+}
+
+func (m *memoryAttempts) ClearFailures(ctx context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.counts, key)
+	delete(m.last, key)
+	return nil
+}
+
+func TestConcurrentAttemptsAreCounted(t *testing.T) {
+	throttle := NewLoginThrottle(newMemoryAttempts())
+	client := web.Client{IPAddress: "203.0.113.7"}
+
+	// Twenty guesses at once, none of which has failed yet when the others are checked
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	admitted := 0
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := throttle.Check(context.Background(), "alice", client); err == nil {
+				mu.Lock()
+				admitted++
+				mu.Unlock()
+			} else if !errors.Is(err, ErrTooManyAttempts) {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if admitted != usernameThrottle.backoffAfter {
+		t.Fatalf("%d concurrent attempts got in, want %d", admitted, usernameThrottle.backoffAfter)
+	}
+}
+
+func TestSuccessClearsUsernameButNotAddress(t *testing.T) {
+	attempts := newMemoryAttempts()
+	throttle := NewLoginThrottle(attempts)
+	ctx := context.Background()
+	client := web.Client{IPAddress: "203.0.113.7"}
+
+	for i := 0; i < 2; i++ {
+		if err := throttle.Check(ctx, "Alice", client); err != nil {
+			t.Fatal(err)
+		}
+		throttle.RecordFailure(ctx, "Alice", "", client, domain.LoginFailureBadPassword)
+	}
+	if err := throttle.Check(ctx, "alice", client); err != nil {
+		t.Fatal(err)
+	}
+	throttle.RecordSuccess(ctx, "alice", "alice-id", client)
+
+	if n := attempts.counts[usernameKey("alice")]; n != 0 {
+		t.Errorf("username has %d failures after logging in, want 0", n)
+	}
+	if n := attempts.counts[ipKey(client.IPAddress)]; n != 2 {
+		t.Errorf("address has %d failures after logging in, want the 2 before", n)
+	}
+}
+
+func TestForgiveTakesBackTheCheck(t *testing.T) {
+	attempts := newMemoryAttempts()
+	throttle := NewLoginThrottle(attempts)
+	ctx := context.Background()
+	client := web.Client{IPAddress: "203.0.113.7"}
+
+	// A password that was right, followed by a second factor that was right
+	if err := throttle.Check(ctx, "alice", client); err != nil {
+		t.Fatal(err)
+	}
+	throttle.Forgive(ctx, "alice", client)
+	if err := throttle.Check(ctx, "alice", client); err != nil {
+		t.Fatal(err)
+	}
+	throttle.RecordSuccess(ctx, "alice", "alice-id", client)
+
+	if n := attempts.counts[ipKey(client.IPAddress)]; n != 0 {
+		t.Fatalf("a two-step login left %d failures on the address", n)
+	}
+}
+
+func TestThrottleWait(t *testing.T) {
+	now := time.Now()
+	last := now.Add(-time.Second)
+
+	tests := []struct {
+		name     string
+		failures int
+		last     *time.Time
+		want     time.Duration
+	}{
+		{"no failures", 0, nil, 0},
+		{"below backoff", 2, &last, 0},
+		{"first backoff has passed", 3, &last, 0},
+		{"second backoff", 4, &last, time.Second},
+		{"capped backoff", 9, &last, time.Minute - time.Second},
+		{"locked out", 10, &last, 15*time.Minute - time.Second},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := usernameThrottle.wait(tt.failures, tt.last, now); got != tt.want {
+				t.Fatalf("wait = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
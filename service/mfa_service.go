@@ -0,0 +1,257 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/hex"
+	"errors"
+	"rtdocs/model/domain"
+	"rtdocs/model/web"
+	"rtdocs/repository"
+	"rtdocs/utils"
+	"strings"
+	"time"
+)
+
+const (
+	// totpIssuer is the name authenticator apps list rtdocs accounts under
+	totpIssuer = "rtdocs"
+
+	recoveryCodeCount = 10
+	// recoveryCodeBytes is the randomness in each recovery code, 80 bits, which
+	// encodes to 16 characters
+	recoveryCodeBytes = 10
+	// recoveryCodeSaltBytes is the size of the random salt stored with each code's hash
+	recoveryCodeSaltBytes = 16
+)
+
+var (
+	ErrMFAUnavailable    = errors.New("two-factor authentication is not available for guest accounts")
+	ErrMFAAlreadyEnabled = errors.New("two-factor authentication is already enabled")
+	ErrMFANotEnrolled    = errors.New("two-factor authentication has not been set up")
+	ErrInvalidMFACode    = errors.New("invalid authentication code")
+)
+
+var recoveryCodeEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+type MFAService interface {
+	IsEnabled(ctx context.Context, userID string) (bool, error)
+	Enroll(ctx context.Context, userID string) (*web.TOTPEnrollment, error)
+	Confirm(ctx context.Context, userID, code string) (*web.RecoveryCodes, error)
+	Disable(ctx context.Context, userID, code string) error
+	RegenerateRecoveryCodes(ctx context.Context, userID, code string) (*web.RecoveryCodes, error)
+	Verify(ctx context.Context, userID, code string) error
+	UseChallenge(ctx context.Context, challenge *utils.Principal) (bool, error)
+}
+
+type mfaService struct {
+	mfaRepo  repository.MFARepository
+	userRepo repository.UserRepository
+}
+
+func NewMFAService(mfaRepo repository.MFARepository, userRepo repository.UserRepository) MFAService {
+	return &mfaService{mfaRepo: mfaRepo, userRepo: userRepo}
+}
+
+// IsEnabled reports whether logging in as the user needs a second factor
+func (s *mfaService) IsEnabled(ctx context.Context, userID string) (bool, error) {
+	totp, err := s.mfaRepo.GetTOTP(ctx, userID)
+	if err != nil {
+		return false, err
+	}
+	return totp != nil && totp.EnabledAt != nil, nil
+}
+
+// Enroll generates a new TOTP secret for the user. It does nothing at login
+// until the user confirms it with a code from their authenticator app.
+func (s *mfaService) Enroll(ctx context.Context, userID string) (*web.TOTPEnrollment, error) {
+	user, err := s.userRepo.GetUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if user.Role == domain.UserRoleGuest {
+		return nil, ErrMFAUnavailable
+	}
+
+	enabled, err := s.IsEnabled(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if enabled {
+		return nil, ErrMFAAlreadyEnabled
+	}
+
+	secret, err := utils.GenerateTOTPSecret()
+	if err != nil {
+		return nil, err
+	}
+	if err := s.mfaRepo.SaveTOTP(ctx, userID, secret); err != nil {
+		return nil, err
+	}
+
+	return &web.TOTPEnrollment{
+		Secret:          secret,
+		ProvisioningURI: utils.TOTPURI(totpIssuer, user.Username, secret),
+	}, nil
+}
+
+// Confirm turns two-factor authentication on once the user shows their
+// authenticator produces valid codes, and returns their recovery codes
+func (s *mfaService) Confirm(ctx context.Context, userID, code string) (*web.RecoveryCodes, error) {
+	totp, err := s.mfaRepo.GetTOTP(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if totp == nil {
+		return nil, ErrMFANotEnrolled
+	}
+	if totp.EnabledAt != nil {
+		return nil, ErrMFAAlreadyEnabled
+	}
+
+	step, ok := utils.ValidateTOTP(totp.Secret, normalizeCode(code), time.Now())
+	if !ok {
+		return nil, ErrInvalidMFACode
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	enabled, err := s.mfaRepo.EnableTOTP(ctx, userID, step, hashes)
+	if err != nil {
+		return nil, err
+	}
+	if !enabled {
+		return nil, ErrMFAAlreadyEnabled
+	}
+
+	return &web.RecoveryCodes{Codes: codes}, nil
+}
+
+// Disable turns two-factor authentication off; the user must give a current code
+func (s *mfaService) Disable(ctx context.Context, userID, code string) error {
+	if err := s.Verify(ctx, userID, code); err != nil {
+		return err
+	}
+	return s.mfaRepo.DeleteTOTP(ctx, userID)
+}
+
+// RegenerateRecoveryCodes replaces the user's recovery codes, invalidating the old ones
+func (s *mfaService) RegenerateRecoveryCodes(ctx context.Context, userID, code string) (*web.RecoveryCodes, error) {
+	if err := s.Verify(ctx, userID, code); err != nil {
+		return nil, err
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	if err := s.mfaRepo.ReplaceRecoveryCodes(ctx, userID, hashes); err != nil {
+		return nil, err
+	}
+
+	return &web.RecoveryCodes{Codes: codes}, nil
+}
+
+// Verify checks a code from the user's authenticator app, or one of their
+// recovery codes. Either can only be used once.
+func (s *mfaService) Verify(ctx context.Context, userID, code string) error {
+	totp, err := s.mfaRepo.GetTOTP(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if totp == nil || totp.EnabledAt == nil {
+		return ErrMFANotEnrolled
+	}
+
+	code = normalizeCode(code)
+	if step, ok := utils.ValidateTOTP(totp.Secret, code, time.Now()); ok {
+		used, err := s.mfaRepo.UseTOTPStep(ctx, userID, step)
+		if err != nil {
+			return err
+		}
+		if !used {
+			return ErrInvalidMFACode
+		}
+		return nil
+	}
+
+	hashes, err := s.mfaRepo.GetRecoveryCodes(ctx, userID)
+	if err != nil {
+		return err
+	}
+	for _, hash := range hashes {
+		if !matchRecoveryCode(hash, code) {
+			continue
+		}
+		used, err := s.mfaRepo.UseRecoveryCode(ctx, userID, hash)
+		if err != nil {
+			return err
+		}
+		if !used {
+			return ErrInvalidMFACode
+		}
+		return nil
+	}
+	return ErrInvalidMFACode
+}
+
+// UseChallenge uses up the MFA token a login was finished with. It reports
+// false if the token has already been used, so it cannot be replayed with
+// another code while it is still valid.
+func (s *mfaService) UseChallenge(ctx context.Context, challenge *utils.Principal) (bool, error) {
+	return s.mfaRepo.UseChallenge(ctx, challenge.TokenID, challenge.UserID, challenge.ExpiresAt)
+}
+
+// newRecoveryCodes returns a fresh set of recovery codes and the hashes to store for them
+func newRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		b := make([]byte, recoveryCodeBytes)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, err
+		}
+		code := strings.ToLower(recoveryCodeEncoding.EncodeToString(b))
+		codes[i] = code[:4] + "-" + code[4:8] + "-" + code[8:12] + "-" + code[12:]
+
+		salt := make([]byte, recoveryCodeSaltBytes)
+		if _, err := rand.Read(salt); err != nil {
+			return nil, nil, err
+		}
+		hashes[i] = hashRecoveryCode(salt, code)
+	}
+	return codes, hashes, nil
+}
+
+// hashRecoveryCode returns the salt and the SHA-256 of the salted code, hex
+// encoded and separated by "$". The salt keeps equal codes from having equal
+// hashes; the code's own 80 bits are what make guessing it from the hash hopeless.
+func hashRecoveryCode(salt []byte, code string) string {
+	sum := sha256.Sum256(append(salt, code...))
+	return hex.EncodeToString(salt) + "$" + hex.EncodeToString(sum[:])
+}
+
+// matchRecoveryCode reports whether code is the one a stored hash was taken of
+func matchRecoveryCode(hash, code string) bool {
+	encodedSalt, _, ok := strings.Cut(hash, "$")
+	if !ok {
+		return false
+	}
+	salt, err := hex.DecodeString(encodedSalt)
+	if err != nil {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(hashRecoveryCode(salt, code)), []byte(hash)) == 1
+}
+
+// normalizeCode strips the spaces and dashes people type into codes, and
+// lowercases recovery codes to the form their hashes were taken of
+func normalizeCode(code string) string {
+	code = strings.NewReplacer(" ", "", "-", "").Replace(code)
+	return strings.ToLower(code)
+}
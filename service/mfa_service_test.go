@@ -0,0 +1,120 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"rtdocs/model/domain"
+	"rtdocs/repository"
+	"strings"
+	"testing"
+	"time"
+)
+
+// memoryMFA keeps one user's enrollment, recovery codes and used challenges in memory
+type memoryMFA struct {
+	repository.MFARepository
+	totp       *domain.TOTP
+	codes      map[string]bool // hash to whether it has been used
+	challenges map[string]bool
+}
+
+func (m *memoryMFA) GetTOTP(ctx context.Context, userID string) (*domain.TOTP, error) {
+	return m.totp, nil
+}
+
+func (m *memoryMFA) GetRecoveryCodes(ctx context.Context, userID string) ([]string, error) {
+	var hashes []string
+	for hash, used := range m.codes {
+		if !used {
+			hashes = append(hashes, hash)
+		}
+	}
+	return hashes, nil
+}
+
+func (m *memoryMFA) UseRecoveryCode(ctx context.Context, userID, codeHash string) (bool, error) {
+	used, ok := m.codes[codeHash]
+	if !ok || used {
+		return false, nil
+	}
+	m.codes[codeHash] = true
+	return true, nil
+}
+
+func (m *memoryMFA) ReplaceRecoveryCodes(ctx context.Context, userID string, codeHashes []string) error {
+	m.codes = make(map[string]bool)
+	for _, hash := range codeHashes {
+		m.codes[hash] = false
+	}
+	return nil
+}
+
+func (m *memoryMFA) UseChallenge(ctx context.Context, tokenID, userID string, expiresAt time.Time) (bool, error) {
+	if m.challenges[tokenID] {
+		return false, nil
+	}
+	m.challenges[tokenID] = true
+	return true, nil
+}
+
+func newMemoryMFA() *memoryMFA {
+	enabled := time.Now()
+	return &memoryMFA{
+		totp:       &domain.TOTP{Secret: "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ", EnabledAt: &enabled},
+		codes:      make(map[string]bool),
+		challenges: make(map[string]bool),
+	}
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	repo := newMemoryMFA()
+	mfa := &mfaService{mfaRepo: repo}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(codes) != recoveryCodeCount {
+		t.Fatalf("%d codes, want %d", len(codes), recoveryCodeCount)
+	}
+	for i, code := range codes {
+		if len(normalizeCode(code)) != 16 || strings.Count(code, "-") != 3 {
+			t.Fatalf("code %q is not four groups of four characters", code)
+		}
+		if strings.Contains(hashes[i], normalizeCode(code)) {
+			t.Fatal("a code is stored in the clear")
+		}
+	}
+	repo.ReplaceRecoveryCodes(context.Background(), "user", hashes)
+
+	// Codes are accepted however they are typed, but only once
+	typed := strings.ToUpper(strings.ReplaceAll(codes[0], "-", " "))
+	if err := mfa.Verify(context.Background(), "user", typed); err != nil {
+		t.Fatalf("Verify(%q) = %v", typed, err)
+	}
+	if err := mfa.Verify(context.Background(), "user", codes[0]); !errors.Is(err, ErrInvalidMFACode) {
+		t.Fatalf("reusing a recovery code: %v, want ErrInvalidMFACode", err)
+	}
+	if err := mfa.Verify(context.Background(), "user", codes[1]); err != nil {
+		t.Fatalf("Verify(%q) = %v", codes[1], err)
+	}
+	if err := mfa.Verify(context.Background(), "user", "aaaa-aaaa-aaaa-aaaa"); !errors.Is(err, ErrInvalidMFACode) {
+		t.Fatalf("an unknown code: %v, want ErrInvalidMFACode", err)
+	}
+}
+
+func TestRecoveryCodeHashesAreSalted(t *testing.T) {
+	first := hashRecoveryCode([]byte("salt one"), "code")
+	second := hashRecoveryCode([]byte("salt two"), "code")
+	if first == second {
+		t.Fatal("the same code hashed the same way with different salts")
+	}
+	if !matchRecoveryCode(first, "code") || !matchRecoveryCode(second, "code") {
+		t.Fatal("a code did not match its own hash")
+	}
+	for _, hash := range []string{"", "code", "zz$" + strings.Repeat("0", 64), first[:len(first)-1]} {
+		if matchRecoveryCode(hash, "code") {
+			t.Errorf("matched the malformed hash %q", hash)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"rtdocs/utils"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+const (
+	// defaultPasswordMinLength is used when PASSWORD_MIN_LENGTH is unset or invalid
+	defaultPasswordMinLength = 10
+	// defaultPasswordMinClasses is used when PASSWORD_MIN_CLASSES is unset or invalid
+	defaultPasswordMinClasses = 2
+
+	// passwordMaxBytes is as much of a password as bcrypt looks at
+	passwordMaxBytes = 72
+
+	// usernameMinMatchLength is the shortest username a password may not
+	// contain; shorter ones would turn up inside most passwords by chance
+	usernameMinMatchLength = 4
+)
+
+var ErrWeakPassword = errors.New("password is too weak")
+
+// PasswordPolicy decides whether a password is good enough to set
+type PasswordPolicy interface {
+	Validate(password, username string) error
+}
+
+type passwordPolicy struct {
+	minLength  int
+	minClasses int
+	breached   *utils.BreachedPasswords
+}
+
+// NewPasswordPolicy reads the policy from PASSWORD_MIN_LENGTH and
+// PASSWORD_MIN_CLASSES, the number of character classes (lowercase,
+// uppercase, digits and symbols) a password must mix. When
+// BREACHED_PASSWORDS_PATH is set, passwords on that list are refused as well.
+func NewPasswordPolicy() PasswordPolicy {
+	policy := &passwordPolicy{
+		minLength:  defaultPasswordMinLength,
+		minClasses: defaultPasswordMinClasses,
+	}
+
+	if n, err := strconv.Atoi(utils.GetEnv("PASSWORD_MIN_LENGTH")); err == nil && n > 0 {
+		policy.minLength = n
+	}
+	if n, err := strconv.Atoi(utils.GetEnv("PASSWORD_MIN_CLASSES")); err == nil && n >= 0 && n <= 4 {
+		policy.minClasses = n
+	}
+
+	if path := utils.GetEnv("BREACHED_PASSWORDS_PATH"); path != "" {
+		breached, err := utils.LoadBreachedPasswords(path)
+		if err != nil {
+			utils.NewLogger().Errorw("Failed to load breached password list; passwords will not be checked against it", "path", path, "error", err)
+		}
+		policy.breached = breached
+	}
+
+	return policy
+}
+
+// Validate returns an error wrapping ErrWeakPassword that says what is wrong with the password
+func (p *passwordPolicy) Validate(password, username string) error {
+	if len([]rune(password)) < p.minLength {
+		return fmt.Errorf("%w: it must be at least %d characters long", ErrWeakPassword, p.minLength)
+	}
+	if len(password) > passwordMaxBytes {
+		return fmt.Errorf("%w: it must be at most %d bytes long", ErrWeakPassword, passwordMaxBytes)
+	}
+	if classes := characterClasses(password); classes < p.minClasses {
+		return fmt.Errorf("%w: it must mix at least %d of lowercase letters, uppercase letters, digits and symbols", ErrWeakPassword, p.minClasses)
+	}
+	if len([]rune(username)) >= usernameMinMatchLength && strings.Contains(strings.ToLower(password), strings.ToLower(username)) {
+		return fmt.Errorf("%w: it must not contain the username", ErrWeakPassword)
+	}
+
+	if p.breached != nil {
+		breached, err := p.breached.Contains(password)
+		if err != nil {
+			// An unreadable list should not stop everyone from setting a password
+			utils.NewLogger().Errorw("Failed to check breached password list", "error", err)
+		}
+		if breached {
+			return fmt.Errorf("%w: it has appeared in a data breach", ErrWeakPassword)
+		}
+	}
+
+	return nil
+}
+
+func characterClasses(password string) int {
+	var lower, upper, digit, symbol int
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = 1
+		case unicode.IsUpper(r):
+			upper = 1
+		case unicode.IsDigit(r):
+			digit = 1
+		default:
+			symbol = 1
+		}
+	}
+	return lower + upper + digit + symbol
+}
@@ -0,0 +1,84 @@
+package service
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPasswordPolicy(t *testing.T) {
+	policy := &passwordPolicy{minLength: 10, minClasses: 2}
+
+	tests := []struct {
+		name     string
+		password string
+		username string
+		reason   string // part of the error message, or "" when the password is accepted
+	}{
+		{"long enough", "correcthorse1", "", ""},
+		{"too short", "horse1", "", "at least 10 characters"},
+		{"one class", "correcthorse", "", "at least 2 of"},
+		{"symbols count as a class", "correct horse", "", ""},
+		{"length in characters", "ééééééééé1", "", ""},
+		{"at the bcrypt limit", strings.Repeat("a", 71) + "1", "", ""},
+		{"past the bcrypt limit", strings.Repeat("a", 72) + "1", "", "at most 72 bytes"},
+		{"past the bcrypt limit in bytes", strings.Repeat("é", 36) + "1", "", "at most 72 bytes"},
+		{"contains the username", "xxAlice2024xx", "alice", "username"},
+		{"contains a four character username", "my-bob4-password", "BOB4", "username"},
+		{"one character username", "always-a-password1", "a", ""},
+		{"two character username", "nothing-to-hide1", "hi", ""},
+		{"three character username", "banner-planning1", "ann", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := policy.Validate(tt.password, tt.username)
+			if tt.reason == "" {
+				if err != nil {
+					t.Fatalf("Validate = %v, want it accepted", err)
+				}
+				return
+			}
+			if !errors.Is(err, ErrWeakPassword) || !strings.Contains(err.Error(), tt.reason) {
+				t.Fatalf("Validate = %v, want ErrWeakPassword about %q", err, tt.reason)
+			}
+		})
+	}
+}
+
+func TestPasswordPolicyFromEnvironment(t *testing.T) {
+	sum := sha1.Sum([]byte("correcthorse1"))
+	list := filepath.Join(t.TempDir(), "pwned.txt")
+	if err := os.WriteFile(list, []byte(strings.ToUpper(hex.EncodeToString(sum[:]))+":42\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name              string
+		minLength, counts string
+		password          string
+		accepted          bool
+	}{
+		{"defaults", "", "", "batterystaple9", true},
+		{"longer minimum", "16", "", "batterystaple9", false},
+		{"all four classes", "", "4", "batterystaple9", false},
+		{"invalid settings fall back to the defaults", "-1", "5", "batterystaple9", true},
+		{"breached", "", "", "correcthorse1", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("PASSWORD_MIN_LENGTH", tt.minLength)
+			t.Setenv("PASSWORD_MIN_CLASSES", tt.counts)
+			t.Setenv("BREACHED_PASSWORDS_PATH", list)
+
+			if err := NewPasswordPolicy().Validate(tt.password, ""); (err == nil) != tt.accepted {
+				t.Fatalf("Validate = %v, want accepted %v", err, tt.accepted)
+			}
+		})
+	}
+}
@@ -0,0 +1,148 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"rtdocs/model/domain"
+	"rtdocs/model/web"
+	"rtdocs/repository"
+	"rtdocs/utils"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// personalTokenPrefixLength is how much of a token is kept in the clear to identify it
+const personalTokenPrefixLength = len(utils.PersonalTokenPrefix) + 6
+
+var (
+	ErrInvalidScope          = errors.New("scopes must be docs:read or docs:write")
+	ErrPersonalTokenNotFound = errors.New("personal access token not found")
+	ErrInvalidPersonalToken  = errors.New("invalid personal access token")
+	ErrPersonalTokenGuest    = errors.New("guest accounts cannot create personal access tokens")
+)
+
+var personalTokenScopes = []string{domain.ScopeDocsRead, domain.ScopeDocsWrite}
+
+type PersonalTokenService interface {
+	CreateToken(ctx context.Context, userID string, req *web.CreatePersonalToken) (*web.CreatedPersonalToken, error)
+	GetTokens(ctx context.Context, userID string) ([]*domain.PersonalToken, error)
+	RevokeToken(ctx context.Context, userID, id string) error
+	Authenticate(ctx context.Context, token string) (*utils.Principal, error)
+}
+
+type personalTokenService struct {
+	tokenRepo repository.PersonalTokenRepository
+	userRepo  repository.UserRepository
+}
+
+func NewPersonalTokenService(tokenRepo repository.PersonalTokenRepository, userRepo repository.UserRepository) PersonalTokenService {
+	return &personalTokenService{tokenRepo: tokenRepo, userRepo: userRepo}
+}
+
+// CreateToken issues a personal access token. The token is only ever returned here.
+func (s *personalTokenService) CreateToken(ctx context.Context, userID string, req *web.CreatePersonalToken) (*web.CreatedPersonalToken, error) {
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		return nil, errors.New("name is required")
+	}
+	if len(req.Scopes) == 0 {
+		return nil, ErrInvalidScope
+	}
+	for _, scope := range req.Scopes {
+		if !slices.Contains(personalTokenScopes, scope) {
+			return nil, ErrInvalidScope
+		}
+	}
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		return nil, errors.New("expiry must be in the future")
+	}
+
+	user, err := s.userRepo.GetUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if user.Role == domain.UserRoleGuest {
+		return nil, ErrPersonalTokenGuest
+	}
+
+	scopes := slices.Clone(req.Scopes)
+	slices.Sort(scopes)
+
+	secret, err := randomToken()
+	if err != nil {
+		return nil, err
+	}
+	token := utils.PersonalTokenPrefix + secret
+
+	created, err := s.tokenRepo.CreatePersonalToken(ctx, &domain.PersonalToken{
+		ID:        uuid.New().String(),
+		UserID:    userID,
+		Name:      name,
+		Prefix:    token[:personalTokenPrefixLength],
+		TokenHash: hashToken(token),
+		Scopes:    slices.Compact(scopes),
+		CreatedAt: time.Now(),
+		ExpiresAt: req.ExpiresAt,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &web.CreatedPersonalToken{PersonalToken: created, Token: token}, nil
+}
+
+// GetTokens lists a user's live tokens, without the tokens themselves
+func (s *personalTokenService) GetTokens(ctx context.Context, userID string) ([]*domain.PersonalToken, error) {
+	return s.tokenRepo.GetPersonalTokens(ctx, userID)
+}
+
+func (s *personalTokenService) RevokeToken(ctx context.Context, userID, id string) error {
+	if _, err := uuid.Parse(id); err != nil {
+		return ErrPersonalTokenNotFound
+	}
+
+	revoked, err := s.tokenRepo.RevokePersonalToken(ctx, userID, id)
+	if err != nil {
+		return err
+	}
+	if !revoked {
+		return ErrPersonalTokenNotFound
+	}
+	return nil
+}
+
+// Authenticate returns the principal behind a personal access token. Tokens
+// of disabled accounts stop working, as their sessions do, and so do those of
+// accounts that must reset their password, whose credentials may be compromised.
+func (s *personalTokenService) Authenticate(ctx context.Context, token string) (*utils.Principal, error) {
+	personalToken, err := s.tokenRepo.UsePersonalToken(ctx, hashToken(token))
+	if err != nil {
+		return nil, err
+	}
+	if personalToken == nil {
+		return nil, ErrInvalidPersonalToken
+	}
+
+	user, err := s.userRepo.GetUser(ctx, personalToken.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if user.DisabledAt != nil || user.PasswordResetRequired {
+		return nil, ErrInvalidPersonalToken
+	}
+
+	principal := &utils.Principal{
+		UserID:   user.ID,
+		Username: user.Username,
+		Role:     user.Role,
+		Type:     utils.TokenTypePersonal,
+		Scopes:   personalToken.Scopes,
+	}
+	if personalToken.ExpiresAt != nil {
+		principal.ExpiresAt = *personalToken.ExpiresAt
+	}
+	return principal, nil
+}
@@ -0,0 +1,60 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"rtdocs/model/domain"
+	"rtdocs/repository"
+	"testing"
+	"time"
+)
+
+// storedPersonalTokens holds one personal access token
+type storedPersonalTokens struct {
+	repository.PersonalTokenRepository
+	token *domain.PersonalToken
+}
+
+func (s *storedPersonalTokens) UsePersonalToken(ctx context.Context, tokenHash string) (*domain.PersonalToken, error) {
+	if tokenHash != s.token.TokenHash {
+		return nil, nil
+	}
+	return s.token, nil
+}
+
+func TestAuthenticatePersonalToken(t *testing.T) {
+	disabled := time.Now()
+	tests := []struct {
+		name string
+		user *domain.User
+		want error
+	}{
+		{"active", &domain.User{ID: "user", Username: "alice"}, nil},
+		{"disabled", &domain.User{ID: "user", Username: "alice", DisabledAt: &disabled}, ErrInvalidPersonalToken},
+		{"password reset required", &domain.User{ID: "user", Username: "alice", PasswordResetRequired: true}, ErrInvalidPersonalToken},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tokens := &storedPersonalTokens{token: &domain.PersonalToken{
+				UserID:    "user",
+				TokenHash: hashToken("rtd_pat_secret"),
+				Scopes:    []string{domain.ScopeDocsRead},
+			}}
+			users := &memoryUsers{users: map[string]*domain.User{"user": tt.user}}
+			service := &personalTokenService{tokenRepo: tokens, userRepo: users}
+
+			principal, err := service.Authenticate(context.Background(), "rtd_pat_secret")
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("Authenticate = %v, want %v", err, tt.want)
+			}
+			if err == nil && (principal.UserID != "user" || !principal.IsPersonalToken() || principal.HasScope(domain.ScopeDocsWrite)) {
+				t.Fatalf("principal = %+v", principal)
+			}
+
+			if _, err := service.Authenticate(context.Background(), "rtd_pat_other"); !errors.Is(err, ErrInvalidPersonalToken) {
+				t.Fatalf("an unknown token: %v, want ErrInvalidPersonalToken", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,161 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"rtdocs/model/domain"
+	"rtdocs/repository"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// maxUsernameLength is the longest username the users table holds
+const maxUsernameLength = 50
+
+// externalAccount is a user as an external identity provider or directory knows them
+type externalAccount struct {
+	Issuer        string
+	Subject       string
+	Username      string // preferred; a suffix is added if it is taken
+	Email         string
+	EmailVerified bool
+	Role          string // defaults to authenticated
+}
+
+// accountProvisioner links users authenticated elsewhere to rtdocs accounts,
+// creating the account the first time one signs in
+type accountProvisioner struct {
+	userRepo     repository.UserRepository
+	identityRepo repository.IdentityRepository
+}
+
+func newAccountProvisioner(userRepo repository.UserRepository, identityRepo repository.IdentityRepository) *accountProvisioner {
+	return &accountProvisioner{userRepo: userRepo, identityRepo: identityRepo}
+}
+
+// userFor returns the account linked to an external identity, linking or
+// provisioning one first if the identity is new
+func (p *accountProvisioner) userFor(ctx context.Context, account *externalAccount) (*domain.User, error) {
+	user, err := p.identityRepo.GetIdentityUser(ctx, account.Issuer, account.Subject)
+	if err != nil || user != nil {
+		return user, err
+	}
+	return p.linkIdentity(ctx, account)
+}
+
+// linkIdentity links a new external identity to the account with its email
+// address if both sides have verified it, or provisions a separate account for it
+func (p *accountProvisioner) linkIdentity(ctx context.Context, account *externalAccount) (*domain.User, error) {
+	var user *domain.User
+	email := account.Email
+	if email != "" {
+		existing, err := p.userRepo.GetUserByEmail(ctx, email)
+		if err != nil {
+			return nil, err
+		}
+		// An unverified address could belong to anyone, so it must not unlock an
+		// existing account: the provider must have verified it, and so must we,
+		// or whoever registered it here first could be handed someone else's sign-in
+		if existing != nil && account.EmailVerified && existing.Verified && existing.Role != domain.UserRoleGuest {
+			user = existing
+		}
+		if existing != nil && user == nil {
+			email = ""
+		}
+	}
+
+	if user == nil {
+		var err error
+		if user, err = p.provisionUser(ctx, account.Username, email, account.EmailVerified, account.Role); err != nil {
+			return nil, err
+		}
+	}
+
+	identity := &domain.Identity{
+		Issuer:  account.Issuer,
+		Subject: account.Subject,
+		UserID:  user.ID,
+		Email:   email,
+	}
+	if err := p.identityRepo.LinkIdentity(ctx, identity); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// provisionUser creates an account for a user authenticated elsewhere. It
+// gets an unguessable password, so it can only log in with a password after a reset.
+func (p *accountProvisioner) provisionUser(ctx context.Context, username, email string, verified bool, role string) (*domain.User, error) {
+	username, err := p.availableUsername(ctx, username, email)
+	if err != nil {
+		return nil, err
+	}
+	if role == "" {
+		role = domain.UserRoleAuthenticated
+	}
+
+	password, err := randomToken()
+	if err != nil {
+		return nil, err
+	}
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, err
+	}
+
+	user := &domain.User{
+		ID:        uuid.New().String(),
+		Username:  username,
+		Email:     email,
+		Verified:  verified && email != "",
+		Password:  string(hashedPassword),
+		Role:      role,
+		CreatedAt: time.Now(),
+	}
+	return p.userRepo.CreateUser(ctx, user)
+}
+
+// availableUsername picks an unused username from the preferred one or the
+// email address, adding a random suffix if it is taken. Names too long for
+// the users table are shortened.
+func (p *accountProvisioner) availableUsername(ctx context.Context, preferred, email string) (string, error) {
+	base := strings.TrimSpace(preferred)
+	if base == "" {
+		base, _, _ = strings.Cut(email, "@")
+	}
+	if base == "" {
+		base = "user"
+	}
+	base = truncate(base, maxUsernameLength)
+
+	candidate := base
+	for attempt := 0; attempt < 5; attempt++ {
+		existing, err := p.userRepo.GetUserByUsername(ctx, candidate)
+		if err != nil {
+			return "", err
+		}
+		if existing == nil {
+			return candidate, nil
+		}
+
+		suffix, err := randomToken()
+		if err != nil {
+			return "", err
+		}
+		// Leave room for the dash and six characters of the suffix
+		candidate = truncate(base, maxUsernameLength-7) + "-" + strings.ToLower(suffix[:6])
+	}
+	return "", errors.New("could not find an available username")
+}
+
+// truncate shortens s to at most n characters
+func truncate(s string, n int) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	return string([]rune(s)[:n])
+}
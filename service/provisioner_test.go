@@ -0,0 +1,179 @@
+package service
+
+import (
+	"context"
+	"rtdocs/model/domain"
+	"rtdocs/repository"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+// memoryUsers is a user store in memory
+type memoryUsers struct {
+	repository.UserRepository
+	users map[string]*domain.User
+}
+
+func (u *memoryUsers) GetUser(ctx context.Context, id string) (*domain.User, error) {
+	return u.users[id], nil
+}
+
+func (u *memoryUsers) GetUserByUsername(ctx context.Context, username string) (*domain.User, error) {
+	for _, user := range u.users {
+		if user.Username == username {
+			return user, nil
+		}
+	}
+	return nil, nil
+}
+
+func (u *memoryUsers) GetUserByEmail(ctx context.Context, email string) (*domain.User, error) {
+	for _, user := range u.users {
+		if user.Email != "" && strings.EqualFold(user.Email, email) {
+			return user, nil
+		}
+	}
+	return nil, nil
+}
+
+func (u *memoryUsers) CreateUser(ctx context.Context, user *domain.User) (*domain.User, error) {
+	if existing, _ := u.GetUserByUsername(ctx, user.Username); existing != nil {
+		return nil, repository.ErrDuplicateUsername
+	}
+	if existing, _ := u.GetUserByEmail(ctx, user.Email); existing != nil {
+		return nil, repository.ErrDuplicateEmail
+	}
+	if utf8.RuneCountInString(user.Username) > maxUsernameLength {
+		return nil, &usernameTooLong{user.Username}
+	}
+	u.users[user.ID] = user
+	return user, nil
+}
+
+func (u *memoryUsers) UpdateUser(ctx context.Context, user *domain.User) (*domain.User, error) {
+	u.users[user.ID] = user
+	return user, nil
+}
+
+type usernameTooLong struct {
+	username string
+}
+
+func (e *usernameTooLong) Error() string {
+	return "value too long for type character varying(50): " + e.username
+}
+
+// memoryIdentities links external identities and keeps pending sign-ins in memory
+type memoryIdentities struct {
+	users      *memoryUsers
+	identities map[string]string // issuer and subject to user ID
+	logins     map[string][2]string
+}
+
+func newMemoryIdentities(users *memoryUsers) *memoryIdentities {
+	return &memoryIdentities{users: users, identities: make(map[string]string), logins: make(map[string][2]string)}
+}
+
+func (m *memoryIdentities) GetIdentityUser(ctx context.Context, issuer, subject string) (*domain.User, error) {
+	userID, ok := m.identities[issuer+" "+subject]
+	if !ok {
+		return nil, nil
+	}
+	return m.users.users[userID], nil
+}
+
+func (m *memoryIdentities) LinkIdentity(ctx context.Context, identity *domain.Identity) error {
+	m.identities[identity.Issuer+" "+identity.Subject] = identity.UserID
+	return nil
+}
+
+func (m *memoryIdentities) HasIdentity(ctx context.Context, userID string, issuers []string) (bool, error) {
+	for identity, linked := range m.identities {
+		issuer, _, _ := strings.Cut(identity, " ")
+		if linked == userID && slices.Contains(issuers, issuer) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (m *memoryIdentities) CreateOIDCLogin(ctx context.Context, stateHash, nonce, codeVerifier string, expiresAt time.Time) error {
+	m.logins[stateHash] = [2]string{nonce, codeVerifier}
+	return nil
+}
+
+func (m *memoryIdentities) ConsumeOIDCLogin(ctx context.Context, stateHash string) (string, string, error) {
+	login := m.logins[stateHash]
+	delete(m.logins, stateHash)
+	return login[0], login[1], nil
+}
+
+func TestLinkIdentity(t *testing.T) {
+	tests := []struct {
+		name          string
+		local         *domain.User
+		emailVerified bool
+		linked        bool
+	}{
+		{"both verified", &domain.User{ID: "local", Username: "alice", Email: "alice@example.com", Verified: true, Role: domain.UserRoleAuthenticated}, true, true},
+		{"unverified at the provider", &domain.User{ID: "local", Username: "alice", Email: "alice@example.com", Verified: true, Role: domain.UserRoleAuthenticated}, false, false},
+		{"unverified here", &domain.User{ID: "local", Username: "alice", Email: "alice@example.com", Role: domain.UserRoleAuthenticated}, true, false},
+		{"no local account", nil, true, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			users := &memoryUsers{users: make(map[string]*domain.User)}
+			if tt.local != nil {
+				users.users[tt.local.ID] = tt.local
+			}
+			provisioner := newAccountProvisioner(users, newMemoryIdentities(users))
+
+			user, err := provisioner.userFor(context.Background(), &externalAccount{
+				Issuer:        "https://idp.example.com",
+				Subject:       "alice",
+				Username:      "alice",
+				Email:         "alice@example.com",
+				EmailVerified: tt.emailVerified,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if linked := user.ID == "local"; linked != tt.linked {
+				t.Fatalf("linked to the local account = %v, want %v", linked, tt.linked)
+			}
+			if !tt.linked && tt.local != nil && (user.Email != "" || user.Username == "alice") {
+				t.Fatalf("the separate account took the local account's username or address: %+v", user)
+			}
+
+			// The identity signs in to the same account from now on
+			again, err := provisioner.userFor(context.Background(), &externalAccount{Issuer: "https://idp.example.com", Subject: "alice"})
+			if err != nil || again.ID != user.ID {
+				t.Fatalf("second sign-in = %v, %v; want the account from the first", again, err)
+			}
+		})
+	}
+}
+
+func TestProvisionedUsernamesFit(t *testing.T) {
+	users := &memoryUsers{users: make(map[string]*domain.User)}
+	provisioner := newAccountProvisioner(users, newMemoryIdentities(users))
+	long := strings.Repeat("ä", 80)
+
+	for i, subject := range []string{"first", "second"} {
+		user, err := provisioner.userFor(context.Background(), &externalAccount{Issuer: "https://idp.example.com", Subject: subject, Username: long})
+		if err != nil {
+			t.Fatalf("provisioning %s: %v", subject, err)
+		}
+		if n := utf8.RuneCountInString(user.Username); n > maxUsernameLength {
+			t.Fatalf("username has %d characters", n)
+		}
+		// The second is suffixed, as the shortened name is taken
+		if i == 1 && !strings.Contains(user.Username, "-") {
+			t.Fatalf("username %q was not made unique", user.Username)
+		}
+	}
+}
@@ -0,0 +1,221 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"log"
+	"rtdocs/model/domain"
+	"rtdocs/realtime"
+	"rtdocs/repository"
+	"rtdocs/utils"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// defaultRevisionInterval is used when REVISION_INTERVAL is unset or invalid
+const defaultRevisionInterval = 5 * time.Minute
+
+var ErrRevisionNotFound = errors.New("revision not found")
+
+type RevisionService interface {
+	Snapshot(ctx context.Context, document *domain.Document, authorID string) (*domain.Revision, error)
+	Checkpoint(ctx context.Context, document *domain.Document, authorID string) (*domain.Revision, error)
+	GetRevisions(ctx context.Context, documentID string) ([]*domain.Revision, error)
+	GetRevision(ctx context.Context, documentID, id string) (*domain.Revision, error)
+	RestoreRevision(ctx context.Context, documentID, id, authorID string) (*domain.Document, []realtime.Update, error)
+}
+
+type revisionService struct {
+	repo       repository.RevisionRepository
+	docService DocumentService
+	interval   time.Duration
+
+	mu     sync.Mutex
+	latest map[string]*revisionMark
+}
+
+// revisionMark is what Snapshot needs to know about a document's latest revision
+type revisionMark struct {
+	at       time.Time
+	checksum [sha256.Size]byte
+}
+
+func NewRevisionService(repo repository.RevisionRepository, docService DocumentService) RevisionService {
+	interval, err := time.ParseDuration(utils.GetEnv("REVISION_INTERVAL"))
+	if err != nil || interval <= 0 {
+		interval = defaultRevisionInterval
+	}
+
+	return &revisionService{
+		repo:       repo,
+		docService: docService,
+		interval:   interval,
+		latest:     make(map[string]*revisionMark),
+	}
+}
+
+// Snapshot records a revision of the document if its content changed and the
+// interval has passed since the last revision. It returns nil when no
+// revision was needed.
+func (s *revisionService) Snapshot(ctx context.Context, document *domain.Document, authorID string) (*domain.Revision, error) {
+	return s.snapshot(ctx, document, authorID, true)
+}
+
+// Checkpoint records a revision of the document if its content changed since
+// the last revision, however recent that was, as when the last editor leaves
+// and edits the interval held back would otherwise have no revision
+func (s *revisionService) Checkpoint(ctx context.Context, document *domain.Document, authorID string) (*domain.Revision, error) {
+	return s.snapshot(ctx, document, authorID, false)
+}
+
+func (s *revisionService) GetRevisions(ctx context.Context, documentID string) ([]*domain.Revision, error) {
+	return s.repo.GetRevisions(ctx, documentID)
+}
+
+func (s *revisionService) GetRevision(ctx context.Context, documentID, id string) (*domain.Revision, error) {
+	return getRevision(ctx, s.repo, documentID, id)
+}
+
+// RestoreRevision puts a document back to a revision. The current state is
+// recorded as a revision first so the restore itself can be undone. For CRDT
+// documents it also returns the updates that were logged to reach the restored
+// content, so open editors can merge them.
+func (s *revisionService) RestoreRevision(ctx context.Context, documentID, id, authorID string) (*domain.Document, []realtime.Update, error) {
+	revision, err := getRevision(ctx, s.repo, documentID, id)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	document, err := s.docService.GetDocument(ctx, documentID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if _, err := s.snapshot(ctx, document, authorID, false); err != nil {
+		return nil, nil, err
+	}
+
+	var updates []realtime.Update
+	if document.SyncMode == realtime.ModeCRDT {
+		sequence, err := s.docService.LoadSequence(ctx, document)
+		if err != nil {
+			return nil, nil, err
+		}
+		updates = sequence.Replace(revision.Content, realtime.ServerSite)
+		if err := s.docService.AppendSequenceUpdates(ctx, documentID, updates); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	document.Title = revision.Title
+	document.Content = revision.Content
+	document.UpdatedAt = time.Now()
+
+	restored, err := s.docService.UpdateDocument(ctx, document)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if _, err := s.snapshot(ctx, restored, authorID, false); err != nil {
+		log.Printf("Failed to record restored revision: %v", err)
+	}
+
+	return restored, updates, nil
+}
+
+// getRevision returns a revision of a document, or ErrRevisionNotFound if the
+// document has no revision with that ID
+func getRevision(ctx context.Context, repo repository.RevisionRepository, documentID, id string) (*domain.Revision, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, ErrRevisionNotFound
+	}
+
+	revision, err := repo.GetRevision(ctx, documentID, id)
+	if err != nil {
+		return nil, err
+	}
+	if revision == nil {
+		return nil, ErrRevisionNotFound
+	}
+	return revision, nil
+}
+
+// snapshot records a revision unless the content is unchanged since the
+// latest one or, when throttle is set, the latest one is younger than the interval
+func (s *revisionService) snapshot(ctx context.Context, document *domain.Document, authorID string, throttle bool) (*domain.Revision, error) {
+	mark, err := s.latestMark(ctx, document.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	if mark != nil {
+		if mark.checksum == checksumOf(document.Title, document.Content) {
+			return nil, nil
+		}
+		if throttle && time.Since(mark.at) < s.interval {
+			return nil, nil
+		}
+	}
+
+	return s.createRevision(ctx, document, authorID)
+}
+
+func (s *revisionService) createRevision(ctx context.Context, document *domain.Document, authorID string) (*domain.Revision, error) {
+	revision := &domain.Revision{
+		ID:         uuid.New().String(),
+		DocumentID: document.ID,
+		Title:      document.Title,
+		Content:    document.Content,
+		AuthorID:   authorID,
+		CreatedAt:  time.Now(),
+	}
+
+	created, err := s.repo.CreateRevision(ctx, revision)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	s.latest[document.ID] = markOf(created)
+	s.mu.Unlock()
+
+	return created, nil
+}
+
+// latestMark returns the cached mark of a document's latest revision, loading it on first use
+func (s *revisionService) latestMark(ctx context.Context, documentID string) (*revisionMark, error) {
+	s.mu.Lock()
+	mark, ok := s.latest[documentID]
+	s.mu.Unlock()
+	if ok {
+		return mark, nil
+	}
+
+	revision, err := s.repo.GetLatestRevision(ctx, documentID)
+	if err != nil {
+		return nil, err
+	}
+	if revision != nil {
+		mark = markOf(revision)
+	}
+
+	s.mu.Lock()
+	s.latest[documentID] = mark
+	s.mu.Unlock()
+
+	return mark, nil
+}
+
+func markOf(revision *domain.Revision) *revisionMark {
+	return &revisionMark{
+		at:       revision.CreatedAt,
+		checksum: checksumOf(revision.Title, revision.Content),
+	}
+}
+
+func checksumOf(title, content string) [sha256.Size]byte {
+	return sha256.Sum256([]byte(title + "\x00" + content))
+}
@@ -0,0 +1,223 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"rtdocs/model/domain"
+	"rtdocs/realtime"
+	"rtdocs/repository"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type memoryRevisions struct {
+	repository.RevisionRepository
+	revisions []*domain.Revision
+}
+
+func (m *memoryRevisions) CreateRevision(ctx context.Context, revision *domain.Revision) (*domain.Revision, error) {
+	stored := *revision
+	m.revisions = append(m.revisions, &stored)
+	return revision, nil
+}
+
+func (m *memoryRevisions) GetRevision(ctx context.Context, documentID, id string) (*domain.Revision, error) {
+	for _, revision := range m.revisions {
+		if revision.DocumentID == documentID && revision.ID == id {
+			return revision, nil
+		}
+	}
+	return nil, nil
+}
+
+func (m *memoryRevisions) GetLatestRevision(ctx context.Context, documentID string) (*domain.Revision, error) {
+	revisions, _ := m.GetRevisions(ctx, documentID)
+	if len(revisions) == 0 {
+		return nil, nil
+	}
+	return revisions[0], nil
+}
+
+func (m *memoryRevisions) GetRevisions(ctx context.Context, documentID string) ([]*domain.Revision, error) {
+	var revisions []*domain.Revision
+	for _, revision := range m.revisions {
+		if revision.DocumentID == documentID {
+			revisions = append(revisions, revision)
+		}
+	}
+	sort.SliceStable(revisions, func(i, j int) bool {
+		return revisions[i].CreatedAt.After(revisions[j].CreatedAt)
+	})
+	return revisions, nil
+}
+
+type editedDocuments struct {
+	storedDocuments
+}
+
+func (e *editedDocuments) UpdateDocument(ctx context.Context, document *domain.Document) (*domain.Document, error) {
+	updated := *document
+	e.documents[document.ID] = &updated
+	return &updated, nil
+}
+
+func newRevisionService(t *testing.T, docs DocumentService) (*revisionService, *memoryRevisions) {
+	t.Helper()
+	t.Setenv("REVISION_INTERVAL", "1h")
+
+	revisions := &memoryRevisions{}
+	return NewRevisionService(revisions, docs).(*revisionService), revisions
+}
+
+func TestSnapshotInterval(t *testing.T) {
+	ctx := context.Background()
+	s, revisions := newRevisionService(t, nil)
+	document := &domain.Document{ID: "doc", Title: "Notes", Content: "one"}
+
+	if revision, err := s.Snapshot(ctx, document, "alice"); err != nil || revision == nil {
+		t.Fatalf("first Snapshot = %v, %v; want a revision", revision, err)
+	}
+
+	document.Content = "two"
+	if revision, err := s.Snapshot(ctx, document, "alice"); err != nil || revision != nil {
+		t.Fatalf("Snapshot within the interval = %v, %v; want none", revision, err)
+	}
+
+	// Once the interval has passed since the latest revision, changes are recorded again
+	s.latest["doc"].at = time.Now().Add(-2 * time.Hour)
+	if revision, err := s.Snapshot(ctx, document, "alice"); err != nil || revision == nil || revision.Content != "two" {
+		t.Fatalf("Snapshot after the interval = %v, %v; want a revision of two", revision, err)
+	}
+
+	// Unchanged content is never recorded twice, however long ago
+	s.latest["doc"].at = time.Now().Add(-2 * time.Hour)
+	if revision, err := s.Snapshot(ctx, document, "alice"); err != nil || revision != nil {
+		t.Fatalf("Snapshot of unchanged content = %v, %v; want none", revision, err)
+	}
+
+	// A checkpoint ignores the interval but not unchanged content
+	document.Content = "three"
+	if revision, err := s.Checkpoint(ctx, document, "alice"); err != nil || revision == nil {
+		t.Fatalf("Checkpoint within the interval = %v, %v; want a revision", revision, err)
+	}
+	if revision, err := s.Checkpoint(ctx, document, "alice"); err != nil || revision != nil {
+		t.Fatalf("Checkpoint of unchanged content = %v, %v; want none", revision, err)
+	}
+
+	if len(revisions.revisions) != 3 {
+		t.Fatalf("recorded %d revisions, want 3", len(revisions.revisions))
+	}
+}
+
+func TestSnapshotLoadsLatestRevision(t *testing.T) {
+	ctx := context.Background()
+	s, revisions := newRevisionService(t, nil)
+	revisions.revisions = []*domain.Revision{
+		{ID: uuid.New().String(), DocumentID: "doc", Title: "Notes", Content: "old", CreatedAt: time.Now().Add(-time.Minute)},
+	}
+
+	document := &domain.Document{ID: "doc", Title: "Notes", Content: "new"}
+	if revision, err := s.Snapshot(ctx, document, "alice"); err != nil || revision != nil {
+		t.Fatalf("Snapshot within the interval of a stored revision = %v, %v; want none", revision, err)
+	}
+}
+
+func TestRevisionHistory(t *testing.T) {
+	ctx := context.Background()
+	s, _ := newRevisionService(t, nil)
+
+	for i, content := range []string{"one", "two", "three"} {
+		document := &domain.Document{ID: "doc", Title: "Notes", Content: content}
+		if _, err := s.Checkpoint(ctx, document, "alice"); err != nil {
+			t.Fatalf("Checkpoint %d: %v", i, err)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if _, err := s.Checkpoint(ctx, &domain.Document{ID: "other", Content: "elsewhere"}, "bob"); err != nil {
+		t.Fatalf("Checkpoint of another document: %v", err)
+	}
+
+	revisions, err := s.GetRevisions(ctx, "doc")
+	if err != nil {
+		t.Fatalf("GetRevisions: %v", err)
+	}
+	var contents []string
+	for _, revision := range revisions {
+		contents = append(contents, revision.Content)
+	}
+	if want := []string{"three", "two", "one"}; !reflect.DeepEqual(contents, want) {
+		t.Fatalf("history = %v, want %v", contents, want)
+	}
+
+	revision, err := s.GetRevision(ctx, "doc", revisions[1].ID)
+	if err != nil || revision.Content != "two" {
+		t.Fatalf("GetRevision = %v, %v; want two", revision, err)
+	}
+}
+
+func TestGetRevisionNotFound(t *testing.T) {
+	ctx := context.Background()
+	s, _ := newRevisionService(t, nil)
+	revision, err := s.Checkpoint(ctx, &domain.Document{ID: "doc", Content: "one"}, "alice")
+	if err != nil {
+		t.Fatalf("Checkpoint: %v", err)
+	}
+
+	tests := []struct {
+		name, documentID, id string
+	}{
+		{"unknown", "doc", uuid.New().String()},
+		{"malformed", "doc", "not-a-uuid"},
+		{"another document's", "other", revision.ID},
+	}
+	for _, tt := range tests {
+		if _, err := s.GetRevision(ctx, tt.documentID, tt.id); !errors.Is(err, ErrRevisionNotFound) {
+			t.Errorf("GetRevision of %s revision: %v, want ErrRevisionNotFound", tt.name, err)
+		}
+		if _, _, err := s.RestoreRevision(ctx, tt.documentID, tt.id, "alice"); !errors.Is(err, ErrRevisionNotFound) {
+			t.Errorf("RestoreRevision of %s revision: %v, want ErrRevisionNotFound", tt.name, err)
+		}
+	}
+}
+
+func TestRestoreRevision(t *testing.T) {
+	ctx := context.Background()
+	docs := &editedDocuments{storedDocuments{documents: map[string]*domain.Document{
+		"doc": {ID: "doc", Title: "Draft", Content: "first", SyncMode: realtime.ModeOT},
+	}}}
+	s, revisions := newRevisionService(t, docs)
+
+	old, err := s.Checkpoint(ctx, docs.documents["doc"], "alice")
+	if err != nil {
+		t.Fatalf("Checkpoint: %v", err)
+	}
+	// An edit the interval held back, which the restore must not lose
+	docs.documents["doc"].Title, docs.documents["doc"].Content = "Final", "second"
+
+	restored, updates, err := s.RestoreRevision(ctx, "doc", old.ID, "bob")
+	if err != nil {
+		t.Fatalf("RestoreRevision: %v", err)
+	}
+	if restored.Title != "Draft" || restored.Content != "first" || updates != nil {
+		t.Fatalf("RestoreRevision = %+v, %v; want the first draft", restored, updates)
+	}
+	if stored := docs.documents["doc"]; stored.Content != "first" {
+		t.Fatalf("stored content = %q, want first", stored.Content)
+	}
+
+	// The state before the restore and the restore itself are both in the history
+	var contents []string
+	for _, revision := range revisions.revisions {
+		contents = append(contents, revision.Content)
+	}
+	if want := []string{"first", "second", "first"}; !reflect.DeepEqual(contents, want) {
+		t.Fatalf("revisions after restore = %v, want first, second, first", contents)
+	}
+	if author := revisions.revisions[2].AuthorID; author != "bob" {
+		t.Fatalf("restore recorded by %q, want bob", author)
+	}
+}
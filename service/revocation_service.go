@@ -0,0 +1,123 @@
+package service
+
+import (
+	"context"
+	"rtdocs/repository"
+	"sync"
+	"time"
+)
+
+// revocationCacheTTL is how long a session found to be active is trusted
+// without asking the database again. Revocations made through this process
+// take effect immediately; ones made by another instance within this window.
+const revocationCacheTTL = 30 * time.Second
+
+// RevocationService tracks which sessions have been logged out. Every token
+// carries the ID of the session it was issued for in its "sid" claim, so
+// revoking a session kills both its access and refresh tokens.
+type RevocationService interface {
+	IsRevoked(ctx context.Context, sessionID string) (bool, error)
+	Revoke(ctx context.Context, sessionID string) error
+	RevokeAll(ctx context.Context, userID string) error
+}
+
+type revocationEntry struct {
+	revoked bool
+	until   time.Time
+}
+
+type revocationService struct {
+	sessionRepo repository.SessionRepository
+
+	mu        sync.Mutex
+	cache     map[string]revocationEntry
+	lastPrune time.Time
+}
+
+func NewRevocationService(sessionRepo repository.SessionRepository) RevocationService {
+	return &revocationService{
+		sessionRepo: sessionRepo,
+		cache:       make(map[string]revocationEntry),
+		lastPrune:   time.Now(),
+	}
+}
+
+// IsRevoked reports whether a session has been logged out. A session that
+// does not exist counts as revoked. Looking an active session up again also
+// updates its last seen time, which is therefore accurate to revocationCacheTTL.
+func (s *revocationService) IsRevoked(ctx context.Context, sessionID string) (bool, error) {
+	s.mu.Lock()
+	entry, ok := s.cache[sessionID]
+	s.mu.Unlock()
+	if ok && time.Now().Before(entry.until) {
+		return entry.revoked, nil
+	}
+
+	session, err := s.sessionRepo.GetSession(ctx, sessionID)
+	if err != nil {
+		return false, err
+	}
+
+	entry = revocationEntry{revoked: true, until: time.Now().Add(revocationCacheTTL)}
+	if session != nil {
+		if session.RevokedAt != nil {
+			// Tokens of the session are dead until they expire on their own
+			entry.until = session.ExpiresAt
+		} else {
+			entry.revoked = false
+			if err := s.sessionRepo.TouchSession(ctx, sessionID); err != nil {
+				return false, err
+			}
+		}
+	}
+	s.remember(sessionID, entry)
+
+	return entry.revoked, nil
+}
+
+func (s *revocationService) Revoke(ctx context.Context, sessionID string) error {
+	session, err := s.sessionRepo.GetSession(ctx, sessionID)
+	if err != nil {
+		return err
+	}
+	if session == nil {
+		return nil
+	}
+
+	if err := s.sessionRepo.RevokeSession(ctx, sessionID); err != nil {
+		return err
+	}
+	s.remember(sessionID, revocationEntry{revoked: true, until: session.ExpiresAt})
+	return nil
+}
+
+// RevokeAll logs a user out of every device
+func (s *revocationService) RevokeAll(ctx context.Context, userID string) error {
+	sessions, err := s.sessionRepo.RevokeUserSessions(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	for _, session := range sessions {
+		s.remember(session.ID, revocationEntry{revoked: true, until: session.ExpiresAt})
+	}
+	return nil
+}
+
+func (s *revocationService) remember(sessionID string, entry revocationEntry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.cache[sessionID] = entry
+
+	now := time.Now()
+	if now.Sub(s.lastPrune) < revocationCacheTTL {
+		return
+	}
+	for id, e := range s.cache {
+		if now.After(e.until) {
+			delete(s.cache, id)
+		}
+	}
+	s.lastPrune = now
+}
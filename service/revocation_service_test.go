@@ -0,0 +1,131 @@
+package service
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"rtdocs/middleware"
+	"rtdocs/model/domain"
+	"rtdocs/model/web"
+	"rtdocs/utils"
+	"testing"
+	"time"
+)
+
+// countedSessions counts the session lookups that reach the store
+type countedSessions struct {
+	*memorySessions
+	lookups int
+}
+
+func (c *countedSessions) GetSession(ctx context.Context, id string) (*domain.Session, error) {
+	c.lookups++
+	return c.memorySessions.GetSession(ctx, id)
+}
+
+func TestRevocationCache(t *testing.T) {
+	ctx := context.Background()
+	sessions := &countedSessions{memorySessions: newMemorySessions()}
+	sessions.CreateSession(ctx, &domain.Session{ID: "session", UserID: "user", ExpiresAt: time.Now().Add(time.Hour)})
+	s := NewRevocationService(sessions).(*revocationService)
+
+	for i := 0; i < 3; i++ {
+		if revoked, err := s.IsRevoked(ctx, "session"); err != nil || revoked {
+			t.Fatalf("IsRevoked of a live session = %v, %v", revoked, err)
+		}
+	}
+	if sessions.lookups != 1 {
+		t.Fatalf("%d lookups for three checks within the TTL, want 1", sessions.lookups)
+	}
+
+	// Revoked by another instance: trusted as live until the cached entry expires
+	sessions.RevokeSession(ctx, "session")
+	if revoked, _ := s.IsRevoked(ctx, "session"); revoked {
+		t.Fatal("cached entry was not used within the TTL")
+	}
+	s.cache["session"] = revocationEntry{until: time.Now().Add(-time.Second)}
+	if revoked, err := s.IsRevoked(ctx, "session"); err != nil || !revoked {
+		t.Fatalf("IsRevoked after the TTL = %v, %v; want true", revoked, err)
+	}
+	if sessions.lookups != 2 {
+		t.Fatalf("%d lookups, want the expired entry looked up again", sessions.lookups)
+	}
+
+	// A session that does not exist counts as revoked
+	if revoked, err := s.IsRevoked(ctx, "unknown"); err != nil || !revoked {
+		t.Fatalf("IsRevoked of an unknown session = %v, %v; want true", revoked, err)
+	}
+}
+
+func TestRevocationCachePrunesExpiredEntries(t *testing.T) {
+	ctx := context.Background()
+	s := NewRevocationService(newMemorySessions()).(*revocationService)
+
+	s.cache["stale"] = revocationEntry{revoked: true, until: time.Now().Add(-time.Minute)}
+	s.lastPrune = time.Now().Add(-2 * revocationCacheTTL)
+	s.IsRevoked(ctx, "unknown")
+
+	if _, ok := s.cache["stale"]; ok {
+		t.Fatal("expired entry was kept")
+	}
+	if _, ok := s.cache["unknown"]; !ok {
+		t.Fatal("fresh entry was pruned")
+	}
+}
+
+func TestLogoutRejectsTokens(t *testing.T) {
+	ctx := context.Background()
+	auth, _ := newSessionTestService()
+	alice := &domain.User{ID: "alice", Username: "alice"}
+
+	laptop, err := auth.issueTokens(ctx, alice, web.Client{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	phone, err := auth.issueTokens(ctx, alice, web.Client{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := auth.issueTokens(ctx, &domain.User{ID: "bob", Username: "bob"}, web.Client{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handler := middleware.AuthMiddleware(auth.tokenGen, auth.revocations, nil, nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	status := func(token *utils.Token) int {
+		r := httptest.NewRequest(http.MethodGet, "/api/documents", nil)
+		r.Header.Set("Authorization", "Bearer "+token.AccessToken)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w.Code
+	}
+	expect := func(when string, want map[*utils.Token]int) {
+		t.Helper()
+		for token, code := range want {
+			if got := status(token); got != code {
+				t.Errorf("%s: status %d, want %d", when, got, code)
+			}
+		}
+	}
+
+	// Every session is live, and now cached as such
+	expect("before logout", map[*utils.Token]int{laptop: http.StatusOK, phone: http.StatusOK, other: http.StatusOK})
+
+	// Logging out of this process takes effect at once, despite the cache
+	if err := auth.Logout(ctx, "Bearer "+laptop.AccessToken); err != nil {
+		t.Fatalf("Logout = %v", err)
+	}
+	expect("after logout", map[*utils.Token]int{laptop: http.StatusUnauthorized, phone: http.StatusOK, other: http.StatusOK})
+	if _, err := auth.Refresh(ctx, laptop.RefreshToken); err == nil {
+		t.Error("refresh token of a logged out session still works")
+	}
+
+	if err := auth.LogoutAll(ctx, "alice"); err != nil {
+		t.Fatalf("LogoutAll = %v", err)
+	}
+	expect("after logging out everywhere", map[*utils.Token]int{laptop: http.StatusUnauthorized, phone: http.StatusUnauthorized, other: http.StatusOK})
+
+	if err := auth.Logout(ctx, "Bearer not-a-token"); err != ErrInvalidAccessToken {
+		t.Fatalf("Logout with a bad token = %v, want ErrInvalidAccessToken", err)
+	}
+}
@@ -0,0 +1,683 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"rtdocs/model/domain"
+	"rtdocs/model/web"
+	"rtdocs/repository"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// scimBasePath is where the SCIM endpoints are served; resource locations are relative to the host
+const scimBasePath = "/scim/v2"
+
+var (
+	ErrUsernameTaken     = errors.New("a user with this username already exists")
+	ErrGroupNotFound     = errors.New("group not found")
+	ErrInvalidSCIMFilter = errors.New("unsupported filter")
+	ErrInvalidSCIMValue  = errors.New("invalid value")
+	ErrInvalidSCIMPatch  = errors.New("invalid patch operation")
+)
+
+// scimGroup is a group as the identity provider sees it. rtdocs has no groups
+// of its own, so each one stands for a role: its members are the users the
+// identity provider added to it who still have that role. Users removed from a
+// group go back to the default role. Users given the role some other way, by
+// an admin or the LDAP directory, are not members and are left alone.
+type scimGroup struct {
+	id   string
+	role string
+}
+
+var scimGroups = []scimGroup{
+	{id: "admins", role: domain.UserRoleAdmin},
+}
+
+// SCIMService lets an identity provider create, update and deactivate users
+// as people join and leave, following SCIM 2.0 (RFC 7644). Guests are not
+// visible to it.
+type SCIMService interface {
+	GetUsers(ctx context.Context, filter string, startIndex, count int) (*web.SCIMListResponse, error)
+	GetUser(ctx context.Context, id string) (*web.SCIMUser, error)
+	CreateUser(ctx context.Context, req *web.SCIMUser) (*web.SCIMUser, error)
+	ReplaceUser(ctx context.Context, id string, req *web.SCIMUser) (*web.SCIMUser, error)
+	PatchUser(ctx context.Context, id string, req *web.SCIMPatchRequest) (*web.SCIMUser, error)
+	DeactivateUser(ctx context.Context, id string) error
+	GetGroups(ctx context.Context, filter string, startIndex, count int) (*web.SCIMListResponse, error)
+	GetGroup(ctx context.Context, id string) (*web.SCIMGroup, error)
+	PatchGroup(ctx context.Context, id string, req *web.SCIMPatchRequest) (*web.SCIMGroup, error)
+}
+
+type scimService struct {
+	userRepo    repository.UserRepository
+	scimRepo    repository.SCIMRepository
+	revocations RevocationService
+	passwords   PasswordPolicy
+}
+
+func NewSCIMService(userRepo repository.UserRepository, scimRepo repository.SCIMRepository, revocations RevocationService, passwords PasswordPolicy) SCIMService {
+	return &scimService{userRepo: userRepo, scimRepo: scimRepo, revocations: revocations, passwords: passwords}
+}
+
+// userChanges are the attributes a request sets; nil ones are left as they are
+type userChanges struct {
+	userName *string
+	email    *string
+	password *string
+	active   *bool
+}
+
+// GetUsers lists users a page at a time; the only filter supported is
+// userName eq "...", which is what identity providers use to match accounts.
+// userName is case-insensitive in SCIM, so the filter is too.
+func (s *scimService) GetUsers(ctx context.Context, filter string, startIndex, count int) (*web.SCIMListResponse, error) {
+	offset, limit := scimPage(startIndex, count)
+
+	var users []*domain.User
+	var total int
+	if filter != "" {
+		userName, err := parseEqualityFilter(filter, "userName")
+		if err != nil {
+			return nil, err
+		}
+		matches, err := s.userRepo.GetUsersByUsernameFold(ctx, userName)
+		if err != nil {
+			return nil, err
+		}
+		total = len(matches)
+		users = matches[min(offset, total):min(offset+limit, total)]
+	} else {
+		var err error
+		if users, total, err = s.userRepo.ListUsers(ctx, limit, offset); err != nil {
+			return nil, err
+		}
+	}
+
+	resources, err := s.toSCIMUsers(ctx, users)
+	if err != nil {
+		return nil, err
+	}
+	return scimList(resources, total, offset), nil
+}
+
+func (s *scimService) GetUser(ctx context.Context, id string) (*web.SCIMUser, error) {
+	user, err := s.lookupUser(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return s.toSCIMUser(ctx, user)
+}
+
+// CreateUser provisions a user. Without a password the user gets an
+// unguessable one and signs in through the identity provider.
+func (s *scimService) CreateUser(ctx context.Context, req *web.SCIMUser) (*web.SCIMUser, error) {
+	changes := changesFromUser(req)
+	user := &domain.User{
+		ID:        uuid.New().String(),
+		Role:      domain.UserRoleAuthenticated,
+		CreatedAt: time.Now(),
+	}
+	if err := s.apply(ctx, user, changes); err != nil {
+		return nil, err
+	}
+	if changes.password == nil {
+		password, err := randomToken()
+		if err != nil {
+			return nil, err
+		}
+		hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+		if err != nil {
+			return nil, err
+		}
+		user.Password = string(hashedPassword)
+	}
+
+	createdUser, err := s.userRepo.CreateUser(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.setActive(ctx, createdUser, changes.active); err != nil {
+		return nil, err
+	}
+	// A new user is in no group yet
+	return toSCIMUser(createdUser, nil), nil
+}
+
+// ReplaceUser updates a user from a full representation. A missing active
+// attribute leaves the user as they are rather than reactivating them.
+func (s *scimService) ReplaceUser(ctx context.Context, id string, req *web.SCIMUser) (*web.SCIMUser, error) {
+	user, err := s.lookupUser(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return s.update(ctx, user, changesFromUser(req))
+}
+
+// PatchUser applies add, replace and remove operations to a user.
+// Attributes rtdocs does not keep are ignored so they cannot fail the patch.
+func (s *scimService) PatchUser(ctx context.Context, id string, req *web.SCIMPatchRequest) (*web.SCIMUser, error) {
+	user, err := s.lookupUser(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	var changes userChanges
+	for _, op := range req.Operations {
+		switch strings.ToLower(op.Op) {
+		case "add", "replace":
+			if op.Path != "" {
+				err = changes.set(op.Path, op.Value)
+			} else {
+				err = changes.setAll(op.Value)
+			}
+		case "remove":
+			if isEmailPath(op.Path) {
+				empty := ""
+				changes.email = &empty
+			}
+		default:
+			return nil, fmt.Errorf("%w: unknown op %q", ErrInvalidSCIMPatch, op.Op)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return s.update(ctx, user, changes)
+}
+
+// DeactivateUser disables a user and logs them out everywhere. Their
+// documents are kept, so a deleted user is only ever deactivated.
+func (s *scimService) DeactivateUser(ctx context.Context, id string) error {
+	user, err := s.lookupUser(ctx, id)
+	if err != nil {
+		return err
+	}
+	active := false
+	return s.setActive(ctx, user, &active)
+}
+
+// GetGroups lists the groups; the only filter supported is displayName eq "..."
+func (s *scimService) GetGroups(ctx context.Context, filter string, startIndex, count int) (*web.SCIMListResponse, error) {
+	offset, limit := scimPage(startIndex, count)
+
+	var displayName string
+	if filter != "" {
+		var err error
+		if displayName, err = parseEqualityFilter(filter, "displayName"); err != nil {
+			return nil, err
+		}
+	}
+
+	var groups []scimGroup
+	for _, group := range scimGroups {
+		if filter == "" || strings.EqualFold(group.id, displayName) {
+			groups = append(groups, group)
+		}
+	}
+
+	resources := []*web.SCIMGroup{}
+	for i := offset; i < len(groups) && len(resources) < limit; i++ {
+		resource, err := s.toSCIMGroup(ctx, groups[i])
+		if err != nil {
+			return nil, err
+		}
+		resources = append(resources, resource)
+	}
+	return scimList(resources, len(groups), offset), nil
+}
+
+func (s *scimService) GetGroup(ctx context.Context, id string) (*web.SCIMGroup, error) {
+	group, err := lookupGroup(id)
+	if err != nil {
+		return nil, err
+	}
+	return s.toSCIMGroup(ctx, group)
+}
+
+// PatchGroup adds users to or removes them from a group, which gives them or
+// takes away its role. Every member the operations name is looked up before
+// anything changes, and the changes are then made together, so a patch naming
+// an unknown user changes nothing. The group itself cannot be renamed.
+func (s *scimService) PatchGroup(ctx context.Context, id string, req *web.SCIMPatchRequest) (*web.SCIMGroup, error) {
+	group, err := lookupGroup(id)
+	if err != nil {
+		return nil, err
+	}
+
+	current, err := s.memberIDs(ctx, group)
+	if err != nil {
+		return nil, err
+	}
+	members := make(map[string]bool, len(current))
+	for _, userID := range current {
+		members[userID] = true
+	}
+
+	for _, op := range req.Operations {
+		path := strings.TrimSpace(op.Path)
+		value := op.Value
+		if path == "" {
+			// Without a path only the members in the value object matter
+			var values struct {
+				Members json.RawMessage `json:"members"`
+			}
+			if err := json.Unmarshal(op.Value, &values); err != nil {
+				return nil, fmt.Errorf("%w: value must be an object when there is no path", ErrInvalidSCIMPatch)
+			}
+			if values.Members == nil {
+				continue
+			}
+			path, value = "members", values.Members
+		}
+
+		var memberID string
+		if match := memberPath.FindStringSubmatch(path); match != nil {
+			if err := json.Unmarshal([]byte(match[1]), &memberID); err != nil {
+				return nil, fmt.Errorf("%w: %s", ErrInvalidSCIMPatch, path)
+			}
+		} else if !strings.EqualFold(path, "members") {
+			continue
+		}
+
+		var refs []string
+		if memberID != "" {
+			refs = []string{memberID}
+		} else if len(value) > 0 {
+			var values []web.SCIMMember
+			if err := json.Unmarshal(value, &values); err != nil {
+				return nil, fmt.Errorf("%w: members must be a list", ErrInvalidSCIMPatch)
+			}
+			for _, ref := range values {
+				refs = append(refs, ref.Value)
+			}
+		}
+		userIDs, err := s.resolveMembers(ctx, refs)
+		if err != nil {
+			return nil, err
+		}
+
+		switch strings.ToLower(op.Op) {
+		case "add":
+			for _, userID := range userIDs {
+				members[userID] = true
+			}
+		case "remove":
+			if memberID == "" && len(value) == 0 {
+				// Removing the members attribute empties the group
+				clear(members)
+			}
+			for _, userID := range userIDs {
+				delete(members, userID)
+			}
+		case "replace":
+			clear(members)
+			for _, userID := range userIDs {
+				members[userID] = true
+			}
+		default:
+			return nil, fmt.Errorf("%w: unknown op %q", ErrInvalidSCIMPatch, op.Op)
+		}
+	}
+
+	var add, remove []string
+	for _, userID := range current {
+		if !members[userID] {
+			remove = append(remove, userID)
+		}
+	}
+	for userID := range members {
+		if !slices.Contains(current, userID) {
+			add = append(add, userID)
+		}
+	}
+	slices.Sort(add)
+	if err := s.scimRepo.UpdateGroupMembers(ctx, group.id, group.role, add, remove); err != nil {
+		return nil, err
+	}
+
+	return s.toSCIMGroup(ctx, group)
+}
+
+// resolveMembers returns the IDs of the users members refers to, failing with
+// ErrInvalidSCIMValue if any is not a user the identity provider can see
+func (s *scimService) resolveMembers(ctx context.Context, members []string) ([]string, error) {
+	userIDs := make([]string, len(members))
+	for i, id := range members {
+		user, err := s.lookupUser(ctx, id)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidSCIMValue, err)
+		}
+		userIDs[i] = user.ID
+	}
+	return userIDs, nil
+}
+
+// lookupUser returns a user by ID, or ErrUserNotFound for unknown IDs and guests
+func (s *scimService) lookupUser(ctx context.Context, id string) (*domain.User, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, ErrUserNotFound
+	}
+	user, err := s.userRepo.GetUser(ctx, id)
+	if err != nil || user == nil || user.Role == domain.UserRoleGuest {
+		return nil, ErrUserNotFound
+	}
+	return user, nil
+}
+
+// update saves changes to an existing user
+func (s *scimService) update(ctx context.Context, user *domain.User, changes userChanges) (*web.SCIMUser, error) {
+	if err := s.apply(ctx, user, changes); err != nil {
+		return nil, err
+	}
+	savedUser, err := s.userRepo.UpdateUser(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.setActive(ctx, savedUser, changes.active); err != nil {
+		return nil, err
+	}
+	return s.toSCIMUser(ctx, savedUser)
+}
+
+// apply checks changes and makes them to user, without saving it. A new email
+// address has to be verified by the user like any other: the identity
+// provider can set any address, and a verified one is enough to link an
+// account to a single sign-on identity.
+func (s *scimService) apply(ctx context.Context, user *domain.User, changes userChanges) error {
+	if changes.userName != nil {
+		userName := strings.TrimSpace(*changes.userName)
+		if userName == "" {
+			return fmt.Errorf("%w: userName is required", ErrInvalidSCIMValue)
+		}
+		if userName != user.Username {
+			existing, err := s.userRepo.GetUserByUsername(ctx, userName)
+			if err != nil {
+				return err
+			}
+			if existing != nil && existing.ID != user.ID {
+				return ErrUsernameTaken
+			}
+			user.Username = userName
+		}
+	}
+	if user.Username == "" {
+		return fmt.Errorf("%w: userName is required", ErrInvalidSCIMValue)
+	}
+
+	if changes.email != nil && !strings.EqualFold(*changes.email, user.Email) {
+		email := strings.TrimSpace(*changes.email)
+		if email != "" {
+			if !validEmail(email) {
+				return ErrInvalidEmail
+			}
+			existing, err := s.userRepo.GetUserByEmail(ctx, email)
+			if err != nil {
+				return err
+			}
+			if existing != nil && existing.ID != user.ID {
+				return ErrEmailTaken
+			}
+		}
+		user.Email = email
+		user.Verified = false
+	}
+
+	if changes.password != nil {
+		if err := s.passwords.Validate(*changes.password, user.Username); err != nil {
+			return err
+		}
+		hashedPassword, err := bcrypt.GenerateFromPassword([]byte(*changes.password), bcrypt.DefaultCost)
+		if err != nil {
+			return err
+		}
+		user.Password = string(hashedPassword)
+	}
+	return nil
+}
+
+// setActive disables or re-enables a user if active says so; a disabled user is logged out everywhere
+func (s *scimService) setActive(ctx context.Context, user *domain.User, active *bool) error {
+	if active == nil || *active == (user.DisabledAt == nil) {
+		return nil
+	}
+
+	if err := s.userRepo.SetDisabled(ctx, user.ID, !*active); err != nil {
+		return err
+	}
+	if *active {
+		user.DisabledAt = nil
+		return nil
+	}
+	now := time.Now()
+	user.DisabledAt = &now
+	return s.revocations.RevokeAll(ctx, user.ID)
+}
+
+func (s *scimService) memberIDs(ctx context.Context, group scimGroup) ([]string, error) {
+	users, err := s.scimRepo.GetGroupMembers(ctx, group.id, group.role)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]string, len(users))
+	for i, user := range users {
+		ids[i] = user.ID
+	}
+	return ids, nil
+}
+
+func (s *scimService) toSCIMGroup(ctx context.Context, group scimGroup) (*web.SCIMGroup, error) {
+	users, err := s.scimRepo.GetGroupMembers(ctx, group.id, group.role)
+	if err != nil {
+		return nil, err
+	}
+
+	members := make([]web.SCIMMember, len(users))
+	for i, user := range users {
+		members[i] = web.SCIMMember{Value: user.ID, Display: user.Username, Ref: scimBasePath + "/Users/" + user.ID}
+	}
+	return &web.SCIMGroup{
+		Schemas:     []string{web.SCIMSchemaGroup},
+		ID:          group.id,
+		DisplayName: group.id,
+		Members:     members,
+		Meta:        &web.SCIMMeta{ResourceType: "Group", Location: scimBasePath + "/Groups/" + group.id},
+	}, nil
+}
+
+// set records the new value of a user attribute. Email paths such as
+// emails[type eq "work"].value set the one address rtdocs keeps.
+func (c *userChanges) set(attr string, value json.RawMessage) error {
+	var err error
+	switch lower := strings.ToLower(attr); {
+	case lower == "username":
+		c.userName = new(string)
+		err = json.Unmarshal(value, c.userName)
+	case lower == "password":
+		c.password = new(string)
+		err = json.Unmarshal(value, c.password)
+	case lower == "active":
+		c.active = new(bool)
+		*c.active, err = parseSCIMBool(value)
+	case lower == "emails":
+		var emails []web.SCIMEmail
+		err = json.Unmarshal(value, &emails)
+		email := primaryEmail(emails)
+		c.email = &email
+	case isEmailPath(lower):
+		c.email = new(string)
+		err = json.Unmarshal(value, c.email)
+	}
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidSCIMValue, attr)
+	}
+	return nil
+}
+
+// setAll records the attributes of a value object, as sent in an operation without a path
+func (c *userChanges) setAll(value json.RawMessage) error {
+	var values map[string]json.RawMessage
+	if err := json.Unmarshal(value, &values); err != nil {
+		return fmt.Errorf("%w: value must be an object when there is no path", ErrInvalidSCIMPatch)
+	}
+	for attr, value := range values {
+		if err := c.set(attr, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func changesFromUser(req *web.SCIMUser) userChanges {
+	email := primaryEmail(req.Emails)
+	changes := userChanges{userName: &req.UserName, email: &email, active: req.Active}
+	if req.Password != "" {
+		changes.password = &req.Password
+	}
+	return changes
+}
+
+func (s *scimService) toSCIMUser(ctx context.Context, user *domain.User) (*web.SCIMUser, error) {
+	resources, err := s.toSCIMUsers(ctx, []*domain.User{user})
+	if err != nil {
+		return nil, err
+	}
+	return resources[0], nil
+}
+
+// toSCIMUsers converts users, looking up the groups of all of them at once
+func (s *scimService) toSCIMUsers(ctx context.Context, users []*domain.User) ([]*web.SCIMUser, error) {
+	if len(users) == 0 {
+		return nil, nil
+	}
+	userIDs := make([]string, len(users))
+	for i, user := range users {
+		userIDs[i] = user.ID
+	}
+	groups, err := s.scimRepo.GetGroupsOf(ctx, userIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	resources := make([]*web.SCIMUser, len(users))
+	for i, user := range users {
+		resources[i] = toSCIMUser(user, groups[user.ID])
+	}
+	return resources, nil
+}
+
+// toSCIMUser converts a user who was added to groupIDs. Groups whose role the
+// user no longer has are left out.
+func toSCIMUser(user *domain.User, groupIDs []string) *web.SCIMUser {
+	active := user.DisabledAt == nil
+	resource := &web.SCIMUser{
+		Schemas:  []string{web.SCIMSchemaUser},
+		ID:       user.ID,
+		UserName: user.Username,
+		Active:   &active,
+		Meta: &web.SCIMMeta{
+			ResourceType: "User",
+			Created:      &user.CreatedAt,
+			Location:     scimBasePath + "/Users/" + user.ID,
+		},
+	}
+	if user.Email != "" {
+		resource.Emails = []web.SCIMEmail{{Value: user.Email, Type: "work", Primary: true}}
+	}
+	for _, groupID := range groupIDs {
+		if group, err := lookupGroup(groupID); err == nil && group.role == user.Role {
+			resource.Groups = append(resource.Groups, web.SCIMMember{Value: group.id, Display: group.id, Ref: scimBasePath + "/Groups/" + group.id})
+		}
+	}
+	return resource
+}
+
+func lookupGroup(id string) (scimGroup, error) {
+	for _, group := range scimGroups {
+		if group.id == id {
+			return group, nil
+		}
+	}
+	return scimGroup{}, ErrGroupNotFound
+}
+
+// primaryEmail picks the address marked primary, or else the first one
+func primaryEmail(emails []web.SCIMEmail) string {
+	for _, email := range emails {
+		if email.Primary {
+			return email.Value
+		}
+	}
+	if len(emails) > 0 {
+		return emails[0].Value
+	}
+	return ""
+}
+
+func isEmailPath(path string) bool {
+	path = strings.ToLower(path)
+	return path == "emails" || path == "emails.value" || strings.HasPrefix(path, "emails[") && strings.HasSuffix(path, "].value")
+}
+
+// parseSCIMBool accepts a JSON boolean or, as some identity providers send, a string
+func parseSCIMBool(value json.RawMessage) (bool, error) {
+	var b bool
+	if err := json.Unmarshal(value, &b); err == nil {
+		return b, nil
+	}
+	var s string
+	if err := json.Unmarshal(value, &s); err != nil {
+		return false, err
+	}
+	return strconv.ParseBool(strings.ToLower(s))
+}
+
+var (
+	equalityFilter = regexp.MustCompile(`^\s*([\w.]+)\s+(?i:eq)\s+("(?:[^"\\]|\\.)*")\s*$`)
+	memberPath     = regexp.MustCompile(`^(?i:members)\[\s*(?i:value)\s+(?i:eq)\s+("(?:[^"\\]|\\.)*")\s*\]$`)
+)
+
+// parseEqualityFilter returns the value of a filter of the form attr eq "value"
+func parseEqualityFilter(filter, attr string) (string, error) {
+	match := equalityFilter.FindStringSubmatch(filter)
+	if match == nil || !strings.EqualFold(match[1], attr) {
+		return "", fmt.Errorf("%w: only %s eq is supported", ErrInvalidSCIMFilter, attr)
+	}
+	var value string
+	if err := json.Unmarshal([]byte(match[2]), &value); err != nil {
+		return "", fmt.Errorf("%w: %s", ErrInvalidSCIMFilter, filter)
+	}
+	return value, nil
+}
+
+// scimPage turns a 1-based startIndex and a count into an offset and limit.
+// A negative count means the default page size.
+func scimPage(startIndex, count int) (int, int) {
+	if count < 0 {
+		count = defaultPageSize
+	}
+	return max(startIndex, 1) - 1, min(count, maxPageSize)
+}
+
+func scimList[T any](resources []T, total, offset int) *web.SCIMListResponse {
+	if resources == nil {
+		resources = []T{}
+	}
+	return &web.SCIMListResponse{
+		Schemas:      []string{web.SCIMSchemaListResponse},
+		TotalResults: total,
+		StartIndex:   offset + 1,
+		ItemsPerPage: len(resources),
+		Resources:    resources,
+	}
+}
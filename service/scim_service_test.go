@@ -0,0 +1,320 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"rtdocs/model/domain"
+	"rtdocs/model/web"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func (u *memoryUsers) GetUsersByUsernameFold(ctx context.Context, username string) ([]*domain.User, error) {
+	var users []*domain.User
+	for _, user := range u.users {
+		if strings.EqualFold(user.Username, username) && user.Role != domain.UserRoleGuest {
+			users = append(users, user)
+		}
+	}
+	slices.SortFunc(users, func(a, b *domain.User) int { return strings.Compare(a.ID, b.ID) })
+	return users, nil
+}
+
+// memorySCIM keeps group membership in memory, next to the users it changes the roles of
+type memorySCIM struct {
+	users   *memoryUsers
+	members map[string]map[string]bool // group ID to user IDs
+}
+
+func newMemorySCIM(users *memoryUsers) *memorySCIM {
+	return &memorySCIM{users: users, members: make(map[string]map[string]bool)}
+}
+
+func (m *memorySCIM) GetGroupMembers(ctx context.Context, groupID, role string) ([]*domain.User, error) {
+	var users []*domain.User
+	for userID := range m.members[groupID] {
+		if user := m.users.users[userID]; user != nil && user.Role == role {
+			users = append(users, user)
+		}
+	}
+	slices.SortFunc(users, func(a, b *domain.User) int { return strings.Compare(a.ID, b.ID) })
+	return users, nil
+}
+
+func (m *memorySCIM) GetGroupsOf(ctx context.Context, userIDs []string) (map[string][]string, error) {
+	groups := make(map[string][]string)
+	for groupID, members := range m.members {
+		for _, userID := range userIDs {
+			if members[userID] {
+				groups[userID] = append(groups[userID], groupID)
+			}
+		}
+	}
+	return groups, nil
+}
+
+func (m *memorySCIM) UpdateGroupMembers(ctx context.Context, groupID, role string, add, remove []string) error {
+	if m.members[groupID] == nil {
+		m.members[groupID] = make(map[string]bool)
+	}
+	for _, userID := range remove {
+		delete(m.members[groupID], userID)
+		if user := m.users.users[userID]; user.Role == role {
+			user.Role = domain.UserRoleAuthenticated
+		}
+	}
+	for _, userID := range add {
+		m.members[groupID][userID] = true
+		m.users.users[userID].Role = role
+	}
+	return nil
+}
+
+const (
+	aliceID = "00000000-0000-0000-0000-00000000000a"
+	bobID   = "00000000-0000-0000-0000-00000000000b"
+	carolID = "00000000-0000-0000-0000-00000000000c"
+	daveID  = "00000000-0000-0000-0000-00000000000d"
+)
+
+// newSCIMTestService has alice in the admins group, carol made an admin by
+// hand, and bob and dave as ordinary users
+func newSCIMTestService() (*scimService, *memoryUsers, *memorySCIM) {
+	users := &memoryUsers{users: map[string]*domain.User{
+		aliceID: {ID: aliceID, Username: "alice", Role: domain.UserRoleAdmin},
+		bobID:   {ID: bobID, Username: "bob", Role: domain.UserRoleAuthenticated},
+		carolID: {ID: carolID, Username: "carol", Role: domain.UserRoleAdmin},
+		daveID:  {ID: daveID, Username: "Dave", Role: domain.UserRoleAuthenticated},
+	}}
+	groups := newMemorySCIM(users)
+	groups.members["admins"] = map[string]bool{aliceID: true}
+	return &scimService{userRepo: users, scimRepo: groups}, users, groups
+}
+
+func TestParseEqualityFilter(t *testing.T) {
+	tests := []struct {
+		filter string
+		attr   string
+		value  string
+		ok     bool
+	}{
+		{`userName eq "alice"`, "userName", "alice", true},
+		{`UserName EQ "alice"`, "userName", "alice", true},
+		{`  userName   eq   "alice"  `, "userName", "alice", true},
+		{`userName eq "a \"quoted\" name"`, "userName", `a "quoted" name`, true},
+		{`userName eq "café"`, "userName", "café", true},
+		{`displayName eq "admins"`, "displayName", "admins", true},
+		{`userName eq ""`, "userName", "", true},
+		{`userName eq alice`, "userName", "", false},
+		{`userName ne "alice"`, "userName", "", false},
+		{`userName co "ali"`, "userName", "", false},
+		{`emails eq "alice@example.com"`, "userName", "", false},
+		{`userName eq "alice" and active eq true`, "userName", "", false},
+		{`userName eq "alice" or userName eq "bob"`, "userName", "", false},
+		{`userName eq "unterminated`, "userName", "", false},
+		{`userName eq "bad \q escape"`, "userName", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.filter, func(t *testing.T) {
+			value, err := parseEqualityFilter(tt.filter, tt.attr)
+			if (err == nil) != tt.ok {
+				t.Fatalf("parseEqualityFilter = %q, %v; want ok %v", value, err, tt.ok)
+			}
+			if err != nil && !errors.Is(err, ErrInvalidSCIMFilter) {
+				t.Fatalf("error %v is not ErrInvalidSCIMFilter", err)
+			}
+			if value != tt.value {
+				t.Fatalf("value = %q, want %q", value, tt.value)
+			}
+		})
+	}
+}
+
+func TestMemberPath(t *testing.T) {
+	tests := []struct {
+		path   string
+		member string // JSON string, "" for no match
+	}{
+		{`members[value eq "` + aliceID + `"]`, `"` + aliceID + `"`},
+		{`Members[Value EQ "` + aliceID + `"]`, `"` + aliceID + `"`},
+		{`members[ value eq "` + aliceID + `" ]`, `"` + aliceID + `"`},
+		{`members[value eq "with \"quotes\""]`, `"with \"quotes\""`},
+		{`members`, ""},
+		{`members[display eq "alice"]`, ""},
+		{`members[value eq "` + aliceID + `"].display`, ""},
+		{`members[value ne "` + aliceID + `"]`, ""},
+		{`members[value eq ` + aliceID + `]`, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			match := memberPath.FindStringSubmatch(tt.path)
+			switch {
+			case tt.member == "" && match != nil:
+				t.Fatalf("matched %q", match[1])
+			case tt.member != "" && (match == nil || match[1] != tt.member):
+				t.Fatalf("match = %q, want %s", match, tt.member)
+			}
+		})
+	}
+}
+
+func TestSCIMPage(t *testing.T) {
+	tests := []struct {
+		startIndex, count int
+		offset, limit     int
+	}{
+		{0, -1, 0, defaultPageSize},
+		{1, 10, 0, 10},
+		{5, 10, 4, 10},
+		{-3, 10, 0, 10},
+		{1, 0, 0, 0},
+		{1, maxPageSize + 1, 0, maxPageSize},
+	}
+
+	for _, tt := range tests {
+		offset, limit := scimPage(tt.startIndex, tt.count)
+		if offset != tt.offset || limit != tt.limit {
+			t.Errorf("scimPage(%d, %d) = %d, %d; want %d, %d", tt.startIndex, tt.count, offset, limit, tt.offset, tt.limit)
+		}
+	}
+}
+
+func TestGetUsersByUserName(t *testing.T) {
+	scim, users, _ := newSCIMTestService()
+	// Usernames are unique only as written, so one filter can match two users
+	users.users[carolID].Username = "DAVE"
+
+	tests := []struct {
+		name              string
+		filter            string
+		startIndex, count int
+		total             int
+		found             []string
+	}{
+		{"another case", `userName eq "dave"`, 1, -1, 2, []string{carolID, daveID}},
+		{"second page", `userName eq "dave"`, 2, 1, 2, []string{daveID}},
+		{"past the end", `userName eq "dave"`, 3, 1, 2, nil},
+		{"count of zero", `userName eq "dave"`, 1, 0, 2, nil},
+		{"no match", `userName eq "erin"`, 1, -1, 0, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			list, err := scim.GetUsers(context.Background(), tt.filter, tt.startIndex, tt.count)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var found []string
+			for _, user := range list.Resources.([]*web.SCIMUser) {
+				found = append(found, user.ID)
+			}
+			if list.TotalResults != tt.total || !slices.Equal(found, tt.found) {
+				t.Fatalf("found %v of %d, want %v of %d", found, list.TotalResults, tt.found, tt.total)
+			}
+			if list.StartIndex != max(tt.startIndex, 1) || list.ItemsPerPage != len(found) {
+				t.Fatalf("startIndex %d, itemsPerPage %d", list.StartIndex, list.ItemsPerPage)
+			}
+		})
+	}
+}
+
+func TestPatchGroup(t *testing.T) {
+	members := func(ids ...string) string {
+		var refs []string
+		for _, id := range ids {
+			refs = append(refs, `{"value": "`+id+`"}`)
+		}
+		return "[" + strings.Join(refs, ", ") + "]"
+	}
+
+	tests := []struct {
+		name   string
+		op     web.SCIMPatchOperation
+		err    error
+		admins []string // alice is the only member, carol an admin by hand
+	}{
+		{"add", web.SCIMPatchOperation{Op: "add", Path: "members", Value: []byte(members(bobID))}, nil, []string{aliceID, bobID, carolID}},
+		{"add without a path", web.SCIMPatchOperation{Op: "add", Value: []byte(`{"members": ` + members(bobID) + `}`)}, nil, []string{aliceID, bobID, carolID}},
+		{"replace", web.SCIMPatchOperation{Op: "replace", Path: "members", Value: []byte(members(bobID, daveID))}, nil, []string{bobID, carolID, daveID}},
+		{"replace with nobody", web.SCIMPatchOperation{Op: "replace", Path: "members", Value: []byte(`[]`)}, nil, []string{carolID}},
+		{"replace naming an unknown user", web.SCIMPatchOperation{Op: "replace", Path: "members", Value: []byte(members(bobID, "00000000-0000-0000-0000-000000000999"))}, ErrInvalidSCIMValue, []string{aliceID, carolID}},
+		{"replace naming a malformed ID", web.SCIMPatchOperation{Op: "replace", Path: "members", Value: []byte(members(bobID, "bob"))}, ErrInvalidSCIMValue, []string{aliceID, carolID}},
+		{"remove by path", web.SCIMPatchOperation{Op: "remove", Path: `members[value eq "` + aliceID + `"]`}, nil, []string{carolID}},
+		{"remove a hand-made admin", web.SCIMPatchOperation{Op: "remove", Path: `members[value eq "` + carolID + `"]`}, nil, []string{aliceID, carolID}},
+		{"remove everyone", web.SCIMPatchOperation{Op: "remove", Path: "members"}, nil, []string{carolID}},
+		{"unknown op", web.SCIMPatchOperation{Op: "move", Path: "members", Value: []byte(members(bobID))}, ErrInvalidSCIMPatch, []string{aliceID, carolID}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scim, users, _ := newSCIMTestService()
+
+			group, err := scim.PatchGroup(context.Background(), "admins", &web.SCIMPatchRequest{Operations: []web.SCIMPatchOperation{tt.op}})
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("PatchGroup = %v, want %v", err, tt.err)
+			}
+
+			var admins []string
+			for id, user := range users.users {
+				if user.Role == domain.UserRoleAdmin {
+					admins = append(admins, id)
+				}
+			}
+			slices.Sort(admins)
+			if !slices.Equal(admins, tt.admins) {
+				t.Fatalf("admins = %v, want %v", admins, tt.admins)
+			}
+
+			if err == nil {
+				for _, member := range group.Members {
+					if member.Value == carolID {
+						t.Fatal("an admin made by hand is listed as a member")
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestPatchGroupIsAllOrNothing(t *testing.T) {
+	scim, users, groups := newSCIMTestService()
+
+	// The first operation is fine, the second names nobody
+	_, err := scim.PatchGroup(context.Background(), "admins", &web.SCIMPatchRequest{Operations: []web.SCIMPatchOperation{
+		{Op: "remove", Path: "members"},
+		{Op: "add", Path: "members", Value: []byte(`[{"value": "00000000-0000-0000-0000-000000000999"}]`)},
+	}})
+	if !errors.Is(err, ErrInvalidSCIMValue) {
+		t.Fatalf("PatchGroup = %v, want ErrInvalidSCIMValue", err)
+	}
+	if users.users[aliceID].Role != domain.UserRoleAdmin || !groups.members["admins"][aliceID] {
+		t.Fatal("the first operation was applied")
+	}
+}
+
+func TestSCIMEmailsAreNotVerified(t *testing.T) {
+	scim, users, _ := newSCIMTestService()
+	ctx := context.Background()
+
+	created, err := scim.CreateUser(ctx, &web.SCIMUser{UserName: "erin", Emails: []web.SCIMEmail{{Value: "erin@example.com", Primary: true}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if users.users[created.ID].Verified {
+		t.Fatal("a new user's address was taken as verified")
+	}
+
+	// Changing a verified address makes the new one unverified
+	users.users[bobID].Email, users.users[bobID].Verified = "bob@example.com", true
+	if _, err := scim.PatchUser(ctx, bobID, &web.SCIMPatchRequest{Operations: []web.SCIMPatchOperation{
+		{Op: "replace", Path: "emails[type eq \"work\"].value", Value: []byte(`"alice@example.com"`)},
+	}}); err != nil {
+		t.Fatal(err)
+	}
+	if bob := users.users[bobID]; bob.Email != "alice@example.com" || bob.Verified {
+		t.Fatalf("bob = %+v, want the new address unverified", bob)
+	}
+}
@@ -0,0 +1,76 @@
+package service
+
+import (
+	"context"
+	"rtdocs/repository"
+	"rtdocs/utils"
+	"time"
+)
+
+const (
+	// defaultSweepInterval is used when SWEEP_INTERVAL is unset or invalid
+	defaultSweepInterval = time.Hour
+	// defaultLoginAttemptRetention is used when LOGIN_ATTEMPT_RETENTION is unset or invalid
+	defaultLoginAttemptRetention = 90 * 24 * time.Hour
+)
+
+// Sweeper deletes data that is no longer needed:
+//   - guest accounts whose token has expired, together with the documents they
+//     created. Guests that registered or logged in in time no longer exist,
+//     their data having moved to the real account.
+//   - login failure counts that have run past their lockout
+//   - login attempts older than the audit log is kept for
+type Sweeper interface {
+	Run(ctx context.Context)
+	Sweep(ctx context.Context) error
+}
+
+type sweeper struct {
+	userRepo         repository.UserRepository
+	attemptRepo      repository.LoginAttemptRepository
+	interval         time.Duration
+	attemptRetention time.Duration
+}
+
+func NewSweeper(userRepo repository.UserRepository, attemptRepo repository.LoginAttemptRepository) Sweeper {
+	interval, err := time.ParseDuration(utils.GetEnv("SWEEP_INTERVAL"))
+	if err != nil || interval <= 0 {
+		interval = defaultSweepInterval
+	}
+	retention, err := time.ParseDuration(utils.GetEnv("LOGIN_ATTEMPT_RETENTION"))
+	if err != nil || retention <= 0 {
+		retention = defaultLoginAttemptRetention
+	}
+
+	return &sweeper{userRepo: userRepo, attemptRepo: attemptRepo, interval: interval, attemptRetention: retention}
+}
+
+// Run sweeps on an interval until ctx is cancelled
+func (s *sweeper) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.Sweep(ctx); err != nil {
+				utils.NewLogger().Errorw("Failed to sweep", "error", err)
+			}
+		}
+	}
+}
+
+// Sweep deletes everything that has expired now
+func (s *sweeper) Sweep(ctx context.Context) error {
+	now := time.Now()
+	if _, err := s.userRepo.DeleteExpiredGuests(ctx, now.Add(-utils.GuestTokenDuration)); err != nil {
+		return err
+	}
+	if _, err := s.attemptRepo.DeleteFailuresBefore(ctx, now.Add(-max(usernameThrottle.lockout, ipThrottle.lockout))); err != nil {
+		return err
+	}
+	_, err := s.attemptRepo.DeleteLoginAttemptsBefore(ctx, now.Add(-s.attemptRetention))
+	return err
+}
@@ -2,40 +2,166 @@ package service
 
 import (
 	"context"
+	"errors"
 	"rtdocs/model/domain"
 	"rtdocs/repository"
+	"strings"
 
 	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
 )
 
+var ErrInvalidUserRole = errors.New("role must be authenticated, guest or admin")
+
 type UserService interface {
 	GetUser(ctx context.Context, id string) (*domain.User, error)
 	GetAllUsers(ctx context.Context) ([]*domain.User, error)
 	CreateUser(ctx context.Context, newUser *domain.User) (*domain.User, error)
 	UpdateUser(ctx context.Context, updatedUser *domain.User) (*domain.User, error)
+	GetRole(ctx context.Context, id string) (string, error)
+	SearchUsers(ctx context.Context, search string, limit, offset int) ([]*domain.User, int, error)
+	SetDisabled(ctx context.Context, id string, disabled bool) error
+	RequirePasswordReset(ctx context.Context, id string) error
 }
 
 type userService struct {
-	repo repository.UserRepository
+	repo      repository.UserRepository
+	passwords PasswordPolicy
 }
 
-func NewUserService(repo repository.UserRepository) UserService {
-	return &userService{repo: repo}
+func NewUserService(repo repository.UserRepository, passwords PasswordPolicy) UserService {
+	return &userService{repo: repo, passwords: passwords}
 }
 
 func (s *userService) GetUser(ctx context.Context, id string) (*domain.User, error) {
-	return s.repo.GetUser(ctx, id)
+	user, err := s.repo.GetUser(ctx, id)
+	if err != nil || user == nil {
+		return nil, err
+	}
+	return withoutPassword(user), nil
 }
 
 func (s *userService) GetAllUsers(ctx context.Context) ([]*domain.User, error) {
-	return s.repo.GetAllUsers(ctx)
+	users, err := s.repo.GetAllUsers(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, user := range users {
+		withoutPassword(user)
+	}
+	return users, nil
 }
 
 func (s *userService) CreateUser(ctx context.Context, newUser *domain.User) (*domain.User, error) {
+	if newUser.Username == "" || newUser.Password == "" {
+		return nil, errors.New("username and password are required")
+	}
+	if newUser.Role == "" {
+		newUser.Role = domain.UserRoleAuthenticated
+	}
+	if !validUserRole(newUser.Role) {
+		return nil, ErrInvalidUserRole
+	}
+	if err := s.passwords.Validate(newUser.Password, newUser.Username); err != nil {
+		return nil, err
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(newUser.Password), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, err
+	}
+	newUser.Password = string(hashedPassword)
+
 	newUser.ID = uuid.New().String()
-	return s.repo.CreateUser(ctx, newUser)
+	createdUser, err := s.repo.CreateUser(ctx, newUser)
+	if err != nil {
+		return nil, err
+	}
+	return withoutPassword(createdUser), nil
 }
 
+// UpdateUser changes the fields that are set on updatedUser; the password is hashed
 func (s *userService) UpdateUser(ctx context.Context, updatedUser *domain.User) (*domain.User, error) {
-	return s.repo.UpdateUser(ctx, updatedUser)
+	user, err := s.repo.GetUser(ctx, updatedUser.ID)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, errors.New("user ID is required")
+	}
+
+	if updatedUser.Username != "" {
+		user.Username = updatedUser.Username
+	}
+	if updatedUser.Email != "" && !strings.EqualFold(updatedUser.Email, user.Email) {
+		// A new address has to be verified again
+		user.Email = updatedUser.Email
+		user.Verified = false
+	}
+	if updatedUser.Role != "" {
+		if !validUserRole(updatedUser.Role) {
+			return nil, ErrInvalidUserRole
+		}
+		user.Role = updatedUser.Role
+	}
+	if updatedUser.Password != "" {
+		if err := s.passwords.Validate(updatedUser.Password, user.Username); err != nil {
+			return nil, err
+		}
+		hashedPassword, err := bcrypt.GenerateFromPassword([]byte(updatedUser.Password), bcrypt.DefaultCost)
+		if err != nil {
+			return nil, err
+		}
+		user.Password = string(hashedPassword)
+	}
+
+	savedUser, err := s.repo.UpdateUser(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+	return withoutPassword(savedUser), nil
+}
+
+// GetRole returns a user's account role, or "" if the user does not exist
+func (s *userService) GetRole(ctx context.Context, id string) (string, error) {
+	user, err := s.repo.GetUser(ctx, id)
+	if err != nil || user == nil {
+		return "", err
+	}
+	return user.Role, nil
+}
+
+func (s *userService) SearchUsers(ctx context.Context, search string, limit, offset int) ([]*domain.User, int, error) {
+	users, total, err := s.repo.SearchUsers(ctx, search, limit, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	for _, user := range users {
+		withoutPassword(user)
+	}
+	return users, total, nil
+}
+
+// SetDisabled disables or re-enables an account
+func (s *userService) SetDisabled(ctx context.Context, id string, disabled bool) error {
+	return s.repo.SetDisabled(ctx, id, disabled)
+}
+
+// RequirePasswordReset stops a user logging in until they reset their password
+func (s *userService) RequirePasswordReset(ctx context.Context, id string) error {
+	return s.repo.SetPasswordResetRequired(ctx, id, true)
+}
+
+func validUserRole(role string) bool {
+	switch role {
+	case domain.UserRoleAuthenticated, domain.UserRoleGuest, domain.UserRoleAdmin:
+		return true
+	}
+	return false
+}
+
+// withoutPassword clears the password hash so it is never sent to a client
+func withoutPassword(user *domain.User) *domain.User {
+	user.Password = ""
+	return user
 }
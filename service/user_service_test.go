@@ -0,0 +1,145 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"rtdocs/model/domain"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// storedUsers hands out copies of users, as the database does, so clearing a
+// returned password cannot clear the stored hash
+type storedUsers struct {
+	memoryUsers
+}
+
+func newStoredUsers(users ...*domain.User) *storedUsers {
+	s := &storedUsers{memoryUsers{users: make(map[string]*domain.User)}}
+	for _, user := range users {
+		s.users[user.ID] = user
+	}
+	return s
+}
+
+func (s *storedUsers) GetUser(ctx context.Context, id string) (*domain.User, error) {
+	user, ok := s.users[id]
+	if !ok {
+		return nil, nil
+	}
+	found := *user
+	return &found, nil
+}
+
+func (s *storedUsers) GetAllUsers(ctx context.Context) ([]*domain.User, error) {
+	var users []*domain.User
+	for _, user := range s.users {
+		found := *user
+		users = append(users, &found)
+	}
+	return users, nil
+}
+
+func (s *storedUsers) CreateUser(ctx context.Context, user *domain.User) (*domain.User, error) {
+	stored := *user
+	s.users[user.ID] = &stored
+	return user, nil
+}
+
+func (s *storedUsers) UpdateUser(ctx context.Context, user *domain.User) (*domain.User, error) {
+	return s.CreateUser(ctx, user)
+}
+
+func TestCreateUserRole(t *testing.T) {
+	tests := []struct {
+		role string
+		want string
+		err  error
+	}{
+		{"", domain.UserRoleAuthenticated, nil},
+		{domain.UserRoleAdmin, domain.UserRoleAdmin, nil},
+		{domain.UserRoleGuest, domain.UserRoleGuest, nil},
+		{"user", "", ErrInvalidUserRole},
+		{"superuser", "", ErrInvalidUserRole},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.role, func(t *testing.T) {
+			ctx := context.Background()
+			users := newStoredUsers()
+			s := NewUserService(users, anyPassword{})
+
+			created, err := s.CreateUser(ctx, &domain.User{Username: "alice", Password: "correct horse", Role: tt.role})
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("CreateUser = %v, want %v", err, tt.err)
+			}
+			if err != nil {
+				if len(users.users) != 0 {
+					t.Fatal("CreateUser stored a user with an invalid role")
+				}
+				return
+			}
+
+			if created.Password != "" {
+				t.Fatal("CreateUser returned the password hash")
+			}
+			if role, err := s.GetRole(ctx, created.ID); err != nil || role != tt.want {
+				t.Fatalf("GetRole = %q, %v; want %q", role, err, tt.want)
+			}
+			stored := users.users[created.ID]
+			if bcrypt.CompareHashAndPassword([]byte(stored.Password), []byte("correct horse")) != nil {
+				t.Fatal("CreateUser did not store a hash of the password")
+			}
+		})
+	}
+}
+
+func TestUpdateUserRole(t *testing.T) {
+	ctx := context.Background()
+	users := newStoredUsers(&domain.User{ID: "alice", Username: "alice", Password: "hash", Role: domain.UserRoleAuthenticated})
+	s := NewUserService(users, anyPassword{})
+
+	if _, err := s.UpdateUser(ctx, &domain.User{ID: "alice", Role: "root"}); !errors.Is(err, ErrInvalidUserRole) {
+		t.Fatalf("UpdateUser to an unknown role = %v, want ErrInvalidUserRole", err)
+	}
+	if role, _ := s.GetRole(ctx, "alice"); role != domain.UserRoleAuthenticated {
+		t.Fatalf("role after a rejected update = %q", role)
+	}
+
+	updated, err := s.UpdateUser(ctx, &domain.User{ID: "alice", Role: domain.UserRoleAdmin})
+	if err != nil {
+		t.Fatalf("UpdateUser: %v", err)
+	}
+	if updated.Password != "" || users.users["alice"].Password != "hash" {
+		t.Fatalf("UpdateUser returned password %q and stored %q; want none and the old hash", updated.Password, users.users["alice"].Password)
+	}
+	if role, _ := s.GetRole(ctx, "alice"); role != domain.UserRoleAdmin {
+		t.Fatalf("role after promotion = %q, want admin", role)
+	}
+
+	if role, err := s.GetRole(ctx, "nobody"); err != nil || role != "" {
+		t.Fatalf("GetRole of an unknown user = %q, %v; want none", role, err)
+	}
+}
+
+func TestUsersAreListedWithoutPasswords(t *testing.T) {
+	ctx := context.Background()
+	s := NewUserService(newStoredUsers(
+		&domain.User{ID: "alice", Username: "alice", Password: "hash"},
+		&domain.User{ID: "bob", Username: "bob", Password: "hash"},
+	), anyPassword{})
+
+	users, err := s.GetAllUsers(ctx)
+	if err != nil || len(users) != 2 {
+		t.Fatalf("GetAllUsers = %v, %v; want two users", users, err)
+	}
+	for _, user := range users {
+		if user.Password != "" {
+			t.Fatalf("GetAllUsers returned the password hash of %s", user.ID)
+		}
+	}
+	if user, err := s.GetUser(ctx, "alice"); err != nil || user.Password != "" {
+		t.Fatalf("GetUser = %+v, %v; want alice without a password", user, err)
+	}
+}
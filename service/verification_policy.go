@@ -0,0 +1,71 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"rtdocs/repository"
+	"rtdocs/utils"
+	"strings"
+)
+
+// Actions that can be withheld from users who have not verified their email address
+const (
+	ActionSharePublic     = "share_public"
+	ActionCreateDocument  = "create_document"
+	ActionGrantPermission = "grant_permission"
+)
+
+// defaultUnverifiedRestrictions is used when UNVERIFIED_RESTRICTIONS is unset
+const defaultUnverifiedRestrictions = ActionSharePublic
+
+var ErrEmailNotVerified = errors.New("you must verify your email address first")
+
+// VerificationPolicy decides what users may do before verifying their email address
+type VerificationPolicy interface {
+	Require(ctx context.Context, userID, action string) error
+}
+
+type verificationPolicy struct {
+	userRepo   repository.UserRepository
+	restricted map[string]bool
+}
+
+// NewVerificationPolicy restricts the actions listed, comma separated, in
+// UNVERIFIED_RESTRICTIONS. Set it to "none" to allow unverified users everything.
+func NewVerificationPolicy(userRepo repository.UserRepository) VerificationPolicy {
+	setting := utils.GetEnv("UNVERIFIED_RESTRICTIONS")
+	if setting == "" {
+		setting = defaultUnverifiedRestrictions
+	}
+
+	restricted := make(map[string]bool)
+	for _, action := range strings.Split(setting, ",") {
+		switch action = strings.TrimSpace(action); action {
+		case ActionSharePublic, ActionCreateDocument, ActionGrantPermission:
+			restricted[action] = true
+		case "", "none":
+		default:
+			utils.NewLogger().Warnw("Ignoring unknown unverified restriction", "action", action)
+		}
+	}
+
+	return &verificationPolicy{userRepo: userRepo, restricted: restricted}
+}
+
+// Require returns ErrEmailNotVerified if the action is restricted and the
+// user is not verified, or there is no user
+func (p *verificationPolicy) Require(ctx context.Context, userID, action string) error {
+	if !p.restricted[action] {
+		return nil
+	}
+
+	user, err := p.userRepo.GetUser(ctx, userID)
+	if err != nil {
+		return err
+	}
+	// Anonymous requests have no address to verify
+	if user == nil || !user.Verified {
+		return ErrEmailNotVerified
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"rtdocs/model/domain"
+	"rtdocs/repository"
+	"testing"
+)
+
+// knownUsers looks users up by ID, returning nil for an empty ID like the repository does
+type knownUsers struct {
+	repository.UserRepository
+	users map[string]*domain.User
+}
+
+func (u *knownUsers) GetUser(ctx context.Context, id string) (*domain.User, error) {
+	if id == "" {
+		return nil, nil
+	}
+	user, ok := u.users[id]
+	if !ok {
+		return nil, errors.New("user not found")
+	}
+	return user, nil
+}
+
+func TestVerificationPolicy(t *testing.T) {
+	users := &knownUsers{users: map[string]*domain.User{
+		"verified":   {ID: "verified", Verified: true},
+		"unverified": {ID: "unverified"},
+	}}
+	policy := &verificationPolicy{userRepo: users, restricted: map[string]bool{ActionSharePublic: true}}
+
+	tests := []struct {
+		user, action string
+		want         error
+	}{
+		{"verified", ActionSharePublic, nil},
+		{"unverified", ActionSharePublic, ErrEmailNotVerified},
+		{"", ActionSharePublic, ErrEmailNotVerified},
+		{"unverified", ActionCreateDocument, nil},
+		{"", ActionCreateDocument, nil},
+	}
+
+	for _, tt := range tests {
+		if err := policy.Require(context.Background(), tt.user, tt.action); !errors.Is(err, tt.want) {
+			t.Errorf("Require(%q, %s) = %v, want %v", tt.user, tt.action, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package utils
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// BreachedPasswords checks passwords against a local copy of a breached
+// password list in the Have I Been Pwned format: uppercase SHA-1 hashes with
+// an optional ":count". The list is either one file of full hashes, loaded
+// into memory, or a directory of k-anonymity range files named by the first
+// five characters of the hash and holding the remaining 35, of which only the
+// one file for a password's prefix is read.
+type BreachedPasswords struct {
+	dir    string
+	hashes map[string]struct{}
+}
+
+// LoadBreachedPasswords opens the list at path, a file or a directory of range files
+func LoadBreachedPasswords(path string) (*BreachedPasswords, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return &BreachedPasswords{dir: path}, nil
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	hashes := make(map[string]struct{})
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		hash, ok := parseBreachedLine(scanner.Text())
+		if ok && len(hash) == sha1.Size*2 {
+			hashes[hash] = struct{}{}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return &BreachedPasswords{hashes: hashes}, nil
+}
+
+// Contains reports whether password appears in the list
+func (b *BreachedPasswords) Contains(password string) (bool, error) {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+
+	if b.hashes != nil {
+		_, ok := b.hashes[hash]
+		return ok, nil
+	}
+
+	prefix, suffix := hash[:5], hash[5:]
+	file, err := b.openRange(prefix)
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if line, ok := parseBreachedLine(scanner.Text()); ok && line == suffix {
+			return true, nil
+		}
+	}
+	return false, scanner.Err()
+}
+
+// openRange opens the range file for a hash prefix, which downloaders name with or without ".txt"
+func (b *BreachedPasswords) openRange(prefix string) (*os.File, error) {
+	file, err := os.Open(filepath.Join(b.dir, prefix))
+	if errors.Is(err, fs.ErrNotExist) {
+		return os.Open(filepath.Join(b.dir, prefix+".txt"))
+	}
+	return file, err
+}
+
+// parseBreachedLine returns the hash on a line of the list, skipping lines
+// that are not a hex hash with an optional positive count. Lines with a count
+// of 0 are padding some range files are served with, not real entries.
+func parseBreachedLine(line string) (string, bool) {
+	hash, count, hasCount := strings.Cut(strings.TrimSpace(line), ":")
+	if hash == "" || strings.Trim(hash, "0123456789ABCDEFabcdef") != "" {
+		return "", false
+	}
+	if hasCount {
+		if n, err := strconv.Atoi(count); err != nil || n <= 0 {
+			return "", false
+		}
+	}
+	return strings.ToUpper(hash), true
+}
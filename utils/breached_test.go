@@ -0,0 +1,136 @@
+package utils
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func sha1Hex(password string) string {
+	sum := sha1.Sum([]byte(password))
+	return strings.ToUpper(hex.EncodeToString(sum[:]))
+}
+
+func TestParseBreachedLine(t *testing.T) {
+	tests := []struct {
+		line string
+		hash string // "" when the line must be skipped
+	}{
+		{"0018A45C4D1DEF81644B54AB7F969B88D65:3", "0018A45C4D1DEF81644B54AB7F969B88D65"},
+		{"0018a45c4d1def81644b54ab7f969b88d65:12\r", "0018A45C4D1DEF81644B54AB7F969B88D65"},
+		{"  0018A45C4D1DEF81644B54AB7F969B88D65  ", "0018A45C4D1DEF81644B54AB7F969B88D65"},
+		{"0018A45C4D1DEF81644B54AB7F969B88D65:0", ""},
+		{"0018A45C4D1DEF81644B54AB7F969B88D65:00", ""},
+		{"0018A45C4D1DEF81644B54AB7F969B88D65:-1", ""},
+		{"0018A45C4D1DEF81644B54AB7F969B88D65:many", ""},
+		{"0018A45C4D1DEF81644B54AB7F969B88D65:", ""},
+		{"not a hash:3", ""},
+		{":3", ""},
+		{"", ""},
+	}
+
+	for _, tt := range tests {
+		hash, ok := parseBreachedLine(tt.line)
+		if ok != (tt.hash != "") || hash != tt.hash {
+			t.Errorf("parseBreachedLine(%q) = %q, %v; want %q", tt.line, hash, ok, tt.hash)
+		}
+	}
+}
+
+// breachedLines lists the breached passwords in the HIBP format, each hash
+// cut down by cut and followed by its count, along with some malformed lines
+func breachedLines(cut func(string) string, counts map[string]string) string {
+	lines := []string{"", "garbage", "ZZZZ:1"}
+	for password, count := range counts {
+		lines = append(lines, cut(sha1Hex(password))+count)
+	}
+	return strings.Join(lines, "\r\n") + "\r\n"
+}
+
+var breachedCounts = map[string]string{
+	"password":    ":3861493",
+	"hunter2":     ":17043",
+	"uncounted":   "",
+	"padding":     ":0",
+	"miscounted":  ":lots",
+	"letmein1234": ":1",
+}
+
+var breachedWant = map[string]bool{
+	"password":     true,
+	"hunter2":      true,
+	"uncounted":    true,
+	"padding":      false,
+	"miscounted":   false,
+	"letmein1234":  true,
+	"not-breached": false,
+}
+
+func TestBreachedPasswordsFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pwned-passwords-sha1-ordered-by-hash.txt")
+	if err := os.WriteFile(path, []byte(breachedLines(func(hash string) string { return hash }, breachedCounts)), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	breached, err := LoadBreachedPasswords(path)
+	if err != nil {
+		t.Fatalf("LoadBreachedPasswords: %v", err)
+	}
+
+	for password, want := range breachedWant {
+		if got, err := breached.Contains(password); err != nil || got != want {
+			t.Errorf("Contains(%q) = %v, %v; want %v", password, got, err, want)
+		}
+	}
+}
+
+func TestBreachedPasswordsRangeDirectory(t *testing.T) {
+	dir := t.TempDir()
+	ranges := make(map[string]map[string]string)
+	for password, count := range breachedCounts {
+		prefix := sha1Hex(password)[:5]
+		if ranges[prefix] == nil {
+			ranges[prefix] = make(map[string]string)
+		}
+		ranges[prefix][password] = count
+	}
+	i := 0
+	for prefix, counts := range ranges {
+		// Downloaders name range files with or without an extension
+		name := prefix
+		if i%2 == 1 {
+			name += ".txt"
+		}
+		i++
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(breachedLines(func(hash string) string { return hash[5:] }, counts)), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	breached, err := LoadBreachedPasswords(dir)
+	if err != nil {
+		t.Fatalf("LoadBreachedPasswords: %v", err)
+	}
+	for password, want := range breachedWant {
+		if got, err := breached.Contains(password); err != nil || got != want {
+			t.Errorf("Contains(%q) = %v, %v; want %v", password, got, err, want)
+		}
+	}
+
+	// A full hash in a range file does not match the suffix
+	prefix := sha1Hex("secret")[:5]
+	if err := os.WriteFile(filepath.Join(dir, prefix), []byte(sha1Hex("secret")+":5\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := breached.Contains("secret"); got {
+		t.Error("Contains matched a full hash in a range file")
+	}
+}
+
+func TestLoadBreachedPasswordsMissing(t *testing.T) {
+	if _, err := LoadBreachedPasswords(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Fatal("LoadBreachedPasswords of a missing path succeeded")
+	}
+}
@@ -0,0 +1,125 @@
+package utils
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// trustedProxies are the reverse proxies in front of the server, set once at startup
+var trustedProxies []netip.Prefix
+
+// TrustProxies sets which peers are trusted to report the client's address in
+// X-Forwarded-For. list holds addresses and CIDR ranges separated by commas,
+// as in TRUSTED_PROXIES; an empty list trusts no one.
+func TrustProxies(list string) error {
+	var proxies []netip.Prefix
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if prefix, err := netip.ParsePrefix(entry); err == nil {
+			proxies = append(proxies, prefix.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(entry)
+		if err != nil {
+			return fmt.Errorf("%q is neither an address nor a CIDR range", entry)
+		}
+		proxies = append(proxies, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+	}
+
+	trustedProxies = proxies
+	return nil
+}
+
+// ClientIP returns the address the request came from. X-Forwarded-For is only
+// believed as far as trusted proxies added to it, because any client can set it.
+func ClientIP(r *http.Request) string {
+	return clientIP(r, trustedProxies)
+}
+
+// clientIP walks X-Forwarded-For from the right, starting at the peer, for as
+// long as each hop is a trusted proxy. The first hop that is not is the client.
+func clientIP(r *http.Request, proxies []netip.Prefix) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil || !trusted(addr, proxies) {
+		return host
+	}
+
+	var hops []string
+	for _, value := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(value, ",")...)
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			// A proxy we trust passed on something that is not an address
+			break
+		}
+		addr = hop
+		if !trusted(hop, proxies) {
+			break
+		}
+	}
+	return addr.String()
+}
+
+func trusted(addr netip.Addr, proxies []netip.Prefix) bool {
+	for _, proxy := range proxies {
+		if proxy.Contains(addr.Unmap()) {
+			return true
+		}
+	}
+	return false
+}
+
+// DeviceName gives a short, human readable description of a user agent such
+// as "Firefox on Windows", good enough for a user to recognise their devices.
+func DeviceName(userAgent string) string {
+	if userAgent == "" {
+		return "Unknown device"
+	}
+
+	os := "Unknown OS"
+	for _, candidate := range []struct{ token, name string }{
+		{"iPhone", "iPhone"},
+		{"iPad", "iPad"},
+		{"Android", "Android"},
+		{"Windows", "Windows"},
+		{"Mac OS X", "macOS"},
+		{"CrOS", "ChromeOS"},
+		{"Linux", "Linux"},
+	} {
+		if strings.Contains(userAgent, candidate.token) {
+			os = candidate.name
+			break
+		}
+	}
+
+	// Order matters: Edge and Opera mention Chrome, and Chrome mentions Safari
+	browser := ""
+	for _, candidate := range []struct{ token, name string }{
+		{"Edg/", "Edge"},
+		{"OPR/", "Opera"},
+		{"Firefox/", "Firefox"},
+		{"Chrome/", "Chrome"},
+		{"Safari/", "Safari"},
+	} {
+		if strings.Contains(userAgent, candidate.token) {
+			browser = candidate.name
+			break
+		}
+	}
+	if browser == "" {
+		return os
+	}
+	return browser + " on " + os
+}
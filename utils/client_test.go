@@ -0,0 +1,53 @@
+package utils
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	if err := TrustProxies("10.0.0.0/8, 192.0.2.1,2001:db8::/32"); err != nil {
+		t.Fatal(err)
+	}
+	defer TrustProxies("")
+
+	tests := []struct {
+		name         string
+		remoteAddr   string
+		forwardedFor []string
+		want         string
+	}{
+		{"direct", "203.0.113.7:51234", nil, "203.0.113.7"},
+		{"untrusted peer forwarding", "203.0.113.7:51234", []string{"198.51.100.1"}, "203.0.113.7"},
+		{"trusted proxy", "10.1.2.3:443", []string{"198.51.100.1"}, "198.51.100.1"},
+		{"trusted proxy without a header", "10.1.2.3:443", nil, "10.1.2.3"},
+		{"spoofed hops left of the client", "10.1.2.3:443", []string{"1.1.1.1, 198.51.100.1"}, "198.51.100.1"},
+		{"chain of proxies", "192.0.2.1:443", []string{"198.51.100.1, 10.9.9.9"}, "198.51.100.1"},
+		{"several header lines", "10.1.2.3:443", []string{"1.1.1.1", "198.51.100.1, 10.0.0.1"}, "198.51.100.1"},
+		{"only proxies", "10.1.2.3:443", []string{"10.0.0.2"}, "10.0.0.2"},
+		{"garbage from the client", "10.1.2.3:443", []string{"not an address"}, "10.1.2.3"},
+		{"IPv6 proxy", "[2001:db8::1]:443", []string{"198.51.100.1"}, "198.51.100.1"},
+		{"IPv4-mapped proxy", "[::ffff:10.1.2.3]:443", []string{"198.51.100.1"}, "198.51.100.1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/api/auth/login", nil)
+			r.RemoteAddr = tt.remoteAddr
+			for _, value := range tt.forwardedFor {
+				r.Header.Add("X-Forwarded-For", value)
+			}
+			if got := ClientIP(r); got != tt.want {
+				t.Fatalf("ClientIP = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTrustProxiesRejectsGarbage(t *testing.T) {
+	for _, list := range []string{"proxy.example.com", "10.0.0.0/33", "10.0.0.1:80"} {
+		if err := TrustProxies(list); err == nil {
+			t.Errorf("TrustProxies(%q) accepted it", list)
+		}
+	}
+}
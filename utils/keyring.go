@@ -0,0 +1,361 @@
+package utils
+
+import (
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Signing algorithms. HS256 uses the shared secrets; the others use a Keyring.
+const (
+	AlgorithmHS256 = "HS256"
+	AlgorithmRS256 = "RS256"
+	AlgorithmEdDSA = "EdDSA"
+)
+
+const (
+	rsaKeyBits = 2048
+	// keyringCheckInterval is how often Run looks for a due rotation or a key
+	// written by another instance
+	keyringCheckInterval = time.Hour
+	// keyringReloadInterval is how often a token with an unknown kid may make
+	// the keyring read its directory again, in case another instance rotated
+	keyringReloadInterval = 10 * time.Second
+)
+
+var (
+	ErrUnknownAlgorithm = errors.New("unknown signing algorithm")
+	ErrNoSigningKey     = errors.New("no signing key")
+)
+
+// SigningKey is one asymmetric key of a keyring. ID is published as "kid".
+type SigningKey struct {
+	ID        string
+	Algorithm string
+	CreatedAt time.Time
+
+	private crypto.Signer
+}
+
+func (k *SigningKey) method() jwt.SigningMethod {
+	if k.Algorithm == AlgorithmEdDSA {
+		return jwt.SigningMethodEdDSA
+	}
+	return jwt.SigningMethodRS256
+}
+
+// Keyring holds the keys tokens are signed with. The newest key signs; older
+// ones stay available for verification until every token they signed has
+// expired. With a directory the keys are kept there as PEM files, so they
+// survive restarts and can be shared by several instances.
+type Keyring struct {
+	algorithm   string
+	dir         string
+	rotateEvery time.Duration
+	retain      time.Duration
+
+	mu         sync.RWMutex
+	keys       []*SigningKey // oldest first
+	reloadedAt time.Time
+}
+
+// NewKeyring loads the keys in dir, if any, and creates a first key when none
+// is current. Keys are replaced every rotateEvery and dropped retain after
+// being replaced, which must be at least the longest token lifetime.
+func NewKeyring(algorithm, dir string, rotateEvery, retain time.Duration) (*Keyring, error) {
+	if algorithm != AlgorithmRS256 && algorithm != AlgorithmEdDSA {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownAlgorithm, algorithm)
+	}
+
+	k := &Keyring{algorithm: algorithm, dir: dir, rotateEvery: rotateEvery, retain: retain}
+	if err := k.rotateIfDue(); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// Current returns the key new tokens are signed with
+func (k *Keyring) Current() (*SigningKey, error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	if len(k.keys) == 0 {
+		return nil, ErrNoSigningKey
+	}
+	return k.keys[len(k.keys)-1], nil
+}
+
+// Key returns the key with the given ID, or nil if it is unknown or retired.
+// An unknown ID may be a key another instance has just created, so the
+// directory is read again, at most once every keyringReloadInterval.
+func (k *Keyring) Key(id string) *SigningKey {
+	if key := k.key(id); key != nil || k.dir == "" {
+		return key
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if time.Since(k.reloadedAt) < keyringReloadInterval {
+		return k.find(id)
+	}
+	k.reloadedAt = time.Now()
+	if err := k.load(); err != nil {
+		NewLogger().Errorw("Failed to reload signing keys", "error", err)
+	}
+	return k.find(id)
+}
+
+func (k *Keyring) key(id string) *SigningKey {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	return k.find(id)
+}
+
+// find looks a key up by ID; k.mu must be held
+func (k *Keyring) find(id string) *SigningKey {
+	for _, key := range k.keys {
+		if key.ID == id {
+			return key
+		}
+	}
+	return nil
+}
+
+// Rotate makes a new key current straight away, e.g. after a key has leaked.
+// Tokens signed with the old keys keep working until those keys are retired.
+func (k *Keyring) Rotate() error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	return k.rotate()
+}
+
+// Run rotates keys on schedule until ctx is cancelled
+func (k *Keyring) Run(ctx context.Context) {
+	ticker := time.NewTicker(min(k.rotateEvery, keyringCheckInterval))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := k.rotateIfDue(); err != nil {
+				NewLogger().Errorw("Failed to rotate signing keys", "error", err)
+			}
+		}
+	}
+}
+
+func (k *Keyring) rotateIfDue() error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	// Another instance sharing the directory may already have rotated
+	if err := k.load(); err != nil {
+		return err
+	}
+	if len(k.keys) > 0 && time.Since(k.keys[len(k.keys)-1].CreatedAt) < k.rotateEvery {
+		return nil
+	}
+	return k.rotate()
+}
+
+// rotate generates and stores a new current key, then retires old keys; k.mu must be held
+func (k *Keyring) rotate() error {
+	key, err := generateSigningKey(k.algorithm)
+	if err != nil {
+		return err
+	}
+	if err := k.save(key); err != nil {
+		return err
+	}
+	k.keys = append(k.keys, key)
+
+	return k.retire()
+}
+
+// retire drops keys that were replaced more than k.retain ago; k.mu must be held
+func (k *Keyring) retire() error {
+	kept := k.keys[:0]
+	for i, key := range k.keys {
+		if i+1 < len(k.keys) && time.Since(k.keys[i+1].CreatedAt) > k.retain {
+			if k.dir != "" {
+				if err := os.Remove(filepath.Join(k.dir, key.ID+".pem")); err != nil && !errors.Is(err, os.ErrNotExist) {
+					return err
+				}
+			}
+			continue
+		}
+		kept = append(kept, key)
+	}
+	k.keys = kept
+	return nil
+}
+
+// load replaces the keys with the ones in k.dir, keeping the current ones if
+// it cannot read them all; k.mu must be held
+func (k *Keyring) load() error {
+	if k.dir == "" {
+		return nil
+	}
+	if err := os.MkdirAll(k.dir, 0o700); err != nil {
+		return err
+	}
+
+	entries, err := os.ReadDir(k.dir)
+	if err != nil {
+		return err
+	}
+
+	var keys []*SigningKey
+	for _, entry := range entries {
+		id, ok := strings.CutSuffix(entry.Name(), ".pem")
+		if !ok || entry.IsDir() {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(k.dir, entry.Name()))
+		if err != nil {
+			return err
+		}
+		key, err := parseSigningKey(id, data)
+		if err != nil {
+			return fmt.Errorf("signing key %s: %w", entry.Name(), err)
+		}
+		// Keys of another algorithm are left over from a change of JWT_ALGORITHM
+		if key.Algorithm == k.algorithm {
+			keys = append(keys, key)
+		}
+	}
+
+	sort.Slice(keys, func(i, j int) bool { return keys[i].CreatedAt.Before(keys[j].CreatedAt) })
+	k.keys = keys
+	return nil
+}
+
+// save writes a key to k.dir; k.mu must be held
+func (k *Keyring) save(key *SigningKey) error {
+	if k.dir == "" {
+		return nil
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(key.private)
+	if err != nil {
+		return err
+	}
+	data := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+	return os.WriteFile(filepath.Join(k.dir, key.ID+".pem"), data, 0o600)
+}
+
+func generateSigningKey(algorithm string) (*SigningKey, error) {
+	var private crypto.Signer
+	var err error
+	switch algorithm {
+	case AlgorithmRS256:
+		private, err = rsa.GenerateKey(rand.Reader, rsaKeyBits)
+	case AlgorithmEdDSA:
+		_, private, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		err = ErrUnknownAlgorithm
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// The ID starts with the creation time so it survives a round trip through the directory
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return nil, err
+	}
+	createdAt := time.Now()
+	id := strconv.FormatInt(createdAt.Unix(), 10) + "-" + hex.EncodeToString(suffix)
+
+	return &SigningKey{ID: id, Algorithm: algorithm, CreatedAt: createdAt, private: private}, nil
+}
+
+func parseSigningKey(id string, data []byte) (*SigningKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block")
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	seconds, _, _ := strings.Cut(id, "-")
+	unix, err := strconv.ParseInt(seconds, 10, 64)
+	if err != nil {
+		return nil, errors.New("key ID does not start with a timestamp")
+	}
+
+	key := &SigningKey{ID: id, CreatedAt: time.Unix(unix, 0)}
+	switch private := parsed.(type) {
+	case *rsa.PrivateKey:
+		key.Algorithm, key.private = AlgorithmRS256, private
+	case ed25519.PrivateKey:
+		key.Algorithm, key.private = AlgorithmEdDSA, private
+	default:
+		return nil, ErrUnknownAlgorithm
+	}
+	return key, nil
+}
+
+// JWK is a public key in JSON Web Key format (RFC 7517)
+type JWK struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+	Curve     string `json:"crv,omitempty"`
+	X         string `json:"x,omitempty"`
+}
+
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS returns the public half of every key tokens may still be signed with
+func (k *Keyring) JWKS() JWKSet {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	set := JWKSet{Keys: make([]JWK, 0, len(k.keys))}
+	for _, key := range k.keys {
+		jwk := JWK{KeyID: key.ID, Use: "sig", Algorithm: key.Algorithm}
+		switch public := key.private.Public().(type) {
+		case *rsa.PublicKey:
+			jwk.KeyType = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.KeyType = "OKP"
+			jwk.Curve = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(public)
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+	return set
+}
@@ -0,0 +1,140 @@
+package utils
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+)
+
+func TestKeyringSignsAndVerifies(t *testing.T) {
+	for _, algorithm := range []string{AlgorithmEdDSA, AlgorithmRS256} {
+		t.Run(algorithm, func(t *testing.T) {
+			keyring, err := NewKeyring(algorithm, t.TempDir(), time.Hour, time.Hour)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tokens := NewKeyringTokenGenerator(keyring, "15m", "24h")
+
+			token, err := tokens.GenerateToken("user", "alice", "session")
+			if err != nil {
+				t.Fatal(err)
+			}
+			principal, err := tokens.Verify(token.AccessToken, TokenTypeAccess)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if principal.UserID != "user" || principal.SessionID != "session" {
+				t.Fatalf("principal = %+v", principal)
+			}
+		})
+	}
+}
+
+func TestKeyringLoadsKeysFromAnotherInstance(t *testing.T) {
+	dir := t.TempDir()
+	first, err := NewKeyring(AlgorithmEdDSA, dir, time.Hour, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := NewKeyring(AlgorithmEdDSA, dir, time.Hour, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The second instance rotates; the first has not seen the new key yet
+	if err := second.Rotate(); err != nil {
+		t.Fatal(err)
+	}
+	rotated, err := second.Current()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token, err := NewKeyringTokenGenerator(second, "15m", "24h").GenerateToken("user", "alice", "session")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewKeyringTokenGenerator(first, "15m", "24h").Verify(token.AccessToken); err != nil {
+		t.Fatalf("token signed with a key from the shared directory was rejected: %v", err)
+	}
+	if first.Key(rotated.ID) == nil {
+		t.Fatal("the new key was not loaded")
+	}
+
+	// Unknown IDs do not reread the directory more than once per interval
+	if err := second.Rotate(); err != nil {
+		t.Fatal(err)
+	}
+	newest, _ := second.Current()
+	if first.Key("unknown") != nil || first.Key(newest.ID) != nil {
+		t.Fatal("keyring reloaded within the reload interval")
+	}
+	first.reloadedAt = time.Now().Add(-keyringReloadInterval)
+	if first.Key(newest.ID) == nil {
+		t.Fatal("keyring did not reload after the interval")
+	}
+}
+
+func TestEmptyKeyringCannotSign(t *testing.T) {
+	keyring := &Keyring{algorithm: AlgorithmEdDSA}
+	if _, err := keyring.Current(); !errors.Is(err, ErrNoSigningKey) {
+		t.Fatalf("Current = %v, want ErrNoSigningKey", err)
+	}
+	if _, err := NewKeyringTokenGenerator(keyring, "15m", "24h").GenerateGuestToken("guest", "guest"); !errors.Is(err, ErrNoSigningKey) {
+		t.Fatalf("signing with no keys: %v, want ErrNoSigningKey", err)
+	}
+}
+
+func TestJWKS(t *testing.T) {
+	for _, algorithm := range []string{AlgorithmEdDSA, AlgorithmRS256} {
+		t.Run(algorithm, func(t *testing.T) {
+			keyring, err := NewKeyring(algorithm, "", time.Hour, time.Hour)
+			if err != nil {
+				t.Fatal(err)
+			}
+			key, _ := keyring.Current()
+
+			set := keyring.JWKS()
+			if len(set.Keys) != 1 {
+				t.Fatalf("JWKS has %d keys, want 1", len(set.Keys))
+			}
+			jwk := set.Keys[0]
+			if jwk.KeyID != key.ID || jwk.Algorithm != algorithm || jwk.Use != "sig" {
+				t.Fatalf("jwk = %+v", jwk)
+			}
+
+			switch public := key.private.Public().(type) {
+			case ed25519.PublicKey:
+				x, _ := base64.RawURLEncoding.DecodeString(jwk.X)
+				if jwk.KeyType != "OKP" || jwk.Curve != "Ed25519" || !public.Equal(ed25519.PublicKey(x)) {
+					t.Fatalf("jwk does not describe the Ed25519 key: %+v", jwk)
+				}
+			case *rsa.PublicKey:
+				n, _ := base64.RawURLEncoding.DecodeString(jwk.N)
+				e, _ := base64.RawURLEncoding.DecodeString(jwk.E)
+				if jwk.KeyType != "RSA" || new(big.Int).SetBytes(n).Cmp(public.N) != 0 || int(new(big.Int).SetBytes(e).Int64()) != public.E {
+					t.Fatalf("jwk does not describe the RSA key: %+v", jwk)
+				}
+			}
+		})
+	}
+}
+
+func TestKeyringRetiresReplacedKeys(t *testing.T) {
+	keyring, err := NewKeyring(AlgorithmEdDSA, t.TempDir(), time.Hour, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	old, _ := keyring.Current()
+	time.Sleep(time.Millisecond)
+	if err := keyring.Rotate(); err != nil {
+		t.Fatal(err)
+	}
+	if keyring.Key(old.ID) != nil {
+		t.Fatal("a key replaced longer than retain ago is still trusted")
+	}
+}
@@ -1,38 +1,84 @@
 package utils
 
 import (
-	"errors"
-	"strings"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
 )
 
+// Token types, carried in the "type" claim so a refresh token can never be used as an access token
+const (
+	TokenTypeAccess  = "access"
+	TokenTypeRefresh = "refresh"
+	TokenTypeGuest   = "guest"
+	TokenTypeMFA     = "mfa"
+	// TokenTypePersonal marks principals authenticated with a personal access
+	// token, which is an opaque string rather than a JWT
+	TokenTypePersonal = "personal"
+)
+
+// PersonalTokenPrefix starts every personal access token, telling them apart
+// from JWTs and making them easy to find in leaked code
+const PersonalTokenPrefix = "rtd_pat_"
+
+// Every token is issued by and for this service; the verifier rejects any other iss or aud
+const (
+	TokenIssuer   = "rtdocs"
+	TokenAudience = "rtdocs-api"
+)
+
+// GuestTokenDuration is how long a guest token, and so the guest account, lasts
+const GuestTokenDuration = 24 * time.Hour
+
+// MFATokenDuration is how long a user has to enter their second factor after their password
+const MFATokenDuration = 5 * time.Minute
+
 type Token struct {
-	AccessToken  string
-	RefreshToken string
+	AccessToken      string
+	RefreshToken     string
+	RefreshExpiresAt time.Time
 }
 
 type TokenGenerator interface {
-	GenerateToken(ID, username string) (*Token, error)
-	ValidateToken(token string) (jwt.Claims, error)
+	TokenVerifier
+	GenerateToken(ID, username, sessionID string) (*Token, error)
+	GenerateGuestToken(ID, username string) (string, error)
+	GenerateMFAToken(ID, username string) (string, error)
 }
 
 type tokenGenerator struct {
 	secretKey            string
+	guestSecretKey       string
+	keyring              *Keyring
 	accessTokenDuration  string
 	refreshTokenDuration string
 }
 
-func NewTokenGenerator(secretKey, accessTokenDuration, refreshTokenDuration string) TokenGenerator {
+// NewTokenGenerator signs user tokens with secretKey and guest tokens with guestSecretKey
+func NewTokenGenerator(secretKey, guestSecretKey, accessTokenDuration, refreshTokenDuration string) TokenGenerator {
 	return &tokenGenerator{
 		secretKey:            secretKey,
+		guestSecretKey:       guestSecretKey,
 		accessTokenDuration:  accessTokenDuration,
 		refreshTokenDuration: refreshTokenDuration,
 	}
 }
 
-func (t *tokenGenerator) GenerateToken(ID, username string) (*Token, error) {
+// NewKeyringTokenGenerator signs every token with the current key of keyring,
+// so other services can verify them with the published public keys
+func NewKeyringTokenGenerator(keyring *Keyring, accessTokenDuration, refreshTokenDuration string) TokenGenerator {
+	return &tokenGenerator{
+		keyring:              keyring,
+		accessTokenDuration:  accessTokenDuration,
+		refreshTokenDuration: refreshTokenDuration,
+	}
+}
+
+// GenerateToken issues an access and refresh token pair for a user's session.
+// Both carry the session ID in "sid"; the refresh token also gets a unique
+// "jti" so every rotation produces a different token.
+func (t *tokenGenerator) GenerateToken(ID, username, sessionID string) (*Token, error) {
 	durationAccess, err := time.ParseDuration(t.accessTokenDuration)
 	if err != nil {
 		return nil, err
@@ -42,47 +88,72 @@ func (t *tokenGenerator) GenerateToken(ID, username string) (*Token, error) {
 		return nil, err
 	}
 
-	claims := jwt.MapClaims{
-		"user_id":  ID,
-		"username": username,
-		"exp":      time.Now().Add(durationAccess).Unix(),
-	}
+	claims := t.claims(ID, username, TokenTypeAccess, time.Now().Add(durationAccess))
+	claims["sid"] = sessionID
 
-	accessToken := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	accessTokenString, err := accessToken.SignedString([]byte(t.secretKey))
+	accessTokenString, err := t.sign(claims, t.secretKey)
 	if err != nil {
 		return nil, err
 	}
 
-	claims["exp"] = time.Now().Add(durationRefresh).Unix()
-	refreshToken := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	refreshTokenString, err := refreshToken.SignedString([]byte(t.secretKey))
+	refreshExpiresAt := time.Now().Add(durationRefresh)
+	claims["type"] = TokenTypeRefresh
+	claims["jti"] = uuid.New().String()
+	claims["exp"] = refreshExpiresAt.Unix()
+	refreshTokenString, err := t.sign(claims, t.secretKey)
 	if err != nil {
 		return nil, err
 	}
 
 	return &Token{
-		AccessToken:  accessTokenString,
-		RefreshToken: refreshTokenString,
+		AccessToken:      accessTokenString,
+		RefreshToken:     refreshTokenString,
+		RefreshExpiresAt: refreshExpiresAt,
 	}, nil
 }
 
-func (t *tokenGenerator) ValidateToken(token string) (jwt.Claims, error) {
-	fields := strings.Split(token, " ")
-	if len(fields) != 2 {
-		return nil, errors.New("invalid token")
+// GenerateGuestToken issues a guest token. Guests have no session, so their
+// tokens cannot be refreshed or revoked and simply expire.
+func (t *tokenGenerator) GenerateGuestToken(ID, username string) (string, error) {
+	claims := t.claims(ID, username, TokenTypeGuest, time.Now().Add(GuestTokenDuration))
+	claims["role"] = "guest"
+
+	return t.sign(claims, t.guestSecretKey)
+}
+
+// GenerateMFAToken issues the challenge token a user whose password was
+// correct exchanges, along with a second factor, for a session
+func (t *tokenGenerator) GenerateMFAToken(ID, username string) (string, error) {
+	claims := t.claims(ID, username, TokenTypeMFA, time.Now().Add(MFATokenDuration))
+	claims["jti"] = uuid.New().String()
+
+	return t.sign(claims, t.secretKey)
+}
+
+// sign signs with the keyring's current key, or with secret when there is no keyring
+func (t *tokenGenerator) sign(claims jwt.MapClaims, secret string) (string, error) {
+	if t.keyring == nil {
+		return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(secret))
 	}
 
-	jwtToken, err := jwt.Parse(fields[1], func(token *jwt.Token) (interface{}, error) {
-		return []byte(t.secretKey), nil
-	})
+	key, err := t.keyring.Current()
 	if err != nil {
-		return nil, err
+		return "", err
 	}
+	token := jwt.NewWithClaims(key.method(), claims)
+	token.Header["kid"] = key.ID
+	return token.SignedString(key.private)
+}
 
-	if !jwtToken.Valid {
-		return nil, errors.New("invalid token")
+func (t *tokenGenerator) claims(ID, username, tokenType string, expiresAt time.Time) jwt.MapClaims {
+	now := time.Now()
+	return jwt.MapClaims{
+		"user_id":  ID,
+		"username": username,
+		"type":     tokenType,
+		"iss":      TokenIssuer,
+		"aud":      TokenAudience,
+		"iat":      now.Unix(),
+		"exp":      expiresAt.Unix(),
 	}
-
-	return jwtToken.Claims, nil
 }
@@ -0,0 +1,107 @@
+package utils
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfc6238Secret is the SHA-1 key of the RFC 6238 test vectors, "12345678901234567890", in base32
+const rfc6238Secret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestValidateTOTP(t *testing.T) {
+	// The RFC's eight digit codes, cut to the six authenticator apps show
+	tests := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+
+	for _, tt := range tests {
+		now := time.Unix(tt.unix, 0)
+		step, ok := ValidateTOTP(rfc6238Secret, tt.code, now)
+		if !ok || step != tt.unix/30 {
+			t.Errorf("ValidateTOTP(%s at %d) = %d, %v; want %d, true", tt.code, tt.unix, step, ok, tt.unix/30)
+		}
+		if _, ok := ValidateTOTP(strings.ToLower(rfc6238Secret), tt.code, now); !ok {
+			t.Errorf("a lowercase secret rejected %s at %d", tt.code, tt.unix)
+		}
+	}
+}
+
+func TestValidateTOTPWindow(t *testing.T) {
+	at := time.Unix(1111111111, 0)
+	code := "050471"
+
+	for _, tt := range []struct {
+		offset time.Duration
+		ok     bool
+	}{
+		{-30 * time.Second, true},
+		{30 * time.Second, true},
+		{-60 * time.Second, false},
+		{60 * time.Second, false},
+	} {
+		// The code still belongs to its own step when accepted from a neighbouring one
+		step, ok := ValidateTOTP(rfc6238Secret, code, at.Add(tt.offset))
+		if ok != tt.ok || (ok && step != at.Unix()/30) {
+			t.Errorf("code checked %v away = %d, %v; want ok %v", tt.offset, step, ok, tt.ok)
+		}
+	}
+}
+
+func TestValidateTOTPRejectsMalformedInput(t *testing.T) {
+	now := time.Unix(59, 0)
+	for _, tt := range []struct{ secret, code string }{
+		{rfc6238Secret, "28708"},
+		{rfc6238Secret, "2870820"},
+		{rfc6238Secret, "94287082"},
+		{rfc6238Secret, ""},
+		{"not base32!", "287082"},
+	} {
+		if _, ok := ValidateTOTP(tt.secret, tt.code, now); ok {
+			t.Errorf("ValidateTOTP(%q, %q) accepted", tt.secret, tt.code)
+		}
+	}
+}
+
+func TestGenerateTOTPSecret(t *testing.T) {
+	secret, err := GenerateTOTPSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := totpEncoding.DecodeString(secret)
+	if err != nil || len(key) != 20 {
+		t.Fatalf("secret %q decodes to %d bytes, %v; want 20", secret, len(key), err)
+	}
+
+	other, _ := GenerateTOTPSecret()
+	if other == secret {
+		t.Fatal("two secrets were the same")
+	}
+}
+
+func TestTOTPURI(t *testing.T) {
+	uri, err := url.Parse(TOTPURI("rtdocs", "alice smith", rfc6238Secret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if uri.Scheme != "otpauth" || uri.Host != "totp" || uri.Path != "/rtdocs:alice smith" {
+		t.Fatalf("uri = %s", uri)
+	}
+
+	query := uri.Query()
+	want := map[string]string{"secret": rfc6238Secret, "issuer": "rtdocs", "algorithm": "SHA1", "digits": "6", "period": "30"}
+	for key, value := range want {
+		if query.Get(key) != value {
+			t.Errorf("%s = %q, want %q", key, query.Get(key), value)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var ErrInvalidToken = errors.New("invalid token")
+
+// Principal is the verified identity behind a token
+type Principal struct {
+	UserID    string
+	Username  string
+	Role      string
+	Type      string   // one of the TokenType constants
+	SessionID string   // empty for guest, MFA and personal access tokens
+	TokenID   string   // the "jti" claim of refresh and MFA tokens, which is unique to each token
+	Scopes    []string // what a personal access token may do; other tokens are unrestricted
+	ExpiresAt time.Time
+}
+
+// IsGuest reports whether the principal authenticated with a guest token
+func (p *Principal) IsGuest() bool {
+	return p.Type == TokenTypeGuest
+}
+
+// IsPersonalToken reports whether the principal authenticated with a personal access token
+func (p *Principal) IsPersonalToken() bool {
+	return p.Type == TokenTypePersonal
+}
+
+// HasScope reports whether the principal may act within scope
+func (p *Principal) HasScope(scope string) bool {
+	return !p.IsPersonalToken() || slices.Contains(p.Scopes, scope)
+}
+
+type TokenVerifier interface {
+	// Verify checks a raw token and returns its principal. The token must be
+	// one of the given types; with none, any type is accepted.
+	Verify(token string, types ...string) (*Principal, error)
+	// JWKS returns the public keys tokens are verified with; it is empty for shared secrets
+	JWKS() JWKSet
+}
+
+// Verify picks the key, from the "kid" header with a keyring or else from the
+// token's type since user and guest tokens are signed with different
+// secrets, then checks the signature, algorithm, expiry, issuer and audience.
+func (t *tokenGenerator) Verify(tokenStr string, types ...string) (*Principal, error) {
+	unverified, _, err := jwt.NewParser().ParseUnverified(tokenStr, jwt.MapClaims{})
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	tokenType, _ := unverified.Claims.(jwt.MapClaims)["type"].(string)
+	if len(types) > 0 && !slices.Contains(types, tokenType) {
+		return nil, fmt.Errorf("%w: %q tokens are not accepted here", ErrInvalidToken, tokenType)
+	}
+
+	var keyFunc jwt.Keyfunc
+	methods := []string{jwt.SigningMethodHS256.Alg()}
+	switch {
+	case t.keyring != nil:
+		keyFunc = func(token *jwt.Token) (interface{}, error) {
+			kid, _ := token.Header["kid"].(string)
+			key := t.keyring.Key(kid)
+			if key == nil || key.Algorithm != token.Method.Alg() {
+				return nil, ErrInvalidToken
+			}
+			return key.private.Public(), nil
+		}
+		methods = []string{jwt.SigningMethodRS256.Alg(), jwt.SigningMethodEdDSA.Alg()}
+	case tokenType == TokenTypeAccess || tokenType == TokenTypeRefresh || tokenType == TokenTypeMFA:
+		keyFunc = func(*jwt.Token) (interface{}, error) { return []byte(t.secretKey), nil }
+	case tokenType == TokenTypeGuest:
+		keyFunc = func(*jwt.Token) (interface{}, error) { return []byte(t.guestSecretKey), nil }
+	default:
+		return nil, ErrInvalidToken
+	}
+
+	token, err := jwt.Parse(tokenStr, keyFunc,
+		jwt.WithValidMethods(methods),
+		jwt.WithIssuer(TokenIssuer),
+		jwt.WithAudience(TokenAudience),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil || !token.Valid {
+		return nil, ErrInvalidToken
+	}
+
+	claims := token.Claims.(jwt.MapClaims)
+	principal := &Principal{Type: tokenType}
+	principal.UserID, _ = claims["user_id"].(string)
+	principal.Username, _ = claims["username"].(string)
+	principal.Role, _ = claims["role"].(string)
+	principal.SessionID, _ = claims["sid"].(string)
+	principal.TokenID, _ = claims["jti"].(string)
+	if exp, err := claims.GetExpirationTime(); err == nil && exp != nil {
+		principal.ExpiresAt = exp.Time
+	}
+
+	if principal.UserID == "" {
+		return nil, ErrInvalidToken
+	}
+	// Only access and refresh tokens belong to a session
+	if (tokenType == TokenTypeAccess || tokenType == TokenTypeRefresh) && principal.SessionID == "" {
+		return nil, ErrInvalidToken
+	}
+	// MFA tokens are used up by their ID
+	if tokenType == TokenTypeMFA && principal.TokenID == "" {
+		return nil, ErrInvalidToken
+	}
+
+	return principal, nil
+}
+
+func (t *tokenGenerator) JWKS() JWKSet {
+	if t.keyring == nil {
+		return JWKSet{Keys: []JWK{}}
+	}
+	return t.keyring.JWKS()
+}
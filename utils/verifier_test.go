@@ -0,0 +1,114 @@
+package utils
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// forgedClaims are the claims of a valid token of tokenType, changed by edit
+func forgedClaims(tokenType string, edit func(jwt.MapClaims)) jwt.MapClaims {
+	claims := (&tokenGenerator{}).claims("user", "alice", tokenType, time.Now().Add(time.Hour))
+	claims["sid"] = "session"
+	claims["jti"] = "token"
+	if edit != nil {
+		edit(claims)
+	}
+	return claims
+}
+
+// forge signs forgedClaims with secret
+func forge(t *testing.T, tokenType, secret string, edit func(jwt.MapClaims)) string {
+	t.Helper()
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, forgedClaims(tokenType, edit)).SignedString([]byte(secret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func TestVerify(t *testing.T) {
+	tokens := NewTokenGenerator("secret", "guest-secret", "15m", "24h")
+	pair, err := tokens.GenerateToken("user", "alice", "session")
+	if err != nil {
+		t.Fatal(err)
+	}
+	guest, err := tokens.GenerateGuestToken("guest", "Guest 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mfa, err := tokens.GenerateMFAToken("user", "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	unsigned, err := jwt.NewWithClaims(jwt.SigningMethodNone, forgedClaims(TokenTypeAccess, nil)).SignedString(jwt.UnsafeAllowNoneSignatureType)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		token string
+		types []string
+		want  *Principal // nil when the token must be rejected
+	}{
+		{"access", pair.AccessToken, nil, &Principal{UserID: "user", Username: "alice", Type: TokenTypeAccess, SessionID: "session"}},
+		{"refresh", pair.RefreshToken, []string{TokenTypeRefresh}, &Principal{UserID: "user", Username: "alice", Type: TokenTypeRefresh, SessionID: "session"}},
+		{"guest", guest, nil, &Principal{UserID: "guest", Username: "Guest 1", Role: "guest", Type: TokenTypeGuest}},
+		{"mfa", mfa, []string{TokenTypeMFA}, &Principal{UserID: "user", Username: "alice", Type: TokenTypeMFA}},
+		{"refresh as access", pair.RefreshToken, []string{TokenTypeAccess, TokenTypeGuest}, nil},
+		{"mfa as access", mfa, []string{TokenTypeAccess}, nil},
+		{"garbage", "not.a.token", nil, nil},
+		{"unsigned", unsigned, nil, nil},
+		{"wrong secret", forge(t, TokenTypeAccess, "other", nil), nil, nil},
+		{"guest signed with the user secret", forge(t, TokenTypeGuest, "secret", nil), nil, nil},
+		{"access signed with the guest secret", forge(t, TokenTypeAccess, "guest-secret", nil), nil, nil},
+		{"unknown type", forge(t, "admin", "secret", nil), nil, nil},
+		{"other issuer", forge(t, TokenTypeAccess, "secret", func(c jwt.MapClaims) { c["iss"] = "elsewhere" }), nil, nil},
+		{"other audience", forge(t, TokenTypeAccess, "secret", func(c jwt.MapClaims) { c["aud"] = "elsewhere" }), nil, nil},
+		{"expired", forge(t, TokenTypeAccess, "secret", func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-time.Minute).Unix() }), nil, nil},
+		{"no expiry", forge(t, TokenTypeAccess, "secret", func(c jwt.MapClaims) { delete(c, "exp") }), nil, nil},
+		{"no user", forge(t, TokenTypeAccess, "secret", func(c jwt.MapClaims) { delete(c, "user_id") }), nil, nil},
+		{"access without a session", forge(t, TokenTypeAccess, "secret", func(c jwt.MapClaims) { delete(c, "sid") }), nil, nil},
+		{"mfa without an ID", forge(t, TokenTypeMFA, "secret", func(c jwt.MapClaims) { delete(c, "jti") }), nil, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			principal, err := tokens.Verify(tt.token, tt.types...)
+			if tt.want == nil {
+				if !errors.Is(err, ErrInvalidToken) {
+					t.Fatalf("Verify = %+v, %v; want ErrInvalidToken", principal, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Verify: %v", err)
+			}
+			if principal.UserID != tt.want.UserID || principal.Username != tt.want.Username || principal.Role != tt.want.Role ||
+				principal.Type != tt.want.Type || principal.SessionID != tt.want.SessionID {
+				t.Fatalf("Verify = %+v, want %+v", principal, tt.want)
+			}
+			if principal.ExpiresAt.Before(time.Now()) {
+				t.Fatalf("principal expires at %v, in the past", principal.ExpiresAt)
+			}
+		})
+	}
+}
+
+func TestPrincipalScopes(t *testing.T) {
+	user := &Principal{Type: TokenTypeAccess}
+	personal := &Principal{Type: TokenTypePersonal, Scopes: []string{"documents:read"}}
+
+	if !user.HasScope("documents:write") {
+		t.Error("an access token is restricted by scope")
+	}
+	if !personal.HasScope("documents:read") || personal.HasScope("documents:write") {
+		t.Error("a personal access token is not restricted to its scopes")
+	}
+	if !(&Principal{Type: TokenTypeGuest}).IsGuest() || user.IsGuest() {
+		t.Error("IsGuest does not follow the token type")
+	}
+}